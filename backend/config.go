@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/RanitManik/zyply/internal/config"
+)
+
+// runConfig runs a config subcommand. loadErr is the error loading cfg, which print reports after
+// the settings so that invalid values can be seen next to the problem.
+func runConfig(w io.Writer, cfg *config.Config, loadErr error, args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New("missing config command, expected print")
+	}
+
+	flags := flag.NewFlagSet("config print", flag.ExitOnError)
+	redacted := flags.Bool("redacted", false, "hide secrets and database passwords")
+	flags.Parse(args[1:])
+
+	// Print each setting with where it came from
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, value := range cfg.Values() {
+		v := value.Value
+		if *redacted {
+			v = value.Redacted()
+		}
+		fmt.Fprintf(tw, "%s=%s\t# %s\n", value.Key, v, value.Origin)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	// Report what is wrong with the configuration
+	if loadErr != nil {
+		return fmt.Errorf("invalid configuration: %w", loadErr)
+	}
+	return nil
+}
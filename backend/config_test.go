@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/RanitManik/zyply/internal/config"
+)
+
+func TestRunConfigPrint(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configFile, []byte("jwt:\n  secret: file-jwt-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	passwordFile := filepath.Join(dir, "db_password")
+	if err := os.WriteFile(passwordFile, []byte("file-db-password\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Production refuses a database URL without TLS, so loading reports an error
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("CONFIG_FILE", configFile)
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("DB_PASSWORD", "")
+	t.Setenv("DB_PASSWORD_FILE", passwordFile)
+	t.Setenv("PASSWORD_MIN_LENGTH", "12")
+	t.Setenv("DATABASE_URL", "postgres://zyply:url-password@db:5432/zyply?sslmode=disable")
+	cfg, loadErr := config.Resolve()
+	if loadErr == nil {
+		t.Fatal("Resolve() = nil, want a validation error")
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		// want maps keys to their printed value and origin
+		want map[string][2]string
+		// hidden are values that must not appear anywhere in the output
+		hidden []string
+	}{
+		{"redacted", []string{"print", "--redacted"}, map[string][2]string{
+			"APP_ENV":             {"production", config.OriginEnv},
+			"JWT_SECRET":          {config.Redacted, config.OriginFile},
+			"DB_PASSWORD":         {config.Redacted, config.OriginSecretFile},
+			"DATABASE_URL":        {"postgres://zyply:xxxxx@db:5432/zyply?sslmode=disable", config.OriginEnv},
+			"PASSWORD_MIN_LENGTH": {"12", config.OriginEnv},
+		}, []string{"file-jwt-secret", "file-db-password", "url-password"}},
+		{"plain", []string{"print"}, map[string][2]string{
+			"JWT_SECRET":   {"file-jwt-secret", config.OriginFile},
+			"DB_PASSWORD":  {"file-db-password", config.OriginSecretFile},
+			"DATABASE_URL": {"postgres://zyply:url-password@db:5432/zyply?sslmode=disable", config.OriginEnv},
+		}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := runConfig(&out, cfg, loadErr, tt.args)
+
+			// The settings are printed, then the validation error is returned
+			if err == nil || !strings.Contains(err.Error(), "DATABASE_URL must not use sslmode=disable") {
+				t.Errorf("runConfig() = %v, want the validation error", err)
+			}
+			printed := map[string][2]string{}
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				setting, origin, _ := strings.Cut(line, "# ")
+				key, value, _ := strings.Cut(strings.TrimSpace(setting), "=")
+				printed[key] = [2]string{value, origin}
+			}
+			for key, want := range tt.want {
+				if got := printed[key]; got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+			for _, secret := range tt.hidden {
+				if strings.Contains(out.String(), secret) {
+					t.Errorf("output contains %q:\n%s", secret, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package account
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/models"
+)
+
+// purgeInterval is how often accounts past their deletion grace period are purged
+const purgeInterval = time.Hour
+
+// StartPurge permanently deletes accounts whose deletion grace period has ended now and
+// then once an hour until the context is cancelled
+func StartPurge(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			purged, err := models.PurgeDeletedUsers(ctx, time.Now())
+			if err != nil {
+				slog.Error("Failed to purge deleted accounts", "error", err)
+			} else if purged > 0 {
+				slog.Info("Purged deleted accounts", "count", purged)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,173 @@
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/RanitManik/zyply/internal/logging"
+	"github.com/RanitManik/zyply/internal/models"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+// Error codes returned in the error envelope
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeValidationFailed   = "validation_failed"
+	CodeRequestTooLarge    = "request_too_large"
+	CodeUnauthorized       = "unauthorized"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeInvalidToken       = "invalid_token"
+	CodeInvalidState       = "invalid_state"
+	CodeInvalidMFACode     = "invalid_mfa_code"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeUserNotFound       = "user_not_found"
+	CodeConflict           = "conflict"
+	CodeEmailTaken         = "email_taken"
+	CodeAccountNotLinked   = "account_not_linked"
+	CodeDomainNotVerified  = "domain_not_verified"
+	CodeMFALocked          = "mfa_locked"
+	CodeReauthRequired     = "reauthentication_required"
+	CodeUpstream           = "upstream_error"
+	CodeInternal           = "internal_error"
+)
+
+// Error is an error with the HTTP status, code and message to respond with
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Details interface{}
+	// Cause is logged for server errors but never sent to the client
+	Cause error
+}
+
+// Error returns the error message
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return e.Message + ": " + e.Cause.Error()
+	}
+	return e.Message
+}
+
+// Unwrap returns the cause
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// New creates an error with a status, code and message
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// WithDetails returns a copy of the error carrying additional details
+func (e *Error) WithDetails(details interface{}) *Error {
+	copied := *e
+	copied.Details = details
+	return &copied
+}
+
+// BadRequest creates a 400 error for an invalid request
+func BadRequest(message string) *Error {
+	return New(http.StatusBadRequest, CodeInvalidRequest, message)
+}
+
+// Validation creates a 400 error listing the invalid fields in its details
+func Validation(fields interface{}) *Error {
+	return New(http.StatusBadRequest, CodeValidationFailed, "Validation failed").WithDetails(fields)
+}
+
+// Unauthorized creates a 401 error with a code
+func Unauthorized(code, message string) *Error {
+	return New(http.StatusUnauthorized, code, message)
+}
+
+// Forbidden creates a 403 error for an action the caller may not perform
+func Forbidden(message string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, message)
+}
+
+// NotFound creates a 404 error for a missing resource
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, message)
+}
+
+// Conflict creates a 409 error for a request that conflicts with the current state
+func Conflict(message string) *Error {
+	return New(http.StatusConflict, CodeConflict, message)
+}
+
+// Internal creates a 500 error whose cause is logged rather than returned
+func Internal(message string, cause error) *Error {
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: message, Cause: cause}
+}
+
+// modelErrors maps model errors to their responses, most specific first
+var modelErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{models.ErrUserNotFound, http.StatusNotFound, CodeUserNotFound},
+	{models.ErrEmailTaken, http.StatusConflict, CodeEmailTaken},
+	{models.ErrNotFound, http.StatusNotFound, CodeNotFound},
+	{models.ErrConflict, http.StatusConflict, CodeConflict},
+}
+
+// envelope is the JSON body of every error response
+type envelope struct {
+	Error body `json:"error"`
+}
+
+// body is the error object inside the envelope
+type body struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// From converts any error into an Error. Model errors map to their status and
+// user-facing message, and anything else becomes an internal error.
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	for _, m := range modelErrors {
+		if errors.Is(err, m.err) {
+			return New(m.status, m.code, modelMessage(err, m.err))
+		}
+	}
+
+	return Internal("Internal server error", err)
+}
+
+// Write writes an error as a JSON envelope carrying the request ID
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := From(err)
+	if apiErr.Status >= http.StatusInternalServerError && apiErr.Cause != nil {
+		logging.FromContext(r.Context()).Error("Request failed", "code", apiErr.Code, "error", apiErr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(envelope{Error: body{
+		Code:      apiErr.Code,
+		Message:   apiErr.Message,
+		Details:   apiErr.Details,
+		RequestID: chimiddleware.GetReqID(r.Context()),
+	}})
+}
+
+// modelMessage returns the user-facing message of a model error
+func modelMessage(err, matched error) string {
+	var modelErr *models.Error
+	if errors.As(err, &modelErr) {
+		return modelErr.Message
+	}
+	return matched.Error()
+}
@@ -0,0 +1,215 @@
+package audit
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"reflect"
+
+	"github.com/RanitManik/zyply/internal/database"
+	"github.com/RanitManik/zyply/internal/logging"
+	"github.com/RanitManik/zyply/internal/middleware"
+	"github.com/RanitManik/zyply/internal/models"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+// Audited actions
+const (
+	ActionSignup              = "auth.signup"
+	ActionLogin               = "auth.login"
+	ActionLoginFailed         = "auth.login_failed"
+	ActionOAuthLogin          = "auth.oauth_login"
+	ActionSAMLLogin           = "auth.saml_login"
+	ActionSAMLLinked          = "auth.saml_linked"
+	ActionPasskeyLogin        = "auth.passkey_login"
+	ActionMFAVerified         = "auth.mfa_verified"
+	ActionMFAFailed           = "auth.mfa_failed"
+	ActionPasswordResetIssued = "auth.password_reset_requested"
+	ActionPasswordReset       = "auth.password_reset"
+	ActionProfileUpdated      = "user.profile_updated"
+	ActionPasswordChanged     = "user.password_changed"
+	ActionEmailChangeIssued   = "user.email_change_requested"
+	ActionEmailChanged        = "user.email_changed"
+	ActionDataExported        = "user.data_exported"
+	ActionDeletionScheduled   = "user.deletion_scheduled"
+	ActionAccountRestored     = "user.restored"
+	ActionTOTPEnabled         = "mfa.totp_enabled"
+	ActionTOTPDisabled        = "mfa.totp_disabled"
+	ActionRecoveryCodesReset  = "mfa.recovery_codes_regenerated"
+	ActionPasskeyRegistered   = "passkey.registered"
+	ActionPasskeyDeleted      = "passkey.deleted"
+	ActionAPIKeyCreated       = "api_key.created"
+	ActionAPIKeyRevoked       = "api_key.revoked"
+	ActionOAuthClientCreated  = "oauth_client.created"
+	ActionOAuthClientDeleted  = "oauth_client.deleted"
+	ActionOAuthAuthorized     = "oauth.authorized"
+	ActionWorkspaceCreated    = "workspace.created"
+	ActionMemberRoleUpdated   = "member.role_updated"
+	ActionMemberRemoved       = "member.removed"
+	ActionInviteCreated       = "invite.created"
+	ActionInviteRevoked       = "invite.revoked"
+	ActionInviteAccepted      = "invite.accepted"
+	ActionSAMLConnectionSaved = "saml_connection.saved"
+	ActionSAMLDomainVerified  = "saml_connection.domain_verified"
+)
+
+// Audit target types
+const (
+	TargetUser           = "user"
+	TargetPasskey        = "passkey"
+	TargetAPIKey         = "api_key"
+	TargetOAuthClient    = "oauth_client"
+	TargetWorkspace      = "workspace"
+	TargetMember         = "member"
+	TargetInvite         = "invite"
+	TargetSAMLConnection = "saml_connection"
+)
+
+// Event describes an action to record
+type Event struct {
+	Action     string
+	TargetType string
+	TargetID   string
+	// ActorID defaults to the authenticated user
+	ActorID int64
+	// OrganizationID defaults to the workspace resolved for the request, if any
+	OrganizationID int64
+	// Before and After are the target's state around the change. Only changed fields are stored.
+	Before interface{}
+	After  interface{}
+}
+
+// Change is the before and after value of a changed field
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Recorder records audit events for requests
+type Recorder interface {
+	Record(r *http.Request, event Event)
+}
+
+// RecorderFunc adapts a function to a Recorder
+type RecorderFunc func(r *http.Request, event Event)
+
+// Record calls f(r, event)
+func (f RecorderFunc) Record(r *http.Request, event Event) {
+	f(r, event)
+}
+
+// Log is a Recorder that appends events to a repository
+type Log struct {
+	Events models.AuditEventRepository
+}
+
+// NewLog creates a new Log
+func NewLog(events models.AuditEventRepository) *Log {
+	return &Log{Events: events}
+}
+
+// Record appends an audit event for a request to the global database
+func Record(r *http.Request, event Event) {
+	NewLog(models.NewPostgresAuditEventRepository(database.DB)).Record(r, event)
+}
+
+// Record appends an audit event for a request. Failures are logged rather than
+// returned so that auditing never breaks the action being audited.
+func (l *Log) Record(r *http.Request, event Event) {
+	ctx := r.Context()
+
+	// Fill in actor and workspace from the request
+	if event.ActorID == 0 {
+		event.ActorID, _ = middleware.GetUserID(ctx)
+	}
+	if event.OrganizationID == 0 {
+		event.OrganizationID, _ = middleware.GetWorkspaceID(ctx)
+	}
+
+	// Compute changes
+	changes, err := Diff(event.Before, event.After)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to compute audit changes", "action", event.Action, "error", err)
+	}
+
+	auditEvent := &models.AuditEvent{
+		Action:     event.Action,
+		TargetType: event.TargetType,
+		TargetID:   event.TargetID,
+		IPAddress:  clientIP(r),
+		UserAgent:  r.UserAgent(),
+		RequestID:  chimiddleware.GetReqID(ctx),
+		Changes:    changes,
+	}
+	if event.ActorID != 0 {
+		auditEvent.ActorID = &event.ActorID
+	}
+	if event.OrganizationID != 0 {
+		auditEvent.OrganizationID = &event.OrganizationID
+	}
+
+	if err := l.Events.Create(r.Context(), auditEvent); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to record audit event", "action", event.Action, "error", err)
+	}
+}
+
+// Diff returns the fields that differ between two JSON-encodable values as a
+// JSON object of {"field": {"before": ..., "after": ...}}. Nil values are treated
+// as empty objects, so creations and deletions record every field.
+func Diff(before, after interface{}) (json.RawMessage, error) {
+	if before == nil && after == nil {
+		return nil, nil
+	}
+
+	beforeFields, err := toFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := toFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]Change{}
+	for field, value := range beforeFields {
+		if !reflect.DeepEqual(value, afterFields[field]) {
+			changes[field] = Change{Before: value, After: afterFields[field]}
+		}
+	}
+	for field, value := range afterFields {
+		if _, ok := beforeFields[field]; !ok {
+			changes[field] = Change{After: value}
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	return json.Marshal(changes)
+}
+
+// toFields converts a JSON-encodable value into its top-level fields
+func toFields(v interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if v == nil {
+		return fields, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// clientIP returns the client IP, which middleware.TrustedProxies has already resolved into RemoteAddr
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
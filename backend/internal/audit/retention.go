@@ -0,0 +1,40 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/models"
+)
+
+// pruneInterval is how often expired audit events are deleted
+const pruneInterval = 24 * time.Hour
+
+// StartRetention deletes audit events older than the retention period now and then
+// once a day until the context is cancelled. A zero retention keeps events forever.
+func StartRetention(ctx context.Context, retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+
+		for {
+			pruned, err := models.PruneAuditEvents(ctx, time.Now().Add(-retention))
+			if err != nil {
+				slog.Error("Failed to prune audit events", "error", err)
+			} else if pruned > 0 {
+				slog.Info("Pruned audit events", "count", pruned)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,14 @@
+package auth
+
+// AccountRestoreTokenPrefix identifies tokens that cancel a scheduled account deletion
+const AccountRestoreTokenPrefix = "zyr_"
+
+// GenerateAccountRestoreToken generates an account restore token and returns it along with the hash to store
+func GenerateAccountRestoreToken() (token, hash string, err error) {
+	return GenerateOAuthSecret(AccountRestoreTokenPrefix)
+}
+
+// HashAccountRestoreToken hashes an account restore token for lookup
+func HashAccountRestoreToken(token string) string {
+	return HashAPIKeySecret(token)
+}
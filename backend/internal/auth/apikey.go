@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/RanitManik/zyply/internal/models"
+)
+
+// APIKeyPrefix is the fixed prefix that identifies Zyply API keys
+const APIKeyPrefix = "zyp"
+
+// Scopes that can be granted to API keys and OAuth clients
+const (
+	// ScopeProfileRead allows reading the user's profile
+	ScopeProfileRead = "profile:read"
+	// ScopeLinksRead allows reading links
+	ScopeLinksRead = "links:read"
+	// ScopeLinksWrite allows creating, updating and deleting links
+	ScopeLinksWrite = "links:write"
+	// ScopeAnalyticsRead allows reading link analytics
+	ScopeAnalyticsRead = "analytics:read"
+)
+
+// Scopes lists every scope that can be granted to an API key or OAuth client
+var Scopes = []string{ScopeProfileRead, ScopeLinksRead, ScopeLinksWrite, ScopeAnalyticsRead}
+
+// ErrInvalidAPIKey is returned when an API key is malformed, unknown, revoked or expired
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// ValidScope checks if a scope can be granted to an API key or OAuth client
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateAPIKey generates a new API key in the form zyp_<prefix>_<secret>
+// and returns the full key, its prefix and the hash of its secret
+func GenerateAPIKey() (key, prefix, secretHash string, err error) {
+	prefix, err = randomHex(6)
+	if err != nil {
+		return "", "", "", err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return APIKeyPrefix + "_" + prefix + "_" + secret, prefix, HashAPIKeySecret(secret), nil
+}
+
+// HashAPIKeySecret hashes an API key secret for storage
+func HashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateAPIKey validates a full API key against the stored keys and returns the stored key
+func ValidateAPIKey(ctx context.Context, keys models.APIKeyRepository, key string) (*models.APIKey, error) {
+	// Split key into prefix and secret
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != APIKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// Get stored key
+	apiKey, err := keys.GetByPrefix(ctx, parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// Compare secret hash and check the key is still active
+	if subtle.ConstantTimeCompare([]byte(apiKey.SecretHash), []byte(HashAPIKeySecret(parts[2]))) != 1 || !apiKey.Active() {
+		return nil, ErrInvalidAPIKey
+	}
+
+	return apiKey, nil
+}
+
+// randomHex returns a random hex string built from n random bytes
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/models"
+)
+
+// storeAPIKey generates an API key, stores it and returns the full key and the stored key
+func storeAPIKey(t *testing.T, keys models.APIKeyRepository, expiresAt *time.Time) (string, *models.APIKey) {
+	t.Helper()
+	key, prefix, secretHash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey: %v", err)
+	}
+	stored, err := keys.Create(context.Background(), 1, 2, "CI", prefix, secretHash, []string{ScopeLinksRead}, expiresAt)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return key, stored
+}
+
+func TestValidateAPIKey(t *testing.T) {
+	ctx := context.Background()
+	keys := models.NewMemoryAPIKeyRepository()
+
+	active, activeKey := storeAPIKey(t, keys, nil)
+	future := time.Now().Add(time.Hour)
+	unexpired, _ := storeAPIKey(t, keys, &future)
+	past := time.Now().Add(-time.Minute)
+	expired, _ := storeAPIKey(t, keys, &past)
+	revoked, revokedKey := storeAPIKey(t, keys, nil)
+	if ok, err := keys.Revoke(ctx, revokedKey.ID, revokedKey.UserID); !ok || err != nil {
+		t.Fatalf("Revoke = %v, %v", ok, err)
+	}
+	unknown, _, _, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"active", active, false},
+		{"unexpired", unexpired, false},
+		{"wrong prefix", "zyx" + active[len(APIKeyPrefix):], true},
+		{"missing secret", APIKeyPrefix + "_" + activeKey.Prefix, true},
+		{"empty secret", APIKeyPrefix + "_" + activeKey.Prefix + "_", true},
+		{"empty key prefix", APIKeyPrefix + "__secret", true},
+		{"unknown", unknown, true},
+		{"revoked", revoked, true},
+		{"expired", expired, true},
+		{"hash mismatch", APIKeyPrefix + "_" + activeKey.Prefix + "_wrongsecret", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateAPIKey(ctx, keys, tt.key)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidAPIKey) {
+					t.Fatalf("ValidateAPIKey() = %v, %v, want ErrInvalidAPIKey", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateAPIKey() = %v", err)
+			}
+			if got.UserID != 1 || got.OrganizationID != 2 {
+				t.Errorf("ValidateAPIKey() = %+v, want the stored key", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package auth
+
+import "time"
+
+// EmailChangeTokenPrefix identifies email change verification tokens
+const EmailChangeTokenPrefix = "zye_"
+
+// EmailChangeExpiry is how long an email change verification link stays valid
+const EmailChangeExpiry = 24 * time.Hour
+
+// GenerateEmailChangeToken generates an email change verification token and returns it along with the hash to store
+func GenerateEmailChangeToken() (token, hash string, err error) {
+	return GenerateOAuthSecret(EmailChangeTokenPrefix)
+}
+
+// HashEmailChangeToken hashes an email change verification token for lookup
+func HashEmailChangeToken(token string) string {
+	return HashAPIKeySecret(token)
+}
@@ -0,0 +1,19 @@
+package auth
+
+import "time"
+
+// InviteTokenPrefix identifies workspace invitation tokens
+const InviteTokenPrefix = "zyi_"
+
+// InviteExpiry is how long a workspace invitation stays valid
+const InviteExpiry = 7 * 24 * time.Hour
+
+// GenerateInviteToken generates a workspace invitation token and returns it along with the hash to store
+func GenerateInviteToken() (token, hash string, err error) {
+	return GenerateOAuthSecret(InviteTokenPrefix)
+}
+
+// HashInviteToken hashes an invitation token for lookup
+func HashInviteToken(token string) string {
+	return HashAPIKeySecret(token)
+}
@@ -1,6 +1,9 @@
 package auth
 
 import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
     "fmt"
     "time"
 
@@ -8,26 +11,68 @@ import (
     "github.com/golang-jwt/jwt/v5"
 )
 
+// PurposeMFAPending marks a token issued after the first login factor that can
+// only be exchanged for a full token by completing two-factor authentication
+const PurposeMFAPending = "mfa_pending"
+
+// MFATokenExpiry is how long an MFA pending token stays valid
+const MFATokenExpiry = 5 * time.Minute
+
+// PurposePasswordReset marks a token that can only be used to reset a forgotten password
+const PurposePasswordReset = "password_reset"
+
+// PasswordResetExpiry is how long a password reset link stays valid
+const PasswordResetExpiry = 30 * time.Minute
+
 // Claims represents the JWT claims
 type Claims struct {
-    UserID int64  `json:"user_id"`
-    Email  string `json:"email"`
+    UserID  int64  `json:"user_id"`
+    Email   string `json:"email"`
+    Purpose string `json:"purpose,omitempty"`
+    // PasswordFingerprint ties a password reset token to the password it replaces, so it stops
+    // working once the password has been reset
+    PasswordFingerprint string `json:"pwf,omitempty"`
     jwt.RegisteredClaims
 }
 
 // GenerateToken generates a JWT token for a user
 func GenerateToken(userID int64, email string, cfg *config.Config) (string, error) {
-    // Create claims
-    claims := &Claims{
-        UserID: userID,
-        Email:  email,
+    return generateToken(userID, email, "", cfg.JWT.Expiry, cfg)
+}
+
+// GenerateMFAToken generates a short-lived token that proves the first login factor
+func GenerateMFAToken(userID int64, email string, cfg *config.Config) (string, error) {
+    return generateToken(userID, email, PurposeMFAPending, MFATokenExpiry, cfg)
+}
+
+// GeneratePasswordResetToken generates a single-use token for resetting the password whose hash is given
+func GeneratePasswordResetToken(userID int64, email, passwordHash string, cfg *config.Config) (string, error) {
+    claims := newClaims(userID, email, PurposePasswordReset, PasswordResetExpiry)
+    claims.PasswordFingerprint = passwordFingerprint(passwordHash, cfg)
+    return signClaims(claims, cfg)
+}
+
+// generateToken generates a signed JWT token with the given purpose and expiry
+func generateToken(userID int64, email, purpose string, expiry time.Duration, cfg *config.Config) (string, error) {
+    return signClaims(newClaims(userID, email, purpose, expiry), cfg)
+}
+
+// newClaims creates the claims of a token with the given purpose and expiry
+func newClaims(userID int64, email, purpose string, expiry time.Duration) *Claims {
+    return &Claims{
+        UserID:  userID,
+        Email:   email,
+        Purpose: purpose,
         RegisteredClaims: jwt.RegisteredClaims{
-            ExpiresAt: jwt.NewNumericDate(time.Now().Add(cfg.JWT.Expiry)),
+            ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
             IssuedAt:  jwt.NewNumericDate(time.Now()),
             NotBefore: jwt.NewNumericDate(time.Now()),
         },
     }
+}
 
+// signClaims signs claims into a JWT token
+func signClaims(claims *Claims, cfg *config.Config) (string, error) {
     // Create token
     token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
@@ -40,8 +85,36 @@ func GenerateToken(userID int64, email string, cfg *config.Config) (string, erro
     return tokenString, nil
 }
 
-// ValidateToken validates a JWT token
+// ValidateToken validates a JWT access token
 func ValidateToken(tokenString string, cfg *config.Config) (*Claims, error) {
+    return validateToken(tokenString, "", cfg)
+}
+
+// ValidateMFAToken validates an MFA pending token
+func ValidateMFAToken(tokenString string, cfg *config.Config) (*Claims, error) {
+    return validateToken(tokenString, PurposeMFAPending, cfg)
+}
+
+// ValidatePasswordResetToken validates a password reset token. Callers must also check
+// ResetsPassword against the user's current password hash, which makes the token single-use.
+func ValidatePasswordResetToken(tokenString string, cfg *config.Config) (*Claims, error) {
+    return validateToken(tokenString, PurposePasswordReset, cfg)
+}
+
+// ResetsPassword checks if a password reset token was issued for the password with the given hash
+func (c *Claims) ResetsPassword(passwordHash string, cfg *config.Config) bool {
+    return c.PasswordFingerprint != "" && hmac.Equal([]byte(c.PasswordFingerprint), []byte(passwordFingerprint(passwordHash, cfg)))
+}
+
+// passwordFingerprint derives a value from a password hash that changes whenever the password does
+func passwordFingerprint(passwordHash string, cfg *config.Config) string {
+    mac := hmac.New(sha256.New, []byte(cfg.JWT.Secret))
+    mac.Write([]byte(PurposePasswordReset + ":" + passwordHash))
+    return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// validateToken validates a JWT token and checks it was issued for the given purpose
+func validateToken(tokenString, purpose string, cfg *config.Config) (*Claims, error) {
     // Parse token
     token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
         // Validate signing method
@@ -57,7 +130,7 @@ func ValidateToken(tokenString string, cfg *config.Config) (*Claims, error) {
     }
 
     // Get claims
-    if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+    if claims, ok := token.Claims.(*Claims); ok && token.Valid && claims.Purpose == purpose {
         return claims, nil
     }
 
@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"time"
+	"sync"
 
 	"github.com/RanitManik/zyply/internal/config"
 	"github.com/RanitManik/zyply/internal/models"
+	"github.com/RanitManik/zyply/internal/tracing"
+	"github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/github"
 	"golang.org/x/oauth2/google"
@@ -51,18 +53,76 @@ func GetGoogleOAuthConfig(cfg *config.Config) *oauth2.Config {
 		ClientID:     cfg.OAuth.Google.ClientID,
 		ClientSecret: cfg.OAuth.Google.ClientSecret,
 		RedirectURL:  cfg.OAuth.Google.RedirectURL,
-		Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"},
+		Scopes:       []string{oidc.ScopeOpenID, "https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"},
 		Endpoint:     google.Endpoint,
 	}
 }
 
+// googleIssuer is the OIDC issuer used to verify Google ID tokens
+const googleIssuer = "https://accounts.google.com"
+
+var (
+	googleProviderMu sync.Mutex
+	googleProvider   *oidc.Provider
+)
+
+// getGoogleOIDCProvider returns the cached Google OIDC provider, discovering it on first use
+func getGoogleOIDCProvider(ctx context.Context) (*oidc.Provider, error) {
+	googleProviderMu.Lock()
+	defer googleProviderMu.Unlock()
+
+	if googleProvider == nil {
+		provider, err := oidc.NewProvider(ctx, googleIssuer)
+		if err != nil {
+			return nil, err
+		}
+		googleProvider = provider
+	}
+
+	return googleProvider, nil
+}
+
+// VerifyGoogleIDToken verifies the ID token returned with a Google OAuth token
+// and checks that it carries the nonce sent with the authorization request
+func VerifyGoogleIDToken(ctx context.Context, token *oauth2.Token, nonce string, cfg *config.Config) (*oidc.IDToken, error) {
+	// Get raw ID token
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, errors.New("no id_token in token response")
+	}
+
+	// Verify signature, issuer, audience and expiry
+	provider, err := getGoogleOIDCProvider(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover Google OIDC provider: %w", err)
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: cfg.OAuth.Google.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify nonce
+	if nonce == "" || idToken.Nonce != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	return idToken, nil
+}
+
+// OAuthContext returns a context whose OAuth token exchanges, OIDC discovery and provider API
+// calls go through the traced HTTP client
+func OAuthContext(ctx context.Context) context.Context {
+	return oidc.ClientContext(ctx, tracing.HTTPClient)
+}
+
 // GetGitHubUser gets user information from GitHub
-func GetGitHubUser(token *oauth2.Token, cfg *config.Config) (*GitHubUser, error) {
+func GetGitHubUser(ctx context.Context, token *oauth2.Token, cfg *config.Config) (*GitHubUser, error) {
 	// Create client
-	client := GetGitHubOAuthConfig(cfg).Client(context.Background(), token)
+	ctx = OAuthContext(ctx)
+	client := GetGitHubOAuthConfig(cfg).Client(ctx, token)
 
 	// Get user info
-	resp, err := client.Get("https://api.github.com/user")
+	resp, err := httpGet(ctx, client, "https://api.github.com/user")
 	if err != nil {
 		return nil, err
 	}
@@ -82,7 +142,7 @@ func GetGitHubUser(token *oauth2.Token, cfg *config.Config) (*GitHubUser, error)
 
 	// If email is not public, get primary email
 	if user.Email == "" {
-		email, err := getGitHubPrimaryEmail(client)
+		email, err := getGitHubPrimaryEmail(ctx, client)
 		if err != nil {
 			return nil, err
 		}
@@ -93,9 +153,9 @@ func GetGitHubUser(token *oauth2.Token, cfg *config.Config) (*GitHubUser, error)
 }
 
 // getGitHubPrimaryEmail gets the primary email from GitHub
-func getGitHubPrimaryEmail(client *http.Client) (string, error) {
+func getGitHubPrimaryEmail(ctx context.Context, client *http.Client) (string, error) {
 	// Get emails
-	resp, err := client.Get("https://api.github.com/user/emails")
+	resp, err := httpGet(ctx, client, "https://api.github.com/user/emails")
 	if err != nil {
 		return "", err
 	}
@@ -128,12 +188,13 @@ func getGitHubPrimaryEmail(client *http.Client) (string, error) {
 }
 
 // GetGoogleUser gets user information from Google
-func GetGoogleUser(token *oauth2.Token, cfg *config.Config) (*GoogleUser, error) {
+func GetGoogleUser(ctx context.Context, token *oauth2.Token, cfg *config.Config) (*GoogleUser, error) {
 	// Create client
-	client := GetGoogleOAuthConfig(cfg).Client(context.Background(), token)
+	ctx = OAuthContext(ctx)
+	client := GetGoogleOAuthConfig(cfg).Client(ctx, token)
 
 	// Get user info
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
+	resp, err := httpGet(ctx, client, "https://www.googleapis.com/oauth2/v2/userinfo")
 	if err != nil {
 		return nil, err
 	}
@@ -154,17 +215,26 @@ func GetGoogleUser(token *oauth2.Token, cfg *config.Config) (*GoogleUser, error)
 	return &user, nil
 }
 
+// httpGet sends a GET request bound to ctx, so it is cancelled and traced with the caller
+func httpGet(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
 // ProcessOAuthUser processes an OAuth user and returns or creates a user
-func ProcessOAuthUser(provider models.OAuthProvider, providerID, email, name string, providerData string) (*models.User, error) {
+func ProcessOAuthUser(ctx context.Context, users models.UserRepository, accounts models.OAuthAccountRepository, provider models.OAuthProvider, providerID, email, name string, providerData string) (*models.User, error) {
 	// Check if OAuth account exists
-	account, err := models.GetOAuthAccount(provider, providerID)
+	account, err := accounts.Get(ctx, provider, providerID)
 	if err != nil {
 		return nil, fmt.Errorf("error checking OAuth account: %w", err)
 	}
 
 	// If account exists, get user
 	if account != nil {
-		user, err := models.GetUserByID(account.UserID)
+		user, err := users.GetByID(ctx, account.UserID)
 		if err != nil {
 			return nil, fmt.Errorf("error getting user: %w", err)
 		}
@@ -172,23 +242,27 @@ func ProcessOAuthUser(provider models.OAuthProvider, providerID, email, name str
 	}
 
 	// Check if user with email exists
-	user, err := models.GetUserByEmail(email)
-	if err != nil && err.Error() != "user not found" {
+	user, err := users.GetByEmail(ctx, email)
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
 		return nil, fmt.Errorf("error checking user: %w", err)
 	}
 
-	// If user doesn't exist, create one
+	// If user doesn't exist, create one along with the OAuth account
 	if user == nil {
 		// Generate random password for OAuth users
-		password := generateRandomPassword()
-		user, err = models.CreateUser(name, email, password)
+		password, err := generateRandomPassword()
+		if err != nil {
+			return nil, fmt.Errorf("error generating password: %w", err)
+		}
+		user, err = users.CreateWithOAuthAccount(ctx, name, email, password, provider, providerID, providerData)
 		if err != nil {
 			return nil, fmt.Errorf("error creating user: %w", err)
 		}
+		return user, nil
 	}
 
-	// Create OAuth account
-	_, err = models.CreateOAuthAccount(user.ID, provider, providerID, providerData)
+	// Link OAuth account to the existing user
+	_, err = accounts.Create(ctx, user.ID, provider, providerID, providerData)
 	if err != nil {
 		return nil, fmt.Errorf("error creating OAuth account: %w", err)
 	}
@@ -197,7 +271,6 @@ func ProcessOAuthUser(provider models.OAuthProvider, providerID, email, name str
 }
 
 // generateRandomPassword generates a random password for OAuth users
-func generateRandomPassword() string {
-	// In a real application, use a proper random generator
-	return "oauth-user-" + fmt.Sprintf("%d", time.Now().UnixNano())
+func generateRandomPassword() (string, error) {
+	return randomString(32)
 }
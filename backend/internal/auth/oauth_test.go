@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const (
+	testOIDCClientID = "zyply"
+	testOIDCKeyID    = "test-key"
+	testOIDCCode     = "authorization-code"
+)
+
+// stubIdP is a minimal OpenID Connect provider serving discovery, JWKS, token and userinfo endpoints
+type stubIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	// signingKey signs ID tokens; it is key unless a test swaps it out
+	signingKey *rsa.PrivateKey
+	claims     jwt.MapClaims
+	userInfo   map[string]interface{}
+	verifier   string
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &stubIdP{key: key, signingKey: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]interface{}{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/jwks",
+			"userinfo_endpoint":                     idp.URL + "/userinfo",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": testOIDCKeyID,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != testOIDCCode || r.FormValue("code_verifier") != idp.verifier {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.claims)
+		token.Header["kid"] = testOIDCKeyID
+		idToken, err := token.SignedString(idp.signingKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeTestJSON(w, map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" || idp.userInfo == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeTestJSON(w, idp.userInfo)
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+
+	return idp
+}
+
+func writeTestJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// useStubGoogle makes Google ID tokens verify against the stub IdP
+func useStubGoogle(t *testing.T, idp *stubIdP) {
+	t.Helper()
+	provider, err := oidc.NewProvider(context.Background(), idp.URL)
+	if err != nil {
+		t.Fatalf("NewProvider() = %v", err)
+	}
+
+	googleProviderMu.Lock()
+	previous := googleProvider
+	googleProvider = provider
+	googleProviderMu.Unlock()
+	t.Cleanup(func() {
+		googleProviderMu.Lock()
+		googleProvider = previous
+		googleProviderMu.Unlock()
+	})
+}
+
+func TestVerifyGoogleIDToken(t *testing.T) {
+	idp := newStubIdP(t)
+	useStubGoogle(t, idp)
+	cfg := &config.Config{}
+	cfg.OAuth.Google.ClientID = testOIDCClientID
+
+	tokenWith := func(claims jwt.MapClaims) *oauth2.Token {
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		idToken.Header["kid"] = testOIDCKeyID
+		raw, err := idToken.SignedString(idp.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return (&oauth2.Token{AccessToken: "access-token"}).WithExtra(map[string]interface{}{"id_token": raw})
+	}
+	claims := func(nonce string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   idp.URL,
+			"aud":   testOIDCClientID,
+			"sub":   "google-user",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": nonce,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		token   *oauth2.Token
+		nonce   string
+		wantErr bool
+	}{
+		{"matching nonce", tokenWith(claims("expected-nonce")), "expected-nonce", false},
+		{"wrong nonce", tokenWith(claims("attacker-nonce")), "expected-nonce", true},
+		{"nonce missing from the token", tokenWith(claims("")), "expected-nonce", true},
+		{"no nonce expected", tokenWith(claims("")), "", true},
+		{"no id token", &oauth2.Token{AccessToken: "access-token"}, "expected-nonce", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idToken, err := VerifyGoogleIDToken(context.Background(), tt.token, tt.nonce, cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("VerifyGoogleIDToken() = %+v, want an error", idToken)
+				}
+				return
+			}
+			if err != nil || idToken.Subject != "google-user" {
+				t.Fatalf("VerifyGoogleIDToken() = %+v, %v, want the verified token", idToken, err)
+			}
+		})
+	}
+}
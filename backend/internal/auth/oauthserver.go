@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/models"
+)
+
+const (
+	// OAuthAccessTokenPrefix identifies access tokens issued by the authorization server
+	OAuthAccessTokenPrefix = "zyo_at_"
+	// OAuthRefreshTokenPrefix identifies refresh tokens issued by the authorization server
+	OAuthRefreshTokenPrefix = "zyo_rt_"
+	// OAuthCodeExpiry is how long an authorization code stays valid
+	OAuthCodeExpiry = 10 * time.Minute
+	// OAuthAccessTokenExpiry is how long an access token stays valid
+	OAuthAccessTokenExpiry = time.Hour
+	// OAuthRefreshTokenExpiry is how long a refresh token stays valid
+	OAuthRefreshTokenExpiry = 30 * 24 * time.Hour
+)
+
+// ErrInvalidOAuthToken is returned when an OAuth access token is unknown, revoked or expired
+var ErrInvalidOAuthToken = errors.New("invalid OAuth token")
+
+// GenerateOAuthClientCredentials generates a client ID and, for confidential clients,
+// a client secret with its hash
+func GenerateOAuthClientCredentials(confidential bool) (clientID, secret, secretHash string, err error) {
+	clientID, err = randomHex(16)
+	if err != nil {
+		return "", "", "", err
+	}
+	if !confidential {
+		return clientID, "", "", nil
+	}
+
+	secret, err = randomString(32)
+	if err != nil {
+		return "", "", "", err
+	}
+	return clientID, secret, HashOAuthSecret(secret), nil
+}
+
+// GenerateOAuthSecret generates a random authorization code or token with the given prefix
+// and returns it along with the hash to store
+func GenerateOAuthSecret(prefix string) (secret, hash string, err error) {
+	random, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	secret = prefix + random
+	return secret, HashOAuthSecret(secret), nil
+}
+
+// HashOAuthSecret hashes a client secret, authorization code or token for storage
+func HashOAuthSecret(secret string) string {
+	return HashAPIKeySecret(secret)
+}
+
+// VerifyOAuthClientSecret checks a client secret against a confidential client's stored hash
+func VerifyOAuthClientSecret(client *models.OAuthClient, secret string) bool {
+	return client.Confidential() && subtle.ConstantTimeCompare([]byte(client.ClientSecretHash), []byte(HashOAuthSecret(secret))) == 1
+}
+
+// VerifyPKCE checks a PKCE code verifier against an S256 code challenge
+func VerifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// ParseOAuthScopes splits a space-delimited scope parameter and checks every scope is valid
+func ParseOAuthScopes(scope string) ([]string, bool) {
+	scopes := strings.Fields(scope)
+	for _, s := range scopes {
+		if !ValidScope(s) {
+			return nil, false
+		}
+	}
+	return scopes, len(scopes) > 0
+}
+
+// ValidRedirectURI checks if a URI can be registered as an OAuth redirect URI.
+// HTTPS is required except for loopback addresses used by native apps.
+func ValidRedirectURI(uri string) bool {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Fragment != "" || parsed.Host == "" {
+		return false
+	}
+	switch parsed.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := parsed.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
+
+// IsOAuthAccessToken checks if a bearer credential is an access token issued by the authorization server
+func IsOAuthAccessToken(token string) bool {
+	return strings.HasPrefix(token, OAuthAccessTokenPrefix)
+}
+
+// ValidateOAuthAccessToken validates an access token against the issued tokens and returns the stored token
+func ValidateOAuthAccessToken(ctx context.Context, tokens models.OAuthServerRepository, token string) (*models.OAuthToken, error) {
+	stored, err := tokens.GetToken(ctx, HashOAuthSecret(token))
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil || stored.TokenType != models.OAuthTokenAccess || !stored.Active() {
+		return nil, ErrInvalidOAuthToken
+	}
+	return stored, nil
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/models"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ErrUnknownOIDCProvider is returned when no OIDC provider is configured with the requested name
+var ErrUnknownOIDCProvider = errors.New("unknown OIDC provider")
+
+// OIDCUser represents a user returned by a generic OIDC provider
+type OIDCUser struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Picture           string `json:"picture"`
+}
+
+// OIDCProvider is a discovered OpenID Connect provider
+type OIDCProvider struct {
+	Name     string
+	OAuth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	provider *oidc.Provider
+}
+
+// OIDCProviders holds the configured OIDC providers and discovers them on first use
+type OIDCProviders struct {
+	// providers is fixed at construction, so only each entry needs locking
+	providers map[string]*oidcProviderEntry
+}
+
+// oidcProviderEntry is a configured provider and, once discovered, its endpoints and keys.
+// Its lock only serializes discovery of this provider, so a slow or unreachable issuer does
+// not hold up logins through the others.
+type oidcProviderEntry struct {
+	mu         sync.Mutex
+	config     config.OIDCProviderConfig
+	discovered *OIDCProvider
+}
+
+// NewOIDCProviders creates a registry for the OIDC providers declared in the config
+func NewOIDCProviders(cfg *config.Config) *OIDCProviders {
+	providers := make(map[string]*oidcProviderEntry, len(cfg.OAuth.OIDC))
+	for _, providerConfig := range cfg.OAuth.OIDC {
+		providers[providerConfig.Name] = &oidcProviderEntry{config: providerConfig}
+	}
+
+	return &OIDCProviders{providers: providers}
+}
+
+// Get returns the named provider, fetching its .well-known/openid-configuration if needed.
+// An *http.Client set on ctx with oidc.ClientContext is used for discovery.
+func (p *OIDCProviders) Get(ctx context.Context, name string) (*OIDCProvider, error) {
+	// Get provider config
+	entry, ok := p.providers[name]
+	if !ok {
+		return nil, ErrUnknownOIDCProvider
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	// Return cached provider
+	if entry.discovered != nil {
+		return entry.discovered, nil
+	}
+
+	// Discover provider
+	discovered, err := oidc.NewProvider(ctx, entry.config.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider %q: %w", name, err)
+	}
+
+	entry.discovered = &OIDCProvider{
+		Name: name,
+		OAuth2: &oauth2.Config{
+			ClientID:     entry.config.ClientID,
+			ClientSecret: entry.config.ClientSecret,
+			RedirectURL:  entry.config.RedirectURL,
+			Scopes:       entry.config.Scopes,
+			Endpoint:     discovered.Endpoint(),
+		},
+		verifier: discovered.Verifier(&oidc.Config{ClientID: entry.config.ClientID}),
+		provider: discovered,
+	}
+
+	return entry.discovered, nil
+}
+
+// Has reports whether a provider with the given name is configured
+func (p *OIDCProviders) Has(name string) bool {
+	_, ok := p.providers[name]
+	return ok
+}
+
+// OAuthProvider returns the provider value stored in oauth_accounts for this provider
+func (p *OIDCProvider) OAuthProvider() models.OAuthProvider {
+	return models.OAuthProvider(p.Name)
+}
+
+// AuthCodeURL returns the authorization URL for the given OAuth state
+func (p *OIDCProvider) AuthCodeURL(state *OAuthState) string {
+	return p.OAuth2.AuthCodeURL(state.State, oauth2.S256ChallengeOption(state.CodeVerifier), oidc.Nonce(state.Nonce))
+}
+
+// Exchange exchanges an authorization code, verifies the returned ID token and
+// returns the user it identifies
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, state *OAuthState) (*OIDCUser, error) {
+	// Exchange code for token
+	token, err := p.OAuth2.Exchange(ctx, code, oauth2.VerifierOption(state.CodeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code for token: %w", err)
+	}
+
+	// Verify ID token
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, errors.New("no id_token in token response")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if state.Nonce == "" || idToken.Nonce != state.Nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	// Read claims from the ID token
+	var user OIDCUser
+	if err := idToken.Claims(&user); err != nil {
+		return nil, err
+	}
+
+	// Fall back to the userinfo endpoint when the ID token has no email
+	if user.Email == "" && p.provider.UserInfoEndpoint() != "" {
+		userInfo, err := p.provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user info: %w", err)
+		}
+		if userInfo.Subject != idToken.Subject {
+			return nil, errors.New("userinfo subject mismatch")
+		}
+		if err := userInfo.Claims(&user); err != nil {
+			return nil, err
+		}
+	}
+	user.Subject = idToken.Subject
+
+	// Require a verified email since users are matched by email
+	if user.Email == "" || !user.EmailVerified {
+		return nil, errors.New("OIDC provider did not return a verified email")
+	}
+	if user.Name == "" {
+		user.Name = user.PreferredUsername
+	}
+	if user.Name == "" {
+		user.Name = user.Email
+	}
+
+	return &user, nil
+}
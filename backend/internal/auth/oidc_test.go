@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// newTestOIDCProvider discovers the stub IdP as the provider named "corp"
+func newTestOIDCProvider(t *testing.T, idp *stubIdP) *OIDCProvider {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.OAuth.OIDC = []config.OIDCProviderConfig{{
+		Name:         "corp",
+		IssuerURL:    idp.URL,
+		ClientID:     testOIDCClientID,
+		ClientSecret: "secret",
+		RedirectURL:  "https://app.example.com/api/auth/oidc/corp/callback",
+		Scopes:       []string{"openid", "email", "profile"},
+	}}
+
+	provider, err := NewOIDCProviders(cfg).Get(context.Background(), "corp")
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	return provider
+}
+
+func TestOIDCProvidersGet(t *testing.T) {
+	idp := newStubIdP(t)
+	cfg := &config.Config{}
+	cfg.OAuth.OIDC = []config.OIDCProviderConfig{{Name: "corp", IssuerURL: idp.URL, ClientID: testOIDCClientID}}
+	providers := NewOIDCProviders(cfg)
+
+	if providers.Has("other") {
+		t.Error("Has(other) = true, want false")
+	}
+	if _, err := providers.Get(context.Background(), "other"); !errors.Is(err, ErrUnknownOIDCProvider) {
+		t.Errorf("Get(other) = %v, want ErrUnknownOIDCProvider", err)
+	}
+
+	first, err := providers.Get(context.Background(), "corp")
+	if err != nil {
+		t.Fatalf("Get(corp) = %v", err)
+	}
+	if first.OAuth2.Endpoint.TokenURL != idp.URL+"/token" {
+		t.Errorf("token URL = %q, want the discovered endpoint", first.OAuth2.Endpoint.TokenURL)
+	}
+
+	// Discovery runs once
+	idp.Close()
+	second, err := providers.Get(context.Background(), "corp")
+	if err != nil || second != first {
+		t.Errorf("Get(corp) after discovery = %p, %v, want the cached provider %p", second, err, first)
+	}
+}
+
+func TestOIDCProvidersDiscoverIndependently(t *testing.T) {
+	idp := newStubIdP(t)
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	cfg := &config.Config{}
+	cfg.OAuth.OIDC = []config.OIDCProviderConfig{
+		{Name: "slow", IssuerURL: slow.URL, ClientID: testOIDCClientID},
+		{Name: "corp", IssuerURL: idp.URL, ClientID: testOIDCClientID},
+	}
+	providers := NewOIDCProviders(cfg)
+
+	// While one issuer hangs, the other provider is still discovered
+	started := make(chan struct{})
+	go func() {
+		close(started)
+		providers.Get(context.Background(), "slow")
+	}()
+	<-started
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := providers.Get(context.Background(), "corp")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Get(corp) = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get(corp) blocked on discovery of another provider")
+	}
+}
+
+func TestOIDCProviderAuthCodeURL(t *testing.T) {
+	provider := newTestOIDCProvider(t, newStubIdP(t))
+	state, err := NewOAuthState(provider.OAuthProvider(), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := url.Parse(provider.AuthCodeURL(state))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	want := map[string]string{
+		"client_id":             testOIDCClientID,
+		"state":                 state.State,
+		"nonce":                 state.Nonce,
+		"code_challenge":        oauth2.S256ChallengeFromVerifier(state.CodeVerifier),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if got := query.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestOIDCProviderExchange(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		setup   func(idp *stubIdP, state *OAuthState)
+		want    *OIDCUser
+		wantErr bool
+	}{
+		{
+			name: "verified email in the id token",
+			want: &OIDCUser{Subject: "user-1", Email: "ada@example.com", EmailVerified: true, Name: "Ada"},
+		},
+		{
+			name: "name falls back to the preferred username",
+			setup: func(idp *stubIdP, state *OAuthState) {
+				delete(idp.claims, "name")
+				idp.claims["preferred_username"] = "ada"
+			},
+			want: &OIDCUser{Subject: "user-1", Email: "ada@example.com", EmailVerified: true, Name: "ada", PreferredUsername: "ada"},
+		},
+		{
+			name: "email from userinfo",
+			setup: func(idp *stubIdP, state *OAuthState) {
+				delete(idp.claims, "email")
+				delete(idp.claims, "email_verified")
+				idp.userInfo = map[string]interface{}{"sub": "user-1", "email": "ada@example.com", "email_verified": true}
+			},
+			want: &OIDCUser{Subject: "user-1", Email: "ada@example.com", EmailVerified: true, Name: "Ada"},
+		},
+		{
+			name: "userinfo for another subject",
+			setup: func(idp *stubIdP, state *OAuthState) {
+				delete(idp.claims, "email")
+				idp.userInfo = map[string]interface{}{"sub": "user-2", "email": "ada@example.com", "email_verified": true}
+			},
+			wantErr: true,
+		},
+		{
+			name:    "unverified email",
+			setup:   func(idp *stubIdP, state *OAuthState) { idp.claims["email_verified"] = false },
+			wantErr: true,
+		},
+		{
+			name:    "wrong nonce",
+			setup:   func(idp *stubIdP, state *OAuthState) { idp.claims["nonce"] = "other" },
+			wantErr: true,
+		},
+		{
+			name:    "missing nonce",
+			setup:   func(idp *stubIdP, state *OAuthState) { delete(idp.claims, "nonce") },
+			wantErr: true,
+		},
+		{
+			name:    "wrong audience",
+			setup:   func(idp *stubIdP, state *OAuthState) { idp.claims["aud"] = "other-client" },
+			wantErr: true,
+		},
+		{
+			name:    "wrong issuer",
+			setup:   func(idp *stubIdP, state *OAuthState) { idp.claims["iss"] = "https://evil.example.com" },
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			setup:   func(idp *stubIdP, state *OAuthState) { idp.claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+			wantErr: true,
+		},
+		{
+			name:    "signed with an unknown key",
+			setup:   func(idp *stubIdP, state *OAuthState) { idp.signingKey = otherKey },
+			wantErr: true,
+		},
+		{
+			name:    "wrong code verifier",
+			setup:   func(idp *stubIdP, state *OAuthState) { idp.verifier = oauth2.GenerateVerifier() },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newStubIdP(t)
+			provider := newTestOIDCProvider(t, idp)
+			state, err := NewOAuthState(provider.OAuthProvider(), "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			idp.verifier = state.CodeVerifier
+			idp.claims = jwt.MapClaims{
+				"iss":            idp.URL,
+				"aud":            testOIDCClientID,
+				"sub":            "user-1",
+				"exp":            time.Now().Add(time.Hour).Unix(),
+				"iat":            time.Now().Unix(),
+				"nonce":          state.Nonce,
+				"email":          "ada@example.com",
+				"email_verified": true,
+				"name":           "Ada",
+			}
+			if tt.setup != nil {
+				tt.setup(idp, state)
+			}
+
+			user, err := provider.Exchange(context.Background(), testOIDCCode, state)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Exchange() = %+v, want an error", user)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange() = %v", err)
+			}
+			if *user != *tt.want {
+				t.Errorf("Exchange() = %+v, want %+v", user, tt.want)
+			}
+		})
+	}
+}
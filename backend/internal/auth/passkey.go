@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/models"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// PasskeyUser adapts a user and their stored credentials to webauthn.User
+type PasskeyUser struct {
+	User        *models.User
+	Credentials []webauthn.Credential
+}
+
+// NewWebAuthn creates the WebAuthn relying party from the config
+func NewWebAuthn(cfg *config.Config) (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthn.RPID,
+		RPDisplayName: cfg.WebAuthn.RPDisplayName,
+		RPOrigins:     cfg.WebAuthn.RPOrigins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+	})
+}
+
+// NewPasskeyUser decodes a user's stored credentials into a PasskeyUser
+func NewPasskeyUser(user *models.User, stored []*models.PasskeyCredential) (*PasskeyUser, error) {
+	credentials := make([]webauthn.Credential, 0, len(stored))
+	for _, s := range stored {
+		var credential webauthn.Credential
+		if err := json.Unmarshal(s.Credential, &credential); err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+
+	return &PasskeyUser{User: user, Credentials: credentials}, nil
+}
+
+// PasskeyUserHandle returns the WebAuthn user handle for a user ID
+func PasskeyUserHandle(userID int64) []byte {
+	return []byte(strconv.FormatInt(userID, 10))
+}
+
+// ParsePasskeyUserHandle returns the user ID encoded in a WebAuthn user handle
+func ParsePasskeyUserHandle(userHandle []byte) (int64, error) {
+	return strconv.ParseInt(string(userHandle), 10, 64)
+}
+
+// WebAuthnID returns the user handle
+func (u *PasskeyUser) WebAuthnID() []byte {
+	return PasskeyUserHandle(u.User.ID)
+}
+
+// WebAuthnName returns the user's email
+func (u *PasskeyUser) WebAuthnName() string {
+	return u.User.Email
+}
+
+// WebAuthnDisplayName returns the user's name
+func (u *PasskeyUser) WebAuthnDisplayName() string {
+	return u.User.Name
+}
+
+// WebAuthnCredentials returns the user's registered credentials
+func (u *PasskeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.Credentials
+}
+
+// WebAuthnIcon returns an empty icon, which is deprecated in the specification
+func (u *PasskeyUser) WebAuthnIcon() string {
+	return ""
+}
+
+// CredentialDescriptors returns descriptors for the user's credentials, used to exclude them on registration
+func (u *PasskeyUser) CredentialDescriptors() []protocol.CredentialDescriptor {
+	descriptors := make([]protocol.CredentialDescriptor, len(u.Credentials))
+	for i, credential := range u.Credentials {
+		descriptors[i] = credential.Descriptor()
+	}
+	return descriptors
+}
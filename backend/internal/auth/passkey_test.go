@@ -0,0 +1,393 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/models"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// softAuthenticator is a software passkey authenticator holding a single ES256 credential
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T, userHandle []byte) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{key: key, credentialID: credentialID, userHandle: userHandle}
+}
+
+// ceremony describes what the authenticator and client put in a response
+type ceremony struct {
+	challenge string
+	origin    string
+	flags     protocol.AuthenticatorFlags
+	// signer signs assertions instead of the credential's key when set
+	signer *ecdsa.PrivateKey
+}
+
+// authData builds authenticator data, with the attested credential when registering
+func (a *softAuthenticator) authData(t *testing.T, flags protocol.AuthenticatorFlags, attested bool) []byte {
+	t.Helper()
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append([]byte{}, rpIDHash[:]...)
+	if attested {
+		flags |= protocol.FlagAttestedCredentialData
+	}
+	data = append(data, byte(flags))
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if !attested {
+		return data
+	}
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, make([]byte, 16)...) // AAGUID
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+	data = append(data, a.credentialID...)
+	return append(data, publicKey...)
+}
+
+// clientData builds the client data JSON the browser would send
+func clientData(t *testing.T, ceremonyType protocol.CeremonyType, c ceremony) []byte {
+	t.Helper()
+	data, err := json.Marshal(protocol.CollectedClientData{
+		Type:      ceremonyType,
+		Challenge: c.challenge,
+		Origin:    c.origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// register creates the credential and returns the parsed attestation response with "none" attestation
+func (a *softAuthenticator) register(t *testing.T, c ceremony) *protocol.ParsedCredentialCreationData {
+	t.Helper()
+	attestationObject, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(t, c.flags, true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(a.response(t, map[string]string{
+		"clientDataJSON":    encode(clientData(t, protocol.CreateCeremony, c)),
+		"attestationObject": encode(attestationObject),
+	}))
+	if err != nil {
+		t.Fatalf("ParseCredentialCreationResponseBody() = %v", err)
+	}
+	return parsed
+}
+
+// assert signs a login challenge and returns the parsed assertion response
+func (a *softAuthenticator) assert(t *testing.T, c ceremony) *protocol.ParsedCredentialAssertionData {
+	t.Helper()
+	a.signCount++
+	authData := a.authData(t, c.flags, false)
+	clientDataJSON := clientData(t, protocol.AssertCeremony, c)
+
+	signer := a.key
+	if c.signer != nil {
+		signer = c.signer
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, signer, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(a.response(t, map[string]string{
+		"clientDataJSON":    encode(clientDataJSON),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(a.userHandle),
+	}))
+	if err != nil {
+		t.Fatalf("ParseCredentialRequestResponseBody() = %v", err)
+	}
+	return parsed
+}
+
+// response wraps an authenticator response in a PublicKeyCredential JSON body
+func (a *softAuthenticator) response(t *testing.T, response map[string]string) *bytes.Reader {
+	t.Helper()
+	body, err := json.Marshal(map[string]interface{}{
+		"id":       encode(a.credentialID),
+		"rawId":    encode(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(body)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func newTestWebAuthn(t *testing.T) *webauthn.WebAuthn {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.WebAuthn.RPID = testRPID
+	cfg.WebAuthn.RPDisplayName = "Zyply"
+	cfg.WebAuthn.RPOrigins = []string{testOrigin}
+	wa, err := NewWebAuthn(cfg)
+	if err != nil {
+		t.Fatalf("NewWebAuthn() = %v", err)
+	}
+	return wa
+}
+
+// registerPasskey registers a passkey for user and returns the user with the credential as it is stored
+func registerPasskey(t *testing.T, wa *webauthn.WebAuthn, user *models.User, authenticator *softAuthenticator) *PasskeyUser {
+	t.Helper()
+	passkeyUser, err := NewPasskeyUser(user, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, session, err := wa.BeginRegistration(passkeyUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := authenticator.register(t, ceremony{
+		challenge: session.Challenge,
+		origin:    testOrigin,
+		flags:     protocol.FlagUserPresent | protocol.FlagUserVerified,
+	})
+	credential, err := wa.CreateCredential(passkeyUser, *session, parsed)
+	if err != nil {
+		t.Fatalf("CreateCredential() = %v", err)
+	}
+
+	encoded, err := json.Marshal(credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := NewPasskeyUser(user, []*models.PasskeyCredential{{Credential: encoded}})
+	if err != nil {
+		t.Fatalf("NewPasskeyUser() = %v", err)
+	}
+	return stored
+}
+
+func TestPasskeyRegistration(t *testing.T) {
+	wa := newTestWebAuthn(t)
+	user := &models.User{ID: 42, Email: "ada@example.com", Name: "Ada"}
+
+	tests := []struct {
+		name    string
+		modify  func(c *ceremony)
+		wantErr bool
+	}{
+		{name: "valid"},
+		{name: "wrong origin", modify: func(c *ceremony) { c.origin = "https://evil.example.com" }, wantErr: true},
+		{name: "wrong challenge", modify: func(c *ceremony) { c.challenge = encode([]byte("another challenge")) }, wantErr: true},
+		{name: "user not verified", modify: func(c *ceremony) { c.flags = protocol.FlagUserPresent }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passkeyUser, err := NewPasskeyUser(user, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			creation, session, err := wa.BeginRegistration(passkeyUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(creation.Response.User.ID.(protocol.URLEncodedBase64), PasskeyUserHandle(user.ID)) {
+				t.Errorf("user ID = %v, want the user handle", creation.Response.User.ID)
+			}
+
+			c := ceremony{
+				challenge: session.Challenge,
+				origin:    testOrigin,
+				flags:     protocol.FlagUserPresent | protocol.FlagUserVerified,
+			}
+			if tt.modify != nil {
+				tt.modify(&c)
+			}
+			authenticator := newSoftAuthenticator(t, PasskeyUserHandle(user.ID))
+			credential, err := wa.CreateCredential(passkeyUser, *session, authenticator.register(t, c))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("CreateCredential() = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateCredential() = %v", err)
+			}
+			if !bytes.Equal(credential.ID, authenticator.credentialID) {
+				t.Errorf("credential ID = %x, want %x", credential.ID, authenticator.credentialID)
+			}
+		})
+	}
+}
+
+func TestPasskeyRegistrationExcludesRegisteredCredentials(t *testing.T) {
+	wa := newTestWebAuthn(t)
+	user := &models.User{ID: 42, Email: "ada@example.com", Name: "Ada"}
+	authenticator := newSoftAuthenticator(t, PasskeyUserHandle(user.ID))
+	passkeyUser := registerPasskey(t, wa, user, authenticator)
+
+	creation, _, err := wa.BeginRegistration(passkeyUser, webauthn.WithExclusions(passkeyUser.CredentialDescriptors()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	excluded := creation.Response.CredentialExcludeList
+	if len(excluded) != 1 || !bytes.Equal(excluded[0].CredentialID, authenticator.credentialID) {
+		t.Errorf("excluded credentials = %v, want the registered passkey", excluded)
+	}
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *ceremony, a *softAuthenticator)
+		wantErr bool
+	}{
+		{name: "valid"},
+		{name: "wrong origin", modify: func(c *ceremony, a *softAuthenticator) { c.origin = "https://evil.example.com" }, wantErr: true},
+		{name: "wrong challenge", modify: func(c *ceremony, a *softAuthenticator) { c.challenge = encode([]byte("another challenge")) }, wantErr: true},
+		{name: "user not verified", modify: func(c *ceremony, a *softAuthenticator) { c.flags = protocol.FlagUserPresent }, wantErr: true},
+		{name: "signed with another key", modify: func(c *ceremony, a *softAuthenticator) { c.signer = otherKey }, wantErr: true},
+		{name: "another user's handle", modify: func(c *ceremony, a *softAuthenticator) { a.userHandle = PasskeyUserHandle(7) }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wa := newTestWebAuthn(t)
+			grace := &models.User{ID: 7, Email: "grace@example.com"}
+			user := &models.User{ID: 42, Email: "ada@example.com"}
+			authenticator := newSoftAuthenticator(t, PasskeyUserHandle(user.ID))
+			users := map[int64]*PasskeyUser{
+				grace.ID: registerPasskey(t, wa, grace, newSoftAuthenticator(t, PasskeyUserHandle(grace.ID))),
+				user.ID:  registerPasskey(t, wa, user, authenticator),
+			}
+
+			_, session, err := wa.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := ceremony{
+				challenge: session.Challenge,
+				origin:    testOrigin,
+				flags:     protocol.FlagUserPresent | protocol.FlagUserVerified,
+			}
+			if tt.modify != nil {
+				tt.modify(&c, authenticator)
+			}
+
+			credential, err := wa.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+				userID, err := ParsePasskeyUserHandle(userHandle)
+				if err != nil {
+					return nil, err
+				}
+				return users[userID], nil
+			}, *session, authenticator.assert(t, c))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("ValidateDiscoverableLogin() = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateDiscoverableLogin() = %v", err)
+			}
+			if credential.Authenticator.SignCount != authenticator.signCount || credential.Authenticator.CloneWarning {
+				t.Errorf("authenticator = %+v, want sign count %d without a clone warning", credential.Authenticator, authenticator.signCount)
+			}
+		})
+	}
+}
+
+func TestPasskeyLoginDetectsClonedAuthenticator(t *testing.T) {
+	wa := newTestWebAuthn(t)
+	user := &models.User{ID: 42, Email: "ada@example.com"}
+	authenticator := newSoftAuthenticator(t, PasskeyUserHandle(user.ID))
+	authenticator.signCount = 10
+	passkeyUser := registerPasskey(t, wa, user, authenticator)
+
+	// A copy of the authenticator reports a sign count that is not ahead of the stored one
+	authenticator.signCount = 5
+	_, session, err := wa.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := authenticator.assert(t, ceremony{
+		challenge: session.Challenge,
+		origin:    testOrigin,
+		flags:     protocol.FlagUserPresent | protocol.FlagUserVerified,
+	})
+	credential, err := wa.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		return passkeyUser, nil
+	}, *session, parsed)
+	if err != nil {
+		t.Fatalf("ValidateDiscoverableLogin() = %v", err)
+	}
+	if !credential.Authenticator.CloneWarning {
+		t.Error("CloneWarning = false, want true")
+	}
+}
+
+func TestPasskeyUserHandle(t *testing.T) {
+	handle := PasskeyUserHandle(42)
+	if id, err := ParsePasskeyUserHandle(handle); err != nil || id != 42 {
+		t.Errorf("ParsePasskeyUserHandle(%q) = %d, %v, want 42", handle, id, err)
+	}
+	if _, err := ParsePasskeyUserHandle([]byte("not a user")); err == nil {
+		t.Error("ParsePasskeyUserHandle(not a user) = nil error, want an error")
+	}
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/validate"
+)
+
+// MaxPasswordBytes is the longest password bcrypt can hash
+const MaxPasswordBytes = 72
+
+// CodeBreachedPassword is the field error code for passwords found in a breach
+const CodeBreachedPassword = "breached_password"
+
+// commonPasswordHashes lists SHA-1 hashes of the most common breached passwords
+//
+//go:embed breached_passwords.txt
+var commonPasswordHashes string
+
+// PasswordPolicy decides which new passwords are acceptable
+type PasswordPolicy struct {
+	MinLength int
+	Breached  *BreachedPasswords
+}
+
+// BreachedPasswords is a local list of SHA-1 hashes of breached passwords. Hashes are grouped
+// by their five-character prefix like the k-anonymity range API of Have I Been Pwned, so the
+// list can be loaded from its downloaded ranges and a lookup only compares suffixes in one range.
+type BreachedPasswords struct {
+	ranges map[string]map[string]bool
+}
+
+// NewPasswordPolicy creates the password policy from configuration. The breached password list
+// always includes the embedded common passwords and the hashes in the configured file, if any.
+func NewPasswordPolicy(cfg *config.Config) (*PasswordPolicy, error) {
+	breached := &BreachedPasswords{ranges: map[string]map[string]bool{}}
+	if err := breached.Load(strings.NewReader(commonPasswordHashes)); err != nil {
+		return nil, err
+	}
+
+	if cfg.Password.BreachedHashesFile != "" {
+		file, err := os.Open(cfg.Password.BreachedHashesFile)
+		if err != nil {
+			return nil, fmt.Errorf("error opening breached password list: %w", err)
+		}
+		defer file.Close()
+		if err := breached.Load(file); err != nil {
+			return nil, fmt.Errorf("error loading breached password list: %w", err)
+		}
+	}
+
+	return &PasswordPolicy{MinLength: cfg.Password.MinLength, Breached: breached}, nil
+}
+
+// Check returns why a new password is unacceptable, reported against the named field, or nil
+func (p *PasswordPolicy) Check(field, password string) *validate.FieldError {
+	switch {
+	case password == "":
+		return &validate.FieldError{Field: field, Code: validate.CodeRequired, Message: field + " is required"}
+	case utf8.RuneCountInString(password) < p.MinLength:
+		return &validate.FieldError{Field: field, Code: validate.CodeTooShort, Message: fmt.Sprintf("%s must be at least %d characters", field, p.MinLength)}
+	case len(password) > MaxPasswordBytes:
+		return &validate.FieldError{Field: field, Code: validate.CodeTooLong, Message: fmt.Sprintf("%s must be at most %d bytes", field, MaxPasswordBytes)}
+	case p.Breached.Contains(password):
+		return &validate.FieldError{Field: field, Code: CodeBreachedPassword, Message: field + " has appeared in a data breach, choose a different one"}
+	}
+	return nil
+}
+
+// Load adds hashes from a reader with one uppercase or lowercase SHA-1 hex hash per line.
+// Lines may carry a ":count" suffix as in Have I Been Pwned downloads, and blank lines
+// and lines starting with # are skipped.
+func (b *BreachedPasswords) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if hash == "" || strings.HasPrefix(hash, "#") {
+			continue
+		}
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != sha1.Size*2 {
+			return fmt.Errorf("line %d: invalid SHA-1 hash", line)
+		}
+
+		hash = strings.ToUpper(hash)
+		prefix, suffix := hash[:5], hash[5:]
+		if b.ranges[prefix] == nil {
+			b.ranges[prefix] = map[string]bool{}
+		}
+		b.ranges[prefix][suffix] = true
+	}
+	return scanner.Err()
+}
+
+// Contains checks if a password's hash is in the list
+func (b *BreachedPasswords) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return b.Range(hash[:5])[hash[5:]]
+}
+
+// Range returns the hash suffixes sharing a five-character prefix
+func (b *BreachedPasswords) Range(prefix string) map[string]bool {
+	return b.ranges[strings.ToUpper(prefix)]
+}
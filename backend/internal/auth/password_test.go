@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/validate"
+)
+
+// SHA-1 hashes of test passwords
+const (
+	passwordHash      = "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8" // password
+	horseStapleHash   = "abf7aad6438836dbe526aa231abde2d0eef74d42" // correct horse battery staple
+	testBreachedLines = "# downloaded range\n\n  " + horseStapleHash + ":42\r\n"
+)
+
+func TestBreachedPasswordsLoad(t *testing.T) {
+	breached := &BreachedPasswords{ranges: map[string]map[string]bool{}}
+	if err := breached.Load(strings.NewReader(testBreachedLines + strings.ToLower(passwordHash) + "\n")); err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+
+	// Hashes match whatever case and surrounding whitespace they were listed with
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		{"correct horse battery staple", true},
+		{"Password", false},
+		{"password ", false},
+		{" correct horse battery staple", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := breached.Contains(tt.password); got != tt.want {
+			t.Errorf("Contains(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+
+	// Ranges are looked up by prefix in either case and hold uppercase suffixes
+	if !breached.Range("abf7a")[strings.ToUpper(horseStapleHash[5:])] || !breached.Range("ABF7A")[strings.ToUpper(horseStapleHash[5:])] {
+		t.Errorf("Range(abf7a) = %v, want the staple suffix", breached.Range("abf7a"))
+	}
+}
+
+func TestBreachedPasswordsLoadRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+	}{
+		{"not hex", strings.Repeat("z", 40)},
+		{"too short", passwordHash[:39]},
+		{"too long", passwordHash + "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breached := &BreachedPasswords{ranges: map[string]map[string]bool{}}
+			if err := breached.Load(strings.NewReader(testBreachedLines + tt.list + "\n")); err == nil || !strings.Contains(err.Error(), "line 4") {
+				t.Errorf("Load() = %v, want an error on line 4", err)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Password.MinLength = 8
+	cfg.Password.BreachedHashesFile = filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(cfg.Password.BreachedHashesFile, []byte(testBreachedLines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := NewPasswordPolicy(cfg)
+	if err != nil {
+		t.Fatalf("NewPasswordPolicy() = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		wantCode string
+	}{
+		{"acceptable", "a-long-unique-passphrase", ""},
+		{"missing", "", validate.CodeRequired},
+		{"too short", "seven77", validate.CodeTooShort},
+		{"counted in characters", "пароль12", ""},
+		{"too long for bcrypt", strings.Repeat("é", 37), validate.CodeTooLong},
+		{"common password", "password", CodeBreachedPassword},
+		{"listed in the configured file", "correct horse battery staple", CodeBreachedPassword},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := policy.Check("password", tt.password)
+			if tt.wantCode == "" {
+				if got != nil {
+					t.Errorf("Check() = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.Code != tt.wantCode || got.Field != "password" {
+				t.Errorf("Check() = %+v, want %s on password", got, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/RanitManik/zyply/internal/models"
+)
+
+// Workspace permissions. The links and analytics permissions share their names with
+// the API key and OAuth scopes, so a token must hold both the role and the scope.
+const (
+	// PermissionLinksRead allows viewing the workspace's links
+	PermissionLinksRead = ScopeLinksRead
+	// PermissionLinksWrite allows creating, editing and deleting the workspace's links
+	PermissionLinksWrite = ScopeLinksWrite
+	// PermissionAnalyticsRead allows viewing the workspace's analytics
+	PermissionAnalyticsRead = ScopeAnalyticsRead
+	// PermissionMembersRead allows listing the workspace's members
+	PermissionMembersRead = "members:read"
+	// PermissionMembersManage allows inviting, removing and changing the roles of members
+	PermissionMembersManage = "members:manage"
+	// PermissionOAuthClientsManage allows registering and deleting the workspace's OAuth clients
+	PermissionOAuthClientsManage = "oauth_clients:manage"
+	// PermissionWorkspaceManage allows configuring the workspace, such as its SAML connection
+	PermissionWorkspaceManage = "workspace:manage"
+	// PermissionAuditRead allows viewing the workspace's audit log
+	PermissionAuditRead = "audit:read"
+)
+
+// Permissions lists every workspace permission
+var Permissions = []string{
+	PermissionLinksRead, PermissionLinksWrite, PermissionAnalyticsRead,
+	PermissionMembersRead, PermissionMembersManage,
+	PermissionOAuthClientsManage, PermissionWorkspaceManage, PermissionAuditRead,
+}
+
+// Policy maps each role to the permissions it grants
+type Policy map[string]map[string]bool
+
+// policy is the policy permission checks use. It grants nothing until one is loaded.
+var policy atomic.Value
+
+// NewPolicy creates a policy from the permissions each role grants, rejecting unknown roles and permissions
+func NewPolicy(grants map[string][]string) (Policy, error) {
+	p := Policy{}
+	for role, permissions := range grants {
+		if !models.ValidRole(role) {
+			return nil, fmt.Errorf("unknown role %q", role)
+		}
+		p[role] = map[string]bool{}
+		for _, permission := range permissions {
+			if !validPermission(permission) {
+				return nil, fmt.Errorf("unknown permission %q for role %q", permission, role)
+			}
+			p[role][permission] = true
+		}
+	}
+	return p, nil
+}
+
+// LoadPolicy loads the policy from the role_permissions table
+func LoadPolicy(ctx context.Context) (Policy, error) {
+	grants, err := models.GetRolePermissions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewPolicy(grants)
+}
+
+// SetPolicy sets the policy permission checks use
+func SetPolicy(p Policy) {
+	policy.Store(p)
+}
+
+// Allows checks if a role grants a permission. Unknown roles grant nothing.
+func (p Policy) Allows(role, permission string) bool {
+	return p[role][permission]
+}
+
+// RoleHasPermission checks if a role grants a permission under the loaded policy
+func RoleHasPermission(role, permission string) bool {
+	p, _ := policy.Load().(Policy)
+	return p.Allows(role, permission)
+}
+
+// CanAssignRole checks if a member with one role may give another role to someone.
+// Only owners may create or change owners.
+func CanAssignRole(actorRole, role string) bool {
+	if !RoleHasPermission(actorRole, PermissionMembersManage) {
+		return false
+	}
+	return role != models.RoleOwner || actorRole == models.RoleOwner
+}
+
+// validPermission checks if a permission is known
+func validPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/RanitManik/zyply/internal/models"
+	"github.com/RanitManik/zyply/migrations"
+)
+
+// rolePermissionsMigrations are the migrations that create and seed the policy table
+var rolePermissionsMigrations = []string{
+	"20240101000008_add_membership_roles.sql",
+	"20240101000009_create_audit_events_table.sql",
+}
+
+// expectedPolicy is the role × permission matrix the seeded policy must grant
+var expectedPolicy = map[string]map[string]bool{
+	models.RoleOwner: {
+		PermissionLinksRead: true, PermissionLinksWrite: true, PermissionAnalyticsRead: true,
+		PermissionMembersRead: true, PermissionMembersManage: true,
+		PermissionOAuthClientsManage: true, PermissionWorkspaceManage: true, PermissionAuditRead: true,
+	},
+	models.RoleAdmin: {
+		PermissionLinksRead: true, PermissionLinksWrite: true, PermissionAnalyticsRead: true,
+		PermissionMembersRead: true, PermissionMembersManage: true,
+		PermissionOAuthClientsManage: true, PermissionWorkspaceManage: true, PermissionAuditRead: true,
+	},
+	models.RoleEditor: {
+		PermissionLinksRead: true, PermissionLinksWrite: true, PermissionAnalyticsRead: true,
+		PermissionMembersRead: true,
+	},
+	models.RoleViewer: {
+		PermissionLinksRead: true, PermissionAnalyticsRead: true,
+		PermissionMembersRead: true,
+	},
+}
+
+// seededPolicy builds the policy from the rows the migrations insert into role_permissions
+func seededPolicy(t *testing.T) Policy {
+	t.Helper()
+
+	row := regexp.MustCompile(`\('([a-z_]+)', '([a-z_:]+)'\)`)
+	grants := map[string][]string{}
+	for _, migration := range rolePermissionsMigrations {
+		data, err := migrations.FS.ReadFile(migration)
+		if err != nil {
+			t.Fatalf("reading migration: %v", err)
+		}
+		rows := row.FindAllStringSubmatch(string(data), -1)
+		if len(rows) == 0 {
+			t.Fatalf("migration %s seeds no role permissions", migration)
+		}
+		for _, r := range rows {
+			grants[r[1]] = append(grants[r[1]], r[2])
+		}
+	}
+	p, err := NewPolicy(grants)
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	return p
+}
+
+func TestSeededPolicyMatrix(t *testing.T) {
+	SetPolicy(seededPolicy(t))
+	t.Cleanup(func() { SetPolicy(nil) })
+
+	for _, role := range models.Roles {
+		for _, permission := range Permissions {
+			want := expectedPolicy[role][permission]
+			if got := RoleHasPermission(role, permission); got != want {
+				t.Errorf("RoleHasPermission(%q, %q) = %v, want %v", role, permission, got, want)
+			}
+		}
+	}
+}
+
+func TestUnknownRolesAndPermissionsGrantNothing(t *testing.T) {
+	SetPolicy(seededPolicy(t))
+	t.Cleanup(func() { SetPolicy(nil) })
+
+	for _, permission := range Permissions {
+		for _, role := range []string{"", "member", "OWNER"} {
+			if RoleHasPermission(role, permission) {
+				t.Errorf("RoleHasPermission(%q, %q) = true, want false", role, permission)
+			}
+		}
+	}
+	for _, role := range models.Roles {
+		if RoleHasPermission(role, "billing:manage") {
+			t.Errorf("RoleHasPermission(%q, billing:manage) = true, want false", role)
+		}
+	}
+}
+
+func TestNoPolicyGrantsNothing(t *testing.T) {
+	SetPolicy(nil)
+
+	for _, role := range models.Roles {
+		for _, permission := range Permissions {
+			if RoleHasPermission(role, permission) {
+				t.Errorf("RoleHasPermission(%q, %q) = true without a policy", role, permission)
+			}
+		}
+	}
+}
+
+func TestNewPolicyRejectsUnknownNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		grants map[string][]string
+	}{
+		{"unknown role", map[string][]string{"member": {PermissionLinksRead}}},
+		{"unknown permission", map[string][]string{models.RoleViewer: {"links:delete"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPolicy(tt.grants); err == nil {
+				t.Error("NewPolicy succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestCanAssignRole(t *testing.T) {
+	SetPolicy(seededPolicy(t))
+	t.Cleanup(func() { SetPolicy(nil) })
+
+	// Rows are the actor's role, columns the role being given
+	want := map[string]map[string]bool{
+		models.RoleOwner:  {models.RoleOwner: true, models.RoleAdmin: true, models.RoleEditor: true, models.RoleViewer: true},
+		models.RoleAdmin:  {models.RoleOwner: false, models.RoleAdmin: true, models.RoleEditor: true, models.RoleViewer: true},
+		models.RoleEditor: {models.RoleOwner: false, models.RoleAdmin: false, models.RoleEditor: false, models.RoleViewer: false},
+		models.RoleViewer: {models.RoleOwner: false, models.RoleAdmin: false, models.RoleEditor: false, models.RoleViewer: false},
+	}
+
+	for _, actor := range models.Roles {
+		for _, role := range models.Roles {
+			if got := CanAssignRole(actor, role); got != want[actor][role] {
+				t.Errorf("CanAssignRole(%q, %q) = %v, want %v", actor, role, got, want[actor][role])
+			}
+		}
+	}
+}
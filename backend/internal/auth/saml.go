@@ -0,0 +1,442 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/models"
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+)
+
+// SAMLRequestCookieName is the name of the cookie that tracks a pending SAML AuthnRequest
+const SAMLRequestCookieName = "saml_request"
+
+// samlLinkPurpose is the signing purpose of tickets that link a SAML identity to the current user
+const samlLinkPurpose = "saml_link"
+
+// samlDomainRecordLabel is the label of the DNS TXT record that proves control of an email domain
+const samlDomainRecordLabel = "_zyply-verification"
+
+// ErrSAMLNotConfigured is returned when no SAML service provider key pair is configured
+var ErrSAMLNotConfigured = errors.New("SAML is not configured")
+
+// ErrInvalidSAMLRequest is returned when the SAML request cookie is missing, tampered with or expired
+var ErrInvalidSAMLRequest = errors.New("invalid SAML request")
+
+// ErrInvalidSAMLLink is returned when a SAML link ticket is tampered with, expired or for another organization
+var ErrInvalidSAMLLink = errors.New("invalid SAML link ticket")
+
+// ErrSAMLAccountNotLinked is returned when a SAML identity asserts the email of an existing user
+// it is not linked to. The user must link it from an authenticated session.
+var ErrSAMLAccountNotLinked = errors.New("SAML identity is not linked to the existing account")
+
+// ErrSAMLEmailMismatch is returned when a SAML identity being linked asserts another user's email
+var ErrSAMLEmailMismatch = errors.New("SAML identity email does not match the account")
+
+// TXTResolver looks up DNS TXT records. *net.Resolver implements it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// organizationPattern restricts organization identifiers used in SAML URLs
+var organizationPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,44}$`)
+
+// samlEmailAttributes are the attribute names commonly used by IdPs for the user's email
+var samlEmailAttributes = []string{
+	"email",
+	"mail",
+	"emailAddress",
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+	"urn:oid:0.9.2342.19200300.100.1.3",
+}
+
+// samlNameAttributes are the attribute names commonly used by IdPs for the user's display name
+var samlNameAttributes = []string{
+	"name",
+	"displayName",
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name",
+	"urn:oid:2.16.840.1.113730.3.1.241",
+}
+
+// SAMLUser represents a user asserted by a SAML identity provider
+type SAMLUser struct {
+	NameID string `json:"name_id"`
+	Email  string `json:"email"`
+	Name   string `json:"name"`
+}
+
+// SAMLRequestState holds the data bound to a pending SAML AuthnRequest
+type SAMLRequestState struct {
+	RequestID    string `json:"request_id"`
+	RelayState   string `json:"relay_state"`
+	Organization string `json:"organization"`
+	ReturnTo     string `json:"return_to,omitempty"`
+	// LinkUserID is the authenticated user the asserted identity is linked to, if any
+	LinkUserID int64 `json:"link_user_id,omitempty"`
+	ExpiresAt  int64 `json:"expires_at"`
+}
+
+// samlLinkTicket authorizes linking a SAML identity to a user who started the link while logged in
+type samlLinkTicket struct {
+	UserID       int64  `json:"user_id"`
+	Organization string `json:"organization"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+// SAMLKeyPair is the service provider's signing and encryption key pair
+type SAMLKeyPair struct {
+	Key         *rsa.PrivateKey
+	Certificate *x509.Certificate
+}
+
+// ValidOrganization checks if an organization identifier is valid
+func ValidOrganization(organization string) bool {
+	return organizationPattern.MatchString(organization)
+}
+
+// LoadSAMLKeyPair loads the service provider key pair from the configured files
+func LoadSAMLKeyPair(cfg *config.Config) (*SAMLKeyPair, error) {
+	if cfg.SAML.CertFile == "" || cfg.SAML.KeyFile == "" {
+		return nil, ErrSAMLNotConfigured
+	}
+
+	// Load key pair
+	pair, err := tls.LoadX509KeyPair(cfg.SAML.CertFile, cfg.SAML.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SAML key pair: %w", err)
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("SAML key must be an RSA private key")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SAML certificate: %w", err)
+	}
+
+	return &SAMLKeyPair{Key: key, Certificate: cert}, nil
+}
+
+// ParseIDPMetadata parses and validates uploaded IdP metadata
+func ParseIDPMetadata(metadata []byte) (*saml.EntityDescriptor, error) {
+	descriptor, err := samlsp.ParseMetadata(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IdP metadata: %w", err)
+	}
+	if descriptor.EntityID == "" {
+		return nil, errors.New("invalid IdP metadata: missing entityID")
+	}
+	if len(descriptor.IDPSSODescriptors) == 0 {
+		return nil, errors.New("invalid IdP metadata: missing IDPSSODescriptor")
+	}
+
+	// Require a signing certificate so assertions can be verified
+	for _, sso := range descriptor.IDPSSODescriptors {
+		for _, keyDescriptor := range sso.KeyDescriptors {
+			if keyDescriptor.Use != "encryption" && len(keyDescriptor.KeyInfo.X509Data.X509Certificates) > 0 {
+				return descriptor, nil
+			}
+		}
+	}
+
+	return nil, errors.New("invalid IdP metadata: missing signing certificate")
+}
+
+// NewSAMLServiceProvider builds the service provider for an organization's SAML connection
+func NewSAMLServiceProvider(conn *models.SAMLConnection, keyPair *SAMLKeyPair, cfg *config.Config) (*saml.ServiceProvider, error) {
+	// Parse IdP metadata
+	idpMetadata, err := ParseIDPMetadata([]byte(conn.IDPMetadata))
+	if err != nil {
+		return nil, err
+	}
+
+	// Build SP URLs
+	base := fmt.Sprintf("%s/api/auth/saml/%s", cfg.SAML.BaseURL, conn.Organization)
+	metadataURL, err := url.Parse(base + "/metadata")
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := url.Parse(base + "/acs")
+	if err != nil {
+		return nil, err
+	}
+
+	return &saml.ServiceProvider{
+		EntityID:          metadataURL.String(),
+		Key:               keyPair.Key,
+		Certificate:       keyPair.Certificate,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       idpMetadata,
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+		AllowIDPInitiated: false,
+	}, nil
+}
+
+// NewSAMLRequestState creates the state for a new AuthnRequest. linkUserID is the user
+// the asserted identity will be linked to, or zero for a login.
+func NewSAMLRequestState(requestID, organization, returnTo string, linkUserID int64) (*SAMLRequestState, error) {
+	relayState, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SAMLRequestState{
+		RequestID:    requestID,
+		RelayState:   relayState,
+		Organization: organization,
+		ReturnTo:     SanitizeReturnTo(returnTo),
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(OAuthStateTTL).Unix(),
+	}, nil
+}
+
+// NewSAMLLinkTicket creates a short-lived ticket that lets the browser of a logged in user
+// start a SAML login that links the asserted identity to them
+func NewSAMLLinkTicket(userID int64, organization string, cfg *config.Config) (string, error) {
+	return encodeSignedValue(samlLinkPurpose, samlLinkTicket{
+		UserID:       userID,
+		Organization: organization,
+		ExpiresAt:    time.Now().Add(OAuthStateTTL).Unix(),
+	}, cfg)
+}
+
+// ReadSAMLLinkTicket verifies a SAML link ticket for an organization and returns the user it was issued to
+func ReadSAMLLinkTicket(value, organization string, cfg *config.Config) (int64, error) {
+	var ticket samlLinkTicket
+	if err := decodeSignedValue(samlLinkPurpose, value, &ticket, cfg); err != nil {
+		return 0, ErrInvalidSAMLLink
+	}
+	if ticket.Organization != organization || ticket.UserID == 0 || time.Now().Unix() > ticket.ExpiresAt {
+		return 0, ErrInvalidSAMLLink
+	}
+
+	return ticket.UserID, nil
+}
+
+// Cookie returns the signed cookie that tracks the AuthnRequest.
+// The IdP posts the response cross-site, so the cookie must be SameSite=None and Secure.
+func (s *SAMLRequestState) Cookie(cfg *config.Config) (*http.Cookie, error) {
+	value, err := encodeSignedValue(SAMLRequestCookieName, s, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Cookie{
+		Name:     SAMLRequestCookieName,
+		Value:    value,
+		Path:     "/api/auth/saml/" + s.Organization,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+		MaxAge:   int(OAuthStateTTL.Seconds()),
+	}, nil
+}
+
+// ReadSAMLRequestState reads and verifies the SAML request cookie for an organization
+// and checks it against the RelayState posted by the IdP
+func ReadSAMLRequestState(r *http.Request, organization string, cfg *config.Config) (*SAMLRequestState, error) {
+	// Get cookie
+	cookie, err := r.Cookie(SAMLRequestCookieName)
+	if err != nil {
+		return nil, ErrInvalidSAMLRequest
+	}
+
+	// Verify and decode cookie
+	var state SAMLRequestState
+	if err := decodeSignedValue(SAMLRequestCookieName, cookie.Value, &state, cfg); err != nil {
+		return nil, ErrInvalidSAMLRequest
+	}
+
+	// Validate organization, expiry and relay state
+	if state.Organization != organization || time.Now().Unix() > state.ExpiresAt {
+		return nil, ErrInvalidSAMLRequest
+	}
+	if r.PostFormValue("RelayState") != state.RelayState {
+		return nil, ErrInvalidSAMLRequest
+	}
+
+	return &state, nil
+}
+
+// ClearSAMLRequestCookie returns a cookie that removes the SAML request cookie for an organization
+func ClearSAMLRequestCookie(organization string) *http.Cookie {
+	return &http.Cookie{
+		Name:     SAMLRequestCookieName,
+		Value:    "",
+		Path:     "/api/auth/saml/" + organization,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+		MaxAge:   -1,
+	}
+}
+
+// SAMLUserFromAssertion extracts the user identity from a validated assertion
+func SAMLUserFromAssertion(assertion *saml.Assertion) (*SAMLUser, error) {
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return nil, errors.New("assertion has no NameID")
+	}
+
+	user := &SAMLUser{
+		NameID: assertion.Subject.NameID.Value,
+		Email:  samlAttribute(assertion, samlEmailAttributes),
+		Name:   samlAttribute(assertion, samlNameAttributes),
+	}
+
+	// Fall back to the NameID when it is an email address
+	if user.Email == "" && strings.Contains(user.NameID, "@") {
+		user.Email = user.NameID
+	}
+	if user.Email == "" {
+		return nil, errors.New("assertion has no email")
+	}
+
+	// Fall back to given name and surname
+	if user.Name == "" {
+		user.Name = strings.TrimSpace(samlAttribute(assertion, []string{"givenName", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname"}) +
+			" " + samlAttribute(assertion, []string{"sn", "surname", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname"}))
+	}
+	if user.Name == "" {
+		user.Name = user.Email
+	}
+
+	return user, nil
+}
+
+// ProcessSAMLUser returns the user linked to a SAML identity, creating one if no user has its email.
+// Unlike OAuth logins, it never links the identity to an existing user by email: an organization
+// could otherwise take over any account on its email domains. ErrSAMLAccountNotLinked is
+// returned instead, and the user links the identity with LinkSAMLUser after logging in.
+func ProcessSAMLUser(ctx context.Context, users models.UserRepository, accounts models.OAuthAccountRepository, provider models.OAuthProvider, samlUser *SAMLUser, providerData string) (*models.User, error) {
+	// Check if the identity is linked
+	account, err := accounts.Get(ctx, provider, samlUser.NameID)
+	if err != nil {
+		return nil, fmt.Errorf("error checking OAuth account: %w", err)
+	}
+	if account != nil {
+		user, err := users.GetByID(ctx, account.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting user: %w", err)
+		}
+		return user, nil
+	}
+
+	// Refuse to take over an existing user
+	_, err = users.GetByEmail(ctx, samlUser.Email)
+	if err == nil {
+		return nil, ErrSAMLAccountNotLinked
+	}
+	if !errors.Is(err, models.ErrUserNotFound) {
+		return nil, fmt.Errorf("error checking user: %w", err)
+	}
+
+	// Create the user along with the linked identity
+	password, err := generateRandomPassword()
+	if err != nil {
+		return nil, fmt.Errorf("error generating password: %w", err)
+	}
+	user, err := users.CreateWithOAuthAccount(ctx, samlUser.Name, samlUser.Email, password, provider, samlUser.NameID, providerData)
+	if err != nil {
+		if errors.Is(err, models.ErrEmailTaken) {
+			return nil, ErrSAMLAccountNotLinked
+		}
+		return nil, fmt.Errorf("error creating user: %w", err)
+	}
+
+	return user, nil
+}
+
+// LinkSAMLUser links a SAML identity to the user who started the link from an authenticated session.
+// The identity must assert the user's email, so a link started by someone else cannot attach
+// another person's identity to their account.
+func LinkSAMLUser(ctx context.Context, users models.UserRepository, accounts models.OAuthAccountRepository, userID int64, provider models.OAuthProvider, samlUser *SAMLUser, providerData string) (*models.User, error) {
+	// Get user
+	user, err := users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(user.Email, samlUser.Email) {
+		return nil, ErrSAMLEmailMismatch
+	}
+
+	// Link identity, which is a no-op if it is already linked to the user
+	_, err = accounts.Create(ctx, user.ID, provider, samlUser.NameID, providerData)
+	if errors.Is(err, models.ErrOAuthAccountLinked) {
+		account, getErr := accounts.Get(ctx, provider, samlUser.NameID)
+		if getErr != nil {
+			return nil, fmt.Errorf("error checking OAuth account: %w", getErr)
+		}
+		if account != nil && account.UserID == user.ID {
+			return user, nil
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating OAuth account: %w", err)
+	}
+
+	return user, nil
+}
+
+// NewSAMLDomainToken generates the token an organization publishes to verify an email domain
+func NewSAMLDomainToken() (string, error) {
+	return randomString(32)
+}
+
+// SAMLDomainRecordName returns the name of the TXT record that verifies a domain
+func SAMLDomainRecordName(domain string) string {
+	return samlDomainRecordLabel + "." + domain
+}
+
+// SAMLDomainRecordValue returns the value of the TXT record that verifies a domain
+func SAMLDomainRecordValue(token string) string {
+	return "zyply-domain-verification=" + token
+}
+
+// CheckSAMLDomainRecord checks if the domain publishes its verification token in DNS.
+// A missing record is not an error.
+func CheckSAMLDomainRecord(ctx context.Context, resolver TXTResolver, domain *models.SAMLDomain) (bool, error) {
+	records, err := resolver.LookupTXT(ctx, SAMLDomainRecordName(domain.Domain))
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to look up TXT record: %w", err)
+	}
+
+	want := SAMLDomainRecordValue(domain.VerificationToken)
+	for _, record := range records {
+		if strings.TrimSpace(record) == want {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// samlAttribute returns the first value of the first matching attribute in an assertion
+func samlAttribute(assertion *saml.Assertion, names []string) string {
+	for _, statement := range assertion.AttributeStatements {
+		for _, name := range names {
+			for _, attr := range statement.Attributes {
+				if (attr.Name == name || attr.FriendlyName == name) && len(attr.Values) > 0 {
+					return strings.TrimSpace(attr.Values[0].Value)
+				}
+			}
+		}
+	}
+	return ""
+}
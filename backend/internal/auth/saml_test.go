@@ -0,0 +1,391 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/models"
+	"github.com/crewjam/saml"
+)
+
+// newTestCertificate generates an RSA key and a self-signed certificate for it
+func newTestCertificate(t *testing.T, commonName string) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	return key, cert
+}
+
+// newTestIdP creates an identity provider with a freshly generated signing certificate
+func newTestIdP(t *testing.T) *saml.IdentityProvider {
+	t.Helper()
+	key, cert := newTestCertificate(t, "idp.example.com")
+	metadataURL, _ := url.Parse("https://idp.example.com/metadata")
+	ssoURL, _ := url.Parse("https://idp.example.com/sso")
+	return &saml.IdentityProvider{
+		Key:         key,
+		Certificate: cert,
+		MetadataURL: *metadataURL,
+		SSOURL:      *ssoURL,
+	}
+}
+
+// newTestServiceProvider builds the service provider of an organization trusting idp
+func newTestServiceProvider(t *testing.T, idp *saml.IdentityProvider) *saml.ServiceProvider {
+	t.Helper()
+	metadata, err := xml.Marshal(idp.Metadata())
+	if err != nil {
+		t.Fatalf("marshal IdP metadata: %v", err)
+	}
+	key, cert := newTestCertificate(t, "api.example.com")
+	cfg := &config.Config{}
+	cfg.SAML.BaseURL = "https://api.example.com"
+
+	sp, err := NewSAMLServiceProvider(&models.SAMLConnection{Organization: "acme", IDPMetadata: string(metadata)}, &SAMLKeyPair{Key: key, Certificate: cert}, cfg)
+	if err != nil {
+		t.Fatalf("NewSAMLServiceProvider: %v", err)
+	}
+	return sp
+}
+
+// signedResponse has idp answer an AuthnRequest of sp with a signed response for session
+func signedResponse(t *testing.T, idp *saml.IdentityProvider, sp *saml.ServiceProvider, authnRequest *saml.AuthnRequest, session *saml.Session) *saml.IdpAuthnRequest {
+	t.Helper()
+	spMetadata := sp.Metadata()
+	descriptor := &spMetadata.SPSSODescriptors[0]
+	var acs *saml.IndexedEndpoint
+	for i, endpoint := range descriptor.AssertionConsumerServices {
+		if endpoint.Binding == saml.HTTPPostBinding {
+			acs = &descriptor.AssertionConsumerServices[i]
+		}
+	}
+
+	req := &saml.IdpAuthnRequest{
+		IDP:                     idp,
+		HTTPRequest:             httptest.NewRequest(http.MethodGet, idp.SSOURL.String(), nil),
+		Request:                 *authnRequest,
+		ServiceProviderMetadata: spMetadata,
+		SPSSODescriptor:         descriptor,
+		ACSEndpoint:             acs,
+		Now:                     saml.TimeNow(),
+	}
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(req, session); err != nil {
+		t.Fatalf("MakeAssertion: %v", err)
+	}
+	if err := req.MakeResponse(); err != nil {
+		t.Fatalf("MakeResponse: %v", err)
+	}
+	return req
+}
+
+// encodeResponse returns the SAMLResponse form value of a response made by signedResponse
+func encodeResponse(t *testing.T, req *saml.IdpAuthnRequest) string {
+	t.Helper()
+	form, err := req.PostBinding()
+	if err != nil {
+		t.Fatalf("PostBinding: %v", err)
+	}
+	return form.SAMLResponse
+}
+
+// postResponse builds the IdP's POST of a SAMLResponse to the service provider's ACS.
+// The form is parsed, as ReadSAMLRequestState does before the ACS handler parses the response.
+func postResponse(sp *saml.ServiceProvider, samlResponse string) *http.Request {
+	form := url.Values{"SAMLResponse": {samlResponse}}
+	r := httptest.NewRequest(http.MethodPost, sp.AcsURL.String(), strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ParseForm()
+	return r
+}
+
+// privateErr returns the reason ParseResponse rejected a response, which it hides from users
+func privateErr(err error) error {
+	var invalid *saml.InvalidResponseError
+	if errors.As(err, &invalid) {
+		return invalid.PrivateErr
+	}
+	return err
+}
+
+// wantRejected checks ParseResponse rejected a response for the given reason
+func wantRejected(t *testing.T, err error, reason string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("ParseResponse accepted the response, want it rejected with %q", reason)
+	}
+	if !strings.Contains(privateErr(err).Error(), reason) {
+		t.Errorf("ParseResponse() error = %v, want %q", privateErr(err), reason)
+	}
+}
+
+func TestSAMLResponseValidation(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := newTestServiceProvider(t, idp)
+	session := &saml.Session{
+		ID:            "session-1",
+		CreateTime:    saml.TimeNow(),
+		ExpireTime:    saml.TimeNow().Add(time.Hour),
+		Index:         "1",
+		NameID:        "00u1ab2cd3",
+		UserGivenName: "Ada",
+		UserSurname:   "Lovelace",
+		CustomAttributes: []saml.Attribute{{
+			Name:   "email",
+			Values: []saml.AttributeValue{{Type: "xs:string", Value: "ada@acme.example"}},
+		}},
+	}
+	newAuthnRequest := func(t *testing.T) *saml.AuthnRequest {
+		authnRequest, err := sp.MakeAuthenticationRequest(idp.SSOURL.String(), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+		if err != nil {
+			t.Fatalf("MakeAuthenticationRequest: %v", err)
+		}
+		return authnRequest
+	}
+
+	t.Run("signed response", func(t *testing.T) {
+		authnRequest := newAuthnRequest(t)
+		assertion, err := sp.ParseResponse(postResponse(sp, encodeResponse(t, signedResponse(t, idp, sp, authnRequest, session))), []string{authnRequest.ID})
+		if err != nil {
+			t.Fatalf("ParseResponse: %v", privateErr(err))
+		}
+
+		user, err := SAMLUserFromAssertion(assertion)
+		if err != nil {
+			t.Fatalf("SAMLUserFromAssertion: %v", err)
+		}
+		want := SAMLUser{NameID: "00u1ab2cd3", Email: "ada@acme.example", Name: "Ada Lovelace"}
+		if *user != want {
+			t.Errorf("user = %+v, want %+v", *user, want)
+		}
+	})
+
+	t.Run("tampered signature", func(t *testing.T) {
+		authnRequest := newAuthnRequest(t)
+		raw, _ := base64.StdEncoding.DecodeString(encodeResponse(t, signedResponse(t, idp, sp, authnRequest, session)))
+		i := strings.Index(string(raw), "SignatureValue>") + len("SignatureValue>")
+		if raw[i] == 'A' {
+			raw[i] = 'B'
+		} else {
+			raw[i] = 'A'
+		}
+		_, err := sp.ParseResponse(postResponse(sp, base64.StdEncoding.EncodeToString(raw)), []string{authnRequest.ID})
+		wantRejected(t, err, "verification error")
+	})
+
+	t.Run("unsigned response", func(t *testing.T) {
+		authnRequest := newAuthnRequest(t)
+		req := signedResponse(t, idp, sp, authnRequest, session)
+		response := saml.Response{
+			ID:           "id-unsigned",
+			InResponseTo: authnRequest.ID,
+			Destination:  sp.AcsURL.String(),
+			IssueInstant: saml.TimeNow(),
+			Version:      "2.0",
+			Issuer:       &saml.Issuer{Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity", Value: idp.MetadataURL.String()},
+			Status:       saml.Status{StatusCode: saml.StatusCode{Value: saml.StatusSuccess}},
+			Assertion:    req.Assertion,
+		}
+		raw, err := xml.Marshal(response)
+		if err != nil {
+			t.Fatalf("marshal response: %v", err)
+		}
+		_, err = sp.ParseResponse(postResponse(sp, base64.StdEncoding.EncodeToString(raw)), []string{authnRequest.ID})
+		wantRejected(t, err, "signature element not present")
+	})
+
+	t.Run("signed by another certificate", func(t *testing.T) {
+		impostor := newTestIdP(t)
+		authnRequest := newAuthnRequest(t)
+		_, err := sp.ParseResponse(postResponse(sp, encodeResponse(t, signedResponse(t, impostor, sp, authnRequest, session))), []string{authnRequest.ID})
+		wantRejected(t, err, "Could not verify certificate against trusted certs")
+	})
+
+	t.Run("response to another request", func(t *testing.T) {
+		authnRequest := newAuthnRequest(t)
+		samlResponse := encodeResponse(t, signedResponse(t, idp, sp, authnRequest, session))
+		_, err := sp.ParseResponse(postResponse(sp, samlResponse), []string{newAuthnRequest(t).ID})
+		wantRejected(t, err, "`InResponseTo` does not match")
+	})
+}
+
+func TestProcessSAMLUser(t *testing.T) {
+	ctx := context.Background()
+	provider := models.OAuthProvider("saml:acme")
+
+	t.Run("new user", func(t *testing.T) {
+		users, accounts := models.NewMemoryRepositories()
+		user, err := ProcessSAMLUser(ctx, users, accounts, provider, &SAMLUser{NameID: "ada", Email: "ada@acme.example", Name: "Ada"}, "{}")
+		if err != nil {
+			t.Fatalf("ProcessSAMLUser: %v", err)
+		}
+
+		again, err := ProcessSAMLUser(ctx, users, accounts, provider, &SAMLUser{NameID: "ada", Email: "ada@acme.example", Name: "Ada"}, "{}")
+		if err != nil || again.ID != user.ID {
+			t.Fatalf("second login = %v, %v, want user %d", again, err, user.ID)
+		}
+	})
+
+	t.Run("existing user is never linked by email", func(t *testing.T) {
+		users, accounts := models.NewMemoryRepositories()
+		if _, err := users.Create(ctx, "Ada", "ada@acme.example", "correct horse battery staple"); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		_, err := ProcessSAMLUser(ctx, users, accounts, provider, &SAMLUser{NameID: "ada", Email: "ada@acme.example", Name: "Ada"}, "{}")
+		if !errors.Is(err, ErrSAMLAccountNotLinked) {
+			t.Fatalf("ProcessSAMLUser() error = %v, want ErrSAMLAccountNotLinked", err)
+		}
+		if account, _ := accounts.Get(ctx, provider, "ada"); account != nil {
+			t.Errorf("identity was linked to user %d", account.UserID)
+		}
+	})
+
+	t.Run("explicit link", func(t *testing.T) {
+		users, accounts := models.NewMemoryRepositories()
+		ada, _ := users.Create(ctx, "Ada", "ada@acme.example", "correct horse battery staple")
+		grace, _ := users.Create(ctx, "Grace", "grace@acme.example", "correct horse battery staple")
+		samlUser := &SAMLUser{NameID: "ada", Email: "ADA@acme.example", Name: "Ada"}
+
+		if _, err := LinkSAMLUser(ctx, users, accounts, grace.ID, provider, samlUser, "{}"); !errors.Is(err, ErrSAMLEmailMismatch) {
+			t.Fatalf("LinkSAMLUser() for another user error = %v, want ErrSAMLEmailMismatch", err)
+		}
+		if _, err := LinkSAMLUser(ctx, users, accounts, ada.ID, provider, samlUser, "{}"); err != nil {
+			t.Fatalf("LinkSAMLUser: %v", err)
+		}
+		if _, err := LinkSAMLUser(ctx, users, accounts, ada.ID, provider, samlUser, "{}"); err != nil {
+			t.Fatalf("LinkSAMLUser() for an identity already linked to the user: %v", err)
+		}
+
+		user, err := ProcessSAMLUser(ctx, users, accounts, provider, samlUser, "{}")
+		if err != nil || user.ID != ada.ID {
+			t.Fatalf("ProcessSAMLUser() = %v, %v, want user %d", user, err, ada.ID)
+		}
+	})
+
+	t.Run("identity linked to another user", func(t *testing.T) {
+		users, accounts := models.NewMemoryRepositories()
+		ada, _ := users.Create(ctx, "Ada", "ada@acme.example", "correct horse battery staple")
+		grace, _ := users.Create(ctx, "Grace", "grace@acme.example", "correct horse battery staple")
+		if _, err := accounts.Create(ctx, grace.ID, provider, "ada", "{}"); err != nil {
+			t.Fatalf("Create account: %v", err)
+		}
+
+		_, err := LinkSAMLUser(ctx, users, accounts, ada.ID, provider, &SAMLUser{NameID: "ada", Email: "ada@acme.example"}, "{}")
+		if !errors.Is(err, models.ErrOAuthAccountLinked) {
+			t.Fatalf("LinkSAMLUser() error = %v, want ErrOAuthAccountLinked", err)
+		}
+	})
+}
+
+func TestSAMLLinkTicket(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.JWT.Secret = "test-secret"
+
+	ticket, err := NewSAMLLinkTicket(42, "acme", cfg)
+	if err != nil {
+		t.Fatalf("NewSAMLLinkTicket: %v", err)
+	}
+	if userID, err := ReadSAMLLinkTicket(ticket, "acme", cfg); err != nil || userID != 42 {
+		t.Fatalf("ReadSAMLLinkTicket() = %d, %v, want 42", userID, err)
+	}
+
+	expired, _ := encodeSignedValue(samlLinkPurpose, samlLinkTicket{UserID: 42, Organization: "acme", ExpiresAt: time.Now().Add(-time.Minute).Unix()}, cfg)
+	otherSecret := &config.Config{}
+	otherSecret.JWT.Secret = "other-secret"
+	forged, _ := NewSAMLLinkTicket(42, "acme", otherSecret)
+	loginState, _ := encodeSignedValue(SAMLRequestCookieName, samlLinkTicket{UserID: 42, Organization: "acme", ExpiresAt: time.Now().Add(time.Minute).Unix()}, cfg)
+
+	tests := []struct {
+		name         string
+		ticket       string
+		organization string
+	}{
+		{"other organization", ticket, "globex"},
+		{"expired", expired, "acme"},
+		{"signed with another secret", forged, "acme"},
+		{"signed for another purpose", loginState, "acme"},
+		{"malformed", "not-a-ticket", "acme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadSAMLLinkTicket(tt.ticket, tt.organization, cfg); !errors.Is(err, ErrInvalidSAMLLink) {
+				t.Errorf("ReadSAMLLinkTicket() error = %v, want ErrInvalidSAMLLink", err)
+			}
+		})
+	}
+}
+
+// stubResolver answers TXT lookups from a fixed set of records
+type stubResolver struct {
+	records map[string][]string
+	err     error
+}
+
+func (r *stubResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	records, ok := r.records[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func TestCheckSAMLDomainRecord(t *testing.T) {
+	domain := &models.SAMLDomain{Domain: "acme.example", VerificationToken: "token-123"}
+	recordName := "_zyply-verification.acme.example"
+
+	tests := []struct {
+		name     string
+		resolver *stubResolver
+		want     bool
+		wantErr  bool
+	}{
+		{"record published", &stubResolver{records: map[string][]string{recordName: {"v=spf1 -all", "zyply-domain-verification=token-123"}}}, true, false},
+		{"other token", &stubResolver{records: map[string][]string{recordName: {"zyply-domain-verification=token-456"}}}, false, false},
+		{"token on the apex", &stubResolver{records: map[string][]string{"acme.example": {"zyply-domain-verification=token-123"}}}, false, false},
+		{"no record", &stubResolver{}, false, false},
+		{"lookup failure", &stubResolver{err: &net.DNSError{Err: "server misbehaving", Name: recordName, IsTemporary: true}}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CheckSAMLDomainRecord(context.Background(), tt.resolver, domain)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("CheckSAMLDomainRecord() = %v, %v, want %v (error %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/models"
+	"golang.org/x/oauth2"
+)
+
+// OAuthStateCookieName is the name of the cookie that carries the signed OAuth state
+const OAuthStateCookieName = "oauth_state"
+
+// OAuthStateTTL is how long an OAuth login flow may take before its state expires
+const OAuthStateTTL = 5 * time.Minute
+
+// ErrInvalidOAuthState is returned when the OAuth state cookie is missing, tampered with or expired
+var ErrInvalidOAuthState = errors.New("invalid oauth state")
+
+// OAuthState holds everything bound to a single in-flight OAuth login
+type OAuthState struct {
+	State        string               `json:"state"`
+	Provider     models.OAuthProvider `json:"provider"`
+	CodeVerifier string               `json:"code_verifier"`
+	Nonce        string               `json:"nonce"`
+	ReturnTo     string               `json:"return_to,omitempty"`
+	ExpiresAt    int64                `json:"expires_at"`
+}
+
+// NewOAuthState creates a new OAuth state with random state, PKCE verifier and nonce
+func NewOAuthState(provider models.OAuthProvider, returnTo string) (*OAuthState, error) {
+	state, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OAuthState{
+		State:        state,
+		Provider:     provider,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		Nonce:        nonce,
+		ReturnTo:     SanitizeReturnTo(returnTo),
+		ExpiresAt:    time.Now().Add(OAuthStateTTL).Unix(),
+	}, nil
+}
+
+// Cookie returns the signed cookie that stores the OAuth state
+func (s *OAuthState) Cookie(r *http.Request, cfg *config.Config) (*http.Cookie, error) {
+	value, err := encodeSignedValue(OAuthStateCookieName, s, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Cookie{
+		Name:     OAuthStateCookieName,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   isSecure(r),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(OAuthStateTTL.Seconds()),
+	}, nil
+}
+
+// ReadOAuthState reads and verifies the OAuth state cookie for the given provider
+// and checks it against the state returned by the provider
+func ReadOAuthState(r *http.Request, provider models.OAuthProvider, cfg *config.Config) (*OAuthState, error) {
+	// Get cookie
+	cookie, err := r.Cookie(OAuthStateCookieName)
+	if err != nil {
+		return nil, ErrInvalidOAuthState
+	}
+
+	// Verify and decode cookie
+	var state OAuthState
+	if err := decodeSignedValue(OAuthStateCookieName, cookie.Value, &state, cfg); err != nil {
+		return nil, ErrInvalidOAuthState
+	}
+
+	// Validate provider, expiry and state
+	if state.Provider != provider || time.Now().Unix() > state.ExpiresAt {
+		return nil, ErrInvalidOAuthState
+	}
+	returned := r.URL.Query().Get("state")
+	if returned == "" || !hmac.Equal([]byte(returned), []byte(state.State)) {
+		return nil, ErrInvalidOAuthState
+	}
+
+	return &state, nil
+}
+
+// ClearOAuthStateCookie returns a cookie that removes the OAuth state cookie
+func ClearOAuthStateCookie(r *http.Request) *http.Cookie {
+	return &http.Cookie{
+		Name:     OAuthStateCookieName,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   isSecure(r),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	}
+}
+
+// SanitizeReturnTo only allows local paths as post-login return targets
+func SanitizeReturnTo(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.Contains(returnTo, "\\") {
+		return ""
+	}
+	return returnTo
+}
+
+// errInvalidSignedValue is returned when a signed value is malformed or its signature does not match
+var errInvalidSignedValue = errors.New("invalid signed value")
+
+// encodeSignedValue serializes v as JSON and signs it with the JWT secret for the given purpose
+func encodeSignedValue(purpose string, v interface{}, cfg *config.Config) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + signValue(purpose, encoded, cfg), nil
+}
+
+// decodeSignedValue verifies a value produced by encodeSignedValue and decodes it into v
+func decodeSignedValue(purpose, value string, v interface{}, cfg *config.Config) error {
+	// Verify signature
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signValue(purpose, encoded, cfg))) {
+		return errInvalidSignedValue
+	}
+
+	// Decode payload
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return errInvalidSignedValue
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return errInvalidSignedValue
+	}
+
+	return nil
+}
+
+// signValue signs an encoded value with the JWT secret, scoped to the given purpose
+func signValue(purpose, encoded string, cfg *config.Config) string {
+	mac := hmac.New(sha256.New, []byte(cfg.JWT.Secret))
+	mac.Write([]byte(purpose + ":" + encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// randomString returns a URL-safe random string built from n random bytes
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// isSecure checks if the request arrived over HTTPS, directly or through a trusted proxy
+func isSecure(r *http.Request) bool {
+	return r.TLS != nil || r.URL.Scheme == "https"
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/models"
+)
+
+// testStateConfig returns a config with the secret OAuth state cookies are signed with
+func testStateConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.JWT.Secret = "test-secret"
+	return cfg
+}
+
+// stateCookie signs state into a cookie
+func stateCookie(t *testing.T, state *OAuthState, cfg *config.Config) *http.Cookie {
+	t.Helper()
+	cookie, err := state.Cookie(httptest.NewRequest(http.MethodGet, "/api/auth/google", nil), cfg)
+	if err != nil {
+		t.Fatalf("Cookie() = %v", err)
+	}
+	return cookie
+}
+
+// callback builds the provider callback request carrying a state cookie and a returned state
+func callback(cookie *http.Cookie, returnedState string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/auth/google/callback?"+url.Values{"state": {returnedState}}.Encode(), nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestOAuthStateRoundTrip(t *testing.T) {
+	cfg := testStateConfig()
+	state, err := NewOAuthState(models.ProviderGoogle, "/links?page=2")
+	if err != nil {
+		t.Fatalf("NewOAuthState() = %v", err)
+	}
+	cookie := stateCookie(t, state, cfg)
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge != int(OAuthStateTTL.Seconds()) {
+		t.Errorf("cookie = %+v, want an HttpOnly, SameSite=Lax cookie living OAuthStateTTL", cookie)
+	}
+
+	got, err := ReadOAuthState(callback(cookie, state.State), models.ProviderGoogle, cfg)
+	if err != nil {
+		t.Fatalf("ReadOAuthState() = %v", err)
+	}
+	if *got != *state {
+		t.Errorf("ReadOAuthState() = %+v, want %+v", got, state)
+	}
+}
+
+func TestReadOAuthStateRejects(t *testing.T) {
+	cfg := testStateConfig()
+	state, err := NewOAuthState(models.ProviderGoogle, "")
+	if err != nil {
+		t.Fatalf("NewOAuthState() = %v", err)
+	}
+	cookie := stateCookie(t, state, cfg)
+	payload, signature, _ := strings.Cut(cookie.Value, ".")
+
+	// A cookie for GitHub whose payload is signed correctly
+	github := *state
+	github.Provider = models.ProviderGitHub
+	githubCookie := stateCookie(t, &github, cfg)
+	githubPayload, _, _ := strings.Cut(githubCookie.Value, ".")
+
+	expired := *state
+	expired.ExpiresAt = time.Now().Add(-time.Second).Unix()
+
+	otherSecret := testStateConfig()
+	otherSecret.JWT.Secret = "other-secret"
+
+	tests := []struct {
+		name     string
+		cookie   *http.Cookie
+		returned string
+		provider models.OAuthProvider
+	}{
+		{"missing cookie", nil, state.State, models.ProviderGoogle},
+		{"tampered MAC", &http.Cookie{Name: OAuthStateCookieName, Value: payload + "." + strings.ToUpper(signature)}, state.State, models.ProviderGoogle},
+		{"tampered payload", &http.Cookie{Name: OAuthStateCookieName, Value: githubPayload + "." + signature}, state.State, models.ProviderGitHub},
+		{"missing MAC", &http.Cookie{Name: OAuthStateCookieName, Value: payload}, state.State, models.ProviderGoogle},
+		{"signed with another secret", stateCookie(t, state, otherSecret), state.State, models.ProviderGoogle},
+		{"expired", stateCookie(t, &expired, cfg), state.State, models.ProviderGoogle},
+		{"wrong provider", cookie, state.State, models.ProviderGitHub},
+		{"wrong returned state", cookie, state.State + "x", models.ProviderGoogle},
+		{"missing returned state", cookie, "", models.ProviderGoogle},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadOAuthState(callback(tt.cookie, tt.returned), tt.provider, cfg)
+			if !errors.Is(err, ErrInvalidOAuthState) {
+				t.Errorf("ReadOAuthState() = %+v, %v, want ErrInvalidOAuthState", got, err)
+			}
+		})
+	}
+}
+
+func TestSanitizeReturnTo(t *testing.T) {
+	tests := []struct {
+		returnTo string
+		want     string
+	}{
+		{"/links", "/links"},
+		{"/links?page=2#top", "/links?page=2#top"},
+		{"", ""},
+		{"links", ""},
+		{"https://evil.com", ""},
+		{"//evil.com", ""},
+		{"/\\evil.com", ""},
+	}
+
+	for _, tt := range tests {
+		if got := SanitizeReturnTo(tt.returnTo); got != tt.want {
+			t.Errorf("SanitizeReturnTo(%q) = %q, want %q", tt.returnTo, got, tt.want)
+		}
+	}
+}
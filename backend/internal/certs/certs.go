@@ -0,0 +1,182 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/config"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// reloadInterval is how often certificate files are checked for changes
+const reloadInterval = 30 * time.Second
+
+// Reloader serves a certificate from files and reloads it when they change, so renewed
+// certificates are picked up without a restart
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+// NewReloader loads the certificate and key files
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	reloader := &Reloader{certFile: certFile, keyFile: keyFile}
+	if _, err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch reloads the certificate in the background whenever the files change.
+// A certificate that fails to load is logged and the previous one kept.
+func (r *Reloader) Watch(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(reloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			reloaded, err := r.reload()
+			if err != nil {
+				slog.Error("Failed to reload TLS certificate", "error", err)
+			} else if reloaded {
+				slog.Info("Reloaded TLS certificate", "cert_file", r.certFile)
+			}
+		}
+	}()
+}
+
+// reload loads the certificate if either file changed since the last load
+func (r *Reloader) reload() (bool, error) {
+	// Check modification times
+	var modTimes [2]time.Time
+	for i, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, fmt.Errorf("error reading TLS certificate: %w", err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTimes == r.modTimes
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	// Load certificate
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("error loading TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert, r.modTimes = &cert, modTimes
+	r.mu.Unlock()
+	return true, nil
+}
+
+// NewACMEManager creates a manager that obtains and renews certificates for the configured domains
+func NewACMEManager(cfg *config.Config) (*autocert.Manager, error) {
+	client := &acme.Client{DirectoryURL: cfg.TLS.ACMEDirectoryURL}
+
+	// Trust a custom CA for the ACME directory, such as a local test CA
+	if cfg.TLS.ACMECAFile != "" {
+		pem, err := os.ReadFile(cfg.TLS.ACMECAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading ACME CA file: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("ACME CA file contains no PEM certificates")
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.TLS.ACMEDomains...),
+		Cache:      autocert.DirCache(cfg.TLS.ACMECacheDir),
+		Email:      cfg.TLS.ACMEEmail,
+		Client:     client,
+	}, nil
+}
+
+// Setup returns the TLS configuration for the API server and the handler for the HTTP redirect
+// listener, which also answers ACME HTTP-01 challenges. HTTP/2 is negotiated over TLS.
+func Setup(ctx context.Context, cfg *config.Config) (*tls.Config, http.Handler, error) {
+	redirect := RedirectHandler(cfg.Server.Port)
+
+	if len(cfg.TLS.ACMEDomains) > 0 {
+		manager, err := NewACMEManager(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return tlsConfig, manager.HTTPHandler(redirect), nil
+	}
+
+	reloader, err := NewReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	reloader.Watch(ctx)
+	return &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+		MinVersion:     tls.VersionTLS12,
+	}, redirect, nil
+}
+
+// RedirectHandler redirects requests to the same URL on the HTTPS port
+func RedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, "Use HTTPS", http.StatusBadRequest)
+			return
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		// Only GET and HEAD are redirected, since other methods would send their body over HTTP first
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Use HTTPS", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,571 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/config"
+)
+
+// newTestCert creates a certificate for name, signed by parent or self-signed if parent is nil
+func newTestCert(t *testing.T, name string, pub *ecdsa.PublicKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeKeyPair writes a self-signed certificate for name and its key, setting both files' modification time
+func writeKeyPair(t *testing.T, certFile, keyFile, name string, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := newTestCert(t, name, &key.PublicKey, nil, key)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: cert.Raw},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	}
+	for file, block := range files {
+		if err := os.WriteFile(file, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// servedName returns the common name of the certificate a reloader currently serves
+func servedName(t *testing.T, reloader *Reloader) string {
+	t.Helper()
+	cert, err := reloader.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	loaded := time.Now().Add(-time.Hour)
+	writeKeyPair(t, certFile, keyFile, "first.example.com", loaded)
+
+	reloader, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewReloader() = %v", err)
+	}
+	if name := servedName(t, reloader); name != "first.example.com" {
+		t.Fatalf("serving %q, want first.example.com", name)
+	}
+
+	// Unchanged files are not reloaded
+	if reloaded, err := reloader.reload(); reloaded || err != nil {
+		t.Errorf("reload() of unchanged files = %t, %v, want false, nil", reloaded, err)
+	}
+
+	// A renewed certificate is picked up
+	writeKeyPair(t, certFile, keyFile, "second.example.com", loaded.Add(time.Minute))
+	if reloaded, err := reloader.reload(); !reloaded || err != nil {
+		t.Fatalf("reload() of renewed files = %t, %v, want true, nil", reloaded, err)
+	}
+	if name := servedName(t, reloader); name != "second.example.com" {
+		t.Errorf("serving %q after renewal, want second.example.com", name)
+	}
+
+	// A broken certificate keeps the previous one
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := reloader.reload(); reloaded || err == nil {
+		t.Errorf("reload() of a broken key = %t, %v, want false and an error", reloaded, err)
+	}
+	if name := servedName(t, reloader); name != "second.example.com" {
+		t.Errorf("serving %q after a failed reload, want second.example.com", name)
+	}
+
+	if _, err := NewReloader(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Error("NewReloader() with a missing file = nil error, want an error")
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		port         string
+		method       string
+		host         string
+		target       string
+		wantStatus   int
+		wantLocation string
+	}{
+		{"default port", "443", http.MethodGet, "example.com", "/links?page=2", http.StatusMovedPermanently, "https://example.com/links?page=2"},
+		{"custom port", "8443", http.MethodGet, "example.com:8080", "/links", http.StatusMovedPermanently, "https://example.com:8443/links"},
+		{"ipv6 host", "8443", http.MethodHead, "[::1]:8080", "/", http.StatusMovedPermanently, "https://[::1]:8443/"},
+		{"post", "443", http.MethodPost, "example.com", "/api/auth/login", http.StatusBadRequest, ""},
+		{"no host", "443", http.MethodGet, "", "/", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.Host = tt.host
+			rec := httptest.NewRecorder()
+			RedirectHandler(tt.port).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus || rec.Header().Get("Location") != tt.wantLocation {
+				t.Errorf("response = %d %q, want %d %q", rec.Code, rec.Header().Get("Location"), tt.wantStatus, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestSetupWithCertificateFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{}
+	cfg.Server.Port = "443"
+	cfg.TLS.CertFile, cfg.TLS.KeyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	if _, _, err := Setup(context.Background(), cfg); err == nil {
+		t.Fatal("Setup() without certificate files = nil error, want an error")
+	}
+
+	writeKeyPair(t, cfg.TLS.CertFile, cfg.TLS.KeyFile, "example.com", time.Now())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tlsConfig, redirect, err := Setup(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Setup() = %v", err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS12 || !slices.Contains(tlsConfig.NextProtos, "h2") {
+		t.Errorf("TLS config = %+v, want TLS 1.2 and HTTP/2", tlsConfig)
+	}
+	if cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"}); err != nil || cert == nil {
+		t.Errorf("GetCertificate() = %v, %v, want the loaded certificate", cert, err)
+	}
+
+	rec := httptest.NewRecorder()
+	redirect.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if rec.Code != http.StatusMovedPermanently {
+		t.Errorf("redirect status = %d, want 301", rec.Code)
+	}
+}
+
+// acmeStub is a Pebble-like ACME CA (RFC 8555) that validates HTTP-01 challenges in-process
+// through challengeHandler and issues certificates from its own CA
+type acmeStub struct {
+	*httptest.Server
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+
+	mu sync.Mutex
+	// challengeHandler serves /.well-known/acme-challenge/ as the HTTP listener would
+	challengeHandler http.Handler
+	thumbprint       string
+	domain           string
+	authzStatus      string
+	orderStatus      string
+	certificate      []byte
+	issued           int
+}
+
+const acmeChallengeToken = "challenge-token"
+
+func newACMEStub(t *testing.T) *acmeStub {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &acmeStub{ca: newTestCert(t, "Test ACME CA", &caKey.PublicKey, nil, caKey), caKey: caKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dir", func(w http.ResponseWriter, r *http.Request) {
+		s.write(w, http.StatusOK, map[string]string{
+			"newNonce":   s.URL + "/nonce",
+			"newAccount": s.URL + "/account",
+			"newOrder":   s.URL + "/order",
+			"revokeCert": s.URL + "/revoke",
+			"keyChange":  s.URL + "/key-change",
+		})
+	})
+	mux.HandleFunc("/nonce", func(w http.ResponseWriter, r *http.Request) {
+		s.setNonce(w)
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/account", s.handleAccount)
+	mux.HandleFunc("/order", s.handleNewOrder)
+	mux.HandleFunc("/order/1", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.write(w, http.StatusOK, s.order())
+	})
+	mux.HandleFunc("/authz/1", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.write(w, http.StatusOK, s.authz())
+	})
+	mux.HandleFunc("/challenge/1", s.handleChallenge)
+	mux.HandleFunc("/finalize/1", s.handleFinalize)
+	mux.HandleFunc("/cert/1", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.setNonce(w)
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(s.certificate)
+	})
+	s.Server = httptest.NewTLSServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *acmeStub) setNonce(w http.ResponseWriter) {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	w.Header().Set("Replay-Nonce", base64.RawURLEncoding.EncodeToString(nonce))
+}
+
+func (s *acmeStub) write(w http.ResponseWriter, status int, v interface{}) {
+	s.setNonce(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// readJWS returns the protected header and payload of a JWS request body. Signatures are not checked.
+func readJWS(r *http.Request, payload interface{}) (protected struct {
+	JWK json.RawMessage `json:"jwk"`
+}, err error) {
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return protected, err
+	}
+	header, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return protected, err
+	}
+	if err := json.Unmarshal(header, &protected); err != nil {
+		return protected, err
+	}
+	body, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil || len(body) == 0 || payload == nil {
+		return protected, err
+	}
+	return protected, json.Unmarshal(body, payload)
+}
+
+func (s *acmeStub) handleAccount(w http.ResponseWriter, r *http.Request) {
+	protected, err := readJWS(r, nil)
+	if err != nil {
+		s.write(w, http.StatusBadRequest, map[string]string{"type": "urn:ietf:params:acme:error:malformed"})
+		return
+	}
+
+	// Thumbprint of the account key (RFC 7638), for key authorizations
+	var jwk struct {
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	json.Unmarshal(protected.JWK, &jwk)
+	sum := sha256.Sum256([]byte(fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, jwk.Crv, jwk.X, jwk.Y)))
+
+	s.mu.Lock()
+	s.thumbprint = base64.RawURLEncoding.EncodeToString(sum[:])
+	s.mu.Unlock()
+	w.Header().Set("Location", s.URL+"/account/1")
+	s.write(w, http.StatusCreated, map[string]string{"status": "valid"})
+}
+
+func (s *acmeStub) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Identifiers []struct {
+			Value string `json:"value"`
+		} `json:"identifiers"`
+	}
+	if _, err := readJWS(r, &req); err != nil || len(req.Identifiers) != 1 {
+		s.write(w, http.StatusBadRequest, map[string]string{"type": "urn:ietf:params:acme:error:malformed"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.domain = req.Identifiers[0].Value
+	s.authzStatus, s.orderStatus = "pending", "pending"
+	w.Header().Set("Location", s.URL+"/order/1")
+	s.write(w, http.StatusCreated, s.order())
+}
+
+// handleChallenge validates the HTTP-01 challenge by fetching the key authorization from the challenge handler
+func (s *acmeStub) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req := httptest.NewRequest(http.MethodGet, "http://"+s.domain+"/.well-known/acme-challenge/"+acmeChallengeToken, nil)
+	rec := httptest.NewRecorder()
+	s.challengeHandler.ServeHTTP(rec, req)
+	if rec.Code == http.StatusOK && strings.TrimSpace(rec.Body.String()) == acmeChallengeToken+"."+s.thumbprint {
+		s.authzStatus, s.orderStatus = "valid", "ready"
+	} else {
+		s.authzStatus, s.orderStatus = "invalid", "invalid"
+	}
+	s.write(w, http.StatusOK, s.challenge())
+}
+
+func (s *acmeStub) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CSR string `json:"csr"`
+	}
+	if _, err := readJWS(r, &req); err != nil {
+		s.write(w, http.StatusBadRequest, map[string]string{"type": "urn:ietf:params:acme:error:malformed"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil || s.orderStatus != "ready" || !slices.Equal(csr.DNSNames, []string{s.domain}) {
+		s.write(w, http.StatusForbidden, map[string]string{"type": "urn:ietf:params:acme:error:orderNotReady"})
+		return
+	}
+
+	// Issue certificate
+	leaf, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(int64(s.issued + 2)),
+		Subject:      pkix.Name{CommonName: s.domain},
+		DNSNames:     []string{s.domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, s.ca, csr.PublicKey, s.caKey)
+	if err != nil {
+		s.write(w, http.StatusInternalServerError, map[string]string{"type": "urn:ietf:params:acme:error:serverInternal"})
+		return
+	}
+	s.certificate = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.ca.Raw})...)
+	s.orderStatus = "valid"
+	s.issued++
+	s.write(w, http.StatusOK, s.order())
+}
+
+func (s *acmeStub) order() map[string]interface{} {
+	order := map[string]interface{}{
+		"status":         s.orderStatus,
+		"identifiers":    []map[string]string{{"type": "dns", "value": s.domain}},
+		"authorizations": []string{s.URL + "/authz/1"},
+		"finalize":       s.URL + "/finalize/1",
+	}
+	if s.orderStatus == "valid" {
+		order["certificate"] = s.URL + "/cert/1"
+	}
+	return order
+}
+
+func (s *acmeStub) authz() map[string]interface{} {
+	return map[string]interface{}{
+		"status":     s.authzStatus,
+		"identifier": map[string]string{"type": "dns", "value": s.domain},
+		"challenges": []interface{}{s.challenge()},
+	}
+}
+
+// challenge only offers HTTP-01, which the redirect listener answers
+func (s *acmeStub) challenge() map[string]string {
+	return map[string]string{
+		"type":   "http-01",
+		"url":    s.URL + "/challenge/1",
+		"token":  acmeChallengeToken,
+		"status": s.authzStatus,
+	}
+}
+
+// acmeConfig returns a config that obtains certificates for app.example.com from the stub
+func (s *acmeStub) acmeConfig(t *testing.T) *config.Config {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &config.Config{}
+	cfg.Server.Port = "8443"
+	cfg.TLS.ACMEDomains = []string{"app.example.com"}
+	cfg.TLS.ACMEEmail = "ops@example.com"
+	cfg.TLS.ACMEDirectoryURL = s.URL + "/dir"
+	cfg.TLS.ACMECacheDir = filepath.Join(dir, "cache")
+	cfg.TLS.ACMECAFile = filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(cfg.TLS.ACMECAFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestSetupWithACME(t *testing.T) {
+	stub := newACMEStub(t)
+	cfg := stub.acmeConfig(t)
+
+	tlsConfig, handler, err := Setup(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Setup() = %v", err)
+	}
+	stub.mu.Lock()
+	stub.challengeHandler = handler
+	stub.mu.Unlock()
+
+	// The certificate is issued through the HTTP-01 challenge and then served from the cache
+	for i := 0; i < 2; i++ {
+		cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "app.example.com"})
+		if err != nil {
+			t.Fatalf("GetCertificate() = %v", err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := leaf.CheckSignatureFrom(stub.ca); err != nil || leaf.VerifyHostname("app.example.com") != nil {
+			t.Errorf("certificate for %v (%v), want one issued by the stub for app.example.com", leaf.DNSNames, err)
+		}
+	}
+	if stub.issued != 1 {
+		t.Errorf("issued %d certificates, want 1", stub.issued)
+	}
+	if cached, _ := filepath.Glob(filepath.Join(cfg.TLS.ACMECacheDir, "app.example.com*")); len(cached) == 0 {
+		t.Error("certificate not cached")
+	}
+
+	// Only configured domains get certificates
+	if _, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"}); err == nil {
+		t.Error("GetCertificate(other.example.com) = nil error, want an error")
+	}
+
+	if tlsConfig.MinVersion != tls.VersionTLS12 || !slices.Contains(tlsConfig.NextProtos, "h2") {
+		t.Errorf("TLS config = %+v, want TLS 1.2 and HTTP/2", tlsConfig)
+	}
+
+	// Other HTTP requests are redirected
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://app.example.com/links", nil))
+	if location := rec.Header().Get("Location"); rec.Code != http.StatusMovedPermanently || location != "https://app.example.com:8443/links" {
+		t.Errorf("redirect = %d %q, want 301 https://app.example.com:8443/links", rec.Code, location)
+	}
+}
+
+func TestSetupWithACMEFailures(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, stub *acmeStub, cfg *config.Config)
+	}{
+		{
+			name: "challenge not answered",
+			setup: func(t *testing.T, stub *acmeStub, cfg *config.Config) {
+				stub.challengeHandler = RedirectHandler(cfg.Server.Port)
+			},
+		},
+		{
+			name: "untrusted directory",
+			setup: func(t *testing.T, stub *acmeStub, cfg *config.Config) {
+				cfg.TLS.ACMECAFile = ""
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newACMEStub(t)
+			cfg := stub.acmeConfig(t)
+			tt.setup(t, stub, cfg)
+
+			tlsConfig, handler, err := Setup(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("Setup() = %v", err)
+			}
+			stub.mu.Lock()
+			if stub.challengeHandler == nil {
+				stub.challengeHandler = handler
+			}
+			stub.mu.Unlock()
+
+			if _, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "app.example.com"}); err == nil {
+				t.Fatal("GetCertificate() = nil error, want an error")
+			}
+			if stub.issued != 0 {
+				t.Errorf("issued %d certificates, want 0", stub.issued)
+			}
+		})
+	}
+}
+
+func TestNewACMEManagerCAFile(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.pem")
+	if err := os.WriteFile(invalid, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{filepath.Join(dir, "missing.pem"), invalid} {
+		cfg := &config.Config{}
+		cfg.TLS.ACMEDomains = []string{"app.example.com"}
+		cfg.TLS.ACMECAFile = file
+		if _, err := NewACMEManager(cfg); err == nil {
+			t.Errorf("NewACMEManager() with CA file %s = nil error, want an error", filepath.Base(file))
+		}
+	}
+}
@@ -1,7 +1,14 @@
 package config
 
 import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -9,13 +16,27 @@ import (
 
 // Config holds all configuration for the application
 type Config struct {
+	// Environment is development or production. Production refuses to start with insecure settings.
+	Environment string
+
 	Database struct {
+		// URL is a connection URL that takes precedence over the discrete settings
+		URL      string
 		Host     string
 		Port     string
 		User     string
 		Password string
 		Name     string
 		SSLMode  string
+
+		MaxOpenConns    int
+		MaxIdleConns    int
+		ConnMaxLifetime time.Duration
+		ConnMaxIdleTime time.Duration
+		// ConnectTimeout is how long startup keeps retrying to connect
+		ConnectTimeout time.Duration
+		// AutoMigrate applies pending migrations on startup
+		AutoMigrate bool
 	}
 	JWT struct {
 		Secret string
@@ -32,58 +53,459 @@ type Config struct {
 			ClientSecret string
 			RedirectURL  string
 		}
+		OIDC []OIDCProviderConfig
+	}
+	SAML struct {
+		CertFile string
+		KeyFile  string
+		BaseURL  string
+	}
+	WebAuthn struct {
+		RPID          string
+		RPDisplayName string
+		RPOrigins     []string
+	}
+	Audit struct {
+		Retention time.Duration
+	}
+	Account struct {
+		DeletionGracePeriod time.Duration
+		// ReauthWindow is how long after logging in a session may change its password or email
+		// or delete its account without confirming a password or two-factor code again
+		ReauthWindow time.Duration
+	}
+	Password struct {
+		MinLength          int
+		BreachedHashesFile string
 	}
 	Server struct {
 		Port        string
 		FrontendURL string
+		// ShutdownDrainDelay is how long readiness fails before the server stops accepting connections
+		ShutdownDrainDelay time.Duration
+		// TrustedProxies are the networks whose X-Forwarded-For and X-Forwarded-Proto headers are believed
+		TrustedProxies []*net.IPNet
+	}
+	TLS struct {
+		// CertFile and KeyFile serve HTTPS with a certificate that is reloaded when the files change
+		CertFile string
+		KeyFile  string
+		// ACMEDomains serve HTTPS with certificates obtained automatically from an ACME CA instead
+		ACMEDomains      []string
+		ACMEEmail        string
+		ACMEDirectoryURL string
+		ACMECacheDir     string
+		// ACMECAFile is a PEM bundle trusted for the ACME directory, for testing against a local CA
+		ACMECAFile string
+		// RedirectPort serves HTTP redirects to HTTPS and ACME challenges when set
+		RedirectPort string
 	}
+	CORS struct {
+		// AllowedOrigins may browse the API. An origin like https://*.example.com allows its subdomains.
+		AllowedOrigins []string
+		// OAuthAllowedOrigins may also call the OAuth token and revoke endpoints, for third-party apps
+		OAuthAllowedOrigins []string
+		MaxAge              time.Duration
+	}
+	SecurityHeaders struct {
+		// HSTSMaxAge is sent in Strict-Transport-Security, which is omitted when zero
+		HSTSMaxAge            time.Duration
+		HSTSIncludeSubdomains bool
+		// APIContentSecurityPolicy applies to JSON and other non-HTML responses
+		APIContentSecurityPolicy  string
+		HTMLContentSecurityPolicy string
+		ReferrerPolicy            string
+	}
+	Mail struct {
+		// SMTPHost sends emails through an SMTP server. Emails are logged instead when it is empty.
+		SMTPHost     string
+		SMTPPort     string
+		SMTPUsername string
+		SMTPPassword string
+		From         string
+	}
+	Log struct {
+		Level  slog.Level
+		Format string
+	}
+	Tracing struct {
+		Exporter    string
+		ServiceName string
+	}
+	Metrics struct {
+		Enabled bool
+		// AdminPort serves /metrics on a separate port instead of the API port when set
+		AdminPort string
+	}
+
+	// values are the resolved settings in load order
+	values []Value
 }
 
-// LoadConfig loads configuration from environment variables
+// Environments
+const (
+	EnvironmentDevelopment = "development"
+	EnvironmentProduction  = "production"
+)
+
+// Tracing exporters
+const (
+	TracingExporterNone   = "none"
+	TracingExporterOTLP   = "otlp"
+	TracingExporterStdout = "stdout"
+)
+
+// Log formats
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// OIDCProviderConfig holds configuration for a generic OpenID Connect provider
+type OIDCProviderConfig struct {
+	Name         string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// oidcProviderNamePattern restricts provider names to values safe for URLs and the oauth_accounts table
+var oidcProviderNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+// reservedOIDCProviderNames are route names under /api/auth that OIDC providers cannot use
+var reservedOIDCProviderNames = map[string]bool{
+	"github":          true,
+	"google":          true,
+	"signup":          true,
+	"login":           true,
+	"forgot-password": true,
+	"reset-password":  true,
+	"me":              true,
+	"mfa":             true,
+	"saml":            true,
+	"passkeys":        true,
+}
+
+// LoadConfig loads configuration from environment variables, secret files and the optional
+// YAML or TOML file named by CONFIG_FILE, then validates it
 func LoadConfig() (*Config, error) {
+	cfg, err := Resolve()
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// Resolve loads configuration like LoadConfig, but returns the settings resolved so far along with
+// any error, so that they can be shown next to what is wrong with them
+func Resolve() (*Config, error) {
 	// Load .env file if it exists
 	_ = godotenv.Load()
 
 	cfg := &Config{}
+	src, err := newSource(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return cfg, err
+	}
+	defer func() { cfg.values = src.values }()
+
+	// Environment
+	cfg.Environment = strings.ToLower(src.get("APP_ENV", EnvironmentDevelopment))
+	if cfg.Environment != EnvironmentDevelopment && cfg.Environment != EnvironmentProduction {
+		return cfg, fmt.Errorf("invalid APP_ENV: must be development or production")
+	}
 
 	// Database configuration
-	cfg.Database.Host = getEnv("DB_HOST", "localhost")
-	cfg.Database.Port = getEnv("DB_PORT", "5432")
-	cfg.Database.User = getEnv("DB_USER", "postgres")
-	cfg.Database.Password = getEnv("DB_PASSWORD", "postgres")
-	cfg.Database.Name = getEnv("DB_NAME", "zyply")
-	cfg.Database.SSLMode = getEnv("DB_SSLMODE", "disable")
+	cfg.Database.Host = src.get("DB_HOST", "localhost")
+	cfg.Database.Port = src.get("DB_PORT", "5432")
+	cfg.Database.User = src.get("DB_USER", "postgres")
+	cfg.Database.Password = src.get("DB_PASSWORD", defaultDBPassword)
+	cfg.Database.Name = src.get("DB_NAME", "zyply")
+	cfg.Database.SSLMode = src.get("DB_SSLMODE", "disable")
+	cfg.Database.URL = src.get("DATABASE_URL", "")
 
-	// JWT configuration
-	cfg.JWT.Secret = getEnv("JWT_SECRET", "your-jwt-secret-key-change-in-production")
-	expiryStr := getEnv("JWT_EXPIRY", "24h")
-	expiry, err := time.ParseDuration(expiryStr)
+	// Database pool configuration
+	maxOpenConns, err := strconv.Atoi(src.get("DB_MAX_OPEN_CONNS", "25"))
+	if err != nil || maxOpenConns < 1 {
+		return cfg, fmt.Errorf("invalid DB_MAX_OPEN_CONNS: must be a positive number")
+	}
+	cfg.Database.MaxOpenConns = maxOpenConns
+	maxIdleConns, err := strconv.Atoi(src.get("DB_MAX_IDLE_CONNS", "5"))
+	if err != nil || maxIdleConns < 0 || maxIdleConns > maxOpenConns {
+		return cfg, fmt.Errorf("invalid DB_MAX_IDLE_CONNS: must be between 0 and DB_MAX_OPEN_CONNS")
+	}
+	cfg.Database.MaxIdleConns = maxIdleConns
+	if cfg.Database.ConnMaxLifetime, err = src.getDuration("DB_CONN_MAX_LIFETIME", "30m"); err != nil {
+		return cfg, err
+	}
+	if cfg.Database.ConnMaxIdleTime, err = src.getDuration("DB_CONN_MAX_IDLE_TIME", "5m"); err != nil {
+		return cfg, err
+	}
+	if cfg.Database.ConnectTimeout, err = src.getDuration("DB_CONNECT_TIMEOUT", "60s"); err != nil {
+		return cfg, err
+	}
+	if cfg.Database.ConnectTimeout == 0 {
+		return cfg, fmt.Errorf("invalid DB_CONNECT_TIMEOUT: must be greater than zero")
+	}
+	autoMigrate, err := strconv.ParseBool(src.get("DB_AUTO_MIGRATE", "true"))
 	if err != nil {
-		expiry = 24 * time.Hour
+		return cfg, fmt.Errorf("invalid DB_AUTO_MIGRATE: must be true or false")
+	}
+	cfg.Database.AutoMigrate = autoMigrate
+
+	// JWT configuration
+	cfg.JWT.Secret = src.get("JWT_SECRET", defaultJWTSecret)
+	if cfg.JWT.Expiry, err = src.getDuration("JWT_EXPIRY", "24h"); err != nil {
+		return cfg, err
+	}
+	if cfg.JWT.Expiry == 0 {
+		return cfg, fmt.Errorf("invalid JWT_EXPIRY: must be greater than zero")
 	}
-	cfg.JWT.Expiry = expiry
 
 	// OAuth configuration
-	cfg.OAuth.GitHub.ClientID = getEnv("GITHUB_CLIENT_ID", "")
-	cfg.OAuth.GitHub.ClientSecret = getEnv("GITHUB_CLIENT_SECRET", "")
-	cfg.OAuth.GitHub.RedirectURL = getEnv("GITHUB_REDIRECT_URL", "http://localhost:8080/api/auth/github/callback")
+	cfg.OAuth.GitHub.ClientID = src.get("GITHUB_CLIENT_ID", "")
+	cfg.OAuth.GitHub.ClientSecret = src.get("GITHUB_CLIENT_SECRET", "")
+	cfg.OAuth.GitHub.RedirectURL = src.get("GITHUB_REDIRECT_URL", "http://localhost:8080/api/auth/github/callback")
+
+	cfg.OAuth.Google.ClientID = src.get("GOOGLE_CLIENT_ID", "")
+	cfg.OAuth.Google.ClientSecret = src.get("GOOGLE_CLIENT_SECRET", "")
+	cfg.OAuth.Google.RedirectURL = src.get("GOOGLE_REDIRECT_URL", "http://localhost:8080/api/auth/google/callback")
+
+	oidcProviders, err := loadOIDCProviders(src)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.OAuth.OIDC = oidcProviders
 
-	cfg.OAuth.Google.ClientID = getEnv("GOOGLE_CLIENT_ID", "")
-	cfg.OAuth.Google.ClientSecret = getEnv("GOOGLE_CLIENT_SECRET", "")
-	cfg.OAuth.Google.RedirectURL = getEnv("GOOGLE_REDIRECT_URL", "http://localhost:8080/api/auth/google/callback")
+	// SAML configuration
+	cfg.SAML.CertFile = src.get("SAML_CERT_FILE", "")
+	cfg.SAML.KeyFile = src.get("SAML_KEY_FILE", "")
+	cfg.SAML.BaseURL = strings.TrimRight(src.get("SAML_BASE_URL", "http://localhost:8080"), "/")
 
 	// Server configuration
-	cfg.Server.Port = getEnv("SERVER_PORT", "8080")
-	cfg.Server.FrontendURL = getEnv("FRONTEND_URL", "http://localhost:3000")
+	cfg.Server.Port = src.get("SERVER_PORT", "8080")
+	cfg.Server.FrontendURL = src.get("FRONTEND_URL", "http://localhost:3000")
+	if cfg.Server.ShutdownDrainDelay, err = src.getDuration("SHUTDOWN_DRAIN_DELAY", "0s"); err != nil {
+		return cfg, err
+	}
 
-	return cfg, nil
+	// Trusted proxy configuration
+	for _, proxy := range splitList(src.get("TRUSTED_PROXIES", "")) {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid TRUSTED_PROXIES: %q must be an IP address or CIDR", proxy)
+		}
+		cfg.Server.TrustedProxies = append(cfg.Server.TrustedProxies, network)
+	}
+
+	// TLS configuration
+	cfg.TLS.CertFile = src.get("TLS_CERT_FILE", "")
+	cfg.TLS.KeyFile = src.get("TLS_KEY_FILE", "")
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		return cfg, fmt.Errorf("invalid TLS_CERT_FILE and TLS_KEY_FILE: both must be set to serve TLS")
+	}
+	cfg.TLS.ACMEDomains = splitList(src.get("ACME_DOMAINS", ""))
+	if cfg.TLS.CertFile != "" && len(cfg.TLS.ACMEDomains) > 0 {
+		return cfg, fmt.Errorf("invalid ACME_DOMAINS: cannot be used with TLS_CERT_FILE")
+	}
+	cfg.TLS.ACMEEmail = src.get("ACME_EMAIL", "")
+	cfg.TLS.ACMEDirectoryURL = src.get("ACME_DIRECTORY_URL", "https://acme-v02.api.letsencrypt.org/directory")
+	cfg.TLS.ACMECacheDir = src.get("ACME_CACHE_DIR", "acme-cache")
+	cfg.TLS.ACMECAFile = src.get("ACME_CA_FILE", "")
+	cfg.TLS.RedirectPort = src.get("HTTP_REDIRECT_PORT", "")
+	if cfg.TLS.RedirectPort != "" && !cfg.TLSEnabled() {
+		return cfg, fmt.Errorf("invalid HTTP_REDIRECT_PORT: requires TLS_CERT_FILE or ACME_DOMAINS")
+	}
+	if cfg.TLS.RedirectPort != "" && cfg.TLS.RedirectPort == cfg.Server.Port {
+		return cfg, fmt.Errorf("invalid HTTP_REDIRECT_PORT: must differ from SERVER_PORT")
+	}
+
+	// CORS configuration
+	if cfg.CORS.AllowedOrigins, err = parseOrigins("CORS_ALLOWED_ORIGINS", src.get("CORS_ALLOWED_ORIGINS", cfg.Server.FrontendURL)); err != nil {
+		return cfg, err
+	}
+	if cfg.CORS.OAuthAllowedOrigins, err = parseOrigins("CORS_OAUTH_ALLOWED_ORIGINS", src.get("CORS_OAUTH_ALLOWED_ORIGINS", "")); err != nil {
+		return cfg, err
+	}
+	if cfg.CORS.MaxAge, err = src.getDuration("CORS_MAX_AGE", "5m"); err != nil {
+		return cfg, err
+	}
+
+	// Security headers configuration
+	if cfg.SecurityHeaders.HSTSMaxAge, err = src.getDuration("HSTS_MAX_AGE", "8760h"); err != nil {
+		return cfg, err
+	}
+	includeSubdomains, err := strconv.ParseBool(src.get("HSTS_INCLUDE_SUBDOMAINS", "false"))
+	if err != nil {
+		return cfg, fmt.Errorf("invalid HSTS_INCLUDE_SUBDOMAINS: must be true or false")
+	}
+	cfg.SecurityHeaders.HSTSIncludeSubdomains = includeSubdomains
+	cfg.SecurityHeaders.APIContentSecurityPolicy = src.get("CSP_API", "default-src 'none'; frame-ancestors 'none'")
+	cfg.SecurityHeaders.HTMLContentSecurityPolicy = src.get("CSP_HTML", "default-src 'self'; base-uri 'none'; form-action 'self'; frame-ancestors 'none'")
+	cfg.SecurityHeaders.ReferrerPolicy = src.get("REFERRER_POLICY", "no-referrer")
+
+	// WebAuthn configuration
+	cfg.WebAuthn.RPID = src.get("WEBAUTHN_RP_ID", "localhost")
+	cfg.WebAuthn.RPDisplayName = src.get("WEBAUTHN_RP_DISPLAY_NAME", "Zyply")
+	cfg.WebAuthn.RPOrigins = splitList(src.get("WEBAUTHN_RP_ORIGINS", cfg.Server.FrontendURL))
+
+	// Mail configuration
+	cfg.Mail.SMTPHost = src.get("SMTP_HOST", "")
+	cfg.Mail.SMTPPort = src.get("SMTP_PORT", "587")
+	cfg.Mail.SMTPUsername = src.get("SMTP_USERNAME", "")
+	cfg.Mail.SMTPPassword = src.get("SMTP_PASSWORD", "")
+	cfg.Mail.From = src.get("MAIL_FROM", "Zyply <no-reply@localhost>")
+
+	// Audit configuration
+	retentionDays, err := strconv.Atoi(src.get("AUDIT_RETENTION_DAYS", "365"))
+	if err != nil || retentionDays < 0 {
+		return cfg, fmt.Errorf("invalid AUDIT_RETENTION_DAYS: must be a non-negative number of days")
+	}
+	cfg.Audit.Retention = time.Duration(retentionDays) * 24 * time.Hour
+
+	// Account configuration
+	graceDays, err := strconv.Atoi(src.get("ACCOUNT_DELETION_GRACE_DAYS", "30"))
+	if err != nil || graceDays < 0 {
+		return cfg, fmt.Errorf("invalid ACCOUNT_DELETION_GRACE_DAYS: must be a non-negative number of days")
+	}
+	cfg.Account.DeletionGracePeriod = time.Duration(graceDays) * 24 * time.Hour
+	if cfg.Account.ReauthWindow, err = src.getDuration("ACCOUNT_REAUTH_WINDOW", "5m"); err != nil {
+		return cfg, err
+	}
+
+	// Password policy configuration
+	minLength, err := strconv.Atoi(src.get("PASSWORD_MIN_LENGTH", "8"))
+	if err != nil || minLength < 1 || minLength > 72 {
+		return cfg, fmt.Errorf("invalid PASSWORD_MIN_LENGTH: must be between 1 and 72")
+	}
+	cfg.Password.MinLength = minLength
+	cfg.Password.BreachedHashesFile = src.get("PASSWORD_BREACHED_HASHES_FILE", "")
+
+	// Logging configuration
+	if err := cfg.Log.Level.UnmarshalText([]byte(src.get("LOG_LEVEL", "info"))); err != nil {
+		return cfg, fmt.Errorf("invalid LOG_LEVEL: must be debug, info, warn or error")
+	}
+	cfg.Log.Format = strings.ToLower(src.get("LOG_FORMAT", LogFormatJSON))
+	if cfg.Log.Format != LogFormatJSON && cfg.Log.Format != LogFormatText {
+		return cfg, fmt.Errorf("invalid LOG_FORMAT: must be json or text")
+	}
+
+	// Tracing configuration, using the standard OpenTelemetry variable names
+	cfg.Tracing.Exporter = strings.ToLower(src.get("OTEL_TRACES_EXPORTER", TracingExporterNone))
+	switch cfg.Tracing.Exporter {
+	case TracingExporterNone, TracingExporterOTLP, TracingExporterStdout:
+	default:
+		return cfg, fmt.Errorf("invalid OTEL_TRACES_EXPORTER: must be none, otlp or stdout")
+	}
+	cfg.Tracing.ServiceName = src.get("OTEL_SERVICE_NAME", "zyply")
+
+	// Metrics configuration
+	metricsEnabled, err := strconv.ParseBool(src.get("METRICS_ENABLED", "true"))
+	if err != nil {
+		return cfg, fmt.Errorf("invalid METRICS_ENABLED: must be true or false")
+	}
+	cfg.Metrics.Enabled = metricsEnabled
+	cfg.Metrics.AdminPort = src.get("METRICS_ADMIN_PORT", "")
+	if cfg.Metrics.AdminPort != "" && cfg.Metrics.AdminPort == cfg.Server.Port {
+		return cfg, fmt.Errorf("invalid METRICS_ADMIN_PORT: must differ from SERVER_PORT")
+	}
+
+	if src.err != nil {
+		return cfg, src.err
+	}
+	cfg.values = src.values
+
+	return cfg, cfg.Validate()
+}
+
+// TLSEnabled checks if the server serves HTTPS itself rather than behind a TLS-terminating proxy
+func (c *Config) TLSEnabled() bool {
+	return c.TLS.CertFile != "" || len(c.TLS.ACMEDomains) > 0
+}
+
+// Values returns every resolved setting with its origin, in load order
+func (c *Config) Values() []Value {
+	return c.values
+}
+
+// loadOIDCProviders loads the OIDC providers listed in OIDC_PROVIDERS.
+// Each provider is configured with OIDC_<NAME>_* variables, e.g. OIDC_KEYCLOAK_ISSUER_URL.
+func loadOIDCProviders(src *source) ([]OIDCProviderConfig, error) {
+	var providers []OIDCProviderConfig
+	seen := map[string]bool{}
+
+	for _, name := range strings.Split(src.get("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		// Validate name
+		if !oidcProviderNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid OIDC provider name %q", name)
+		}
+		if reservedOIDCProviderNames[name] {
+			return nil, fmt.Errorf("OIDC provider name %q is reserved", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate OIDC provider %q", name)
+		}
+		seen[name] = true
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := OIDCProviderConfig{
+			Name:         name,
+			IssuerURL:    src.get(prefix+"ISSUER_URL", ""),
+			ClientID:     src.get(prefix+"CLIENT_ID", ""),
+			ClientSecret: src.get(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  src.get(prefix+"REDIRECT_URL", "http://localhost:8080/api/auth/"+name+"/callback"),
+			Scopes:       strings.Fields(src.get(prefix+"SCOPES", "openid email profile")),
+		}
+		if provider.IssuerURL == "" || provider.ClientID == "" {
+			return nil, fmt.Errorf("OIDC provider %q requires %sISSUER_URL and %sCLIENT_ID", name, prefix, prefix)
+		}
+
+		providers = append(providers, provider)
+	}
+
+	return providers, nil
 }
 
-// getEnv gets an environment variable or returns a default value
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+// parseOrigins parses a comma-separated list of origins such as https://app.example.com or
+// https://*.example.com. Paths and a bare * are rejected so the allowlist stays explicit.
+func parseOrigins(key, value string) ([]string, error) {
+	origins := splitList(value)
+	for i, origin := range origins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.User != nil || strings.TrimSuffix(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("invalid %s: %q must be an origin such as https://app.example.com", key, origin)
+		}
+		host := strings.TrimPrefix(u.Hostname(), "*.")
+		if host == "" || strings.Contains(host, "*") {
+			return nil, fmt.Errorf("invalid %s: %q may only use a wildcard for the leading subdomain", key, origin)
+		}
+		origins[i] = strings.ToLower(u.Scheme + "://" + u.Host)
+	}
+	return origins, nil
+}
+
+// splitList splits a comma-separated list, trimming whitespace and dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
-	return value
+	return items
 }
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Origins of configuration values
+const (
+	OriginDefault    = "default"
+	OriginFile       = "file"
+	OriginEnv        = "env"
+	OriginSecretFile = "secret file"
+)
+
+// Redacted replaces secret values when configuration is printed
+const Redacted = "[REDACTED]"
+
+// Value is a resolved configuration value and where it came from
+type Value struct {
+	Key    string
+	Value  string
+	Origin string
+}
+
+// Secret checks if a key holds a credential
+func (v Value) Secret() bool {
+	return strings.Contains(v.Key, "SECRET") || strings.HasSuffix(v.Key, "PASSWORD") || v.Key == "DATABASE_URL"
+}
+
+// Redacted returns the value with credentials hidden. Connection URLs keep everything but their password.
+func (v Value) Redacted() string {
+	if !v.Secret() || v.Value == "" {
+		return v.Value
+	}
+	if v.Key == "DATABASE_URL" {
+		if u, err := url.Parse(v.Value); err == nil && u.User != nil {
+			return u.Redacted()
+		}
+	}
+	return Redacted
+}
+
+// source resolves configuration keys, preferring in order an environment variable, a file named by
+// the variable with a _FILE suffix, the config file, and the default. Secrets can be mounted as files
+// and referenced with e.g. JWT_SECRET_FILE=/run/secrets/jwt_secret.
+type source struct {
+	file   map[string]string
+	values []Value
+	// err is the first error resolving a key, such as an unreadable secret file
+	err error
+}
+
+// newSource creates a source reading the YAML or TOML config file at path, if any. Nested keys in
+// the file are joined with underscores, so jwt.secret and JWT_SECRET configure the same value.
+func newSource(path string) (*source, error) {
+	src := &source{file: map[string]string{}}
+	if path == "" {
+		return src, nil
+	}
+
+	// Read file
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	// Parse file
+	var tree map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("unsupported config file %s: must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	flatten(src.file, "", tree)
+	return src, nil
+}
+
+// flatten adds the values of a parsed config file to keys, naming them like environment variables
+func flatten(keys map[string]string, prefix string, tree map[string]interface{}) {
+	for name, value := range tree {
+		key := strings.ToUpper(strings.ReplaceAll(prefix+name, "-", "_"))
+		switch value := value.(type) {
+		case map[string]interface{}:
+			flatten(keys, key+"_", value)
+		case []interface{}:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			keys[key] = strings.Join(items, ",")
+		case nil:
+		default:
+			keys[key] = fmt.Sprint(value)
+		}
+	}
+}
+
+// get resolves a key, falling back to a default value
+func (s *source) get(key, defaultValue string) string {
+	value, origin, err := s.lookup(key)
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	if origin == "" || err != nil {
+		value, origin = defaultValue, OriginDefault
+	}
+
+	s.values = append(s.values, Value{Key: key, Value: value, Origin: origin})
+	return value
+}
+
+// lookup resolves a key without a default, returning an empty origin if it is not set
+func (s *source) lookup(key string) (string, string, error) {
+	value, secretFile := os.Getenv(key), os.Getenv(key+"_FILE")
+	switch {
+	case value != "" && secretFile != "":
+		return "", "", fmt.Errorf("invalid %s: set either %s or %s_FILE, not both", key, key, key)
+	case value != "":
+		return value, OriginEnv, nil
+	case secretFile != "":
+		value, err := readSecretFile(key, secretFile)
+		return value, OriginSecretFile, err
+	}
+
+	if value, ok := s.file[key]; ok && value != "" {
+		return value, OriginFile, nil
+	}
+	if secretFile, ok := s.file[key+"_FILE"]; ok && secretFile != "" {
+		value, err := readSecretFile(key, secretFile)
+		return value, OriginSecretFile, err
+	}
+
+	return "", "", nil
+}
+
+// getDuration resolves a non-negative duration
+func (s *source) getDuration(key, defaultValue string) (time.Duration, error) {
+	value, err := time.ParseDuration(s.get(key, defaultValue))
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid %s: must be a non-negative duration such as %s", key, defaultValue)
+	}
+	return value, nil
+}
+
+// readSecretFile reads a secret from a mounted file, dropping the trailing newline
+func readSecretFile(key, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s_FILE: %w", key, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
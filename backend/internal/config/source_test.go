@@ -0,0 +1,169 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestFile writes a file into a temporary directory and returns its path
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSourcePrecedence(t *testing.T) {
+	envSecret := writeTestFile(t, "env_secret", "from-env-secret-file\n")
+	fileSecret := writeTestFile(t, "file_secret", "from-file-secret-file\n")
+
+	tests := []struct {
+		name       string
+		file       map[string]string
+		env        string
+		envFile    string
+		wantValue  string
+		wantOrigin string
+		wantErr    bool
+	}{
+		{"default", nil, "", "", "default", OriginDefault, false},
+		{"file", map[string]string{"JWT_SECRET": "from-file"}, "", "", "from-file", OriginFile, false},
+		{"secret file named in the file", map[string]string{"JWT_SECRET_FILE": fileSecret}, "", "", "from-file-secret-file", OriginSecretFile, false},
+		{"env over file", map[string]string{"JWT_SECRET": "from-file"}, "from-env", "", "from-env", OriginEnv, false},
+		{"secret file over file", map[string]string{"JWT_SECRET": "from-file"}, "", envSecret, "from-env-secret-file", OriginSecretFile, false},
+		{"secret file named in env over secret file named in the file", map[string]string{"JWT_SECRET_FILE": fileSecret}, "", envSecret, "from-env-secret-file", OriginSecretFile, false},
+		{"env and secret file", nil, "from-env", envSecret, "default", OriginDefault, true},
+		{"missing secret file", nil, "", filepath.Join(t.TempDir(), "missing"), "default", OriginDefault, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_SECRET", tt.env)
+			t.Setenv("JWT_SECRET_FILE", tt.envFile)
+			src := &source{file: map[string]string{}}
+			for key, value := range tt.file {
+				src.file[key] = value
+			}
+
+			if got := src.get("JWT_SECRET", "default"); got != tt.wantValue {
+				t.Errorf("get() = %q, want %q", got, tt.wantValue)
+			}
+			if got := src.values[0].Origin; got != tt.wantOrigin {
+				t.Errorf("origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if (src.err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", src.err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewSourceFormats(t *testing.T) {
+	want := map[string]string{
+		"APP_ENV":              "production",
+		"JWT_SECRET":           "file-secret",
+		"JWT_ACCESS_TOKEN_TTL": "15m",
+		"DB_PORT":              "5433",
+		"CORS_ALLOWED_ORIGINS": "https://a.example.com,https://b.example.com",
+	}
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"config.yaml", `
+app_env: production
+jwt:
+  secret: file-secret
+  access-token-ttl: 15m
+db:
+  port: 5433
+cors:
+  allowed_origins:
+    - https://a.example.com
+    - https://b.example.com
+`},
+		{"config.yml", `
+APP_ENV: production
+JWT_SECRET: file-secret
+JWT_ACCESS_TOKEN_TTL: 15m
+DB_PORT: 5433
+CORS_ALLOWED_ORIGINS: https://a.example.com,https://b.example.com
+`},
+		{"config.toml", `
+app_env = "production"
+
+[jwt]
+secret = "file-secret"
+access-token-ttl = "15m"
+
+[db]
+port = 5433
+
+[cors]
+allowed_origins = ["https://a.example.com", "https://b.example.com"]
+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := newSource(writeTestFile(t, tt.name, tt.content))
+			if err != nil {
+				t.Fatalf("newSource() = %v", err)
+			}
+			if !reflect.DeepEqual(src.file, want) {
+				t.Errorf("file = %v, want %v", src.file, want)
+			}
+		})
+	}
+}
+
+func TestNewSourceErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{"missing file", filepath.Join(t.TempDir(), "config.yaml"), "error reading config file"},
+		{"unsupported format", writeTestFile(t, "config.json", `{}`), "unsupported config file"},
+		{"invalid yaml", writeTestFile(t, "config.yaml", "jwt: [secret"), "error parsing config file"},
+		{"yaml in a toml file", writeTestFile(t, "config.toml", "jwt:\n  secret: file-secret\n"), "error parsing config file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newSource(tt.path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("newSource() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValueRedacted(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		want  string
+	}{
+		{"JWT_SECRET", "s3cret", Redacted},
+		{"DB_PASSWORD", "s3cret", Redacted},
+		{"OIDC_OKTA_CLIENT_SECRET", "s3cret", Redacted},
+		{"DATABASE_URL", "postgres://zyply:s3cret@db:5432/zyply?sslmode=require", "postgres://zyply:xxxxx@db:5432/zyply?sslmode=require"},
+		{"DATABASE_URL", "postgres://db:5432/zyply?password=s3cret", Redacted},
+		{"DATABASE_URL", "host=db password=s3cret", Redacted},
+		{"JWT_SECRET", "", ""},
+		{"DB_HOST", "db", "db"},
+		{"PASSWORD_MIN_LENGTH", "12", "12"},
+	}
+
+	for _, tt := range tests {
+		if got := (Value{Key: tt.key, Value: tt.value}).Redacted(); got != tt.want {
+			t.Errorf("Redacted(%s=%q) = %q, want %q", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
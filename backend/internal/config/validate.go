@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Insecure defaults that production refuses to run with
+const (
+	defaultJWTSecret  = "your-jwt-secret-key-change-in-production"
+	defaultDBPassword = "postgres"
+	minJWTSecretBytes = 32
+)
+
+// Validate checks the configuration for settings that are unsafe in production.
+// Development accepts the defaults so the service runs without any setup.
+func (c *Config) Validate() error {
+	if c.Environment != EnvironmentProduction {
+		return nil
+	}
+
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// JWT
+	if c.JWT.Secret == defaultJWTSecret || len(c.JWT.Secret) < minJWTSecretBytes {
+		fail("JWT_SECRET must be set to a random value of at least %d bytes", minJWTSecretBytes)
+	}
+
+	// Database
+	if c.Database.URL == "" {
+		if c.Database.Password == "" || c.Database.Password == defaultDBPassword {
+			fail("DB_PASSWORD must be set to a non-default value")
+		}
+		if c.Database.SSLMode == "disable" {
+			fail("DB_SSLMODE must not be disable")
+		}
+	} else if u, err := url.Parse(c.Database.URL); err == nil && u.Query().Get("sslmode") == "disable" {
+		fail("DATABASE_URL must not use sslmode=disable")
+	}
+
+	// Placeholder secrets from the sample configuration
+	for _, value := range c.values {
+		if value.Secret() && strings.HasPrefix(value.Value, "your-") && value.Key != "JWT_SECRET" {
+			fail("%s must be set to a real value", value.Key)
+		}
+	}
+
+	// Emails carry password reset links, which must not end up in logs
+	if c.Mail.SMTPHost == "" {
+		fail("SMTP_HOST must be set")
+	}
+
+	// Metrics expose route patterns, login failures and pool stats, so keep them off the public API port
+	if c.Metrics.Enabled && c.Metrics.AdminPort == "" {
+		fail("METRICS_ADMIN_PORT must be set when METRICS_ENABLED is true")
+	}
+
+	// Public URLs
+	if !strings.HasPrefix(c.Server.FrontendURL, "https://") {
+		fail("FRONTEND_URL must use https")
+	}
+	if c.SAML.CertFile != "" && !strings.HasPrefix(c.SAML.BaseURL, "https://") {
+		fail("SAML_BASE_URL must use https")
+	}
+	corsOrigins := append(append([]string{}, c.CORS.AllowedOrigins...), c.CORS.OAuthAllowedOrigins...)
+	for _, origin := range corsOrigins {
+		if !strings.HasPrefix(origin, "https://") {
+			fail("CORS_ALLOWED_ORIGINS and CORS_OAUTH_ALLOWED_ORIGINS must use https")
+			break
+		}
+	}
+	for _, origin := range c.WebAuthn.RPOrigins {
+		if !strings.HasPrefix(origin, "https://") {
+			fail("WEBAUTHN_RP_ORIGINS must use https")
+			break
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("insecure configuration for production: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -1,11 +1,14 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"time"
 
 	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/migrations"
 	_ "github.com/lib/pq"
 	"github.com/pressly/goose/v3"
 )
@@ -13,10 +16,70 @@ import (
 // DB is the database connection
 var DB *sql.DB
 
-// Initialize initializes the database connection and runs migrations
+// Connection retry backoff bounds
+const (
+	initialRetryDelay = 500 * time.Millisecond
+	maxRetryDelay     = 10 * time.Second
+)
+
+// retryAfter waits between connection attempts; tests replace it to run without sleeping
+var retryAfter = time.After
+
+// pinger is a database connection that can be checked
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// Initialize initializes the database connection and runs migrations unless auto-migration is disabled
 func Initialize(cfg *config.Config) error {
-	// Create connection string
-	connStr := fmt.Sprintf(
+	if err := Connect(cfg); err != nil {
+		return err
+	}
+
+	// Run migrations
+	if !cfg.Database.AutoMigrate {
+		slog.Info("Automatic migrations are disabled")
+		return nil
+	}
+	if err := MigrateUp(); err != nil {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	return nil
+}
+
+// Connect opens the database connection and waits for the database to accept connections
+func Connect(cfg *config.Config) error {
+	// Connect to database
+	var err error
+	DB, err = sql.Open("postgres", ConnectionString(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	// Configure pool
+	DB.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	DB.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	DB.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime)
+	DB.SetConnMaxIdleTime(cfg.Database.ConnMaxIdleTime)
+
+	// Wait for the database to accept connections
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Database.ConnectTimeout)
+	defer cancel()
+	if err := waitForConnection(ctx, DB); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	slog.Info("Connected to database")
+	return nil
+}
+
+// ConnectionString returns DATABASE_URL if set, and otherwise builds a connection string from the discrete settings
+func ConnectionString(cfg *config.Config) string {
+	if cfg.Database.URL != "" {
+		return cfg.Database.URL
+	}
+	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 		cfg.Database.Host,
 		cfg.Database.Port,
@@ -25,43 +88,109 @@ func Initialize(cfg *config.Config) error {
 		cfg.Database.Name,
 		cfg.Database.SSLMode,
 	)
+}
 
-	// Connect to database
-	var err error
-	DB, err = sql.Open("postgres", connStr)
-	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+// waitForConnection pings the database until it responds, backing off exponentially
+// between attempts, and returns the last error once the context is done
+func waitForConnection(ctx context.Context, db pinger) error {
+	delay := initialRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+
+		slog.Warn("Database not ready, retrying", "attempt", attempt, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-retryAfter(delay):
+		}
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
 	}
+}
 
-	// Check connection
-	if err := DB.Ping(); err != nil {
-		return fmt.Errorf("failed to ping database: %w", err)
+// Stats returns connection pool statistics for metrics
+func Stats() sql.DBStats {
+	if DB == nil {
+		return sql.DBStats{}
 	}
+	return DB.Stats()
+}
 
-	log.Println("Connected to database successfully")
+// setupGoose points goose at the embedded migrations
+func setupGoose() error {
+	goose.SetBaseFS(migrations.FS)
+	if err := goose.SetDialect("postgres"); err != nil {
+		return fmt.Errorf("failed to set goose dialect: %w", err)
+	}
+	return nil
+}
 
-	// Run migrations
-	if err := runMigrations(); err != nil {
-		return fmt.Errorf("failed to run migrations: %w", err)
+// MigrateUp applies all pending migrations
+func MigrateUp() error {
+	if err := setupGoose(); err != nil {
+		return err
+	}
+	if err := goose.Up(DB, "."); err != nil {
+		return err
 	}
 
+	slog.Info("Migrations completed")
 	return nil
 }
 
-// runMigrations runs database migrations
-func runMigrations() error {
-	// Set up goose
-	if err := goose.SetDialect("postgres"); err != nil {
-		return fmt.Errorf("failed to set goose dialect: %w", err)
+// MigrateDown rolls back the most recently applied migration
+func MigrateDown() error {
+	if err := setupGoose(); err != nil {
+		return err
 	}
+	return goose.Down(DB, ".")
+}
 
-	// Run migrations
-	if err := goose.Up(DB, "migrations"); err != nil {
-		return fmt.Errorf("failed to run migrations: %w", err)
+// MigrateStatus logs which migrations have been applied
+func MigrateStatus() error {
+	if err := setupGoose(); err != nil {
+		return err
 	}
+	return goose.Status(DB, ".")
+}
 
-	log.Println("Migrations completed successfully")
-	return nil
+// Pool is a connection pool that can report the migration version of its database
+type Pool struct {
+	*sql.DB
+}
+
+// MigrationVersion returns the version of the most recently applied migration
+func (p Pool) MigrationVersion(ctx context.Context) (int64, error) {
+	var version int64
+	err := p.QueryRowContext(ctx, "SELECT COALESCE(MAX(version_id), 0) FROM goose_db_version WHERE is_applied").Scan(&version)
+	return version, err
+}
+
+// LatestMigration returns the version of the newest embedded migration
+func LatestMigration() (int64, error) {
+	if err := setupGoose(); err != nil {
+		return 0, err
+	}
+	collected, err := goose.CollectMigrations(".", 0, goose.MaxVersion)
+	if err != nil {
+		return 0, err
+	}
+	last, err := collected.Last()
+	if err != nil {
+		return 0, err
+	}
+	return last.Version, nil
+}
+
+// CreateMigration writes a new timestamped SQL migration named name into dir on disk
+func CreateMigration(dir, name string) error {
+	return goose.Create(nil, dir, name, "sql")
 }
 
 // Close closes the database connection
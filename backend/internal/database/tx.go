@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Querier is implemented by *sql.DB and *sql.Tx
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// txKey is the context key for the transaction of a unit of work
+type txKey struct{}
+
+// WithTx runs fn as one unit of work in a transaction on db, committing if fn returns nil and
+// rolling back otherwise. Queries made through Conn with the context passed to fn join the
+// transaction, as do nested WithTx calls.
+func WithTx(ctx context.Context, db *sql.DB, fn func(ctx context.Context) error) error {
+	// Join the transaction already in progress
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	ctx, span := tracer.Start(ctx, "transaction")
+	defer span.End()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		recordError(span, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		recordError(span, err)
+		return err
+	}
+	err = tx.Commit()
+	recordError(span, err)
+	return err
+}
+
+// Conn returns the transaction of the unit of work in ctx, or db outside of one. Queries
+// made through it are traced.
+func Conn(ctx context.Context, db *sql.DB) Querier {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tracedQuerier{q: tx}
+	}
+	return tracedQuerier{q: db}
+}
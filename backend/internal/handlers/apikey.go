@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/apierr"
+	"github.com/RanitManik/zyply/internal/audit"
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/middleware"
+	"github.com/RanitManik/zyply/internal/models"
+	"github.com/RanitManik/zyply/internal/validate"
+	"github.com/go-chi/chi/v5"
+)
+
+// APIKeyHandler handles personal API key management
+type APIKeyHandler struct {
+	Config *config.Config
+}
+
+// NewAPIKeyHandler creates a new APIKeyHandler
+func NewAPIKeyHandler(cfg *config.Config) *APIKeyHandler {
+	return &APIKeyHandler{
+		Config: cfg,
+	}
+}
+
+// CreateAPIKeyRequest represents a request to create an API key
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" validate:"required,max=255"`
+	Scopes        []string `json:"scopes" validate:"required"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// CreateAPIKeyResponse represents a newly created API key. The full key is only returned once.
+type CreateAPIKeyResponse struct {
+	*models.APIKey
+	Key string `json:"key"`
+}
+
+// List lists the current user's API keys in the current workspace
+func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	// Get workspace ID
+	workspaceID, ok := middleware.GetWorkspaceID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.BadRequest("Workspace required"))
+		return
+	}
+
+	// Get keys
+	keys, err := models.GetAPIKeys(r.Context(), userID, workspaceID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get API keys", err))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// Create creates a new API key for the current user in the current workspace
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	// Get workspace ID
+	workspaceID, ok := middleware.GetWorkspaceID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.BadRequest("Workspace required"))
+		return
+	}
+
+	// Parse request
+	var req CreateAPIKeyRequest
+	checkExpiry := func() *validate.FieldError {
+		if req.ExpiresInDays < 0 {
+			return &validate.FieldError{Field: "expires_in_days", Code: validate.CodeInvalid, Message: "expires_in_days must not be negative"}
+		}
+		return nil
+	}
+	if err := decodeRequest(w, r, &req, checkScopes(&req.Scopes), checkExpiry); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	// Generate key
+	key, prefix, secretHash, err := auth.GenerateAPIKey()
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate API key", err))
+		return
+	}
+
+	// Store key
+	apiKey, err := models.CreateAPIKey(r.Context(), userID, workspaceID, req.Name, prefix, secretHash, req.Scopes, expiresAt)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to create API key", err))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionAPIKeyCreated,
+		TargetType: audit.TargetAPIKey,
+		TargetID:   strconv.FormatInt(apiKey.ID, 10),
+		After:      apiKey,
+	})
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPIKeyResponse{
+		APIKey: apiKey,
+		Key:    key,
+	})
+}
+
+// Revoke revokes one of the current user's API keys
+func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	// Get key ID
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		apierr.Write(w, r, apierr.BadRequest("Invalid API key ID"))
+		return
+	}
+
+	// Revoke key
+	revoked, err := models.RevokeAPIKey(r.Context(), id, userID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to revoke API key", err))
+		return
+	}
+	if !revoked {
+		apierr.Write(w, r, apierr.NotFound("API key not found"))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionAPIKeyRevoked,
+		TargetType: audit.TargetAPIKey,
+		TargetID:   strconv.FormatInt(id, 10),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
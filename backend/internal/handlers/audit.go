@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/apierr"
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/middleware"
+	"github.com/RanitManik/zyply/internal/models"
+)
+
+const (
+	// defaultAuditPageSize is the number of audit events returned when no limit is given
+	defaultAuditPageSize = 50
+	// maxAuditPageSize is the largest number of audit events returned in one page
+	maxAuditPageSize = 200
+)
+
+// AuditHandler handles the audit log
+type AuditHandler struct {
+	Config *config.Config
+}
+
+// NewAuditHandler creates a new AuditHandler
+func NewAuditHandler(cfg *config.Config) *AuditHandler {
+	return &AuditHandler{
+		Config: cfg,
+	}
+}
+
+// AuditEventsResponse represents a page of audit events
+type AuditEventsResponse struct {
+	Events     []*models.AuditEvent `json:"events"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// List lists the current workspace's audit events, newest first. Events can be filtered with the
+// actor_id, action, target_type, target_id, since and until query parameters and paged with
+// limit and the cursor returned as next_cursor.
+func (h *AuditHandler) List(w http.ResponseWriter, r *http.Request) {
+	// Get user and workspace
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+	org, ok := currentWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse filters
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		OrganizationID: org.ID,
+		Action:         query.Get("action"),
+		TargetType:     query.Get("target_type"),
+		TargetID:       query.Get("target_id"),
+		Limit:          defaultAuditPageSize,
+	}
+	if org.Personal {
+		// Account events such as logins belong to no workspace, so show them in the personal one
+		filter.PersonalActorID = userID
+	}
+
+	var err error
+	if filter.ActorID, err = parseInt64Param(query.Get("actor_id")); err != nil {
+		apierr.Write(w, r, apierr.BadRequest("Invalid actor_id"))
+		return
+	}
+	if filter.BeforeID, err = parseInt64Param(query.Get("cursor")); err != nil {
+		apierr.Write(w, r, apierr.BadRequest("Invalid cursor"))
+		return
+	}
+	if filter.Since, err = parseTimeParam(query.Get("since")); err != nil {
+		apierr.Write(w, r, apierr.BadRequest("Invalid since, expected an RFC 3339 time"))
+		return
+	}
+	if filter.Until, err = parseTimeParam(query.Get("until")); err != nil {
+		apierr.Write(w, r, apierr.BadRequest("Invalid until, expected an RFC 3339 time"))
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxAuditPageSize {
+			apierr.Write(w, r, apierr.BadRequest("Invalid limit, expected 1 to "+strconv.Itoa(maxAuditPageSize)))
+			return
+		}
+	}
+
+	// Get events
+	events, err := models.ListAuditEvents(r.Context(), filter)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get audit events", err))
+		return
+	}
+
+	resp := AuditEventsResponse{Events: events}
+	if len(events) == filter.Limit {
+		resp.NextCursor = strconv.FormatInt(events[len(events)-1].ID, 10)
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// parseInt64Param parses an optional integer query parameter
+func parseInt64Param(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// parseTimeParam parses an optional RFC 3339 time query parameter
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
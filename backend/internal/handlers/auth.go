@@ -1,78 +1,116 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"time"
+	"net/url"
+	"strconv"
 
+	"github.com/RanitManik/zyply/internal/apierr"
+	"github.com/RanitManik/zyply/internal/audit"
 	"github.com/RanitManik/zyply/internal/auth"
 	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/logging"
+	"github.com/RanitManik/zyply/internal/mail"
+	"github.com/RanitManik/zyply/internal/metrics"
+	"github.com/RanitManik/zyply/internal/middleware"
 	"github.com/RanitManik/zyply/internal/models"
+	"github.com/RanitManik/zyply/internal/validate"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/oauth2"
 )
 
 // AuthHandler handles authentication requests
 type AuthHandler struct {
-	Config *config.Config
+	Config        *config.Config
+	OIDC          *auth.OIDCProviders
+	Passwords     *auth.PasswordPolicy
+	Users         models.UserRepository
+	OAuthAccounts models.OAuthAccountRepository
+	MFA           models.MFARepository
+	Audit         audit.Recorder
+	Mail          mail.Sender
 }
 
 // NewAuthHandler creates a new AuthHandler
-func NewAuthHandler(cfg *config.Config) *AuthHandler {
+func NewAuthHandler(cfg *config.Config, passwords *auth.PasswordPolicy, users models.UserRepository, oauthAccounts models.OAuthAccountRepository, mfa models.MFARepository, recorder audit.Recorder, mailer mail.Sender) *AuthHandler {
 	return &AuthHandler{
-		Config: cfg,
+		Config:        cfg,
+		OIDC:          auth.NewOIDCProviders(cfg),
+		Passwords:     passwords,
+		Users:         users,
+		OAuthAccounts: oauthAccounts,
+		MFA:           mfa,
+		Audit:         recorder,
+		Mail:          mailer,
 	}
 }
 
 // SignupRequest represents a signup request
 type SignupRequest struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Name     string `json:"name" validate:"required,max=255"`
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password"` // Checked against the password policy
 }
 
 // LoginRequest represents a login request
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"required"`
+	Password string `json:"password" validate:"required"`
 }
 
-// AuthResponse represents an authentication response
+// AuthResponse represents an authentication response. When the user has
+// two-factor authentication enabled, only MFARequired and MFAToken are set.
 type AuthResponse struct {
-	Token string       `json:"token"`
-	User  *models.User `json:"user"`
+	Token       string       `json:"token,omitempty"`
+	User        *models.User `json:"user,omitempty"`
+	MFARequired bool         `json:"mfa_required,omitempty"`
+	MFAToken    string       `json:"mfa_token,omitempty"`
 }
 
 // ForgotPasswordRequest represents a forgot password request
 type ForgotPasswordRequest struct {
-	Email string `json:"email"`
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ResetPasswordRequest represents a request to set a new password with a reset token
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password"` // Checked against the password policy
 }
 
 // Signup handles user signup
 func (h *AuthHandler) Signup(w http.ResponseWriter, r *http.Request) {
 	// Parse request
 	var req SignupRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Validate request
-	if req.Name == "" || req.Email == "" || req.Password == "" {
-		http.Error(w, "Name, email, and password are required", http.StatusBadRequest)
+	checkPassword := func() *validate.FieldError { return h.Passwords.Check("password", req.Password) }
+	if err := decodeRequest(w, r, &req, checkPassword); err != nil {
+		apierr.Write(w, r, err)
 		return
 	}
 
 	// Create user
-	user, err := models.CreateUser(req.Name, req.Email, req.Password)
+	user, err := h.Users.Create(r.Context(), req.Name, req.Email, req.Password)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierr.Write(w, r, err)
 		return
 	}
+	h.Audit.Record(r, audit.Event{
+		Action:     audit.ActionSignup,
+		ActorID:    user.ID,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.FormatInt(user.ID, 10),
+		After:      user,
+	})
 
 	// Generate token
 	token, err := auth.GenerateToken(user.ID, user.Email, h.Config)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		apierr.Write(w, r, apierr.Internal("Failed to generate token", err))
 		return
 	}
 
@@ -89,42 +127,38 @@ func (h *AuthHandler) Signup(w http.ResponseWriter, r *http.Request) {
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	// Parse request
 	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Validate request
-	if req.Email == "" || req.Password == "" {
-		http.Error(w, "Email and password are required", http.StatusBadRequest)
+	if err := decodeRequest(w, r, &req); err != nil {
+		apierr.Write(w, r, err)
 		return
 	}
 
 	// Get user
-	user, err := models.GetUserByEmail(req.Email)
+	user, err := h.Users.GetByEmail(r.Context(), req.Email)
 	if err != nil {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		if errors.Is(err, models.ErrUserNotFound) {
+			metrics.RecordLogin("password", false)
+			err = apierr.Unauthorized(apierr.CodeInvalidCredentials, "Invalid email or password")
+		}
+		apierr.Write(w, r, err)
 		return
 	}
 
 	// Verify password
 	if !user.VerifyPassword(req.Password) {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		recordLogin(h.Audit, r, user, audit.ActionLoginFailed, "password")
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeInvalidCredentials, "Invalid email or password"))
 		return
 	}
+	recordLogin(h.Audit, r, user, audit.ActionLogin, "password")
 
-	// Generate token
-	token, err := auth.GenerateToken(user.ID, user.Email, h.Config)
+	// Generate token, or an MFA pending token if two-factor authentication is enabled
+	resp, err := newAuthResponse(r.Context(), h.MFA, user, h.Config)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		apierr.Write(w, r, apierr.Internal("Failed to generate token", err))
 		return
 	}
 
 	// Return response
-	resp := AuthResponse{
-		Token: token,
-		User:  user,
-	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
@@ -134,64 +168,54 @@ func (h *AuthHandler) GitHubLogin(w http.ResponseWriter, r *http.Request) {
 	// Get OAuth config
 	oauthConfig := auth.GetGitHubOAuthConfig(h.Config)
 
-	// Generate state
-	state := fmt.Sprintf("%d", time.Now().UnixNano())
-
-	// Store state in cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "oauth_state",
-		Value:    state,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   r.TLS != nil,
-		MaxAge:   int(5 * time.Minute.Seconds()),
-	})
+	// Generate state and store it in a signed cookie
+	state, ok := h.startOAuth(w, r, models.ProviderGitHub)
+	if !ok {
+		return
+	}
 
 	// Redirect to GitHub
-	url := oauthConfig.AuthCodeURL(state)
+	url := oauthConfig.AuthCodeURL(state.State, oauth2.S256ChallengeOption(state.CodeVerifier))
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
 // GitHubCallback handles GitHub OAuth callback
 func (h *AuthHandler) GitHubCallback(w http.ResponseWriter, r *http.Request) {
-	// Get state from cookie
-	stateCookie, err := r.Cookie("oauth_state")
+	// Validate state against the signed cookie
+	state, err := auth.ReadOAuthState(r, models.ProviderGitHub, h.Config)
 	if err != nil {
-		http.Error(w, "Invalid state", http.StatusBadRequest)
-		return
-	}
-
-	// Validate state
-	if r.URL.Query().Get("state") != stateCookie.Value {
-		http.Error(w, "Invalid state", http.StatusBadRequest)
+		writeCallbackError(w, r, models.ProviderGitHub, apierr.New(http.StatusBadRequest, apierr.CodeInvalidState, "Invalid state"))
 		return
 	}
+	http.SetCookie(w, auth.ClearOAuthStateCookie(r))
 
 	// Get code
 	code := r.URL.Query().Get("code")
 	if code == "" {
-		http.Error(w, "Code is required", http.StatusBadRequest)
+		writeCallbackError(w, r, models.ProviderGitHub, apierr.BadRequest("Code is required"))
 		return
 	}
 
 	// Exchange code for token
 	oauthConfig := auth.GetGitHubOAuthConfig(h.Config)
-	token, err := oauthConfig.Exchange(r.Context(), code)
+	token, err := oauthConfig.Exchange(auth.OAuthContext(r.Context()), code, oauth2.VerifierOption(state.CodeVerifier))
 	if err != nil {
-		http.Error(w, "Failed to exchange code for token", http.StatusInternalServerError)
+		writeCallbackError(w, r, models.ProviderGitHub, apierr.Internal("Failed to exchange code for token", err))
 		return
 	}
 
 	// Get user info
-	githubUser, err := auth.GetGitHubUser(token, h.Config)
+	githubUser, err := auth.GetGitHubUser(r.Context(), token, h.Config)
 	if err != nil {
-		http.Error(w, "Failed to get user info", http.StatusInternalServerError)
+		writeCallbackError(w, r, models.ProviderGitHub, apierr.Internal("Failed to get user info", err))
 		return
 	}
 
 	// Process user
 	providerData, _ := json.Marshal(githubUser)
-	user, err := auth.ProcessOAuthUser(
+	user, err := auth.ProcessOAuthUser(r.Context(),
+		h.Users,
+		h.OAuthAccounts,
 		models.ProviderGitHub,
 		fmt.Sprintf("%d", githubUser.ID),
 		githubUser.Email,
@@ -199,20 +223,21 @@ func (h *AuthHandler) GitHubCallback(w http.ResponseWriter, r *http.Request) {
 		string(providerData),
 	)
 	if err != nil {
-		http.Error(w, "Failed to process user", http.StatusInternalServerError)
+		writeCallbackError(w, r, models.ProviderGitHub, apierr.Internal("Failed to process user", err))
 		return
 	}
+	recordLogin(h.Audit, r, user, audit.ActionOAuthLogin, string(models.ProviderGitHub))
 
-	// Generate token
-	jwtToken, err := auth.GenerateToken(user.ID, user.Email, h.Config)
+	// Generate token, or an MFA pending token if two-factor authentication is enabled
+	resp, err := newAuthResponse(r.Context(), h.MFA, user, h.Config)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		writeCallbackError(w, r, models.ProviderGitHub, apierr.Internal("Failed to generate token", err))
 		return
 	}
 
 	// Redirect to frontend with token
-	redirectURL := fmt.Sprintf("%s/auth/callback?token=%s", h.Config.Server.FrontendURL, jwtToken)
-	http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
+	metrics.OAuthCallbacks.WithLabelValues(string(models.ProviderGitHub), metrics.ResultSuccess).Inc()
+	http.Redirect(w, r, frontendCallbackURL(h.Config, resp, state.ReturnTo), http.StatusTemporaryRedirect)
 }
 
 // GoogleLogin initiates Google OAuth flow
@@ -220,64 +245,65 @@ func (h *AuthHandler) GoogleLogin(w http.ResponseWriter, r *http.Request) {
 	// Get OAuth config
 	oauthConfig := auth.GetGoogleOAuthConfig(h.Config)
 
-	// Generate state
-	state := fmt.Sprintf("%d", time.Now().UnixNano())
-
-	// Store state in cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "oauth_state",
-		Value:    state,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   r.TLS != nil,
-		MaxAge:   int(5 * time.Minute.Seconds()),
-	})
+	// Generate state and store it in a signed cookie
+	state, ok := h.startOAuth(w, r, models.ProviderGoogle)
+	if !ok {
+		return
+	}
 
 	// Redirect to Google
-	url := oauthConfig.AuthCodeURL(state)
+	url := oauthConfig.AuthCodeURL(state.State, oauth2.S256ChallengeOption(state.CodeVerifier), oidc.Nonce(state.Nonce))
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
 // GoogleCallback handles Google OAuth callback
 func (h *AuthHandler) GoogleCallback(w http.ResponseWriter, r *http.Request) {
-	// Get state from cookie
-	stateCookie, err := r.Cookie("oauth_state")
+	// Validate state against the signed cookie
+	state, err := auth.ReadOAuthState(r, models.ProviderGoogle, h.Config)
 	if err != nil {
-		http.Error(w, "Invalid state", http.StatusBadRequest)
-		return
-	}
-
-	// Validate state
-	if r.URL.Query().Get("state") != stateCookie.Value {
-		http.Error(w, "Invalid state", http.StatusBadRequest)
+		writeCallbackError(w, r, models.ProviderGoogle, apierr.New(http.StatusBadRequest, apierr.CodeInvalidState, "Invalid state"))
 		return
 	}
+	http.SetCookie(w, auth.ClearOAuthStateCookie(r))
 
 	// Get code
 	code := r.URL.Query().Get("code")
 	if code == "" {
-		http.Error(w, "Code is required", http.StatusBadRequest)
+		writeCallbackError(w, r, models.ProviderGoogle, apierr.BadRequest("Code is required"))
 		return
 	}
 
 	// Exchange code for token
 	oauthConfig := auth.GetGoogleOAuthConfig(h.Config)
-	token, err := oauthConfig.Exchange(r.Context(), code)
+	token, err := oauthConfig.Exchange(auth.OAuthContext(r.Context()), code, oauth2.VerifierOption(state.CodeVerifier))
+	if err != nil {
+		writeCallbackError(w, r, models.ProviderGoogle, apierr.Internal("Failed to exchange code for token", err))
+		return
+	}
+
+	// Verify ID token and nonce
+	idToken, err := auth.VerifyGoogleIDToken(auth.OAuthContext(r.Context()), token, state.Nonce, h.Config)
 	if err != nil {
-		http.Error(w, "Failed to exchange code for token", http.StatusInternalServerError)
+		writeCallbackError(w, r, models.ProviderGoogle, apierr.Unauthorized(apierr.CodeInvalidToken, "Invalid ID token"))
 		return
 	}
 
 	// Get user info
-	googleUser, err := auth.GetGoogleUser(token, h.Config)
+	googleUser, err := auth.GetGoogleUser(r.Context(), token, h.Config)
 	if err != nil {
-		http.Error(w, "Failed to get user info", http.StatusInternalServerError)
+		writeCallbackError(w, r, models.ProviderGoogle, apierr.Internal("Failed to get user info", err))
+		return
+	}
+	if googleUser.ID != idToken.Subject {
+		writeCallbackError(w, r, models.ProviderGoogle, apierr.Unauthorized(apierr.CodeInvalidToken, "Invalid ID token"))
 		return
 	}
 
 	// Process user
 	providerData, _ := json.Marshal(googleUser)
-	user, err := auth.ProcessOAuthUser(
+	user, err := auth.ProcessOAuthUser(r.Context(),
+		h.Users,
+		h.OAuthAccounts,
 		models.ProviderGoogle,
 		googleUser.ID,
 		googleUser.Email,
@@ -285,56 +311,205 @@ func (h *AuthHandler) GoogleCallback(w http.ResponseWriter, r *http.Request) {
 		string(providerData),
 	)
 	if err != nil {
-		http.Error(w, "Failed to process user", http.StatusInternalServerError)
+		writeCallbackError(w, r, models.ProviderGoogle, apierr.Internal("Failed to process user", err))
 		return
 	}
+	recordLogin(h.Audit, r, user, audit.ActionOAuthLogin, string(models.ProviderGoogle))
 
-	// Generate token
-	jwtToken, err := auth.GenerateToken(user.ID, user.Email, h.Config)
+	// Generate token, or an MFA pending token if two-factor authentication is enabled
+	resp, err := newAuthResponse(r.Context(), h.MFA, user, h.Config)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		writeCallbackError(w, r, models.ProviderGoogle, apierr.Internal("Failed to generate token", err))
 		return
 	}
 
 	// Redirect to frontend with token
-	redirectURL := fmt.Sprintf("%s/auth/callback?token=%s", h.Config.Server.FrontendURL, jwtToken)
-	http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
+	metrics.OAuthCallbacks.WithLabelValues(string(models.ProviderGoogle), metrics.ResultSuccess).Inc()
+	http.Redirect(w, r, frontendCallbackURL(h.Config, resp, state.ReturnTo), http.StatusTemporaryRedirect)
 }
 
-// Me gets the current user
-func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
-	// Get token from header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, "Authorization header required", http.StatusUnauthorized)
+// OIDCLogin initiates the OAuth flow for a configured OIDC provider
+func (h *AuthHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	// Get provider
+	provider, ok := h.oidcProvider(w, r)
+	if !ok {
 		return
 	}
 
-	// Check if it's a Bearer token
-	parts := []string{}
-	if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-		parts = []string{"Bearer", authHeader[7:]}
-	} else {
-		parts = []string{}
+	// Generate state and store it in a signed cookie
+	state, ok := h.startOAuth(w, r, provider.OAuthProvider())
+	if !ok {
+		return
 	}
 
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		http.Error(w, "Invalid Authorization header format", http.StatusUnauthorized)
+	// Redirect to provider
+	http.Redirect(w, r, provider.AuthCodeURL(state), http.StatusTemporaryRedirect)
+}
+
+// OIDCCallback handles the OAuth callback for a configured OIDC provider
+func (h *AuthHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	// Get provider
+	provider, ok := h.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+
+	// Validate state against the signed cookie
+	state, err := auth.ReadOAuthState(r, provider.OAuthProvider(), h.Config)
+	if err != nil {
+		writeCallbackError(w, r, provider.OAuthProvider(), apierr.New(http.StatusBadRequest, apierr.CodeInvalidState, "Invalid state"))
+		return
+	}
+	http.SetCookie(w, auth.ClearOAuthStateCookie(r))
+
+	// Get code
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		writeCallbackError(w, r, provider.OAuthProvider(), apierr.BadRequest("Code is required"))
 		return
 	}
 
-	// Validate token
-	tokenString := parts[1]
-	claims, err := auth.ValidateToken(tokenString, h.Config)
+	// Exchange code and verify ID token
+	oidcUser, err := provider.Exchange(auth.OAuthContext(r.Context()), code, state)
 	if err != nil {
-		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		writeCallbackError(w, r, provider.OAuthProvider(), apierr.Unauthorized(apierr.CodeUnauthorized, "Failed to authenticate with provider"))
+		return
+	}
+
+	// Process user
+	providerData, _ := json.Marshal(oidcUser)
+	user, err := auth.ProcessOAuthUser(r.Context(),
+		h.Users,
+		h.OAuthAccounts,
+		provider.OAuthProvider(),
+		oidcUser.Subject,
+		oidcUser.Email,
+		oidcUser.Name,
+		string(providerData),
+	)
+	if err != nil {
+		writeCallbackError(w, r, provider.OAuthProvider(), apierr.Internal("Failed to process user", err))
+		return
+	}
+	recordLogin(h.Audit, r, user, audit.ActionOAuthLogin, string(provider.OAuthProvider()))
+
+	// Generate token, or an MFA pending token if two-factor authentication is enabled
+	resp, err := newAuthResponse(r.Context(), h.MFA, user, h.Config)
+	if err != nil {
+		writeCallbackError(w, r, provider.OAuthProvider(), apierr.Internal("Failed to generate token", err))
+		return
+	}
+
+	// Redirect to frontend with token
+	metrics.OAuthCallbacks.WithLabelValues(string(provider.OAuthProvider()), metrics.ResultSuccess).Inc()
+	http.Redirect(w, r, frontendCallbackURL(h.Config, resp, state.ReturnTo), http.StatusTemporaryRedirect)
+}
+
+// oidcProvider resolves the OIDC provider named in the route
+func (h *AuthHandler) oidcProvider(w http.ResponseWriter, r *http.Request) (*auth.OIDCProvider, bool) {
+	name := chi.URLParam(r, "provider")
+	if !h.OIDC.Has(name) {
+		apierr.Write(w, r, apierr.New(http.StatusNotFound, apierr.CodeNotFound, "Unknown provider"))
+		return nil, false
+	}
+
+	provider, err := h.OIDC.Get(auth.OAuthContext(r.Context()), name)
+	if err != nil {
+		apierr.Write(w, r, &apierr.Error{Status: http.StatusBadGateway, Code: apierr.CodeUpstream, Message: "Provider unavailable", Cause: err})
+		return nil, false
+	}
+
+	return provider, true
+}
+
+// startOAuth creates a new OAuth state for the provider and stores it in a signed cookie
+func (h *AuthHandler) startOAuth(w http.ResponseWriter, r *http.Request, provider models.OAuthProvider) (*auth.OAuthState, bool) {
+	state, err := auth.NewOAuthState(provider, r.URL.Query().Get("redirect"))
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate state", err))
+		return nil, false
+	}
+
+	cookie, err := state.Cookie(r, h.Config)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate state", err))
+		return nil, false
+	}
+	http.SetCookie(w, cookie)
+
+	return state, true
+}
+
+// recordLogin records a login attempt by a user with the given method
+func recordLogin(recorder audit.Recorder, r *http.Request, user *models.User, action, method string) {
+	metrics.RecordLogin(method, action != audit.ActionLoginFailed)
+	recorder.Record(r, audit.Event{
+		Action:     action,
+		ActorID:    user.ID,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.FormatInt(user.ID, 10),
+		After:      map[string]string{"method": method},
+	})
+}
+
+// writeCallbackError writes an OAuth callback error and counts its code as the callback outcome
+func writeCallbackError(w http.ResponseWriter, r *http.Request, provider models.OAuthProvider, err error) {
+	apiErr := apierr.From(err)
+	metrics.OAuthCallbacks.WithLabelValues(string(provider), apiErr.Code).Inc()
+	apierr.Write(w, r, apiErr)
+}
+
+// newAuthResponse issues a full token for the user, or an MFA pending token
+// when the user has two-factor authentication enabled
+func newAuthResponse(ctx context.Context, mfa models.MFARepository, user *models.User, cfg *config.Config) (*AuthResponse, error) {
+	// Check if two-factor authentication is required
+	mfaEnabled, err := mfa.IsMFAEnabled(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if mfaEnabled {
+		mfaToken, err := auth.GenerateMFAToken(user.ID, user.Email, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &AuthResponse{MFARequired: true, MFAToken: mfaToken}, nil
+	}
+
+	// Generate token
+	token, err := auth.GenerateToken(user.ID, user.Email, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthResponse{Token: token, User: user}, nil
+}
+
+// frontendCallbackURL builds the frontend callback URL carrying the token and return path
+func frontendCallbackURL(cfg *config.Config, resp *AuthResponse, returnTo string) string {
+	query := url.Values{}
+	if resp.MFARequired {
+		query.Set("mfa_token", resp.MFAToken)
+	} else {
+		query.Set("token", resp.Token)
+	}
+	if returnTo != "" {
+		query.Set("redirect", returnTo)
+	}
+	return fmt.Sprintf("%s/auth/callback?%s", cfg.Server.FrontendURL, query.Encode())
+}
+
+// Me gets the current user
+func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
 		return
 	}
 
 	// Get user
-	user, err := models.GetUserByID(claims.UserID)
+	user, err := h.Users.GetByID(r.Context(), userID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		apierr.Write(w, r, err)
 		return
 	}
 
@@ -343,48 +518,105 @@ func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
-// ForgotPassword handles password reset requests
+// forgotPasswordMessage is returned whether or not the email belongs to a user, so it cannot be used to find accounts
+const forgotPasswordMessage = "If your email exists in our system, you will receive a password reset link"
+
+// ForgotPassword emails a single-use password reset link. The link is never returned in the
+// response, and the reset token cannot be used as a session.
 func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
 	// Parse request
 	var req ForgotPasswordRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Validate request
-	if req.Email == "" {
-		http.Error(w, "Email is required", http.StatusBadRequest)
+	if err := decodeRequest(w, r, &req); err != nil {
+		apierr.Write(w, r, err)
 		return
 	}
 
 	// Check if user exists
-	user, err := models.GetUserByEmail(req.Email)
+	user, err := h.Users.GetByEmail(r.Context(), req.Email)
 	if err != nil {
-		// Don't reveal that the email doesn't exist for security reasons
-		// Just return success even if the email doesn't exist
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{
-			"message": "If your email exists in our system, you will receive a password reset link",
-		})
+		if !errors.Is(err, models.ErrUserNotFound) {
+			logging.FromContext(r.Context()).Error("Failed to look up user for password reset", "error", err)
+		}
+		writeMessage(w, forgotPasswordMessage)
 		return
 	}
 
 	// Generate reset token
-	resetToken, err := auth.GenerateToken(user.ID, user.Email, h.Config)
+	resetToken, err := auth.GeneratePasswordResetToken(user.ID, user.Email, user.Password, h.Config)
 	if err != nil {
-		http.Error(w, "Failed to generate reset token", http.StatusInternalServerError)
+		apierr.Write(w, r, apierr.Internal("Failed to generate reset token", err))
 		return
 	}
+	h.Audit.Record(r, audit.Event{
+		Action:     audit.ActionPasswordResetIssued,
+		ActorID:    user.ID,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.FormatInt(user.ID, 10),
+	})
 
-	// In a real application, you would send an email with the reset link
-	// For this example, we'll just return the token
-	// TODO: Implement email sending
+	// Send reset link in the background so response times don't reveal whether the email exists
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Zyply password",
+		Body: "Someone asked to reset the password of your Zyply account. To choose a new password, open this link within " +
+			auth.PasswordResetExpiry.String() + ":\n\n" +
+			h.Config.Server.FrontendURL + "/reset-password?token=" + url.QueryEscape(resetToken) +
+			"\n\nIf this wasn't you, you can ignore this email.\n",
+	}
+	sendEmail(r, h.Mail, msg)
+
+	// Return response
+	writeMessage(w, forgotPasswordMessage)
+}
+
+// ResetPassword sets a new password with a token from a password reset email
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req ResetPasswordRequest
+	checkPassword := func() *validate.FieldError { return h.Passwords.Check("new_password", req.NewPassword) }
+	if err := decodeRequest(w, r, &req, checkPassword); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
 
-	// Return success response
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Password reset link sent",
-		"token":   resetToken, // In production, remove this and send via email
+	// Validate token against the user's current password, so it only works once
+	invalidToken := apierr.Unauthorized(apierr.CodeInvalidToken, "Invalid or expired reset token")
+	claims, err := auth.ValidatePasswordResetToken(req.Token, h.Config)
+	if err != nil {
+		apierr.Write(w, r, invalidToken)
+		return
+	}
+	user, err := h.Users.GetByID(r.Context(), claims.UserID)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			err = invalidToken
+		}
+		apierr.Write(w, r, err)
+		return
+	}
+	if !claims.ResetsPassword(user.Password, h.Config) {
+		apierr.Write(w, r, invalidToken)
+		return
+	}
+
+	// Update password
+	if err := h.Users.UpdatePassword(r.Context(), user.ID, req.NewPassword); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+	h.Audit.Record(r, audit.Event{
+		Action:     audit.ActionPasswordReset,
+		ActorID:    user.ID,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.FormatInt(user.ID, 10),
 	})
+
+	// Return response
+	writeMessage(w, "Password has been reset")
+}
+
+// writeMessage writes a JSON response with a message
+func writeMessage(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
 }
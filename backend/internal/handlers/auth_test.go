@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/audit"
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/mail"
+	"github.com/RanitManik/zyply/internal/middleware"
+	"github.com/RanitManik/zyply/internal/models"
+)
+
+// testPassword satisfies the password policy of newTestAuthHandler
+const testPassword = "correct horse battery"
+
+// recordingSender is a mail.Sender that hands sent messages to the test
+type recordingSender struct {
+	sent chan mail.Message
+}
+
+func (s *recordingSender) Send(ctx context.Context, msg mail.Message) error {
+	s.sent <- msg
+	return nil
+}
+
+// testAuthHandler is an AuthHandler backed by in-memory stores
+type testAuthHandler struct {
+	*AuthHandler
+	events *models.MemoryAuditEventRepository
+	mail   *recordingSender
+}
+
+func newTestAuthHandler(t *testing.T) *testAuthHandler {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.JWT.Secret = "test-secret"
+	cfg.JWT.Expiry = time.Hour
+	cfg.Password.MinLength = 12
+	cfg.Server.FrontendURL = "https://app.example.com"
+	passwords, err := auth.NewPasswordPolicy(cfg)
+	if err != nil {
+		t.Fatalf("NewPasswordPolicy: %v", err)
+	}
+
+	users, accounts := models.NewMemoryRepositories()
+	events := models.NewMemoryAuditEventRepository()
+	sender := &recordingSender{sent: make(chan mail.Message, 1)}
+	return &testAuthHandler{
+		AuthHandler: NewAuthHandler(cfg, passwords, users, accounts, models.NewMemoryMFARepository(), audit.NewLog(events), sender),
+		events:      events,
+		mail:        sender,
+	}
+}
+
+// serve calls a handler with a JSON body, as userID if it is not zero, and decodes the JSON response into v
+func serve(t *testing.T, handler http.HandlerFunc, body string, userID int64, v interface{}) int {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if userID != 0 {
+		r = r.WithContext(context.WithValue(r.Context(), middleware.UserIDKey, userID))
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if v != nil && w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("decode response %q: %v", w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+// errorResponse is the JSON error envelope
+type errorResponse struct {
+	Error struct {
+		Code string `json:"code"`
+	} `json:"error"`
+}
+
+// actions returns the actions of the recorded audit events, oldest first
+func (h *testAuthHandler) actions() []string {
+	var actions []string
+	for _, event := range h.events.Events() {
+		actions = append(actions, event.Action)
+	}
+	return actions
+}
+
+// signup signs up a user and returns their ID
+func (h *testAuthHandler) signup(t *testing.T, email string) int64 {
+	t.Helper()
+	var resp AuthResponse
+	body := `{"name":"Ada","email":"` + email + `","password":"` + testPassword + `"}`
+	if status := serve(t, h.Signup, body, 0, &resp); status != http.StatusOK || resp.Token == "" {
+		t.Fatalf("Signup() = %d %+v, want a token", status, resp)
+	}
+	return resp.User.ID
+}
+
+func TestSignupAndLogin(t *testing.T) {
+	h := newTestAuthHandler(t)
+	userID := h.signup(t, "ada@example.com")
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"signup with a taken email", h.Signup, `{"name":"Ada","email":"ada@example.com","password":"` + testPassword + `"}`, http.StatusConflict, "email_taken"},
+		{"signup with a weak password", h.Signup, `{"name":"Ada","email":"ada2@example.com","password":"short"}`, http.StatusBadRequest, "validation_failed"},
+		{"login with a wrong password", h.Login, `{"email":"ada@example.com","password":"wrong password"}`, http.StatusUnauthorized, "invalid_credentials"},
+		{"login with an unknown email", h.Login, `{"email":"grace@example.com","password":"` + testPassword + `"}`, http.StatusUnauthorized, "invalid_credentials"},
+		{"login", h.Login, `{"email":"ada@example.com","password":"` + testPassword + `"}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp struct {
+				errorResponse
+				AuthResponse
+			}
+			status := serve(t, tt.handler, tt.body, 0, &resp)
+			if status != tt.wantStatus || resp.Error.Code != tt.wantCode {
+				t.Fatalf("status = %d %q, want %d %q", status, resp.Error.Code, tt.wantStatus, tt.wantCode)
+			}
+			if tt.wantStatus == http.StatusOK {
+				claims, err := auth.ValidateToken(resp.Token, h.Config)
+				if err != nil || claims.UserID != userID {
+					t.Errorf("token = %q (%v), want a session for user %d", resp.Token, err, userID)
+				}
+			}
+		})
+	}
+
+	want := []string{audit.ActionSignup, audit.ActionLoginFailed, audit.ActionLogin}
+	if got := h.actions(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("audit actions = %v, want %v", got, want)
+	}
+}
+
+func TestLoginWithMFA(t *testing.T) {
+	h := newTestAuthHandler(t)
+	userID := h.signup(t, "ada@example.com")
+
+	// Enroll TOTP
+	var enroll TOTPEnrollResponse
+	if status := serve(t, h.EnrollTOTP, "", userID, &enroll); status != http.StatusOK {
+		t.Fatalf("EnrollTOTP() = %d", status)
+	}
+	code, err := auth.TOTPCode(enroll.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var codes RecoveryCodesResponse
+	if status := serve(t, h.ConfirmTOTP, `{"code":"`+code+`"}`, userID, &codes); status != http.StatusOK || len(codes.RecoveryCodes) != auth.RecoveryCodeCount {
+		t.Fatalf("ConfirmTOTP() = %d with %d recovery codes", status, len(codes.RecoveryCodes))
+	}
+
+	// Login only returns an MFA pending token
+	var login AuthResponse
+	serve(t, h.Login, `{"email":"ada@example.com","password":"`+testPassword+`"}`, 0, &login)
+	if !login.MFARequired || login.MFAToken == "" || login.Token != "" {
+		t.Fatalf("Login() = %+v, want only an MFA token", login)
+	}
+	if _, err := auth.ValidateToken(login.MFAToken, h.Config); err == nil {
+		t.Fatal("MFA token was accepted as a session token")
+	}
+
+	verify := func(code, recoveryCode string) (int, string) {
+		var resp struct {
+			errorResponse
+			AuthResponse
+		}
+		body := `{"mfa_token":"` + login.MFAToken + `","code":"` + code + `","recovery_code":"` + recoveryCode + `"}`
+		status := serve(t, h.VerifyMFA, body, 0, &resp)
+		if status == http.StatusOK && resp.Token == "" {
+			t.Fatalf("VerifyMFA() = %+v, want a token", resp)
+		}
+		return status, resp.Error.Code
+	}
+
+	// The code used to confirm enrollment cannot be replayed
+	if status, errCode := verify(code, ""); status != http.StatusUnauthorized || errCode != "invalid_mfa_code" {
+		t.Errorf("VerifyMFA() with a replayed code = %d %q, want 401 invalid_mfa_code", status, errCode)
+	}
+	if status, _ := verify("", codes.RecoveryCodes[0]); status != http.StatusOK {
+		t.Errorf("VerifyMFA() with a recovery code = %d, want 200", status)
+	}
+	if status, errCode := verify("", codes.RecoveryCodes[0]); status != http.StatusUnauthorized || errCode != "invalid_mfa_code" {
+		t.Errorf("VerifyMFA() with a used recovery code = %d %q, want 401 invalid_mfa_code", status, errCode)
+	}
+
+	// Verification locks after too many failures, even for valid codes
+	for i := 0; i < models.MaxTOTPFailures; i++ {
+		verify("000000", "")
+	}
+	if status, errCode := verify("", codes.RecoveryCodes[1]); status != http.StatusTooManyRequests || errCode != "mfa_locked" {
+		t.Errorf("VerifyMFA() when locked = %d %q, want 429 mfa_locked", status, errCode)
+	}
+
+	var status MFAStatusResponse
+	serve(t, h.MFAStatus, "", userID, &status)
+	if !status.Enabled || status.RecoveryCodesRemaining != auth.RecoveryCodeCount-1 {
+		t.Errorf("MFAStatus() = %+v, want enabled with %d recovery codes", status, auth.RecoveryCodeCount-1)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	h := newTestAuthHandler(t)
+	h.signup(t, "ada@example.com")
+
+	// Unknown emails get the same response and no email
+	var message map[string]string
+	if status := serve(t, h.ForgotPassword, `{"email":"grace@example.com"}`, 0, &message); status != http.StatusOK || message["message"] != forgotPasswordMessage {
+		t.Fatalf("ForgotPassword() for an unknown email = %d %v", status, message)
+	}
+	if status := serve(t, h.ForgotPassword, `{"email":"ada@example.com"}`, 0, &message); status != http.StatusOK || message["message"] != forgotPasswordMessage {
+		t.Fatalf("ForgotPassword() = %d %v", status, message)
+	}
+
+	// The reset link is only sent by email
+	var msg mail.Message
+	select {
+	case msg = <-h.mail.sent:
+	case <-time.After(time.Second):
+		t.Fatal("no password reset email was sent")
+	}
+	if msg.To != "ada@example.com" {
+		t.Fatalf("email sent to %q, want ada@example.com", msg.To)
+	}
+	link := regexp.MustCompile(`https://app\.example\.com/reset-password\?token=(\S+)`).FindStringSubmatch(msg.Body)
+	if link == nil {
+		t.Fatalf("email body %q has no reset link", msg.Body)
+	}
+	token, _ := url.QueryUnescape(link[1])
+
+	reset := func(token, password string) (int, string) {
+		var resp errorResponse
+		status := serve(t, h.ResetPassword, `{"token":"`+token+`","new_password":"`+password+`"}`, 0, &resp)
+		return status, resp.Error.Code
+	}
+	if status, errCode := reset(token, "short"); status != http.StatusBadRequest || errCode != "validation_failed" {
+		t.Errorf("ResetPassword() with a weak password = %d %q, want 400 validation_failed", status, errCode)
+	}
+	if status, _ := reset(token, "a brand new passphrase"); status != http.StatusOK {
+		t.Fatalf("ResetPassword() = %d, want 200", status)
+	}
+	if status, errCode := reset(token, "another new passphrase"); status != http.StatusUnauthorized || errCode != "invalid_token" {
+		t.Errorf("ResetPassword() with a used token = %d %q, want 401 invalid_token", status, errCode)
+	}
+
+	// Only the new password logs in
+	if status := serve(t, h.Login, `{"email":"ada@example.com","password":"`+testPassword+`"}`, 0, nil); status != http.StatusUnauthorized {
+		t.Errorf("Login() with the old password = %d, want 401", status)
+	}
+	if status := serve(t, h.Login, `{"email":"ada@example.com","password":"a brand new passphrase"}`, 0, nil); status != http.StatusOK {
+		t.Errorf("Login() with the new password = %d, want 200", status)
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/database"
+)
+
+// healthCheckTimeout bounds each readiness check
+const healthCheckTimeout = 2 * time.Second
+
+// Check statuses
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// HealthDatabase is the database readiness depends on
+type HealthDatabase interface {
+	PingContext(ctx context.Context) error
+	MigrationVersion(ctx context.Context) (int64, error)
+}
+
+// HealthHandler serves liveness and readiness probes
+type HealthHandler struct {
+	DB HealthDatabase
+	// LatestMigration is the migration version the database must be at to serve traffic
+	LatestMigration int64
+	shuttingDown    atomic.Bool
+}
+
+// HealthCheck is the result of one readiness check
+type HealthCheck struct {
+	Status  string      `json:"status"`
+	Error   string      `json:"error,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// HealthResponse is the overall readiness and the result of each check
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// NewHealthHandler creates a new HealthHandler
+func NewHealthHandler(db HealthDatabase) (*HealthHandler, error) {
+	latest, err := database.LatestMigration()
+	if err != nil {
+		return nil, err
+	}
+	return &HealthHandler{DB: db, LatestMigration: latest}, nil
+}
+
+// StartShutdown makes readiness fail so load balancers stop sending traffic
+func (h *HealthHandler) StartShutdown() {
+	h.shuttingDown.Store(true)
+}
+
+// Live reports that the process is running
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HealthResponse{Status: checkOK, Checks: map[string]HealthCheck{}})
+}
+
+// Ready reports whether the server can handle traffic, with the result of each check
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	// Run checks
+	resp := HealthResponse{
+		Status: checkOK,
+		Checks: map[string]HealthCheck{
+			"shutdown":   h.checkShutdown(),
+			"database":   h.checkDatabase(r.Context()),
+			"migrations": h.checkMigrations(r.Context()),
+		},
+	}
+	status := http.StatusOK
+	for _, check := range resp.Checks {
+		if check.Status != checkOK {
+			resp.Status = checkFail
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkShutdown fails once graceful shutdown has begun
+func (h *HealthHandler) checkShutdown() HealthCheck {
+	if h.shuttingDown.Load() {
+		return HealthCheck{Status: checkFail, Error: "server is shutting down"}
+	}
+	return HealthCheck{Status: checkOK}
+}
+
+// checkDatabase pings the database
+func (h *HealthHandler) checkDatabase(ctx context.Context) HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := h.DB.PingContext(ctx); err != nil {
+		return HealthCheck{Status: checkFail, Error: "database is unreachable"}
+	}
+	return HealthCheck{Status: checkOK, Details: map[string]int64{"latency_ms": time.Since(start).Milliseconds()}}
+}
+
+// checkMigrations checks the database has every migration this build expects. A database
+// ahead of the build passes, so older replicas keep serving during a rolling deploy.
+func (h *HealthHandler) checkMigrations(ctx context.Context) HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	version, err := h.DB.MigrationVersion(ctx)
+	if err != nil {
+		return HealthCheck{Status: checkFail, Error: "migration version is unavailable"}
+	}
+	details := map[string]int64{"version": version, "expected": h.LatestMigration}
+	if version < h.LatestMigration {
+		return HealthCheck{Status: checkFail, Error: fmt.Sprintf("database is at migration %d, expected %d", version, h.LatestMigration), Details: details}
+	}
+	return HealthCheck{Status: checkOK, Details: details}
+}
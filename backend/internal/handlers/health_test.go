@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubHealthDatabase is a database whose ping and migration version are set by the test
+type stubHealthDatabase struct {
+	pingErr    error
+	version    int64
+	versionErr error
+}
+
+func (db *stubHealthDatabase) PingContext(ctx context.Context) error {
+	return db.pingErr
+}
+
+func (db *stubHealthDatabase) MigrationVersion(ctx context.Context) (int64, error) {
+	return db.version, db.versionErr
+}
+
+// probe calls a health endpoint and decodes its response
+func probe(t *testing.T, handler http.HandlerFunc) (int, HealthResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	var resp HealthResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return rec.Code, resp
+}
+
+func TestReady(t *testing.T) {
+	const latest = 20240601000000
+
+	tests := []struct {
+		name       string
+		db         *stubHealthDatabase
+		wantStatus int
+		// wantFailed is the check expected to fail, if any
+		wantFailed string
+	}{
+		{"ready", &stubHealthDatabase{version: latest}, http.StatusOK, ""},
+		{"database ahead of the build", &stubHealthDatabase{version: latest + 1}, http.StatusOK, ""},
+		{"database unreachable", &stubHealthDatabase{pingErr: errors.New("connection refused"), version: latest}, http.StatusServiceUnavailable, "database"},
+		{"pending migrations", &stubHealthDatabase{version: latest - 1}, http.StatusServiceUnavailable, "migrations"},
+		{"migration version unavailable", &stubHealthDatabase{versionErr: errors.New("relation does not exist")}, http.StatusServiceUnavailable, "migrations"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HealthHandler{DB: tt.db, LatestMigration: latest}
+			status, resp := probe(t, h.Ready)
+			if status != tt.wantStatus {
+				t.Errorf("Ready() = %d, want %d", status, tt.wantStatus)
+			}
+			for name, check := range resp.Checks {
+				if want := name != tt.wantFailed; (check.Status == checkOK) != want {
+					t.Errorf("check %s = %+v, want ok %v", name, check, want)
+				}
+			}
+			wantStatus := checkOK
+			if tt.wantFailed != "" {
+				wantStatus = checkFail
+			}
+			if resp.Status != wantStatus {
+				t.Errorf("status = %q, want %q", resp.Status, wantStatus)
+			}
+		})
+	}
+}
+
+func TestStartShutdown(t *testing.T) {
+	h := &HealthHandler{DB: &stubHealthDatabase{version: 1}, LatestMigration: 1}
+	if status, _ := probe(t, h.Ready); status != http.StatusOK {
+		t.Fatalf("Ready() = %d, want 200", status)
+	}
+
+	// Readiness fails so traffic drains, while liveness keeps the process from being restarted
+	h.StartShutdown()
+	if status, resp := probe(t, h.Ready); status != http.StatusServiceUnavailable || resp.Checks["shutdown"].Status != checkFail {
+		t.Errorf("Ready() after StartShutdown = %d %+v, want 503 with a failed shutdown check", status, resp)
+	}
+	if status, resp := probe(t, h.Live); status != http.StatusOK || resp.Status != checkOK {
+		t.Errorf("Live() after StartShutdown = %d %+v, want 200", status, resp)
+	}
+}
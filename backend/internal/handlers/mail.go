@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/RanitManik/zyply/internal/logging"
+	"github.com/RanitManik/zyply/internal/mail"
+)
+
+// sendEmail sends an email in the background so the response does not wait on the mail server,
+// and so response times don't reveal whether an email was sent. Failures are logged.
+func sendEmail(r *http.Request, sender mail.Sender, msg mail.Message) {
+	logger := logging.FromContext(r.Context())
+	go func(ctx context.Context) {
+		if err := sender.Send(ctx, msg); err != nil {
+			logger.Error("Failed to send email", "subject", msg.Subject, "error", err)
+		}
+	}(context.WithoutCancel(r.Context()))
+}
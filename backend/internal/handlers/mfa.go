@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/apierr"
+	"github.com/RanitManik/zyply/internal/audit"
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/metrics"
+	"github.com/RanitManik/zyply/internal/middleware"
+	"github.com/RanitManik/zyply/internal/models"
+)
+
+// errMFALocked is returned when TOTP verification is locked after too many failures
+var errMFALocked = errors.New("too many failed attempts, try again later")
+
+// errInvalidMFACode is returned when a TOTP or recovery code does not match
+var errInvalidMFACode = errors.New("invalid code")
+
+// MFAStatusResponse represents a user's two-factor authentication status
+type MFAStatusResponse struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// TOTPEnrollResponse represents a TOTP enrollment response
+type TOTPEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// MFACodeRequest represents a request carrying a TOTP or recovery code
+type MFACodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFAVerifyRequest represents the second step of a two-factor login
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// RecoveryCodesResponse represents newly generated recovery codes, shown only once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAStatus gets the current user's two-factor authentication status
+func (h *AuthHandler) MFAStatus(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	// Get credential
+	cred, err := h.MFA.GetTOTPCredential(r.Context(), userID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get two-factor status", err))
+		return
+	}
+
+	resp := MFAStatusResponse{Enabled: cred != nil && cred.Enabled()}
+	if resp.Enabled {
+		resp.RecoveryCodesRemaining, err = h.MFA.CountRecoveryCodes(r.Context(), userID)
+		if err != nil {
+			apierr.Write(w, r, apierr.Internal("Failed to get two-factor status", err))
+			return
+		}
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// EnrollTOTP starts TOTP enrollment and returns the secret as an otpauth URI
+func (h *AuthHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	// Get user
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+	user, err := h.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Check if already enabled
+	cred, err := h.MFA.GetTOTPCredential(r.Context(), userID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get two-factor status", err))
+		return
+	}
+	if cred != nil && cred.Enabled() {
+		apierr.Write(w, r, apierr.New(http.StatusConflict, apierr.CodeConflict, "Two-factor authentication is already enabled"))
+		return
+	}
+
+	// Generate and store secret
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate secret", err))
+		return
+	}
+	if err := h.MFA.SavePendingTOTP(r.Context(), userID, secret); err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to save secret", err))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TOTPEnrollResponse{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(secret, user.Email),
+	})
+}
+
+// ConfirmTOTP completes TOTP enrollment and returns the user's recovery codes
+func (h *AuthHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	// Parse request
+	var req MFACodeRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+	if req.Code == "" {
+		apierr.Write(w, r, apierr.BadRequest("Code is required"))
+		return
+	}
+
+	// Get pending credential
+	cred, err := h.MFA.GetTOTPCredential(r.Context(), userID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get two-factor status", err))
+		return
+	}
+	if cred == nil {
+		apierr.Write(w, r, apierr.BadRequest("Two-factor enrollment has not been started"))
+		return
+	}
+	if cred.Enabled() {
+		apierr.Write(w, r, apierr.New(http.StatusConflict, apierr.CodeConflict, "Two-factor authentication is already enabled"))
+		return
+	}
+
+	// Verify code, which also confirms the credential
+	if err := verifySecondFactor(r.Context(), h.MFA, cred, req.Code, ""); err != nil {
+		writeMFAError(w, r, err)
+		return
+	}
+
+	// Generate recovery codes
+	codes, err := replaceRecoveryCodes(r.Context(), h.MFA, userID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate recovery codes", err))
+		return
+	}
+	h.recordMFAEvent(r, userID, audit.ActionTOTPEnabled)
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP turns off two-factor authentication after verifying a code
+func (h *AuthHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	// Get credential
+	cred, req, ok := h.enabledTOTPRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Verify code
+	if err := verifySecondFactor(r.Context(), h.MFA, cred, req.Code, req.RecoveryCode); err != nil {
+		writeMFAError(w, r, err)
+		return
+	}
+
+	// Remove credential and recovery codes
+	if err := h.MFA.DeleteTOTP(r.Context(), cred.UserID); err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to disable two-factor authentication", err))
+		return
+	}
+	h.recordMFAEvent(r, cred.UserID, audit.ActionTOTPDisabled)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after verifying a code
+func (h *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	// Get credential
+	cred, req, ok := h.enabledTOTPRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Verify code
+	if err := verifySecondFactor(r.Context(), h.MFA, cred, req.Code, req.RecoveryCode); err != nil {
+		writeMFAError(w, r, err)
+		return
+	}
+
+	// Generate recovery codes
+	codes, err := replaceRecoveryCodes(r.Context(), h.MFA, cred.UserID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate recovery codes", err))
+		return
+	}
+	h.recordMFAEvent(r, cred.UserID, audit.ActionRecoveryCodesReset)
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// VerifyMFA exchanges an MFA pending token and a TOTP or recovery code for a full token
+func (h *AuthHandler) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req MFAVerifyRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Validate request
+	if req.Code == "" && req.RecoveryCode == "" {
+		apierr.Write(w, r, apierr.BadRequest("Code or recovery code is required"))
+		return
+	}
+
+	// Validate MFA pending token
+	claims, err := auth.ValidateMFAToken(req.MFAToken, h.Config)
+	if err != nil {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeInvalidToken, "Invalid or expired MFA token"))
+		return
+	}
+
+	// Get credential
+	cred, err := h.MFA.GetTOTPCredential(r.Context(), claims.UserID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get two-factor status", err))
+		return
+	}
+	if cred == nil || !cred.Enabled() {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeInvalidToken, "Invalid or expired MFA token"))
+		return
+	}
+
+	// Verify code
+	if err := verifySecondFactor(r.Context(), h.MFA, cred, req.Code, req.RecoveryCode); err != nil {
+		if errors.Is(err, errInvalidMFACode) || errors.Is(err, errMFALocked) {
+			metrics.RecordLogin("mfa", false)
+			h.recordMFAEvent(r, claims.UserID, audit.ActionMFAFailed)
+		}
+		writeMFAError(w, r, err)
+		return
+	}
+	metrics.RecordLogin("mfa", true)
+	h.recordMFAEvent(r, claims.UserID, audit.ActionMFAVerified)
+
+	// Get user
+	user, err := h.Users.GetByID(r.Context(), claims.UserID)
+	if err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Generate token
+	token, err := auth.GenerateToken(user.ID, user.Email, h.Config)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate token", err))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuthResponse{
+		Token: token,
+		User:  user,
+	})
+}
+
+// enabledTOTPRequest parses a code request for the current user and returns their enabled TOTP credential
+func (h *AuthHandler) enabledTOTPRequest(w http.ResponseWriter, r *http.Request) (*models.TOTPCredential, *MFACodeRequest, bool) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return nil, nil, false
+	}
+
+	// Parse request
+	var req MFACodeRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		apierr.Write(w, r, err)
+		return nil, nil, false
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		apierr.Write(w, r, apierr.BadRequest("Code or recovery code is required"))
+		return nil, nil, false
+	}
+
+	// Get credential
+	cred, err := h.MFA.GetTOTPCredential(r.Context(), userID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get two-factor status", err))
+		return nil, nil, false
+	}
+	if cred == nil || !cred.Enabled() {
+		apierr.Write(w, r, apierr.BadRequest("Two-factor authentication is not enabled"))
+		return nil, nil, false
+	}
+
+	return cred, &req, true
+}
+
+// verifySecondFactor verifies a TOTP code or, if no code is given, a recovery code
+func verifySecondFactor(ctx context.Context, mfa models.MFARepository, cred *models.TOTPCredential, code, recoveryCode string) error {
+	// Check lockout
+	if cred.Locked() {
+		return errMFALocked
+	}
+
+	// Verify recovery code
+	if code == "" {
+		used, err := mfa.UseRecoveryCode(ctx, cred.UserID, auth.HashRecoveryCode(recoveryCode))
+		if err != nil {
+			return err
+		}
+		if !used {
+			if err := mfa.RecordTOTPFailure(ctx, cred.UserID); err != nil {
+				return err
+			}
+			return errInvalidMFACode
+		}
+		return nil
+	}
+
+	// Verify TOTP code, rejecting reuse of an already used time step
+	step, ok := auth.ValidateTOTP(cred.Secret, code, time.Now())
+	if ok {
+		ok, err := mfa.UseTOTPStep(ctx, cred.UserID, step)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	if err := mfa.RecordTOTPFailure(ctx, cred.UserID); err != nil {
+		return err
+	}
+	return errInvalidMFACode
+}
+
+// replaceRecoveryCodes generates new recovery codes for a user and stores their hashes
+func replaceRecoveryCodes(ctx context.Context, mfa models.MFARepository, userID int64) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+	if err := mfa.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// recordMFAEvent records a two-factor authentication event for a user
+func (h *AuthHandler) recordMFAEvent(r *http.Request, userID int64, action string) {
+	h.Audit.Record(r, audit.Event{
+		Action:     action,
+		ActorID:    userID,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.FormatInt(userID, 10),
+	})
+}
+
+// writeMFAError writes the HTTP error for a failed second factor verification
+func writeMFAError(w http.ResponseWriter, r *http.Request, err error) {
+	apierr.Write(w, r, mfaError(err))
+}
+
+// mfaError converts an error verifying a TOTP or recovery code into its response
+func mfaError(err error) *apierr.Error {
+	switch {
+	case errors.Is(err, errMFALocked):
+		return apierr.New(http.StatusTooManyRequests, apierr.CodeMFALocked, "Too many failed attempts, try again later")
+	case errors.Is(err, errInvalidMFACode):
+		return apierr.Unauthorized(apierr.CodeInvalidMFACode, "Invalid code")
+	default:
+		return apierr.Internal("Failed to verify code", err)
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/models"
+)
+
+// enableTOTP enrolls and confirms TOTP for a user and returns the secret and recovery codes
+func (h *testAuthHandler) enableTOTP(t *testing.T, userID int64) (string, []string) {
+	t.Helper()
+	var enroll TOTPEnrollResponse
+	if status := serve(t, h.EnrollTOTP, "", userID, &enroll); status != http.StatusOK {
+		t.Fatalf("EnrollTOTP() = %d", status)
+	}
+	code, err := auth.TOTPCode(enroll.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var codes RecoveryCodesResponse
+	if status := serve(t, h.ConfirmTOTP, `{"code":"`+code+`"}`, userID, &codes); status != http.StatusOK {
+		t.Fatalf("ConfirmTOTP() = %d", status)
+	}
+	return enroll.Secret, codes.RecoveryCodes
+}
+
+func TestVerifySecondFactor(t *testing.T) {
+	ctx := context.Background()
+	const userID = 1
+	mfa := models.NewMemoryMFARepository()
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mfa.SavePendingTOTP(ctx, userID, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfa.ReplaceRecoveryCodes(ctx, userID, []string{auth.HashRecoveryCode("abcde-fghij")}); err != nil {
+		t.Fatal(err)
+	}
+	code := func(at time.Time) string {
+		code, err := auth.TOTPCode(secret, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	verify := func(code, recoveryCode string) error {
+		cred, err := mfa.GetTOTPCredential(ctx, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return verifySecondFactor(ctx, mfa, cred, code, recoveryCode)
+	}
+
+	// A code is accepted once and then its step, and every earlier step, is used up
+	now := time.Now()
+	if err := verify(code(now), ""); err != nil {
+		t.Fatalf("verify current code = %v", err)
+	}
+	if err := verify(code(now), ""); !errors.Is(err, errInvalidMFACode) {
+		t.Errorf("verify replayed code = %v, want errInvalidMFACode", err)
+	}
+	if err := verify(code(now.Add(-auth.TOTPPeriod)), ""); !errors.Is(err, errInvalidMFACode) {
+		t.Errorf("verify code of an earlier step = %v, want errInvalidMFACode", err)
+	}
+	if err := verify(code(now.Add(auth.TOTPPeriod)), ""); err != nil {
+		t.Errorf("verify code of the next step = %v", err)
+	}
+
+	// A recovery code works once, however it is typed
+	if err := verify("", "ABCDE FGHIJ"); err != nil {
+		t.Errorf("verify recovery code = %v", err)
+	}
+	if err := verify("", "abcde-fghij"); !errors.Is(err, errInvalidMFACode) {
+		t.Errorf("verify used recovery code = %v, want errInvalidMFACode", err)
+	}
+
+	// Verification locks once MaxTOTPFailures failures are recorded
+	if err := mfa.DeleteTOTP(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfa.SavePendingTOTP(ctx, userID, secret); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < models.MaxTOTPFailures-1; i++ {
+		if err := verify("000000", ""); !errors.Is(err, errInvalidMFACode) {
+			t.Fatalf("failure %d = %v, want errInvalidMFACode", i+1, err)
+		}
+	}
+	if err := verify("", "wrong-code"); !errors.Is(err, errInvalidMFACode) {
+		t.Fatalf("failure %d = %v, want errInvalidMFACode", models.MaxTOTPFailures, err)
+	}
+	if err := verify(code(time.Now()), ""); !errors.Is(err, errMFALocked) {
+		t.Errorf("verify valid code when locked = %v, want errMFALocked", err)
+	}
+}
+
+func TestVerifySecondFactorSuccessResetsFailures(t *testing.T) {
+	ctx := context.Background()
+	const userID = 1
+	mfa := models.NewMemoryMFARepository()
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mfa.SavePendingTOTP(ctx, userID, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	fail := func(n int) {
+		for i := 0; i < n; i++ {
+			cred, _ := mfa.GetTOTPCredential(ctx, userID)
+			verifySecondFactor(ctx, mfa, cred, "000000", "")
+		}
+	}
+	fail(models.MaxTOTPFailures - 1)
+	code, err := auth.TOTPCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, _ := mfa.GetTOTPCredential(ctx, userID)
+	if err := verifySecondFactor(ctx, mfa, cred, code, ""); err != nil {
+		t.Fatalf("verify after %d failures = %v", models.MaxTOTPFailures-1, err)
+	}
+
+	// The failure count starts over after a success
+	fail(models.MaxTOTPFailures - 1)
+	if cred, _ := mfa.GetTOTPCredential(ctx, userID); cred.Locked() {
+		t.Error("locked before MaxTOTPFailures failures in a row")
+	}
+}
+
+func TestRegenerateRecoveryCodesAndDisableTOTP(t *testing.T) {
+	h := newTestAuthHandler(t)
+	userID := h.signup(t, "ada@example.com")
+	_, oldCodes := h.enableTOTP(t, userID)
+
+	// Regenerating replaces every recovery code
+	var codes RecoveryCodesResponse
+	if status := serve(t, h.RegenerateRecoveryCodes, `{"recovery_code":"`+oldCodes[0]+`"}`, userID, &codes); status != http.StatusOK || len(codes.RecoveryCodes) != auth.RecoveryCodeCount {
+		t.Fatalf("RegenerateRecoveryCodes() = %d with %d codes", status, len(codes.RecoveryCodes))
+	}
+	var resp errorResponse
+	if status := serve(t, h.DisableTOTP, `{"recovery_code":"`+oldCodes[1]+`"}`, userID, &resp); status != http.StatusUnauthorized || resp.Error.Code != "invalid_mfa_code" {
+		t.Fatalf("DisableTOTP() with a replaced code = %d %q, want 401 invalid_mfa_code", status, resp.Error.Code)
+	}
+
+	// Disabling removes the credential and recovery codes
+	if status := serve(t, h.DisableTOTP, `{"recovery_code":"`+strings.ToUpper(codes.RecoveryCodes[0])+`"}`, userID, nil); status != http.StatusNoContent {
+		t.Fatalf("DisableTOTP() = %d, want 204", status)
+	}
+	var status MFAStatusResponse
+	serve(t, h.MFAStatus, "", userID, &status)
+	if status.Enabled || status.RecoveryCodesRemaining != 0 {
+		t.Errorf("MFAStatus() = %+v, want disabled", status)
+	}
+	if got := serve(t, h.DisableTOTP, `{"recovery_code":"`+codes.RecoveryCodes[1]+`"}`, userID, nil); got != http.StatusBadRequest {
+		t.Errorf("DisableTOTP() when disabled = %d, want 400", got)
+	}
+}
@@ -0,0 +1,606 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/apierr"
+	"github.com/RanitManik/zyply/internal/audit"
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/middleware"
+	"github.com/RanitManik/zyply/internal/models"
+	"github.com/RanitManik/zyply/internal/validate"
+	"github.com/go-chi/chi/v5"
+)
+
+// OAuthServerHandler handles the OAuth2 authorization server used by third-party apps
+type OAuthServerHandler struct {
+	Config *config.Config
+	OAuth  models.OAuthServerRepository
+	Audit  audit.Recorder
+}
+
+// NewOAuthServerHandler creates a new OAuthServerHandler
+func NewOAuthServerHandler(cfg *config.Config, oauthServer models.OAuthServerRepository, recorder audit.Recorder) *OAuthServerHandler {
+	return &OAuthServerHandler{
+		Config: cfg,
+		OAuth:  oauthServer,
+		Audit:  recorder,
+	}
+}
+
+// RegisterClientRequest represents a request to register an OAuth client
+type RegisterClientRequest struct {
+	Name         string   `json:"name" validate:"required,max=255"`
+	RedirectURIs []string `json:"redirect_uris" validate:"required"`
+	Scopes       []string `json:"scopes" validate:"required"`
+	Confidential bool     `json:"confidential"`
+}
+
+// RegisterClientResponse represents a registered OAuth client. The secret is only returned once.
+type RegisterClientResponse struct {
+	*models.OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// AuthorizeRequest represents the parameters of an authorization request
+type AuthorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Approve             bool   `json:"approve"`
+}
+
+// ConsentResponse describes an authorization request for the consent screen
+type ConsentResponse struct {
+	ClientID    string   `json:"client_id"`
+	ClientName  string   `json:"client_name"`
+	RedirectURI string   `json:"redirect_uri"`
+	Scopes      []string `json:"scopes"`
+}
+
+// AuthorizeResponse tells the frontend where to send the user after consent
+type AuthorizeResponse struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// TokenResponse represents a successful token response (RFC 6749 section 5.1)
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+// OAuthErrorResponse represents an error response (RFC 6749 section 5.2)
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// ListClients lists the OAuth clients registered by the current workspace
+func (h *OAuthServerHandler) ListClients(w http.ResponseWriter, r *http.Request) {
+	// Get workspace ID
+	workspaceID, ok := middleware.GetWorkspaceID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.BadRequest("Workspace required"))
+		return
+	}
+
+	// Get clients
+	clients, err := h.OAuth.ListClients(r.Context(), workspaceID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get OAuth clients", err))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clients)
+}
+
+// RegisterClient registers a new OAuth client owned by the current workspace
+func (h *OAuthServerHandler) RegisterClient(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+	// Get workspace ID
+	workspaceID, ok := middleware.GetWorkspaceID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.BadRequest("Workspace required"))
+		return
+	}
+
+	// Parse request
+	var req RegisterClientRequest
+	checkRedirectURIs := func() *validate.FieldError {
+		for _, uri := range req.RedirectURIs {
+			if !auth.ValidRedirectURI(uri) {
+				return &validate.FieldError{Field: "redirect_uris", Code: validate.CodeInvalid, Message: "redirect_uris contains an invalid URI " + uri}
+			}
+		}
+		return nil
+	}
+	if err := decodeRequest(w, r, &req, checkRedirectURIs, checkScopes(&req.Scopes)); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+
+	// Generate credentials
+	clientID, secret, secretHash, err := auth.GenerateOAuthClientCredentials(req.Confidential)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate client credentials", err))
+		return
+	}
+
+	// Store client
+	client, err := h.OAuth.CreateClient(r.Context(), workspaceID, userID, clientID, secretHash, req.Name, req.RedirectURIs, req.Scopes)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to register OAuth client", err))
+		return
+	}
+	h.Audit.Record(r, audit.Event{
+		Action:     audit.ActionOAuthClientCreated,
+		TargetType: audit.TargetOAuthClient,
+		TargetID:   client.ClientID,
+		After:      client,
+	})
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(RegisterClientResponse{
+		OAuthClient:  client,
+		ClientSecret: secret,
+	})
+}
+
+// DeleteClient deletes one of the current workspace's OAuth clients and all tokens issued to it
+func (h *OAuthServerHandler) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	// Get workspace ID
+	workspaceID, ok := middleware.GetWorkspaceID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.BadRequest("Workspace required"))
+		return
+	}
+
+	// Delete client
+	deleted, err := h.OAuth.DeleteClient(r.Context(), chi.URLParam(r, "clientID"), workspaceID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to delete OAuth client", err))
+		return
+	}
+	if !deleted {
+		apierr.Write(w, r, apierr.NotFound("OAuth client not found"))
+		return
+	}
+	h.Audit.Record(r, audit.Event{
+		Action:     audit.ActionOAuthClientDeleted,
+		TargetType: audit.TargetOAuthClient,
+		TargetID:   chi.URLParam(r, "clientID"),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Consent validates an authorization request and describes it for the consent screen.
+// The frontend calls it with the query parameters the client sent the user with.
+func (h *OAuthServerHandler) Consent(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	query := r.URL.Query()
+	req := AuthorizeRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+
+	// Validate request
+	client, scopes, ok := h.validateAuthorizeRequest(w, r, &req)
+	if !ok {
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConsentResponse{
+		ClientID:    client.ClientID,
+		ClientName:  client.Name,
+		RedirectURI: req.RedirectURI,
+		Scopes:      scopes,
+	})
+}
+
+// Authorize records the user's consent decision and returns the client redirect
+// carrying either an authorization code or an access_denied error
+func (h *OAuthServerHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	// Parse request
+	var req AuthorizeRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Validate request
+	client, scopes, ok := h.validateAuthorizeRequest(w, r, &req)
+	if !ok {
+		return
+	}
+
+	// Build redirect
+	redirectURL, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		apierr.Write(w, r, apierr.BadRequest("Invalid redirect URI"))
+		return
+	}
+	redirectQuery := redirectURL.Query()
+	if req.State != "" {
+		redirectQuery.Set("state", req.State)
+	}
+
+	if !req.Approve {
+		// Deny access
+		redirectQuery.Set("error", "access_denied")
+	} else {
+		// Issue authorization code
+		code, codeHash, err := auth.GenerateOAuthSecret("")
+		if err != nil {
+			apierr.Write(w, r, apierr.Internal("Failed to generate authorization code", err))
+			return
+		}
+		if err := h.OAuth.CreateAuthorizationCode(r.Context(), &models.OAuthAuthorizationCode{
+			CodeHash:      codeHash,
+			ClientID:      client.ClientID,
+			UserID:        userID,
+			RedirectURI:   req.RedirectURI,
+			Scopes:        scopes,
+			CodeChallenge: req.CodeChallenge,
+			ExpiresAt:     time.Now().Add(auth.OAuthCodeExpiry),
+		}); err != nil {
+			apierr.Write(w, r, apierr.Internal("Failed to store authorization code", err))
+			return
+		}
+		h.Audit.Record(r, audit.Event{
+			Action:     audit.ActionOAuthAuthorized,
+			TargetType: audit.TargetOAuthClient,
+			TargetID:   client.ClientID,
+			After:      map[string][]string{"scopes": scopes},
+		})
+		redirectQuery.Set("code", code)
+	}
+	redirectURL.RawQuery = redirectQuery.Encode()
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuthorizeResponse{RedirectTo: redirectURL.String()})
+}
+
+// Token exchanges an authorization code or refresh token for tokens (RFC 6749 section 3.2)
+func (h *OAuthServerHandler) Token(w http.ResponseWriter, r *http.Request) {
+	// Authenticate client
+	client, ok := h.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		h.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		h.exchangeRefreshToken(w, r, client)
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+}
+
+// Revoke revokes an access or refresh token (RFC 7009)
+func (h *OAuthServerHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	// Authenticate client
+	client, ok := h.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+
+	// Get token
+	token := r.PostFormValue("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	// Revoke token. Unknown tokens are not an error.
+	if err := h.OAuth.RevokeToken(r.Context(), auth.HashOAuthSecret(token), client.ClientID); err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// oauthError is an OAuth error of the token endpoint
+type oauthError struct {
+	status      int
+	code        string
+	description string
+}
+
+// Error returns the OAuth error code
+func (e *oauthError) Error() string {
+	return e.code
+}
+
+// errInvalidGrant rejects an unknown, expired, revoked or mismatched code or refresh token
+var errInvalidGrant = &oauthError{status: http.StatusBadRequest, code: "invalid_grant"}
+
+// exchangeAuthorizationCode handles the authorization_code grant
+func (h *OAuthServerHandler) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client *models.OAuthClient) {
+	// Validate request
+	code := r.PostFormValue("code")
+	verifier := r.PostFormValue("code_verifier")
+	if code == "" || verifier == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "code and code_verifier are required")
+		return
+	}
+
+	// Consume code before checking it, so a failed attempt cannot be retried
+	stored, err := h.OAuth.ConsumeAuthorizationCode(r.Context(), auth.HashOAuthSecret(code))
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	if stored == nil || stored.ClientID != client.ClientID || stored.RedirectURI != r.PostFormValue("redirect_uri") {
+		writeTokenError(w, errInvalidGrant)
+		return
+	}
+
+	// Verify PKCE
+	if !auth.VerifyPKCE(verifier, stored.CodeChallenge) {
+		writeTokenError(w, &oauthError{status: http.StatusBadRequest, code: "invalid_grant", description: "code_verifier does not match"})
+		return
+	}
+
+	// Issue tokens
+	resp, tokens, err := newTokens(client.ClientID, stored.UserID, stored.Scopes)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	if err := h.OAuth.CreateTokens(r.Context(), tokens...); err != nil {
+		writeTokenError(w, err)
+		return
+	}
+
+	writeTokens(w, resp)
+}
+
+// exchangeRefreshToken handles the refresh_token grant, rotating the refresh token. Revoking the old
+// token and storing the new pair happen as one unit of work, and a refresh token that is presented
+// again after rotation, even concurrently, revokes the whole grant because it means the token leaked.
+func (h *OAuthServerHandler) exchangeRefreshToken(w http.ResponseWriter, r *http.Request, client *models.OAuthClient) {
+	// Validate request
+	refreshToken := r.PostFormValue("refresh_token")
+	if refreshToken == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "refresh_token is required")
+		return
+	}
+	tokenHash := auth.HashOAuthSecret(refreshToken)
+
+	// Get refresh token
+	stored, err := h.OAuth.GetToken(r.Context(), tokenHash)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	if stored == nil || stored.TokenType != models.OAuthTokenRefresh || stored.ClientID != client.ClientID {
+		writeTokenError(w, errInvalidGrant)
+		return
+	}
+	if stored.RevokedAt != nil {
+		h.revokeReusedGrant(w, r, stored)
+		return
+	}
+	if !stored.Active() {
+		writeTokenError(w, errInvalidGrant)
+		return
+	}
+
+	// Narrow scopes if requested
+	scopes := stored.Scopes
+	if scope := r.PostFormValue("scope"); scope != "" {
+		requested, ok := auth.ParseOAuthScopes(scope)
+		if !ok || !subsetOf(requested, stored.Scopes) {
+			writeTokenError(w, &oauthError{status: http.StatusBadRequest, code: "invalid_scope"})
+			return
+		}
+		scopes = requested
+	}
+
+	// Rotate refresh token. Only the first request to revoke the token gets it back.
+	resp, tokens, err := newTokens(client.ClientID, stored.UserID, scopes)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	rotated, err := h.OAuth.RotateRefreshToken(r.Context(), tokenHash, client.ClientID, tokens...)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	if rotated == nil {
+		h.revokeReusedGrant(w, r, stored)
+		return
+	}
+
+	writeTokens(w, resp)
+}
+
+// revokeReusedGrant revokes the whole grant of a refresh token that was presented again after it
+// was rotated, because that means the token leaked
+func (h *OAuthServerHandler) revokeReusedGrant(w http.ResponseWriter, r *http.Request, token *models.OAuthToken) {
+	if err := h.OAuth.RevokeGrant(r.Context(), token.ClientID, token.UserID); err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	writeTokenError(w, errInvalidGrant)
+}
+
+// newTokens generates a new access and refresh token pair and the tokens to store for it
+func newTokens(clientID string, userID int64, scopes []string) (*TokenResponse, []*models.OAuthToken, error) {
+	// Generate tokens
+	accessToken, accessHash, err := auth.GenerateOAuthSecret(auth.OAuthAccessTokenPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	refreshToken, refreshHash, err := auth.GenerateOAuthSecret(auth.OAuthRefreshTokenPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tokens := []*models.OAuthToken{
+		{TokenHash: accessHash, TokenType: models.OAuthTokenAccess, ClientID: clientID, UserID: userID, Scopes: scopes, ExpiresAt: now.Add(auth.OAuthAccessTokenExpiry)},
+		{TokenHash: refreshHash, TokenType: models.OAuthTokenRefresh, ClientID: clientID, UserID: userID, Scopes: scopes, ExpiresAt: now.Add(auth.OAuthRefreshTokenExpiry)},
+	}
+	return &TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(auth.OAuthAccessTokenExpiry.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	}, tokens, nil
+}
+
+// writeTokens writes a token response
+func writeTokens(w http.ResponseWriter, resp *TokenResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeTokenError writes the OAuth error of a failed grant, or a server error
+func writeTokenError(w http.ResponseWriter, err error) {
+	var oauthErr *oauthError
+	if errors.As(err, &oauthErr) {
+		writeOAuthError(w, oauthErr.status, oauthErr.code, oauthErr.description)
+		return
+	}
+	writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+}
+
+// validateAuthorizeRequest validates the client, redirect URI, scopes and PKCE parameters
+// of an authorization request
+func (h *OAuthServerHandler) validateAuthorizeRequest(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest) (*models.OAuthClient, []string, bool) {
+	// Get client
+	client, err := h.OAuth.GetClient(r.Context(), req.ClientID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get OAuth client", err))
+		return nil, nil, false
+	}
+	if client == nil || !client.AllowsRedirectURI(req.RedirectURI) {
+		apierr.Write(w, r, apierr.BadRequest("Unknown client or redirect URI"))
+		return nil, nil, false
+	}
+
+	// Validate parameters
+	if req.ResponseType != "code" {
+		apierr.Write(w, r, apierr.BadRequest("Unsupported response type"))
+		return nil, nil, false
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		apierr.Write(w, r, apierr.BadRequest("PKCE with S256 is required"))
+		return nil, nil, false
+	}
+	scopes, ok := auth.ParseOAuthScopes(req.Scope)
+	if !ok || !subsetOf(scopes, client.Scopes) {
+		apierr.Write(w, r, apierr.BadRequest("Invalid scope"))
+		return nil, nil, false
+	}
+
+	return client, scopes, true
+}
+
+// authenticateOAuthClient authenticates the client at the token and revocation endpoints
+// using HTTP Basic or client_secret_post, or the client_id alone for public clients
+func (h *OAuthServerHandler) authenticateOAuthClient(w http.ResponseWriter, r *http.Request) (*models.OAuthClient, bool) {
+	// Get client credentials
+	clientID, secret, hasBasic := r.BasicAuth()
+	if !hasBasic {
+		clientID = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+	if clientID == "" {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "")
+		return nil, false
+	}
+
+	// Get client
+	client, err := h.OAuth.GetClient(r.Context(), clientID)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return nil, false
+	}
+	if client == nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "")
+		return nil, false
+	}
+
+	// Confidential clients must present their secret
+	if client.Confidential() && !auth.VerifyOAuthClientSecret(client, secret) {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "")
+		return nil, false
+	}
+
+	return client, true
+}
+
+// subsetOf checks if every item in a is also in b
+func subsetOf(a, b []string) bool {
+	for _, item := range a {
+		found := false
+		for _, other := range b {
+			if item == other {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// writeOAuthError writes an OAuth2 error response
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(OAuthErrorResponse{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/RanitManik/zyply/internal/audit"
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/models"
+)
+
+const (
+	// testClientID is the public client registered by newTestOAuthServerHandler
+	testClientID = "test-client"
+	// testRedirectURI is the redirect URI registered for testClientID
+	testRedirectURI = "https://client.example.com/callback"
+	// testUserID is the user who authorizes testClientID
+	testUserID = 42
+)
+
+// testOAuthServerHandler is an OAuthServerHandler backed by in-memory stores
+type testOAuthServerHandler struct {
+	*OAuthServerHandler
+	store *models.MemoryOAuthServerRepository
+}
+
+// newTestOAuthServerHandler creates an OAuthServerHandler with a registered public client
+func newTestOAuthServerHandler(t *testing.T) *testOAuthServerHandler {
+	t.Helper()
+	store := models.NewMemoryOAuthServerRepository()
+	scopes := []string{auth.ScopeProfileRead, auth.ScopeLinksRead}
+	if _, err := store.CreateClient(context.Background(), 1, 1, testClientID, "", "Test App", []string{testRedirectURI}, scopes); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	return &testOAuthServerHandler{
+		OAuthServerHandler: NewOAuthServerHandler(&config.Config{}, store, audit.NewLog(models.NewMemoryAuditEventRepository())),
+		store:              store,
+	}
+}
+
+// authorize approves an authorization request with the PKCE challenge of verifier and returns the code
+func (h *testOAuthServerHandler) authorize(t *testing.T, verifier string) string {
+	t.Helper()
+	sum := sha256.Sum256([]byte(verifier))
+	body, err := json.Marshal(AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            testClientID,
+		RedirectURI:         testRedirectURI,
+		Scope:               auth.ScopeProfileRead + " " + auth.ScopeLinksRead,
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: "S256",
+		Approve:             true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp AuthorizeResponse
+	if status := serve(t, h.Authorize, string(body), testUserID, &resp); status != http.StatusOK {
+		t.Fatalf("Authorize() = %d, want 200", status)
+	}
+	redirect, err := url.Parse(resp.RedirectTo)
+	if err != nil || redirect.Query().Get("code") == "" {
+		t.Fatalf("Authorize() redirects to %q, want a code", resp.RedirectTo)
+	}
+	return redirect.Query().Get("code")
+}
+
+// tokenResult is a response of the token endpoint
+type tokenResult struct {
+	TokenResponse
+	OAuthErrorResponse
+}
+
+// token posts a token request as the test client
+func (h *testOAuthServerHandler) token(t *testing.T, form url.Values) (int, tokenResult) {
+	t.Helper()
+	form.Set("client_id", testClientID)
+	r := httptest.NewRequest(http.MethodPost, "/api/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.Token(w, r)
+
+	var result tokenResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode response %q: %v", w.Body.String(), err)
+	}
+	return w.Code, result
+}
+
+// exchangeCode exchanges an authorization code for tokens
+func (h *testOAuthServerHandler) exchangeCode(t *testing.T, code, verifier, redirectURI string) (int, tokenResult) {
+	t.Helper()
+	return h.token(t, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"code_verifier": {verifier},
+		"redirect_uri":  {redirectURI},
+	})
+}
+
+// refresh exchanges a refresh token for new tokens
+func (h *testOAuthServerHandler) refresh(t *testing.T, refreshToken string) (int, tokenResult) {
+	t.Helper()
+	return h.token(t, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+}
+
+// testVerifier is a valid PKCE code verifier
+var testVerifier = strings.Repeat("v", 43)
+
+func TestOAuthAuthorizationCodeGrant(t *testing.T) {
+	tests := []struct {
+		name        string
+		verifier    string
+		redirectURI string
+		wantStatus  int
+		wantError   string
+	}{
+		{"valid", testVerifier, testRedirectURI, http.StatusOK, ""},
+		{"PKCE S256 mismatch", strings.Repeat("w", 43), testRedirectURI, http.StatusBadRequest, "invalid_grant"},
+		{"redirect_uri mismatch", testVerifier, "https://client.example.com/other", http.StatusBadRequest, "invalid_grant"},
+		{"missing redirect_uri", testVerifier, "", http.StatusBadRequest, "invalid_grant"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestOAuthServerHandler(t)
+			code := h.authorize(t, testVerifier)
+
+			status, resp := h.exchangeCode(t, code, tt.verifier, tt.redirectURI)
+			if status != tt.wantStatus || resp.Error != tt.wantError {
+				t.Fatalf("token = %d %q, want %d %q", status, resp.Error, tt.wantStatus, tt.wantError)
+			}
+			if tt.wantStatus == http.StatusOK {
+				token, err := auth.ValidateOAuthAccessToken(context.Background(), h.store, resp.AccessToken)
+				if err != nil || token.UserID != testUserID || token.ClientID != testClientID {
+					t.Fatalf("access token = %+v (%v), want a token for the user and client", token, err)
+				}
+			}
+
+			// The code was consumed by the first attempt, whether or not it succeeded
+			status, resp = h.exchangeCode(t, code, testVerifier, testRedirectURI)
+			if status != http.StatusBadRequest || resp.Error != "invalid_grant" {
+				t.Errorf("replayed code = %d %q, want 400 invalid_grant", status, resp.Error)
+			}
+		})
+	}
+}
+
+func TestOAuthRefreshTokenRotation(t *testing.T) {
+	ctx := context.Background()
+	h := newTestOAuthServerHandler(t)
+	_, first := h.exchangeCode(t, h.authorize(t, testVerifier), testVerifier, testRedirectURI)
+
+	// Narrowing to a scope that was not granted is rejected without using up the token
+	status, resp := h.token(t, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {first.RefreshToken},
+		"scope":         {auth.ScopeLinksWrite},
+	})
+	if status != http.StatusBadRequest || resp.Error != "invalid_scope" {
+		t.Fatalf("refresh with a wider scope = %d %q, want 400 invalid_scope", status, resp.Error)
+	}
+
+	// Refreshing rotates the refresh token
+	status, second := h.refresh(t, first.RefreshToken)
+	if status != http.StatusOK || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh = %d %+v, want a new refresh token", status, second)
+	}
+	if second.Scope != first.Scope {
+		t.Errorf("refreshed scope = %q, want %q", second.Scope, first.Scope)
+	}
+	if _, err := auth.ValidateOAuthAccessToken(ctx, h.store, second.AccessToken); err != nil {
+		t.Fatalf("refreshed access token: %v", err)
+	}
+
+	// Presenting the rotated token again revokes every token of the grant
+	status, resp = h.refresh(t, first.RefreshToken)
+	if status != http.StatusBadRequest || resp.Error != "invalid_grant" {
+		t.Fatalf("reused refresh token = %d %q, want 400 invalid_grant", status, resp.Error)
+	}
+	for _, accessToken := range []string{first.AccessToken, second.AccessToken} {
+		if _, err := auth.ValidateOAuthAccessToken(ctx, h.store, accessToken); !errors.Is(err, auth.ErrInvalidOAuthToken) {
+			t.Errorf("access token after reuse = %v, want ErrInvalidOAuthToken", err)
+		}
+	}
+	if status, resp := h.refresh(t, second.RefreshToken); status != http.StatusBadRequest || resp.Error != "invalid_grant" {
+		t.Errorf("latest refresh token after reuse = %d %q, want 400 invalid_grant", status, resp.Error)
+	}
+}
+
+func TestOAuthRefreshTokenOfAnotherClient(t *testing.T) {
+	h := newTestOAuthServerHandler(t)
+	_, tokens := h.exchangeCode(t, h.authorize(t, testVerifier), testVerifier, testRedirectURI)
+	if _, err := h.store.CreateClient(context.Background(), 1, 1, "other-client", "", "Other App", []string{testRedirectURI}, []string{auth.ScopeProfileRead}); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}, "client_id": {"other-client"}}
+	r := httptest.NewRequest(http.MethodPost, "/api/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.Token(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("refresh as another client = %d, want 400", w.Code)
+	}
+
+	// The token stays usable by its own client
+	if status, _ := h.refresh(t, tokens.RefreshToken); status != http.StatusOK {
+		t.Errorf("refresh as the issuing client = %d, want 200", status)
+	}
+}
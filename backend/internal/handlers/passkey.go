@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/apierr"
+	"github.com/RanitManik/zyply/internal/audit"
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/metrics"
+	"github.com/RanitManik/zyply/internal/middleware"
+	"github.com/RanitManik/zyply/internal/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// passkeyChallengeTTL is how long a WebAuthn challenge stays valid when the
+// relying party does not set its own timeout
+const passkeyChallengeTTL = 5 * time.Minute
+
+// PasskeyHandler handles WebAuthn passkey registration and login
+type PasskeyHandler struct {
+	Config   *config.Config
+	WebAuthn *webauthn.WebAuthn
+}
+
+// NewPasskeyHandler creates a new PasskeyHandler
+func NewPasskeyHandler(cfg *config.Config) (*PasskeyHandler, error) {
+	wa, err := auth.NewWebAuthn(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PasskeyHandler{
+		Config:   cfg,
+		WebAuthn: wa,
+	}, nil
+}
+
+// List lists the current user's passkeys
+func (h *PasskeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	// Get credentials
+	creds, err := models.GetPasskeyCredentialsByUserID(r.Context(), userID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get passkeys", err))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(creds)
+}
+
+// Delete deletes one of the current user's passkeys
+func (h *PasskeyHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	// Get passkey ID
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		apierr.Write(w, r, apierr.BadRequest("Invalid passkey ID"))
+		return
+	}
+
+	// Delete credential
+	deleted, err := models.DeletePasskeyCredential(r.Context(), id, userID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to delete passkey", err))
+		return
+	}
+	if !deleted {
+		apierr.Write(w, r, apierr.NotFound("Passkey not found"))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionPasskeyDeleted,
+		TargetType: audit.TargetPasskey,
+		TargetID:   strconv.FormatInt(id, 10),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BeginRegistration starts a passkey registration ceremony for the current user
+func (h *PasskeyHandler) BeginRegistration(w http.ResponseWriter, r *http.Request) {
+	// Get user
+	passkeyUser, ok := h.currentPasskeyUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Create registration options, excluding already registered credentials
+	creation, session, err := h.WebAuthn.BeginRegistration(passkeyUser, webauthn.WithExclusions(passkeyUser.CredentialDescriptors()))
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to start passkey registration", err))
+		return
+	}
+
+	// Store challenge
+	if err := saveChallenge(r.Context(), session, passkeyUser.User.ID, models.CeremonyRegistration); err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to start passkey registration", err))
+		return
+	}
+
+	// Return options
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(creation)
+}
+
+// FinishRegistration verifies the authenticator's attestation and stores the new passkey.
+// The passkey name can be given with the "name" query parameter.
+func (h *PasskeyHandler) FinishRegistration(w http.ResponseWriter, r *http.Request) {
+	// Parse response
+	parsed, err := protocol.ParseCredentialCreationResponse(r)
+	if err != nil {
+		apierr.Write(w, r, apierr.BadRequest("Invalid passkey registration response"))
+		return
+	}
+
+	// Get user
+	passkeyUser, ok := h.currentPasskeyUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Consume challenge
+	session, ok := consumeChallenge(w, r, parsed.Response.CollectedClientData.Challenge, models.CeremonyRegistration, passkeyUser.User.ID)
+	if !ok {
+		return
+	}
+
+	// Verify attestation
+	credential, err := h.WebAuthn.CreateCredential(passkeyUser, *session, parsed)
+	if err != nil {
+		apierr.Write(w, r, apierr.BadRequest("Passkey registration failed"))
+		return
+	}
+
+	// Store credential
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "Passkey"
+	}
+	encoded, err := json.Marshal(credential)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to save passkey", err))
+		return
+	}
+	cred, err := models.CreatePasskeyCredential(r.Context(), passkeyUser.User.ID, credential.ID, name, encoded)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to save passkey", err))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionPasskeyRegistered,
+		TargetType: audit.TargetPasskey,
+		TargetID:   strconv.FormatInt(cred.ID, 10),
+		After:      cred,
+	})
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(cred)
+}
+
+// BeginLogin starts a discoverable passkey login ceremony
+func (h *PasskeyHandler) BeginLogin(w http.ResponseWriter, r *http.Request) {
+	// Create login options
+	assertion, session, err := h.WebAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to start passkey login", err))
+		return
+	}
+
+	// Store challenge
+	if err := saveChallenge(r.Context(), session, 0, models.CeremonyLogin); err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to start passkey login", err))
+		return
+	}
+
+	// Return options
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(assertion)
+}
+
+// FinishLogin verifies the authenticator's assertion and issues a token
+func (h *PasskeyHandler) FinishLogin(w http.ResponseWriter, r *http.Request) {
+	// Parse response
+	parsed, err := protocol.ParseCredentialRequestResponse(r)
+	if err != nil {
+		apierr.Write(w, r, apierr.BadRequest("Invalid passkey login response"))
+		return
+	}
+
+	// Consume challenge
+	session, ok := consumeChallenge(w, r, parsed.Response.CollectedClientData.Challenge, models.CeremonyLogin, 0)
+	if !ok {
+		return
+	}
+
+	// Verify assertion against the user identified by the user handle
+	var passkeyUser *auth.PasskeyUser
+	credential, err := h.WebAuthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := auth.ParsePasskeyUserHandle(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		passkeyUser, err = loadPasskeyUser(r.Context(), userID)
+		return passkeyUser, err
+	}, *session, parsed)
+	if err != nil || passkeyUser == nil {
+		metrics.RecordLogin("passkey", false)
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeInvalidCredentials, "Passkey login failed"))
+		return
+	}
+	if credential.Authenticator.CloneWarning {
+		metrics.RecordLogin("passkey", false)
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeInvalidCredentials, "Passkey login failed"))
+		return
+	}
+
+	// Update sign count and last use
+	encoded, err := json.Marshal(credential)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to update passkey", err))
+		return
+	}
+	if err := models.UpdatePasskeyCredentialUsage(r.Context(), credential.ID, encoded); err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to update passkey", err))
+		return
+	}
+	recordLogin(audit.RecorderFunc(audit.Record), r, passkeyUser.User, audit.ActionPasskeyLogin, "passkey")
+
+	// Generate token
+	token, err := auth.GenerateToken(passkeyUser.User.ID, passkeyUser.User.Email, h.Config)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate token", err))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuthResponse{
+		Token: token,
+		User:  passkeyUser.User,
+	})
+}
+
+// currentPasskeyUser loads the authenticated user along with their passkeys
+func (h *PasskeyHandler) currentPasskeyUser(w http.ResponseWriter, r *http.Request) (*auth.PasskeyUser, bool) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return nil, false
+	}
+
+	// Load user
+	passkeyUser, err := loadPasskeyUser(r.Context(), userID)
+	if err != nil {
+		apierr.Write(w, r, err)
+		return nil, false
+	}
+
+	return passkeyUser, true
+}
+
+// loadPasskeyUser loads a user and their passkeys
+func loadPasskeyUser(ctx context.Context, userID int64) (*auth.PasskeyUser, error) {
+	user, err := models.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := models.GetPasskeyCredentialsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewPasskeyUser(user, creds)
+}
+
+// saveChallenge stores the session data of a WebAuthn ceremony keyed by its challenge
+func saveChallenge(ctx context.Context, session *webauthn.SessionData, userID int64, ceremony string) error {
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := session.Expires
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(passkeyChallengeTTL)
+	}
+
+	return models.SaveWebAuthnChallenge(ctx, &models.WebAuthnChallenge{
+		Challenge:   session.Challenge,
+		UserID:      userID,
+		Ceremony:    ceremony,
+		SessionData: sessionData,
+		ExpiresAt:   expiresAt,
+	})
+}
+
+// consumeChallenge loads and deletes the session data for a challenge, checking it belongs to the user
+func consumeChallenge(w http.ResponseWriter, r *http.Request, challenge, ceremony string, userID int64) (*webauthn.SessionData, bool) {
+	// Consume challenge
+	stored, err := models.ConsumeWebAuthnChallenge(r.Context(), challenge, ceremony)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get challenge", err))
+		return nil, false
+	}
+	if stored == nil || stored.UserID != userID {
+		apierr.Write(w, r, apierr.BadRequest("Invalid or expired challenge"))
+		return nil, false
+	}
+
+	// Decode session data
+	var session webauthn.SessionData
+	if err := json.Unmarshal(stored.SessionData, &session); err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get challenge", err))
+		return nil, false
+	}
+
+	return &session, true
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/apierr"
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/middleware"
+	"github.com/RanitManik/zyply/internal/models"
+)
+
+// Reauthentication confirms the user is present before a sensitive account change. Sessions
+// that logged in within the reauthentication window need not send anything; others confirm
+// their password or a TOTP or recovery code. Users without either, such as those who log in
+// with a passkey or single sign-on, log in again to open the window.
+type Reauthentication struct {
+	Password     string
+	Code         string
+	RecoveryCode string
+}
+
+// reauthenticate checks the request's session logged in recently or that the user confirmed
+// their password or second factor
+func reauthenticate(r *http.Request, cfg *config.Config, mfa models.MFARepository, user *models.User, proof Reauthentication) error {
+	ctx := r.Context()
+
+	// Verify password. Wrong passwords count towards the two-factor lockout, so a stolen
+	// session can only guess a few times.
+	if proof.Password != "" {
+		cred, err := mfa.GetTOTPCredential(ctx, user.ID)
+		if err != nil {
+			return apierr.Internal("Failed to get two-factor status", err)
+		}
+		if cred != nil && cred.Locked() {
+			return mfaError(errMFALocked)
+		}
+		if !user.VerifyPassword(proof.Password) {
+			if err := mfa.RecordTOTPFailure(ctx, user.ID); err != nil {
+				return apierr.Internal("Failed to record failed attempt", err)
+			}
+			return apierr.Unauthorized(apierr.CodeInvalidCredentials, "Password is incorrect")
+		}
+		return nil
+	}
+
+	// Verify second factor
+	if proof.Code != "" || proof.RecoveryCode != "" {
+		cred, err := mfa.GetTOTPCredential(ctx, user.ID)
+		if err != nil {
+			return apierr.Internal("Failed to get two-factor status", err)
+		}
+		if cred == nil || !cred.Enabled() {
+			return apierr.BadRequest("Two-factor authentication is not enabled")
+		}
+		if err := verifySecondFactor(ctx, mfa, cred, proof.Code, proof.RecoveryCode); err != nil {
+			return mfaError(err)
+		}
+		return nil
+	}
+
+	// Accept a recent login
+	if authTime, ok := middleware.GetAuthTime(ctx); ok && time.Since(authTime) <= cfg.Account.ReauthWindow {
+		return nil
+	}
+
+	return apierr.Unauthorized(apierr.CodeReauthRequired, "Confirm your password or a two-factor code, or log in again")
+}
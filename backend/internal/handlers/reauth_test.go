@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/apierr"
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/middleware"
+	"github.com/RanitManik/zyply/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestReauthenticate(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Account.ReauthWindow = 5 * time.Minute
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: 1, Password: string(hash)}
+
+	// Enable TOTP with one recovery code
+	ctx := context.Background()
+	mfa := models.NewMemoryMFARepository()
+	secret, _ := auth.GenerateTOTPSecret()
+	mfa.SavePendingTOTP(ctx, user.ID, secret)
+	mfa.UseTOTPStep(ctx, user.ID, 1)
+	mfa.ReplaceRecoveryCodes(ctx, user.ID, []string{auth.HashRecoveryCode("abcde-fghij")})
+	code, err := auth.TOTPCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		authTime time.Time
+		proof    Reauthentication
+		wantCode string
+	}{
+		{"recent login", time.Now().Add(-time.Minute), Reauthentication{}, ""},
+		{"stale login", time.Now().Add(-time.Hour), Reauthentication{}, apierr.CodeReauthRequired},
+		{"no login time", time.Time{}, Reauthentication{}, apierr.CodeReauthRequired},
+		{"stale login with password", time.Now().Add(-time.Hour), Reauthentication{Password: "correct horse"}, ""},
+		{"wrong password", time.Now().Add(-time.Hour), Reauthentication{Password: "wrong"}, apierr.CodeInvalidCredentials},
+		{"wrong password in a recent login", time.Now(), Reauthentication{Password: "wrong"}, apierr.CodeInvalidCredentials},
+		{"stale login with code", time.Now().Add(-time.Hour), Reauthentication{Code: code}, ""},
+		{"replayed code", time.Now().Add(-time.Hour), Reauthentication{Code: code}, apierr.CodeInvalidMFACode},
+		{"stale login with recovery code", time.Now().Add(-time.Hour), Reauthentication{RecoveryCode: "abcde-fghij"}, ""},
+		{"used recovery code", time.Now().Add(-time.Hour), Reauthentication{RecoveryCode: "abcde-fghij"}, apierr.CodeInvalidMFACode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqCtx := ctx
+			if !tt.authTime.IsZero() {
+				reqCtx = context.WithValue(ctx, middleware.AuthTimeKey, tt.authTime)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/", nil).WithContext(reqCtx)
+
+			err := reauthenticate(r, cfg, mfa, user, tt.proof)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("reauthenticate() = %v, want nil", err)
+				}
+				return
+			}
+			var apiErr *apierr.Error
+			if !errors.As(err, &apiErr) || apiErr.Code != tt.wantCode {
+				t.Fatalf("reauthenticate() = %v, want code %s", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestReauthenticatePasswordLockout(t *testing.T) {
+	cfg := &config.Config{}
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: 1, Password: string(hash)}
+	ctx := context.Background()
+	mfa := models.NewMemoryMFARepository()
+	secret, _ := auth.GenerateTOTPSecret()
+	mfa.SavePendingTOTP(ctx, user.ID, secret)
+	mfa.UseTOTPStep(ctx, user.ID, 1)
+
+	reauth := func(password string) string {
+		err := reauthenticate(httptest.NewRequest(http.MethodPost, "/", nil), cfg, mfa, user, Reauthentication{Password: password})
+		var apiErr *apierr.Error
+		if errors.As(err, &apiErr) {
+			return apiErr.Code
+		}
+		if err != nil {
+			t.Fatalf("reauthenticate() = %v", err)
+		}
+		return ""
+	}
+
+	// Wrong passwords lock reauthentication like wrong codes, after which even the right password fails
+	for i := 0; i < models.MaxTOTPFailures; i++ {
+		if code := reauth("wrong"); code != apierr.CodeInvalidCredentials {
+			t.Fatalf("failure %d = %s, want %s", i+1, code, apierr.CodeInvalidCredentials)
+		}
+	}
+	if code := reauth("correct horse"); code != apierr.CodeMFALocked {
+		t.Errorf("correct password when locked = %q, want %s", code, apierr.CodeMFALocked)
+	}
+	code, err := auth.TOTPCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = reauthenticate(httptest.NewRequest(http.MethodPost, "/", nil), cfg, mfa, user, Reauthentication{Code: code})
+	var apiErr *apierr.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != apierr.CodeMFALocked {
+		t.Errorf("valid code when locked = %v, want %s", err, apierr.CodeMFALocked)
+	}
+}
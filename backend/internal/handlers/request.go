@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/RanitManik/zyply/internal/apierr"
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/validate"
+)
+
+// maxRequestBodySize is the largest JSON request body accepted, in bytes
+const maxRequestBodySize = 1 << 20
+
+// decodeRequest decodes a JSON request body into dst and validates it against its validate tags.
+// Oversized bodies, unknown fields and trailing data are rejected. Checks run after decoding for
+// rules tags cannot express, such as the password policy, and are reported with the tag violations.
+func decodeRequest(w http.ResponseWriter, r *http.Request, dst interface{}, checks ...func() *validate.FieldError) error {
+	// Decode body
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return apierr.BadRequest("Request body must contain a single JSON object")
+	}
+
+	// Validate fields
+	fields := validate.Struct(dst)
+	for _, check := range checks {
+		if field := check(); field != nil {
+			fields = append(fields, *field)
+		}
+	}
+	if len(fields) > 0 {
+		return apierr.Validation(fields)
+	}
+
+	return nil
+}
+
+// checkScopes returns a check that every scope in a field is known
+func checkScopes(scopes *[]string) func() *validate.FieldError {
+	return func() *validate.FieldError {
+		for _, scope := range *scopes {
+			if !auth.ValidScope(scope) {
+				return &validate.FieldError{Field: "scopes", Code: validate.CodeInvalid, Message: "scopes contains an unknown scope " + scope}
+			}
+		}
+		return nil
+	}
+}
+
+// decodeError converts a JSON decoding error into an API error
+func decodeError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return apierr.New(http.StatusRequestEntityTooLarge, apierr.CodeRequestTooLarge, "Request body is too large")
+	case errors.As(err, &typeErr):
+		return apierr.Validation([]validate.FieldError{{
+			Field:   typeErr.Field,
+			Code:    validate.CodeInvalidType,
+			Message: typeErr.Field + " must be a " + typeErr.Type.String(),
+		}})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return apierr.Validation([]validate.FieldError{{Field: field, Code: validate.CodeUnknownField, Message: "Unknown field " + field}})
+	default:
+		return apierr.BadRequest("Invalid request body")
+	}
+}
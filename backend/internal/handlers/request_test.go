@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RanitManik/zyply/internal/apierr"
+	"github.com/RanitManik/zyply/internal/validate"
+)
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantFields []string
+	}{
+		{"valid", `{"name":"ci","scopes":["links:read"]}`, 0, nil},
+		{"missing fields", `{}`, http.StatusBadRequest, []string{"name", "scopes"}},
+		{"empty scopes", `{"name":"ci","scopes":[]}`, http.StatusBadRequest, []string{"scopes"}},
+		{"unknown scope", `{"name":"ci","scopes":["links:delete"]}`, http.StatusBadRequest, []string{"scopes"}},
+		{"negative expiry", `{"name":"ci","scopes":["links:read"],"expires_in_days":-1}`, http.StatusBadRequest, []string{"expires_in_days"}},
+		{"unknown field", `{"name":"ci","scopes":["links:read"],"admin":true}`, http.StatusBadRequest, []string{"admin"}},
+		{"wrong type", `{"name":"ci","scopes":"links:read"}`, http.StatusBadRequest, []string{"scopes"}},
+		{"trailing data", `{"name":"ci","scopes":["links:read"]} {}`, http.StatusBadRequest, nil},
+		{"too large", `{"name":"` + strings.Repeat("a", maxRequestBodySize) + `"}`, http.StatusRequestEntityTooLarge, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			var req CreateAPIKeyRequest
+			checkExpiry := func() *validate.FieldError {
+				if req.ExpiresInDays < 0 {
+					return &validate.FieldError{Field: "expires_in_days", Code: validate.CodeInvalid}
+				}
+				return nil
+			}
+
+			err := decodeRequest(httptest.NewRecorder(), r, &req, checkScopes(&req.Scopes), checkExpiry)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("decodeRequest() = %v, want nil", err)
+				}
+				return
+			}
+
+			var apiErr *apierr.Error
+			if !errors.As(err, &apiErr) || apiErr.Status != tt.wantStatus {
+				t.Fatalf("decodeRequest() = %v, want status %d", err, tt.wantStatus)
+			}
+			if tt.wantFields == nil {
+				return
+			}
+			fields, _ := apiErr.Details.([]validate.FieldError)
+			var got []string
+			for _, field := range fields {
+				got = append(got, field.Field)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("invalid fields = %v, want %v", got, tt.wantFields)
+			}
+		})
+	}
+}
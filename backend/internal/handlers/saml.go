@@ -0,0 +1,550 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/RanitManik/zyply/internal/apierr"
+	"github.com/RanitManik/zyply/internal/audit"
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/logging"
+	"github.com/RanitManik/zyply/internal/metrics"
+	"github.com/RanitManik/zyply/internal/middleware"
+	"github.com/RanitManik/zyply/internal/models"
+	"github.com/RanitManik/zyply/internal/validate"
+	"github.com/crewjam/saml"
+	"github.com/go-chi/chi/v5"
+)
+
+// SAMLHandler handles SAML single sign-on requests
+type SAMLHandler struct {
+	Config        *config.Config
+	KeyPair       *auth.SAMLKeyPair
+	Users         models.UserRepository
+	OAuthAccounts models.OAuthAccountRepository
+	MFA           models.MFARepository
+	// Resolver looks up the TXT records that verify email domains
+	Resolver auth.TXTResolver
+}
+
+// NewSAMLHandler creates a new SAMLHandler. SAML endpoints respond with
+// 503 Service Unavailable when no service provider key pair is configured.
+func NewSAMLHandler(cfg *config.Config, users models.UserRepository, oauthAccounts models.OAuthAccountRepository, mfa models.MFARepository) (*SAMLHandler, error) {
+	keyPair, err := auth.LoadSAMLKeyPair(cfg)
+	if err != nil && !errors.Is(err, auth.ErrSAMLNotConfigured) {
+		return nil, err
+	}
+
+	return &SAMLHandler{
+		Config:        cfg,
+		KeyPair:       keyPair,
+		Users:         users,
+		OAuthAccounts: oauthAccounts,
+		MFA:           mfa,
+		Resolver:      net.DefaultResolver,
+	}, nil
+}
+
+// SAMLConnectionRequest represents an IdP metadata upload
+type SAMLConnectionRequest struct {
+	Metadata     string   `json:"metadata" validate:"required"`
+	EmailDomains []string `json:"email_domains" validate:"required"`
+	// DefaultRole is the role of users provisioned by single sign-on. It defaults to the
+	// connection's current role, or viewer for a new connection.
+	DefaultRole string `json:"default_role"`
+}
+
+// SAMLConnectionResponse represents a SAML connection along with the SP endpoints to configure in the IdP
+type SAMLConnectionResponse struct {
+	*models.SAMLConnection
+	Domains    []*SAMLDomainResponse `json:"domains"`
+	SPEntityID string                `json:"sp_entity_id"`
+	SPACSURL   string                `json:"sp_acs_url"`
+	SPLoginURL string                `json:"sp_login_url"`
+	SPMetadata string                `json:"sp_metadata_url"`
+}
+
+// SAMLDomainResponse represents an email domain along with the DNS record that verifies it
+type SAMLDomainResponse struct {
+	*models.SAMLDomain
+	Verified    bool   `json:"verified"`
+	RecordType  string `json:"record_type"`
+	RecordName  string `json:"record_name"`
+	RecordValue string `json:"record_value"`
+}
+
+// SAMLLinkResponse holds the URL that links the current user to their SAML identity
+type SAMLLinkResponse struct {
+	URL string `json:"url"`
+}
+
+// PutConnection uploads IdP metadata for an organization the current user manages
+func (h *SAMLHandler) PutConnection(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	// Get organization
+	org, ok := organizationWithPermission(w, r, chi.URLParam(r, "organization"), userID, auth.PermissionWorkspaceManage)
+	if !ok {
+		return
+	}
+	if org.Personal {
+		apierr.Write(w, r, apierr.BadRequest("SAML cannot be configured for a personal workspace"))
+		return
+	}
+
+	// Parse request
+	var req SAMLConnectionRequest
+	checkDomains := func() *validate.FieldError {
+		for _, domain := range req.EmailDomains {
+			if domain = strings.TrimSpace(domain); !strings.Contains(domain, ".") || strings.ContainsAny(domain, "@/ ") {
+				return &validate.FieldError{Field: "email_domains", Code: validate.CodeInvalid, Message: "email_domains must be domain names such as example.com"}
+			}
+		}
+		return nil
+	}
+	checkDefaultRole := func() *validate.FieldError {
+		if req.DefaultRole != "" && (!models.ValidRole(req.DefaultRole) || req.DefaultRole == models.RoleOwner) {
+			return &validate.FieldError{Field: "default_role", Code: validate.CodeInvalid, Message: "default_role must be one of admin, editor, viewer"}
+		}
+		return nil
+	}
+	if err := decodeRequest(w, r, &req, checkDomains, checkDefaultRole); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+	idpMetadata, err := auth.ParseIDPMetadata([]byte(req.Metadata))
+	if err != nil {
+		apierr.Write(w, r, apierr.BadRequest(err.Error()))
+		return
+	}
+
+	// Get existing connection for the audit log
+	existing, err := models.GetSAMLConnection(r.Context(), org.Slug)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get SAML connection", err))
+		return
+	}
+
+	// Domains admit users only once verified, so new ones get a verification token
+	domains := make([]*models.SAMLDomain, 0, len(req.EmailDomains))
+	for _, name := range req.EmailDomains {
+		token, err := auth.NewSAMLDomainToken()
+		if err != nil {
+			apierr.Write(w, r, apierr.Internal("Failed to generate domain verification token", err))
+			return
+		}
+		domains = append(domains, &models.SAMLDomain{Domain: strings.ToLower(strings.TrimSpace(name)), VerificationToken: token})
+	}
+	defaultRole := req.DefaultRole
+	if defaultRole == "" {
+		defaultRole = models.RoleViewer
+		if existing != nil {
+			defaultRole = existing.DefaultRole
+		}
+	}
+
+	// Save connection
+	conn, err := models.UpsertSAMLConnection(r.Context(), org, idpMetadata.EntityID, req.Metadata, defaultRole, domains)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to save SAML connection", err))
+		return
+	}
+	event := audit.Event{
+		Action:         audit.ActionSAMLConnectionSaved,
+		OrganizationID: org.ID,
+		TargetType:     audit.TargetSAMLConnection,
+		TargetID:       strconv.FormatInt(conn.ID, 10),
+		After:          conn,
+	}
+	if existing != nil {
+		event.Before = existing
+	}
+	audit.Record(r, event)
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.connectionResponse(conn))
+}
+
+// GetConnection gets the SAML connection for an organization the current user manages
+func (h *SAMLHandler) GetConnection(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	// Get organization
+	if _, ok := organizationWithPermission(w, r, chi.URLParam(r, "organization"), userID, auth.PermissionWorkspaceManage); !ok {
+		return
+	}
+
+	// Get connection
+	conn, err := models.GetSAMLConnection(r.Context(), chi.URLParam(r, "organization"))
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get SAML connection", err))
+		return
+	}
+	if conn == nil {
+		apierr.Write(w, r, apierr.NotFound("SAML connection not found"))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.connectionResponse(conn))
+}
+
+// VerifyDomain verifies an email domain of an organization's SAML connection by looking up its
+// DNS TXT record. The connection admits users from verified domains only.
+func (h *SAMLHandler) VerifyDomain(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	// Get organization
+	org, ok := organizationWithPermission(w, r, chi.URLParam(r, "organization"), userID, auth.PermissionWorkspaceManage)
+	if !ok {
+		return
+	}
+
+	// Get domain
+	conn, err := models.GetSAMLConnection(r.Context(), org.Slug)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get SAML connection", err))
+		return
+	}
+	if conn == nil {
+		apierr.Write(w, r, apierr.NotFound("SAML connection not found"))
+		return
+	}
+	domain := conn.Domain(chi.URLParam(r, "domain"))
+	if domain == nil {
+		apierr.Write(w, r, apierr.NotFound("Domain not found"))
+		return
+	}
+
+	if !domain.Verified() {
+		// Check TXT record
+		found, err := auth.CheckSAMLDomainRecord(r.Context(), h.Resolver, domain)
+		if err != nil {
+			apierr.Write(w, r, &apierr.Error{Status: http.StatusBadGateway, Code: apierr.CodeUpstream, Message: "Failed to look up DNS records", Cause: err})
+			return
+		}
+		if !found {
+			apierr.Write(w, r, apierr.New(http.StatusBadRequest, apierr.CodeDomainNotVerified,
+				"TXT record "+auth.SAMLDomainRecordName(domain.Domain)+" does not contain the verification token"))
+			return
+		}
+
+		// Verify domain, which fails if another organization verified it first
+		verified, err := models.VerifySAMLDomain(r.Context(), org.ID, domain.Domain)
+		if err != nil {
+			apierr.Write(w, r, err)
+			return
+		}
+		if verified == nil {
+			apierr.Write(w, r, apierr.NotFound("Domain not found"))
+			return
+		}
+		domain.VerifiedAt = verified.VerifiedAt
+		audit.Record(r, audit.Event{
+			Action:         audit.ActionSAMLDomainVerified,
+			OrganizationID: org.ID,
+			TargetType:     audit.TargetSAMLConnection,
+			TargetID:       strconv.FormatInt(conn.ID, 10),
+			After:          map[string]string{"domain": domain.Domain},
+		})
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.connectionResponse(conn))
+}
+
+// Link returns a URL that starts a SAML login linking the asserted identity to the current user.
+// Existing users must link their identity this way before they can log in with SAML.
+func (h *SAMLHandler) Link(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	// Get connection
+	conn, _, ok := h.activeServiceProvider(w, r)
+	if !ok {
+		return
+	}
+
+	// Create link ticket
+	ticket, err := auth.NewSAMLLinkTicket(userID, conn.Organization, h.Config)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate link ticket", err))
+		return
+	}
+	query := url.Values{"link": {ticket}}
+	if redirect := r.URL.Query().Get("redirect"); redirect != "" {
+		query.Set("redirect", redirect)
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SAMLLinkResponse{
+		URL: h.Config.SAML.BaseURL + "/api/auth/saml/" + conn.Organization + "?" + query.Encode(),
+	})
+}
+
+// Metadata serves the service provider metadata for an organization
+func (h *SAMLHandler) Metadata(w http.ResponseWriter, r *http.Request) {
+	// Get service provider
+	_, sp, ok := h.serviceProvider(w, r)
+	if !ok {
+		return
+	}
+
+	// Return metadata
+	metadata, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate metadata", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(metadata)
+}
+
+// Login initiates SAML login by redirecting to the organization's IdP. With a link ticket
+// from Link, the asserted identity is linked to the user the ticket was issued to.
+func (h *SAMLHandler) Login(w http.ResponseWriter, r *http.Request) {
+	// Get service provider
+	conn, sp, ok := h.activeServiceProvider(w, r)
+	if !ok {
+		return
+	}
+
+	// Get user to link
+	var linkUserID int64
+	if ticket := r.URL.Query().Get("link"); ticket != "" {
+		var err error
+		if linkUserID, err = auth.ReadSAMLLinkTicket(ticket, conn.Organization, h.Config); err != nil {
+			apierr.Write(w, r, apierr.BadRequest("Invalid or expired SAML link"))
+			return
+		}
+	}
+
+	// Create AuthnRequest
+	authnRequest, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to create SAML request", err))
+		return
+	}
+
+	// Track request in a signed cookie
+	state, err := auth.NewSAMLRequestState(authnRequest.ID, conn.Organization, r.URL.Query().Get("redirect"), linkUserID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate state", err))
+		return
+	}
+	cookie, err := state.Cookie(h.Config)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate state", err))
+		return
+	}
+	http.SetCookie(w, cookie)
+
+	// Redirect to IdP
+	redirectURL, err := authnRequest.Redirect(state.RelayState, sp)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to create SAML request", err))
+		return
+	}
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+// ACS handles the SAML assertion consumer service callback
+func (h *SAMLHandler) ACS(w http.ResponseWriter, r *http.Request) {
+	// Get service provider
+	conn, sp, ok := h.activeServiceProvider(w, r)
+	if !ok {
+		return
+	}
+
+	// Validate request state
+	state, err := auth.ReadSAMLRequestState(r, conn.Organization, h.Config)
+	if err != nil {
+		apierr.Write(w, r, apierr.BadRequest("Invalid SAML request"))
+		return
+	}
+	http.SetCookie(w, auth.ClearSAMLRequestCookie(conn.Organization))
+
+	// Validate response and assertion signatures
+	assertion, err := sp.ParseResponse(r, []string{state.RequestID})
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			logging.FromContext(r.Context()).Warn("Invalid SAML response", "organization", conn.Organization, "error", invalid.PrivateErr)
+		}
+		metrics.RecordLogin("saml", false)
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Invalid SAML response"))
+		return
+	}
+
+	// Get user info
+	samlUser, err := auth.SAMLUserFromAssertion(assertion)
+	if err != nil {
+		metrics.RecordLogin("saml", false)
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Invalid SAML assertion"))
+		return
+	}
+	if !conn.AllowsEmail(samlUser.Email) {
+		metrics.RecordLogin("saml", false)
+		apierr.Write(w, r, apierr.Forbidden("Email domain is not allowed for this organization"))
+		return
+	}
+
+	// Process user, linking the identity if the login was started by Link
+	providerData, _ := json.Marshal(samlUser)
+	var user *models.User
+	if state.LinkUserID != 0 {
+		user, err = auth.LinkSAMLUser(r.Context(), h.Users, h.OAuthAccounts, state.LinkUserID, conn.Provider(), samlUser, string(providerData))
+	} else {
+		user, err = auth.ProcessSAMLUser(r.Context(), h.Users, h.OAuthAccounts, conn.Provider(), samlUser, string(providerData))
+	}
+	if err != nil {
+		metrics.RecordLogin("saml", false)
+		switch {
+		case errors.Is(err, auth.ErrSAMLAccountNotLinked):
+			apierr.Write(w, r, apierr.New(http.StatusConflict, apierr.CodeAccountNotLinked,
+				"An account with this email already exists. Log in and link single sign-on from your account settings"))
+		case errors.Is(err, auth.ErrSAMLEmailMismatch):
+			apierr.Write(w, r, apierr.Forbidden("The identity provider asserted a different email than your account"))
+		case errors.Is(err, models.ErrNotFound), errors.Is(err, models.ErrConflict):
+			apierr.Write(w, r, err)
+		default:
+			apierr.Write(w, r, apierr.Internal("Failed to process user", err))
+		}
+		return
+	}
+	if state.LinkUserID != 0 {
+		audit.Record(r, audit.Event{
+			Action:         audit.ActionSAMLLinked,
+			ActorID:        user.ID,
+			OrganizationID: conn.OrganizationID,
+			TargetType:     audit.TargetUser,
+			TargetID:       strconv.FormatInt(user.ID, 10),
+		})
+	}
+
+	// Provision workspace membership
+	if err := models.AddMembership(r.Context(), conn.OrganizationID, user.ID, conn.DefaultRole); err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to add workspace member", err))
+		return
+	}
+	metrics.RecordLogin("saml", true)
+	audit.Record(r, audit.Event{
+		Action:         audit.ActionSAMLLogin,
+		ActorID:        user.ID,
+		OrganizationID: conn.OrganizationID,
+		TargetType:     audit.TargetUser,
+		TargetID:       strconv.FormatInt(user.ID, 10),
+	})
+
+	// Generate token, or an MFA pending token if two-factor authentication is enabled
+	resp, err := newAuthResponse(r.Context(), h.MFA, user, h.Config)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate token", err))
+		return
+	}
+
+	// Redirect to frontend with token
+	http.Redirect(w, r, frontendCallbackURL(h.Config, resp, state.ReturnTo), http.StatusFound)
+}
+
+// serviceProvider builds the service provider for the organization in the route
+func (h *SAMLHandler) serviceProvider(w http.ResponseWriter, r *http.Request) (*models.SAMLConnection, *saml.ServiceProvider, bool) {
+	// Check SAML is configured
+	if h.KeyPair == nil {
+		apierr.Write(w, r, apierr.New(http.StatusServiceUnavailable, apierr.CodeInternal, "SAML is not configured"))
+		return nil, nil, false
+	}
+
+	// Get connection
+	organization := chi.URLParam(r, "organization")
+	if !auth.ValidOrganization(organization) {
+		apierr.Write(w, r, apierr.NotFound("SAML connection not found"))
+		return nil, nil, false
+	}
+	conn, err := models.GetSAMLConnection(r.Context(), organization)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get SAML connection", err))
+		return nil, nil, false
+	}
+	if conn == nil {
+		apierr.Write(w, r, apierr.NotFound("SAML connection not found"))
+		return nil, nil, false
+	}
+
+	// Build service provider
+	sp, err := auth.NewSAMLServiceProvider(conn, h.KeyPair, h.Config)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Invalid SAML connection", err))
+		return nil, nil, false
+	}
+
+	return conn, sp, true
+}
+
+// activeServiceProvider builds the service provider for the organization in the route
+// and checks its connection has a verified domain to admit users from
+func (h *SAMLHandler) activeServiceProvider(w http.ResponseWriter, r *http.Request) (*models.SAMLConnection, *saml.ServiceProvider, bool) {
+	conn, sp, ok := h.serviceProvider(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	if !conn.Active() {
+		apierr.Write(w, r, apierr.Forbidden("SAML connection has no verified email domain"))
+		return nil, nil, false
+	}
+
+	return conn, sp, true
+}
+
+// connectionResponse adds the SP endpoints and domain verification records to a SAML connection
+func (h *SAMLHandler) connectionResponse(conn *models.SAMLConnection) *SAMLConnectionResponse {
+	base := h.Config.SAML.BaseURL + "/api/auth/saml/" + conn.Organization
+	domains := make([]*SAMLDomainResponse, 0, len(conn.Domains))
+	for _, d := range conn.Domains {
+		domains = append(domains, &SAMLDomainResponse{
+			SAMLDomain:  d,
+			Verified:    d.Verified(),
+			RecordType:  "TXT",
+			RecordName:  auth.SAMLDomainRecordName(d.Domain),
+			RecordValue: auth.SAMLDomainRecordValue(d.VerificationToken),
+		})
+	}
+	return &SAMLConnectionResponse{
+		SAMLConnection: conn,
+		Domains:        domains,
+		SPEntityID:     base + "/metadata",
+		SPACSURL:       base + "/acs",
+		SPLoginURL:     base,
+		SPMetadata:     base + "/metadata",
+	}
+}
@@ -0,0 +1,605 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/apierr"
+	"github.com/RanitManik/zyply/internal/audit"
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/logging"
+	"github.com/RanitManik/zyply/internal/mail"
+	"github.com/RanitManik/zyply/internal/middleware"
+	"github.com/RanitManik/zyply/internal/models"
+	"github.com/RanitManik/zyply/internal/validate"
+)
+
+// maxAvatarURLLength is the maximum length of an avatar URL
+const maxAvatarURLLength = 2048
+
+// localePattern matches BCP 47 language tags such as "en", "pt-BR" or "zh-Hant-TW"
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// UserHandler handles the current user's profile and account settings
+type UserHandler struct {
+	Config    *config.Config
+	Passwords *auth.PasswordPolicy
+	MFA       models.MFARepository
+	Mail      mail.Sender
+}
+
+// NewUserHandler creates a new UserHandler
+func NewUserHandler(cfg *config.Config, passwords *auth.PasswordPolicy, mfa models.MFARepository, mailer mail.Sender) *UserHandler {
+	return &UserHandler{
+		Config:    cfg,
+		Passwords: passwords,
+		MFA:       mfa,
+		Mail:      mailer,
+	}
+}
+
+// UpdateProfileRequest represents a profile update. Omitted fields are left unchanged.
+type UpdateProfileRequest struct {
+	Name      *string `json:"name" validate:"max=255"`
+	AvatarURL *string `json:"avatar_url" validate:"max=2048"`
+	Timezone  *string `json:"timezone"`
+	Locale    *string `json:"locale"`
+}
+
+// ChangePasswordRequest represents a request to change the current user's password. Sessions
+// that did not log in recently confirm the current password or a TOTP or recovery code.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Code            string `json:"code"`
+	RecoveryCode    string `json:"recovery_code"`
+	NewPassword     string `json:"new_password"` // Checked against the password policy
+}
+
+// ChangeEmailRequest represents a request to change the current user's email address. Sessions
+// that did not log in recently confirm the password or a TOTP or recovery code.
+type ChangeEmailRequest struct {
+	NewEmail     string `json:"new_email" validate:"required,email,max=255"`
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// VerifyEmailChangeRequest represents a request to confirm an email change
+type VerifyEmailChangeRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// DeleteAccountRequest represents a request to delete the current user's account. Sessions
+// that did not log in recently confirm the password or a TOTP or recovery code.
+type DeleteAccountRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// RestoreAccountRequest represents a request to cancel a scheduled account deletion
+type RestoreAccountRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// UserExport is a copy of everything stored about a user. Secrets such as password hashes,
+// TOTP secrets and API key hashes are never included.
+type UserExport struct {
+	ExportedAt    time.Time                        `json:"exported_at"`
+	User          *models.User                     `json:"user"`
+	OAuthAccounts []*models.OAuthAccount           `json:"oauth_accounts"`
+	Workspaces    []*models.OrganizationMembership `json:"workspaces"`
+	APIKeys       []*models.APIKey                 `json:"api_keys"`
+	OAuthClients  []*models.OAuthClient            `json:"oauth_clients"`
+	Passkeys      []*models.PasskeyCredential      `json:"passkeys"`
+	MFA           MFAStatusResponse                `json:"mfa"`
+	AuditEvents   []*models.AuditEvent             `json:"audit_events"`
+}
+
+// GetProfile gets the current user's profile
+func (h *UserHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	// Get user
+	user, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// UpdateProfile updates the current user's name, avatar URL, timezone and locale
+func (h *UserHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	// Get user
+	user, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request
+	var req UpdateProfileRequest
+	if err := decodeRequest(w, r, &req, req.checks()...); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Apply changes
+	name, avatarURL, timezone, locale := user.Name, user.AvatarURL, user.Timezone, user.Locale
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+	}
+	if req.AvatarURL != nil {
+		avatarURL = strings.TrimSpace(*req.AvatarURL)
+	}
+	if req.Timezone != nil {
+		timezone = *req.Timezone
+	}
+	if req.Locale != nil {
+		locale = *req.Locale
+	}
+
+	// Update profile
+	updated, err := models.UpdateUserProfile(r.Context(), user.ID, name, avatarURL, timezone, locale)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to update profile", err))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionProfileUpdated,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.FormatInt(user.ID, 10),
+		Before:     user,
+		After:      updated,
+	})
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// ChangePassword changes the current user's password after reauthenticating them
+func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	// Get user
+	user, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request
+	var req ChangePasswordRequest
+	checkPassword := func() *validate.FieldError { return h.Passwords.Check("new_password", req.NewPassword) }
+	if err := decodeRequest(w, r, &req, checkPassword); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Reauthenticate
+	proof := Reauthentication{Password: req.CurrentPassword, Code: req.Code, RecoveryCode: req.RecoveryCode}
+	if err := reauthenticate(r, h.Config, h.MFA, user, proof); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Update password
+	if err := models.UpdateUserPassword(r.Context(), user.ID, req.NewPassword); err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to change password", err))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionPasswordChanged,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.FormatInt(user.ID, 10),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangeEmail starts an email change after reauthenticating the user. The new address
+// only takes effect once the link sent to it is confirmed with VerifyEmailChange.
+func (h *UserHandler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	// Get user
+	user, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request
+	var req ChangeEmailRequest
+	checkChanged := func() *validate.FieldError {
+		if req.NewEmail == user.Email {
+			return &validate.FieldError{Field: "new_email", Code: validate.CodeInvalid, Message: "new_email must differ from the current email"}
+		}
+		return nil
+	}
+	if err := decodeRequest(w, r, &req, checkChanged); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Reauthenticate
+	proof := Reauthentication{Password: req.Password, Code: req.Code, RecoveryCode: req.RecoveryCode}
+	if err := reauthenticate(r, h.Config, h.MFA, user, proof); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Check if email is taken
+	taken, err := models.EmailTaken(r.Context(), req.NewEmail)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to change email", err))
+		return
+	}
+	if taken {
+		apierr.Write(w, r, models.ErrEmailTaken)
+		return
+	}
+
+	// Generate and store verification token
+	token, tokenHash, err := auth.GenerateEmailChangeToken()
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate verification token", err))
+		return
+	}
+	if err := models.CreateEmailChange(r.Context(), user.ID, req.NewEmail, tokenHash, time.Now().Add(auth.EmailChangeExpiry)); err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to change email", err))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionEmailChangeIssued,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.FormatInt(user.ID, 10),
+		After:      map[string]string{"new_email": req.NewEmail},
+	})
+
+	// Send verification link to the new address, so only its owner can confirm the change
+	sendEmail(r, h.Mail, mail.Message{
+		To:      req.NewEmail,
+		Subject: "Confirm your new Zyply email address",
+		Body: "Someone asked to use this address for their Zyply account. " +
+			"To confirm the change, open this link within " + auth.EmailChangeExpiry.String() + ":\n\n" +
+			h.Config.Server.FrontendURL + "/settings/email/verify?token=" + url.QueryEscape(token) +
+			"\n\nIf this wasn't you, you can ignore this email.\n",
+	})
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Verification link sent to the new email address",
+	})
+}
+
+// VerifyEmailChange confirms a pending email change with the token sent to the new address
+func (h *UserHandler) VerifyEmailChange(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req VerifyEmailChangeRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Apply change
+	change, user, err := models.ConfirmEmailChange(r.Context(), auth.HashEmailChangeToken(req.Token))
+	if err != nil {
+		if errors.Is(err, models.ErrEmailTaken) {
+			apierr.Write(w, r, err)
+			return
+		}
+		apierr.Write(w, r, apierr.Internal("Failed to change email", err))
+		return
+	}
+	if change == nil {
+		apierr.Write(w, r, apierr.BadRequest("Invalid or expired verification link"))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionEmailChanged,
+		ActorID:    user.ID,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.FormatInt(user.ID, 10),
+		After:      map[string]string{"email": user.Email},
+	})
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// Export downloads everything stored about the current user, as a ZIP archive of JSON
+// files by default or as a single JSON document with ?format=json
+func (h *UserHandler) Export(w http.ResponseWriter, r *http.Request) {
+	// Get user
+	user, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Validate format
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "json" {
+		apierr.Write(w, r, apierr.BadRequest("Invalid format, expected zip or json"))
+		return
+	}
+
+	// Collect data
+	export, err := h.collectUserExport(r.Context(), user)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to export data", err))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionDataExported,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.FormatInt(user.ID, 10),
+	})
+
+	// Return response
+	filename := fmt.Sprintf("zyply-export-%d-%s", user.ID, export.ExportedAt.Format("20060102"))
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		json.NewEncoder(w).Encode(export)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+	if err := writeUserExportZip(w, export); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to stream data export", "error", err)
+	}
+}
+
+// DeleteAccount schedules the current user's account for deletion after reauthenticating them.
+// The account is hidden and its credentials revoked immediately, and it is purged once the grace
+// period ends unless restored with RestoreAccount.
+func (h *UserHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	// Get user
+	user, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request
+	var req DeleteAccountRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Reauthenticate
+	proof := Reauthentication{Password: req.Password, Code: req.Code, RecoveryCode: req.RecoveryCode}
+	if err := reauthenticate(r, h.Config, h.MFA, user, proof); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Shared workspaces must not be left without an owner
+	orgs, err := models.GetSoleOwnedSharedOrganizations(r.Context(), user.ID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to delete account", err))
+		return
+	}
+	if len(orgs) > 0 {
+		slugs := make([]string, len(orgs))
+		for i, org := range orgs {
+			slugs[i] = org.Slug
+		}
+		apierr.Write(w, r, apierr.Conflict("Transfer ownership of these workspaces before deleting your account: "+strings.Join(slugs, ", ")))
+		return
+	}
+
+	// Generate restore token and schedule deletion
+	token, tokenHash, err := auth.GenerateAccountRestoreToken()
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate restore token", err))
+		return
+	}
+	purgeAfter := time.Now().Add(h.Config.Account.DeletionGracePeriod)
+	if err := models.ScheduleUserDeletion(r.Context(), user.ID, purgeAfter, tokenHash); err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to delete account", err))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionDeletionScheduled,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.FormatInt(user.ID, 10),
+		After:      map[string]time.Time{"purge_after": purgeAfter},
+	})
+
+	// Send restore link to the account's address, so its owner hears about the deletion
+	sendEmail(r, h.Mail, mail.Message{
+		To:      user.Email,
+		Subject: "Your Zyply account is scheduled for deletion",
+		Body: "Your Zyply account and its data will be permanently deleted on " + purgeAfter.UTC().Format(time.RFC1123) + ". " +
+			"To keep your account, open this link before then:\n\n" +
+			h.Config.Server.FrontendURL + "/account/restore?token=" + url.QueryEscape(token) + "\n",
+	})
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":     "Account scheduled for deletion",
+		"purge_after": purgeAfter,
+	})
+}
+
+// RestoreAccount cancels a scheduled account deletion with the token issued by DeleteAccount
+func (h *UserHandler) RestoreAccount(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req RestoreAccountRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Restore user
+	user, err := models.RestoreUser(r.Context(), auth.HashAccountRestoreToken(req.Token))
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to restore account", err))
+		return
+	}
+	if user == nil {
+		apierr.Write(w, r, apierr.BadRequest("Invalid or expired restore link"))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionAccountRestored,
+		ActorID:    user.ID,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.FormatInt(user.ID, 10),
+	})
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// collectUserExport gathers everything stored about a user
+func (h *UserHandler) collectUserExport(ctx context.Context, user *models.User) (*UserExport, error) {
+	export := &UserExport{ExportedAt: time.Now().UTC(), User: user}
+
+	var err error
+	if export.OAuthAccounts, err = models.GetOAuthAccountsByUserID(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	if export.Workspaces, err = models.GetOrganizationsByUserID(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	if export.APIKeys, err = models.GetAPIKeysByUserID(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	if export.OAuthClients, err = models.GetOAuthClientsByOwner(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	if export.Passkeys, err = models.GetPasskeyCredentialsByUserID(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	cred, err := h.MFA.GetTOTPCredential(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	export.MFA.Enabled = cred != nil && cred.Enabled()
+	if export.MFA.Enabled {
+		if export.MFA.RecoveryCodesRemaining, err = h.MFA.CountRecoveryCodes(ctx, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if export.AuditEvents, err = models.ListAuditEvents(ctx, models.AuditFilter{ActorID: user.ID}); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// writeUserExportZip streams a user export as a ZIP archive with one JSON file per section
+func writeUserExportZip(w http.ResponseWriter, export *UserExport) error {
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"user.json", export.User},
+		{"oauth_accounts.json", export.OAuthAccounts},
+		{"workspaces.json", export.Workspaces},
+		{"api_keys.json", export.APIKeys},
+		{"oauth_clients.json", export.OAuthClients},
+		{"passkeys.json", export.Passkeys},
+		{"mfa.json", export.MFA},
+		{"audit_events.json", export.AuditEvents},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, file := range files {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// currentUser loads the authenticated user
+func currentUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return nil, false
+	}
+
+	// Get user
+	user, err := models.GetUserByID(r.Context(), userID)
+	if err != nil {
+		apierr.Write(w, r, err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// checks returns the checks of a profile update its validate tags cannot express
+func (req *UpdateProfileRequest) checks() []func() *validate.FieldError {
+	return []func() *validate.FieldError{
+		func() *validate.FieldError {
+			if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+				return &validate.FieldError{Field: "name", Code: validate.CodeRequired, Message: "name must not be empty"}
+			}
+			return nil
+		},
+		func() *validate.FieldError {
+			if req.AvatarURL != nil {
+				if avatarURL := strings.TrimSpace(*req.AvatarURL); avatarURL != "" && !validAvatarURL(avatarURL) {
+					return &validate.FieldError{Field: "avatar_url", Code: validate.CodeInvalid, Message: "avatar_url must be an absolute http or https URL"}
+				}
+			}
+			return nil
+		},
+		func() *validate.FieldError {
+			if req.Timezone != nil {
+				if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" || *req.Timezone == "Local" {
+					return &validate.FieldError{Field: "timezone", Code: validate.CodeInvalid, Message: "timezone must be an IANA name such as Europe/Berlin"}
+				}
+			}
+			return nil
+		},
+		func() *validate.FieldError {
+			if req.Locale != nil && !localePattern.MatchString(*req.Locale) {
+				return &validate.FieldError{Field: "locale", Code: validate.CodeInvalid, Message: "locale must be a language tag such as en-US"}
+			}
+			return nil
+		},
+	}
+}
+
+// validAvatarURL checks if an avatar URL is an absolute http or https URL
+func validAvatarURL(value string) bool {
+	if len(value) > maxAvatarURLLength {
+		return false
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
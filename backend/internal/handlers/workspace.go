@@ -0,0 +1,531 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/apierr"
+	"github.com/RanitManik/zyply/internal/audit"
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/mail"
+	"github.com/RanitManik/zyply/internal/middleware"
+	"github.com/RanitManik/zyply/internal/models"
+	"github.com/RanitManik/zyply/internal/validate"
+	"github.com/go-chi/chi/v5"
+)
+
+// WorkspaceHandler handles workspaces, their members and invitations
+type WorkspaceHandler struct {
+	Config *config.Config
+	Mail   mail.Sender
+}
+
+// NewWorkspaceHandler creates a new WorkspaceHandler
+func NewWorkspaceHandler(cfg *config.Config, mailer mail.Sender) *WorkspaceHandler {
+	return &WorkspaceHandler{
+		Config: cfg,
+		Mail:   mailer,
+	}
+}
+
+// CreateWorkspaceRequest represents a request to create a workspace
+type CreateWorkspaceRequest struct {
+	Name string `json:"name" validate:"required,max=255"`
+	Slug string `json:"slug" validate:"required"`
+}
+
+// CreateInviteRequest represents a request to invite someone to a workspace
+type CreateInviteRequest struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+	Role  string `json:"role"` // Defaults to editor
+}
+
+// UpdateMemberRequest represents a request to change a member's role
+type UpdateMemberRequest struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// AcceptInviteRequest represents a request to accept a workspace invitation
+type AcceptInviteRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// List lists the workspaces the current user belongs to
+func (h *WorkspaceHandler) List(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	// Make sure the personal workspace exists
+	if _, err := models.GetPersonalOrganization(r.Context(), userID); err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get workspaces", err))
+		return
+	}
+
+	// Get workspaces
+	orgs, err := models.GetOrganizationsByUserID(r.Context(), userID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get workspaces", err))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orgs)
+}
+
+// Create creates a workspace owned by the current user
+func (h *WorkspaceHandler) Create(w http.ResponseWriter, r *http.Request) {
+	// Get user ID
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	// Parse request
+	var req CreateWorkspaceRequest
+	checkSlug := func() *validate.FieldError {
+		slug := strings.ToLower(strings.TrimSpace(req.Slug))
+		if slug != "" && (!auth.ValidOrganization(slug) || strings.HasPrefix(slug, "personal-")) {
+			return &validate.FieldError{Field: "slug", Code: validate.CodeInvalid, Message: "slug must be lowercase letters, digits and hyphens and must not start with personal-"}
+		}
+		return nil
+	}
+	if err := decodeRequest(w, r, &req, checkSlug); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	req.Slug = strings.ToLower(strings.TrimSpace(req.Slug))
+
+	// Check if slug is taken
+	taken, err := models.OrganizationSlugTaken(r.Context(), req.Slug)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to create workspace", err))
+		return
+	}
+	if taken {
+		apierr.Write(w, r, apierr.Conflict("Slug is already taken"))
+		return
+	}
+
+	// Create workspace
+	org, err := models.CreateOrganization(r.Context(), req.Name, req.Slug, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrSlugTaken) {
+			apierr.Write(w, r, apierr.Conflict("Slug is already taken"))
+			return
+		}
+		apierr.Write(w, r, apierr.Internal("Failed to create workspace", err))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:         audit.ActionWorkspaceCreated,
+		OrganizationID: org.ID,
+		TargetType:     audit.TargetWorkspace,
+		TargetID:       strconv.FormatInt(org.ID, 10),
+		After:          org,
+	})
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.OrganizationMembership{
+		Organization: org,
+		Role:         models.RoleOwner,
+	})
+}
+
+// ListMembers lists the members of the workspace in the route
+func (h *WorkspaceHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	// Get workspace
+	org, ok := currentWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	// Get members
+	members, err := models.GetMembers(r.Context(), org.ID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get members", err))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// UpdateMember changes the role of a member of the workspace in the route
+func (h *WorkspaceHandler) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	// Get workspace
+	org, ok := currentWorkspace(w, r)
+	if !ok {
+		return
+	}
+	actorRole := middleware.GetWorkspaceRole(r.Context())
+
+	// Get member
+	member, ok := memberFromRoute(w, r, org)
+	if !ok {
+		return
+	}
+
+	// Parse request
+	var req UpdateMemberRequest
+	if err := decodeRequest(w, r, &req, checkRole(&req.Role)); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Check the actor may give the role
+	if !auth.CanAssignRole(actorRole, req.Role) || !auth.CanAssignRole(actorRole, member.Role) {
+		apierr.Write(w, r, apierr.Forbidden("Not allowed to assign this role"))
+		return
+	}
+
+	// Update role
+	updated, err := models.UpdateMembershipRole(r.Context(), org.ID, member.UserID, req.Role)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to update member", err))
+		return
+	}
+	if !updated {
+		apierr.Write(w, r, apierr.Conflict("The last owner cannot be demoted"))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionMemberRoleUpdated,
+		TargetType: audit.TargetMember,
+		TargetID:   strconv.FormatInt(member.UserID, 10),
+		Before:     map[string]string{"role": member.Role},
+		After:      map[string]string{"role": req.Role},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveMember removes a member from the workspace in the route. Any member may leave;
+// removing someone else requires the members:manage permission.
+func (h *WorkspaceHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	// Get workspace
+	org, ok := currentWorkspace(w, r)
+	if !ok {
+		return
+	}
+	if org.Personal {
+		apierr.Write(w, r, apierr.BadRequest("Members cannot be removed from a personal workspace"))
+		return
+	}
+	userID, _ := middleware.GetUserID(r.Context())
+
+	// Get member
+	member, ok := memberFromRoute(w, r, org)
+	if !ok {
+		return
+	}
+	if member.UserID != userID {
+		if !middleware.Can(r.Context(), auth.PermissionMembersManage) {
+			apierr.Write(w, r, apierr.Forbidden("Missing required permission "+auth.PermissionMembersManage))
+			return
+		}
+		if !auth.CanAssignRole(middleware.GetWorkspaceRole(r.Context()), member.Role) {
+			apierr.Write(w, r, apierr.Forbidden("Only workspace owners can remove owners"))
+			return
+		}
+	}
+
+	// Remove member
+	removed, err := models.RemoveMembership(r.Context(), org.ID, member.UserID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to remove member", err))
+		return
+	}
+	if !removed {
+		apierr.Write(w, r, apierr.Conflict("The last owner cannot be removed"))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionMemberRemoved,
+		TargetType: audit.TargetMember,
+		TargetID:   strconv.FormatInt(member.UserID, 10),
+		Before:     member,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListInvites lists the pending invitations of the workspace in the route
+func (h *WorkspaceHandler) ListInvites(w http.ResponseWriter, r *http.Request) {
+	// Get workspace
+	org, ok := currentWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	// Get invites
+	invites, err := models.GetPendingInvites(r.Context(), org.ID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get invites", err))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+// CreateInvite invites someone by email to the workspace in the route
+func (h *WorkspaceHandler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	// Get workspace
+	org, ok := currentWorkspace(w, r)
+	if !ok {
+		return
+	}
+	if org.Personal {
+		apierr.Write(w, r, apierr.BadRequest("Cannot invite members to a personal workspace"))
+		return
+	}
+
+	// Parse request
+	var req CreateInviteRequest
+	if err := decodeRequest(w, r, &req, checkRole(&req.Role)); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.RoleEditor
+	}
+
+	// Check the actor may give the role
+	if !auth.CanAssignRole(middleware.GetWorkspaceRole(r.Context()), req.Role) {
+		apierr.Write(w, r, apierr.Forbidden("Not allowed to assign this role"))
+		return
+	}
+	userID, _ := middleware.GetUserID(r.Context())
+
+	// Generate token
+	token, tokenHash, err := auth.GenerateInviteToken()
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to generate invite token", err))
+		return
+	}
+
+	// Store invite
+	invite, err := models.CreateInvite(r.Context(), org.ID, req.Email, req.Role, tokenHash, userID, time.Now().Add(auth.InviteExpiry))
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to create invite", err))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionInviteCreated,
+		TargetType: audit.TargetInvite,
+		TargetID:   strconv.FormatInt(invite.ID, 10),
+		After:      invite,
+	})
+
+	// Send invite link to the invitee, who accepts it signed in with that address
+	sendEmail(r, h.Mail, mail.Message{
+		To:      invite.Email,
+		Subject: "You're invited to join " + org.Name + " on Zyply",
+		Body: "You have been invited to join the " + org.Name + " workspace on Zyply as " + invite.Role + ". " +
+			"To accept, open this link within " + auth.InviteExpiry.String() + ":\n\n" +
+			h.Config.Server.FrontendURL + "/invites/accept?token=" + url.QueryEscape(token) +
+			"\n\nIf you don't know why you got this email, you can ignore it.\n",
+	})
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invite)
+}
+
+// DeleteInvite revokes a pending invitation of the workspace in the route
+func (h *WorkspaceHandler) DeleteInvite(w http.ResponseWriter, r *http.Request) {
+	// Get workspace
+	org, ok := currentWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	// Get invite ID
+	id, err := strconv.ParseInt(chi.URLParam(r, "inviteID"), 10, 64)
+	if err != nil {
+		apierr.Write(w, r, apierr.BadRequest("Invalid invite ID"))
+		return
+	}
+
+	// Delete invite
+	deleted, err := models.DeleteInvite(r.Context(), id, org.ID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to delete invite", err))
+		return
+	}
+	if !deleted {
+		apierr.Write(w, r, apierr.NotFound("Invite not found"))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionInviteRevoked,
+		TargetType: audit.TargetInvite,
+		TargetID:   strconv.FormatInt(id, 10),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptInvite adds the current user to the workspace of an invitation sent to their email
+func (h *WorkspaceHandler) AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	// Get user
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+	user, err := models.GetUserByID(r.Context(), userID)
+	if err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Parse request
+	var req AcceptInviteRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		apierr.Write(w, r, err)
+		return
+	}
+
+	// Accept invite
+	invite, err := models.AcceptInvite(r.Context(), auth.HashInviteToken(req.Token), user.ID, user.Email)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to accept invite", err))
+		return
+	}
+	if invite == nil {
+		apierr.Write(w, r, apierr.NotFound("Invalid or expired invite"))
+		return
+	}
+	audit.Record(r, audit.Event{
+		Action:         audit.ActionInviteAccepted,
+		OrganizationID: invite.OrganizationID,
+		TargetType:     audit.TargetInvite,
+		TargetID:       strconv.FormatInt(invite.ID, 10),
+		After:          map[string]string{"role": invite.Role},
+	})
+
+	// Get workspace
+	org, err := models.GetOrganizationByID(r.Context(), invite.OrganizationID)
+	if err != nil || org == nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get workspace", err))
+		return
+	}
+	membership, err := models.GetMembership(r.Context(), org.ID, user.ID)
+	if err != nil || membership == nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get workspace", err))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.OrganizationMembership{
+		Organization: org,
+		Role:         membership.Role,
+	})
+}
+
+// currentWorkspace loads the workspace resolved by middleware.ResolveWorkspace
+func currentWorkspace(w http.ResponseWriter, r *http.Request) (*models.Organization, bool) {
+	workspaceID, ok := middleware.GetWorkspaceID(r.Context())
+	if !ok {
+		apierr.Write(w, r, apierr.BadRequest("Workspace required"))
+		return nil, false
+	}
+
+	org, err := models.GetOrganizationByID(r.Context(), workspaceID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get workspace", err))
+		return nil, false
+	}
+	if org == nil {
+		apierr.Write(w, r, apierr.NotFound("Workspace not found"))
+		return nil, false
+	}
+
+	return org, true
+}
+
+// memberFromRoute loads the membership of the user in the route
+func memberFromRoute(w http.ResponseWriter, r *http.Request, org *models.Organization) (*models.Membership, bool) {
+	memberID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		apierr.Write(w, r, apierr.BadRequest("Invalid user ID"))
+		return nil, false
+	}
+
+	member, err := models.GetMembership(r.Context(), org.ID, memberID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get member", err))
+		return nil, false
+	}
+	if member == nil {
+		apierr.Write(w, r, apierr.NotFound("Member not found"))
+		return nil, false
+	}
+
+	return member, true
+}
+
+// organizationWithPermission loads an organization by slug and checks the user's role in it grants a permission
+func organizationWithPermission(w http.ResponseWriter, r *http.Request, slug string, userID int64, permission string) (*models.Organization, bool) {
+	if !auth.ValidOrganization(slug) {
+		apierr.Write(w, r, apierr.BadRequest("Invalid organization"))
+		return nil, false
+	}
+
+	org, err := models.GetOrganizationBySlug(r.Context(), slug)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get organization", err))
+		return nil, false
+	}
+	if org == nil {
+		apierr.Write(w, r, apierr.NotFound("Organization not found"))
+		return nil, false
+	}
+
+	membership, err := models.GetMembership(r.Context(), org.ID, userID)
+	if err != nil {
+		apierr.Write(w, r, apierr.Internal("Failed to get organization", err))
+		return nil, false
+	}
+	if membership == nil {
+		// Don't reveal organizations the user does not belong to
+		apierr.Write(w, r, apierr.NotFound("Organization not found"))
+		return nil, false
+	}
+	if !auth.RoleHasPermission(membership.Role, permission) {
+		apierr.Write(w, r, apierr.Forbidden("Missing required permission "+permission))
+		return nil, false
+	}
+
+	return org, true
+}
+
+// checkRole returns a check that a role field, if set, names a membership role
+func checkRole(role *string) func() *validate.FieldError {
+	return func() *validate.FieldError {
+		if *role != "" && !models.ValidRole(*role) {
+			return &validate.FieldError{Field: "role", Code: validate.CodeInvalid, Message: "role must be one of " + strings.Join(models.Roles, ", ")}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/RanitManik/zyply/internal/config"
+)
+
+// Redacted replaces the values of sensitive attributes and query parameters
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys and query parameters whose values are never logged
+var sensitiveKeys = map[string]bool{
+	"authorization": true,
+	"cookie":        true,
+	"set-cookie":    true,
+	"password":      true,
+	"token":         true,
+	"secret":        true,
+	"api_key":       true,
+}
+
+// New creates a logger writing JSON, or text for local development, at the configured level
+func New(cfg *config.Config, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level:       cfg.Log.Level,
+		ReplaceAttr: redactAttr,
+	}
+	if cfg.Log.Format == config.LogFormatText {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+// Sensitive checks if an attribute key or query parameter holds a credential
+func Sensitive(key string) bool {
+	key = strings.ToLower(key)
+	return sensitiveKeys[key] || strings.HasSuffix(key, "_token") || strings.HasSuffix(key, "_secret") || strings.HasSuffix(key, "password")
+}
+
+// RedactURL returns a URL's path and query with the values of sensitive query parameters redacted
+func RedactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.Path
+	}
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		rawKey, _, _ := strings.Cut(param, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			key = rawKey
+		}
+		// OAuth authorization codes can be exchanged for tokens
+		if Sensitive(key) || key == "code" {
+			params[i] = rawKey + "=" + Redacted
+		}
+	}
+	return u.Path + "?" + strings.Join(params, "&")
+}
+
+// redactAttr hides the values of sensitive attributes
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if Sensitive(attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+	return attr
+}
+
+// loggerKey is the context key for the request-scoped logger
+type loggerKey struct{}
+
+// requestKey is the context key for the fields shared by all contexts derived from a request
+type requestKey struct{}
+
+// requestFields are set deep in the middleware chain and read back when the request is logged
+type requestFields struct {
+	mu     sync.Mutex
+	userID int64
+}
+
+// WithRequest returns a context carrying a request-scoped logger
+func WithRequest(ctx context.Context, logger *slog.Logger) context.Context {
+	ctx = context.WithValue(ctx, requestKey{}, &requestFields{})
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// WithUserID records the authenticated user of a request and adds it to the request-scoped logger
+func WithUserID(ctx context.Context, userID int64) context.Context {
+	if fields, ok := ctx.Value(requestKey{}).(*requestFields); ok {
+		fields.mu.Lock()
+		fields.userID = userID
+		fields.mu.Unlock()
+	}
+	return context.WithValue(ctx, loggerKey{}, FromContext(ctx).With("user_id", userID))
+}
+
+// UserID returns the user recorded for the request, including by middleware further down the chain
+func UserID(ctx context.Context) (int64, bool) {
+	fields, ok := ctx.Value(requestKey{}).(*requestFields)
+	if !ok {
+		return 0, false
+	}
+	fields.mu.Lock()
+	defer fields.mu.Unlock()
+	return fields.userID, fields.userID != 0
+}
+
+// FromContext returns the request-scoped logger, or the default logger outside of a request
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
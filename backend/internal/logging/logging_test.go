@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"testing"
+
+	"github.com/RanitManik/zyply/internal/config"
+)
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/api/links", "/api/links"},
+		{"/api/links?page=2&sort=desc", "/api/links?page=2&sort=desc"},
+		{"/api/auth/google/callback?code=abc&state=xyz", "/api/auth/google/callback?code=[REDACTED]&state=xyz"},
+		{"/api/auth/verify?token=abc", "/api/auth/verify?token=[REDACTED]"},
+		{"/api/auth/reset?reset_token=abc&page=1", "/api/auth/reset?reset_token=[REDACTED]&page=1"},
+		{"/api/links?access_token=abc", "/api/links?access_token=[REDACTED]"},
+		{"/api/links?Access_Token=abc", "/api/links?Access_Token=[REDACTED]"},
+		{"/api/oauth/token?client_secret=abc", "/api/oauth/token?client_secret=[REDACTED]"},
+		{"/login?password=abc&new_password=def", "/login?password=[REDACTED]&new_password=[REDACTED]"},
+		{"/login?%70assword=abc", "/login?%70assword=[REDACTED]"},
+		{"/api/links?api_key=abc", "/api/links?api_key=[REDACTED]"},
+		{"/api/links?token", "/api/links?token=[REDACTED]"},
+		{"/api/links?code=a&code=b", "/api/links?code=[REDACTED]&code=[REDACTED]"},
+		{"/api/links?%zz=abc", "/api/links?%zz=abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := RedactURL(u); got != tt.want {
+				t.Errorf("RedactURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactAttr(t *testing.T) {
+	tests := []struct {
+		name string
+		attr slog.Attr
+		// want is the logged attribute, decoded from JSON
+		want interface{}
+	}{
+		{"authorization header", slog.String("authorization", "Bearer abc"), Redacted},
+		{"authorization header in canonical case", slog.String("Authorization", "Bearer abc"), Redacted},
+		{"cookie", slog.String("cookie", "session=abc"), Redacted},
+		{"token", slog.String("token", "abc"), Redacted},
+		{"refresh token", slog.String("refresh_token", "abc"), Redacted},
+		{"client secret", slog.String("client_secret", "abc"), Redacted},
+		{"password", slog.String("password", "abc"), Redacted},
+		{"new password", slog.String("NewPassword", "abc"), Redacted},
+		{"non-string value", slog.Int("token", 42), Redacted},
+		{"harmless attribute", slog.String("path", "/api/links"), "/api/links"},
+		{"token count", slog.Int("tokens", 2), float64(2)},
+		{"nested group", slog.Group("request", slog.String("authorization", "Bearer abc"), slog.String("method", "GET")),
+			map[string]interface{}{"authorization": Redacted, "method": "GET"}},
+		{"deeply nested group", slog.Group("request", slog.Group("headers", slog.String("cookie", "session=abc"))),
+			map[string]interface{}{"headers": map[string]interface{}{"cookie": Redacted}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			New(&config.Config{}, &buf).Info("test", tt.attr)
+
+			var entry map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("log entry %q: %v", buf.String(), err)
+			}
+			got, _ := json.Marshal(entry[tt.attr.Key])
+			want, _ := json.Marshal(tt.want)
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s = %s, want %s", tt.attr.Key, got, want)
+			}
+		})
+	}
+}
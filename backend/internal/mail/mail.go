@@ -0,0 +1,82 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strings"
+
+	"github.com/RanitManik/zyply/internal/config"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers emails
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewSender creates a sender for the configured SMTP server, or one that logs emails when no
+// server is configured. Production refuses to start without an SMTP server.
+func NewSender(cfg *config.Config) Sender {
+	if cfg.Mail.SMTPHost == "" {
+		return LogSender{}
+	}
+	return &SMTPSender{
+		Addr:     net.JoinHostPort(cfg.Mail.SMTPHost, cfg.Mail.SMTPPort),
+		Host:     cfg.Mail.SMTPHost,
+		Username: cfg.Mail.SMTPUsername,
+		Password: cfg.Mail.SMTPPassword,
+		From:     cfg.Mail.From,
+	}
+}
+
+// SMTPSender sends emails through an SMTP server, upgrading to TLS when the server supports it
+type SMTPSender struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send sends an email
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	// Build message, refusing header injection through the recipient or subject
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+	body := "From: " + s.From + "\r\n" +
+		"To: " + msg.To + "\r\n" +
+		"Subject: " + msg.Subject + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + msg.Body
+
+	from, err := netmail.ParseAddress(s.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+	return smtp.SendMail(s.Addr, auth, from.Address, []string{msg.To}, []byte(body))
+}
+
+// LogSender logs emails instead of sending them, for local development
+type LogSender struct{}
+
+// Send logs an email
+func (LogSender) Send(ctx context.Context, msg Message) error {
+	slog.InfoContext(ctx, "Email not sent, no SMTP server configured", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
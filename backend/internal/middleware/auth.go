@@ -2,11 +2,17 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/RanitManik/zyply/internal/apierr"
 	"github.com/RanitManik/zyply/internal/auth"
 	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/logging"
+	"github.com/RanitManik/zyply/internal/metrics"
+	"github.com/RanitManik/zyply/internal/models"
 )
 
 // contextKey is a custom type for context keys
@@ -18,35 +24,135 @@ const UserIDKey contextKey = "userID"
 // EmailKey is the context key for email
 const EmailKey contextKey = "email"
 
-// Authenticate authenticates a request using JWT
-func Authenticate(cfg *config.Config) func(next http.Handler) http.Handler {
+// AuthMethodKey is the context key for how the request was authenticated
+const AuthMethodKey contextKey = "authMethod"
+
+// ScopesKey is the context key for the scopes granted to an API key or OAuth token
+const ScopesKey contextKey = "scopes"
+
+// ClientIDKey is the context key for the OAuth client acting on behalf of the user
+const ClientIDKey contextKey = "clientID"
+
+// AuthTimeKey is the context key for when the user logged in to the session
+const AuthTimeKey contextKey = "authTime"
+
+const (
+	// AuthMethodSession marks requests authenticated with a JWT from a login
+	AuthMethodSession = "session"
+	// AuthMethodAPIKey marks requests authenticated with a personal API key
+	AuthMethodAPIKey = "api_key"
+	// AuthMethodOAuth marks requests authenticated with an access token issued to an OAuth client
+	AuthMethodOAuth = "oauth"
+)
+
+// Authenticate authenticates a request using a JWT or OAuth access token
+// ("Bearer <token>") or a personal API key ("ApiKey <key>")
+func Authenticate(cfg *config.Config, users models.UserRepository, apiKeys models.APIKeyRepository, oauthServer models.OAuthServerRepository) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Get Authorization header
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
-				http.Error(w, "Authorization header required", http.StatusUnauthorized)
+				apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Authorization header required"))
 				return
 			}
 
-			// Check if it's a Bearer token
-			parts := strings.Split(authHeader, " ")
-			if len(parts) != 2 || parts[0] != "Bearer" {
-				http.Error(w, "Invalid Authorization header format", http.StatusUnauthorized)
+			// Split scheme and credentials
+			scheme, credentials, ok := strings.Cut(authHeader, " ")
+			if !ok || credentials == "" {
+				apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Invalid Authorization header format"))
 				return
 			}
 
-			// Validate token
-			tokenString := parts[1]
-			claims, err := auth.ValidateToken(tokenString, cfg)
-			if err != nil {
-				http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+			var ctx context.Context
+			switch {
+			case scheme == "Bearer" && auth.IsOAuthAccessToken(credentials):
+				// Validate OAuth access token
+				token, err := auth.ValidateOAuthAccessToken(r.Context(), oauthServer, credentials)
+				if err != nil {
+					if errors.Is(err, auth.ErrInvalidOAuthToken) {
+						metrics.TokenValidationFailures.WithLabelValues(AuthMethodOAuth).Inc()
+						apierr.Write(w, r, apierr.Unauthorized(apierr.CodeInvalidToken, "Invalid or expired token"))
+						return
+					}
+					apierr.Write(w, r, apierr.Internal("Failed to validate token", err))
+					return
+				}
+
+				// Get user the client acts for
+				user, err := users.GetByID(r.Context(), token.UserID)
+				if err != nil {
+					apierr.Write(w, r, apierr.Unauthorized(apierr.CodeInvalidToken, "Invalid or expired token"))
+					return
+				}
+
+				// Add user ID, email, scopes and client to context
+				ctx = context.WithValue(r.Context(), UserIDKey, user.ID)
+				ctx = context.WithValue(ctx, EmailKey, user.Email)
+				ctx = context.WithValue(ctx, AuthMethodKey, AuthMethodOAuth)
+				ctx = context.WithValue(ctx, ScopesKey, token.Scopes)
+				ctx = context.WithValue(ctx, ClientIDKey, token.ClientID)
+			case scheme == "Bearer":
+				// Validate token
+				claims, err := auth.ValidateToken(credentials, cfg)
+				if err != nil {
+					metrics.TokenValidationFailures.WithLabelValues(AuthMethodSession).Inc()
+					apierr.Write(w, r, apierr.Unauthorized(apierr.CodeInvalidToken, "Invalid or expired token"))
+					return
+				}
+
+				// Reject tokens of users who have since been deleted
+				if _, err := users.GetByID(r.Context(), claims.UserID); err != nil {
+					apierr.Write(w, r, apierr.Unauthorized(apierr.CodeInvalidToken, "Invalid or expired token"))
+					return
+				}
+
+				// Add user ID and email to context
+				ctx = context.WithValue(r.Context(), UserIDKey, claims.UserID)
+				ctx = context.WithValue(ctx, EmailKey, claims.Email)
+				ctx = context.WithValue(ctx, AuthMethodKey, AuthMethodSession)
+				if claims.IssuedAt != nil {
+					ctx = context.WithValue(ctx, AuthTimeKey, claims.IssuedAt.Time)
+				}
+			case scheme == "ApiKey":
+				// Validate API key
+				apiKey, err := auth.ValidateAPIKey(r.Context(), apiKeys, credentials)
+				if err != nil {
+					if errors.Is(err, auth.ErrInvalidAPIKey) {
+						metrics.TokenValidationFailures.WithLabelValues(AuthMethodAPIKey).Inc()
+						apierr.Write(w, r, apierr.Unauthorized(apierr.CodeInvalidToken, "Invalid or expired API key"))
+						return
+					}
+					apierr.Write(w, r, apierr.Internal("Failed to validate API key", err))
+					return
+				}
+
+				// Get key owner
+				user, err := users.GetByID(r.Context(), apiKey.UserID)
+				if err != nil {
+					apierr.Write(w, r, apierr.Unauthorized(apierr.CodeInvalidToken, "Invalid or expired API key"))
+					return
+				}
+
+				// Track last use
+				if err := apiKeys.Touch(r.Context(), apiKey.ID); err != nil {
+					logging.FromContext(r.Context()).Error("Failed to record API key use", "error", err)
+				}
+
+				// Add user ID, email, scopes and workspace to context
+				ctx = context.WithValue(r.Context(), UserIDKey, user.ID)
+				ctx = context.WithValue(ctx, EmailKey, user.Email)
+				ctx = context.WithValue(ctx, AuthMethodKey, AuthMethodAPIKey)
+				ctx = context.WithValue(ctx, ScopesKey, apiKey.Scopes)
+				ctx = context.WithValue(ctx, WorkspaceIDKey, apiKey.OrganizationID)
+			default:
+				apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Invalid Authorization header format"))
 				return
 			}
 
-			// Add user ID and email to context
-			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
-			ctx = context.WithValue(ctx, EmailKey, claims.Email)
+			// Attach the user to request logs
+			userID, _ := GetUserID(ctx)
+			ctx = logging.WithUserID(ctx, userID)
 
 			// Call next handler with updated context
 			next.ServeHTTP(w, r.WithContext(ctx))
@@ -54,6 +160,32 @@ func Authenticate(cfg *config.Config) func(next http.Handler) http.Handler {
 	}
 }
 
+// RequireSession rejects requests authenticated with an API key or OAuth token. It is used
+// for account and security settings that must only be changed from a logged-in session.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetAuthMethod(r.Context()) != AuthMethodSession {
+			apierr.Write(w, r, apierr.Forbidden("This endpoint requires a logged-in session"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScope rejects API key and OAuth requests that were not granted the scope.
+// Requests authenticated with a session token have every scope.
+func RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !HasScope(r.Context(), scope) {
+				apierr.Write(w, r, apierr.Forbidden("Missing required scope "+scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // GetUserID gets the user ID from the context
 func GetUserID(ctx context.Context) (int64, bool) {
 	userID, ok := ctx.Value(UserIDKey).(int64)
@@ -65,3 +197,38 @@ func GetEmail(ctx context.Context) (string, bool) {
 	email, ok := ctx.Value(EmailKey).(string)
 	return email, ok
 }
+
+// GetAuthMethod gets how the request was authenticated from the context
+func GetAuthMethod(ctx context.Context) string {
+	method, _ := ctx.Value(AuthMethodKey).(string)
+	return method
+}
+
+// GetAuthTime gets when the user logged in to the session from the context. Session tokens are
+// only issued by a login, so this is when the user last proved their identity.
+func GetAuthTime(ctx context.Context) (time.Time, bool) {
+	authTime, ok := ctx.Value(AuthTimeKey).(time.Time)
+	return authTime, ok
+}
+
+// GetClientID gets the OAuth client acting on behalf of the user from the context
+func GetClientID(ctx context.Context) (string, bool) {
+	clientID, ok := ctx.Value(ClientIDKey).(string)
+	return clientID, ok
+}
+
+// HasScope checks if the request may act within a scope
+func HasScope(ctx context.Context, scope string) bool {
+	switch GetAuthMethod(ctx) {
+	case AuthMethodSession:
+		return true
+	case AuthMethodAPIKey, AuthMethodOAuth:
+		scopes, _ := ctx.Value(ScopesKey).([]string)
+		for _, s := range scopes {
+			if s == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
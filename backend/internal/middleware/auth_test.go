@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{}
+	cfg.JWT.Secret = "test-secret"
+	cfg.JWT.Expiry = time.Hour
+
+	users, _ := models.NewMemoryRepositories()
+	user, err := users.Create(ctx, "Ada", "ada@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatalf("Create user: %v", err)
+	}
+	apiKeys := models.NewMemoryAPIKeyRepository()
+	key, prefix, secretHash, err := auth.GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey: %v", err)
+	}
+	if _, err := apiKeys.Create(ctx, user.ID, 7, "CI", prefix, secretHash, []string{auth.ScopeLinksRead}, nil); err != nil {
+		t.Fatalf("Create API key: %v", err)
+	}
+	token, err := auth.GenerateToken(user.ID, user.Email, cfg)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		if userID, _ := GetUserID(r.Context()); userID != user.ID {
+			t.Errorf("user ID = %d, want %d", userID, user.ID)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	r := chi.NewRouter()
+	r.Use(Authenticate(cfg, users, apiKeys, models.NewMemoryOAuthServerRepository()))
+	r.With(RequireScope(auth.ScopeLinksRead)).Get("/links", ok)
+	r.With(RequireScope(auth.ScopeLinksWrite)).Post("/links", ok)
+	r.With(RequireSession).Get("/settings", ok)
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		want          int
+	}{
+		{"api key with scope", http.MethodGet, "/links", "ApiKey " + key, http.StatusOK},
+		{"api key lacking scope", http.MethodPost, "/links", "ApiKey " + key, http.StatusForbidden},
+		{"api key on session route", http.MethodGet, "/settings", "ApiKey " + key, http.StatusForbidden},
+		{"session on scoped route", http.MethodPost, "/links", "Bearer " + token, http.StatusOK},
+		{"session on session route", http.MethodGet, "/settings", "Bearer " + token, http.StatusOK},
+		{"api key as bearer token", http.MethodGet, "/links", "Bearer " + key, http.StatusUnauthorized},
+		{"unknown api key", http.MethodGet, "/links", "ApiKey " + key + "x", http.StatusUnauthorized},
+		{"unknown scheme", http.MethodGet, "/links", "Basic " + key, http.StatusUnauthorized},
+		{"missing header", http.MethodGet, "/links", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
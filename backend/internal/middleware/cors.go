@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/cors"
+)
+
+// CORSPolicy is the cross-origin policy for a group of routes
+type CORSPolicy struct {
+	// PathPrefixes are the request paths the policy applies to
+	PathPrefixes []string
+	// Origins are allowed origins. An origin like https://*.example.com allows any of its subdomains but not the domain itself.
+	Origins []string
+	Methods []string
+	Headers []string
+	MaxAge  time.Duration
+}
+
+// CORS applies the first policy with a path prefix matching the request. Routes without a policy and
+// origins outside a policy's allowlist get no CORS headers, so browsers block cross-origin requests to them.
+// Policies are matched on the path rather than applied per route group so preflight requests, which
+// have no route of their own, still get the right policy.
+func CORS(policies ...CORSPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		handlers := make([]http.Handler, len(policies))
+		for i, policy := range policies {
+			origins := policy.Origins
+			handlers[i] = cors.Handler(cors.Options{
+				AllowOriginFunc: func(r *http.Request, origin string) bool {
+					return originAllowed(origins, origin)
+				},
+				AllowedMethods:   policy.Methods,
+				AllowedHeaders:   policy.Headers,
+				ExposedHeaders:   []string{"Link"},
+				AllowCredentials: false, // Set to false since we're using JWT in Authorization header
+				MaxAge:           int(policy.MaxAge.Seconds()),
+			})(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for i, policy := range policies {
+				for _, prefix := range policy.PathPrefixes {
+					if strings.HasPrefix(r.URL.Path, prefix) {
+						handlers[i].ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// originAllowed checks an Origin header against an allowlist of origins and wildcard subdomain patterns
+func originAllowed(allowed []string, origin string) bool {
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Host == "" || u.Path != "" {
+		return false
+	}
+
+	for _, pattern := range allowed {
+		if pattern == u.Scheme+"://"+u.Host {
+			return true
+		}
+
+		// Wildcard patterns must match the scheme and port, and at least one subdomain label
+		domain, ok := strings.CutPrefix(pattern, u.Scheme+"://*.")
+		if !ok {
+			continue
+		}
+		host, port, _ := strings.Cut(u.Host, ":")
+		domain, domainPort, _ := strings.Cut(domain, ":")
+		if port == domainPort && strings.HasSuffix(host, "."+domain) && !strings.HasPrefix(host, ".") {
+			return true
+		}
+	}
+	return false
+}
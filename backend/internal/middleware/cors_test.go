@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"https://app.example.com", "https://*.example.com", "https://*.preview.dev:8443", "http://localhost:3000"}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"https://a.example.com", true},
+		{"https://a.b.example.com", true},
+		{"https://A.Example.COM", true},
+		{"https://a.preview.dev:8443", true},
+		{"http://localhost:3000", true},
+		{"https://example.com", false},
+		{"https://evil.com", false},
+		{"https://a.example.com.evil.com", false},
+		{"https://evilexample.com", false},
+		{"https://.example.com", false},
+		{"http://a.example.com", false},
+		{"http://app.example.com", false},
+		{"https://localhost:3000", false},
+		{"http://localhost:3001", false},
+		{"https://a.example.com:8443", false},
+		{"https://a.preview.dev", false},
+		{"https://a.example.com/path", false},
+		{"null", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			if got := originAllowed(allowed, tt.origin); got != tt.want {
+				t.Errorf("originAllowed(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCORSPolicySelection(t *testing.T) {
+	const frontend, thirdParty = "https://app.example.com", "https://client.example.org"
+	handler := CORS(
+		CORSPolicy{
+			PathPrefixes: []string{"/api/oauth/token"},
+			Origins:      []string{frontend, thirdParty},
+			Methods:      []string{http.MethodPost, http.MethodOptions},
+			Headers:      []string{"Authorization", "Content-Type"},
+		},
+		CORSPolicy{
+			PathPrefixes: []string{"/api/"},
+			Origins:      []string{frontend},
+			Methods:      []string{http.MethodGet, http.MethodPost, http.MethodDelete, http.MethodOptions},
+			Headers:      []string{"Authorization", "Content-Type"},
+		},
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// A preflight is an OPTIONS request for the method in preflight
+	tests := []struct {
+		name       string
+		method     string
+		preflight  string
+		path       string
+		origin     string
+		wantOrigin string
+	}{
+		{"third party on the token endpoint", http.MethodPost, "", "/api/oauth/token", thirdParty, thirdParty},
+		{"third party preflight on the token endpoint", http.MethodOptions, http.MethodPost, "/api/oauth/token", thirdParty, thirdParty},
+		{"third party on the rest of the API", http.MethodGet, "", "/api/links", thirdParty, ""},
+		{"third party preflight on the rest of the API", http.MethodOptions, http.MethodPost, "/api/links", thirdParty, ""},
+		{"frontend on the API", http.MethodGet, "", "/api/links", frontend, frontend},
+		{"frontend preflight on the API", http.MethodOptions, http.MethodDelete, "/api/links", frontend, frontend},
+		{"method outside the token endpoint policy", http.MethodOptions, http.MethodDelete, "/api/oauth/token", frontend, ""},
+		{"route without a policy", http.MethodGet, "", "/health", frontend, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.preflight != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.preflight)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+		})
+	}
+}
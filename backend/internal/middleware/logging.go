@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/logging"
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestLogger adds a request-scoped logger to the context and logs each request once it
+// completes with its route pattern, status, latency, request ID and authenticated user.
+// Sensitive query parameters are redacted and headers are never logged.
+func RequestLogger(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			// Add request-scoped logger to context
+			reqLogger := logger.With("request_id", chimiddleware.GetReqID(r.Context()))
+			if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+				reqLogger = reqLogger.With("trace_id", spanContext.TraceID().String())
+			}
+			ctx := logging.WithRequest(r.Context(), reqLogger)
+
+			// Serve request, recording the response status and size
+			ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			attrs := []any{
+				"method", r.Method,
+				"path", logging.RedactURL(r.URL),
+				"route", chi.RouteContext(ctx).RoutePattern(),
+				"status", status,
+				"bytes", ww.BytesWritten(),
+				"latency", time.Since(start),
+				"remote_ip", r.RemoteAddr,
+			}
+			if userID, ok := logging.UserID(ctx); ok {
+				attrs = append(attrs, "user_id", userID)
+			}
+
+			// Log request
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			reqLogger.Log(ctx, level, "Request completed", attrs...)
+		})
+	}
+}
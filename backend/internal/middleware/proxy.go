@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies resolves the client IP and scheme from X-Forwarded-For, X-Real-IP and X-Forwarded-Proto,
+// but only for requests that come from a trusted proxy, so clients cannot spoof them. RemoteAddr becomes
+// the client IP and r.URL.Scheme is set to https when a trusted proxy terminated TLS.
+func TrustedProxies(proxies []*net.IPNet) func(http.Handler) http.Handler {
+	trusted := func(ip net.IP) bool {
+		for _, network := range proxies {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil {
+				r.URL.Scheme = "https"
+			}
+
+			// Ignore forwarded headers unless the connection comes from a trusted proxy
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			if ip := net.ParseIP(host); ip == nil || !trusted(ip) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// The client is the last address not added by a trusted proxy
+			if clientIP := forwardedClientIP(r, trusted); clientIP != "" {
+				r.RemoteAddr = clientIP
+			}
+
+			// The proxy that connected to us sets the last scheme
+			if forwardedProto := r.Header.Get("X-Forwarded-Proto"); forwardedProto != "" {
+				protos := strings.Split(forwardedProto, ",")
+				if proto := strings.ToLower(strings.TrimSpace(protos[len(protos)-1])); proto == "https" || proto == "http" {
+					r.URL.Scheme = proto
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedClientIP walks X-Forwarded-For from the right, skipping trusted proxies
+func forwardedClientIP(r *http.Request, trusted func(net.IP) bool) string {
+	forwardedFor := r.Header.Values("X-Forwarded-For")
+	if len(forwardedFor) == 0 {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+		return ""
+	}
+
+	addrs := strings.Split(strings.Join(forwardedFor, ","), ",")
+	client := ""
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !trusted(ip) {
+			break
+		}
+	}
+	return client
+}
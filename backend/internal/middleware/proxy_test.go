@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		tls        bool
+		wantAddr   string
+		wantScheme string
+	}{
+		{"direct client", "203.0.113.7:1234", nil, false, "203.0.113.7:1234", ""},
+		{"direct TLS client", "203.0.113.7:1234", nil, true, "203.0.113.7:1234", "https"},
+		{"untrusted client spoofing headers", "203.0.113.7:1234", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "https"}, false, "203.0.113.7:1234", ""},
+		{"trusted proxy", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "https"}, false, "198.51.100.1", "https"},
+		{"chain of trusted proxies", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.2"}, false, "198.51.100.1", ""},
+		{"client spoofing the first address", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.66, 198.51.100.1, 10.0.0.2"}, false, "198.51.100.1", ""},
+		{"only trusted addresses", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, false, "10.0.0.3", ""},
+		{"invalid address stops the walk", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1, unknown, 10.0.0.2"}, false, "10.0.0.2", ""},
+		{"real ip", "10.0.0.1:1234", map[string]string{"X-Real-IP": "198.51.100.1"}, false, "198.51.100.1", ""},
+		{"invalid real ip", "10.0.0.1:1234", map[string]string{"X-Real-IP": "unknown"}, false, "10.0.0.1:1234", ""},
+		{"last proxy sets the scheme", "10.0.0.1:1234", map[string]string{"X-Forwarded-Proto": "http, HTTPS"}, false, "10.0.0.1:1234", "https"},
+		{"unknown scheme", "10.0.0.1:1234", map[string]string{"X-Forwarded-Proto": "gopher"}, false, "10.0.0.1:1234", ""},
+		{"proxy downgrading TLS", "10.0.0.1:1234", map[string]string{"X-Forwarded-Proto": "http"}, true, "10.0.0.1:1234", "http"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotAddr, gotScheme string
+			handler := TrustedProxies([]*net.IPNet{proxies})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAddr, gotScheme = r.RemoteAddr, r.URL.Scheme
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/links", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if gotAddr != tt.wantAddr || gotScheme != tt.wantScheme {
+				t.Errorf("RemoteAddr, scheme = %q, %q, want %q, %q", gotAddr, gotScheme, tt.wantAddr, tt.wantScheme)
+			}
+		})
+	}
+}
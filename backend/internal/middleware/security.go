@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/RanitManik/zyply/internal/config"
+)
+
+// SecurityHeaders sets HSTS, Content-Security-Policy, X-Content-Type-Options, Referrer-Policy and
+// X-Frame-Options on every response. HTML responses, such as redirect bodies, get the HTML policy and
+// everything else the stricter API policy.
+func SecurityHeaders(cfg *config.Config) func(http.Handler) http.Handler {
+	headers := cfg.SecurityHeaders
+	hsts := ""
+	if headers.HSTSMaxAge > 0 {
+		hsts = fmt.Sprintf("max-age=%d", int64(headers.HSTSMaxAge.Seconds()))
+		if headers.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			if hsts != "" {
+				h.Set("Strict-Transport-Security", hsts)
+			}
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("X-Frame-Options", "DENY")
+			if headers.ReferrerPolicy != "" {
+				h.Set("Referrer-Policy", headers.ReferrerPolicy)
+			}
+
+			next.ServeHTTP(&securityHeadersWriter{
+				ResponseWriter: w,
+				apiPolicy:      headers.APIContentSecurityPolicy,
+				htmlPolicy:     headers.HTMLContentSecurityPolicy,
+			}, r)
+		})
+	}
+}
+
+// securityHeadersWriter picks the Content-Security-Policy once the response content type is known
+type securityHeadersWriter struct {
+	http.ResponseWriter
+	apiPolicy   string
+	htmlPolicy  string
+	wroteHeader bool
+}
+
+// WriteHeader sets the Content-Security-Policy for the content type before writing the status
+func (w *securityHeadersWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		policy := w.apiPolicy
+		if strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+			policy = w.htmlPolicy
+		}
+		if policy != "" && w.Header().Get("Content-Security-Policy") == "" {
+			w.Header().Set("Content-Security-Policy", policy)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the body, writing the header first if needed
+func (w *securityHeadersWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends buffered data to the client
+func (w *securityHeadersWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *securityHeadersWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
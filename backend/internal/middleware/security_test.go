@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/config"
+)
+
+// Content-Security-Policy values of the test config
+const (
+	testAPIPolicy  = "default-src 'none'"
+	testHTMLPolicy = "default-src 'self'"
+)
+
+func TestSecurityHeadersContentSecurityPolicy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.SecurityHeaders.APIContentSecurityPolicy = testAPIPolicy
+	cfg.SecurityHeaders.HTMLContentSecurityPolicy = testHTMLPolicy
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"json response", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		}, testAPIPolicy},
+		{"html redirect", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/login", http.StatusFound)
+		}, testHTMLPolicy},
+		{"html with charset", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+		}, testHTMLPolicy},
+		{"body without a content type", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}, testAPIPolicy},
+		{"no body", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}, testAPIPolicy},
+		{"handler sets its own policy", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Security-Policy", "default-src 'self' https://idp.example.com")
+			w.WriteHeader(http.StatusOK)
+		}, "default-src 'self' https://idp.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			SecurityHeaders(cfg)(tt.handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := rec.Header().Get("Content-Security-Policy"); got != tt.want {
+				t.Errorf("Content-Security-Policy = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name              string
+		maxAge            time.Duration
+		includeSubdomains bool
+		referrerPolicy    string
+		wantHSTS          string
+	}{
+		{"hsts", 24 * time.Hour, false, "no-referrer", "max-age=86400"},
+		{"hsts with subdomains", 24 * time.Hour, true, "no-referrer", "max-age=86400; includeSubDomains"},
+		{"no hsts", 0, true, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.SecurityHeaders.HSTSMaxAge = tt.maxAge
+			cfg.SecurityHeaders.HSTSIncludeSubdomains = tt.includeSubdomains
+			cfg.SecurityHeaders.ReferrerPolicy = tt.referrerPolicy
+			rec := httptest.NewRecorder()
+			SecurityHeaders(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			h := rec.Header()
+			if got := h.Get("Strict-Transport-Security"); got != tt.wantHSTS {
+				t.Errorf("Strict-Transport-Security = %q, want %q", got, tt.wantHSTS)
+			}
+			if got := h.Get("Referrer-Policy"); got != tt.referrerPolicy {
+				t.Errorf("Referrer-Policy = %q, want %q", got, tt.referrerPolicy)
+			}
+			if h.Get("X-Content-Type-Options") != "nosniff" || h.Get("X-Frame-Options") != "DENY" {
+				t.Errorf("headers = %v, want nosniff and DENY", h)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/RanitManik/zyply/internal/apierr"
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// WorkspaceHeader is the request header that selects the current workspace
+const WorkspaceHeader = "X-Workspace-ID"
+
+// WorkspaceIDKey is the context key for the current workspace ID
+const WorkspaceIDKey contextKey = "workspaceID"
+
+// WorkspaceRoleKey is the context key for the user's role in the current workspace
+const WorkspaceRoleKey contextKey = "workspaceRole"
+
+// ResolveWorkspace resolves the workspace a request acts within and checks the user belongs to it.
+// API keys are bound to the workspace they were created in; other requests select a workspace
+// with the {workspaceID} route parameter or the X-Workspace-ID header and default to the
+// user's personal workspace.
+func ResolveWorkspace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Get user ID
+		userID, ok := GetUserID(r.Context())
+		if !ok {
+			apierr.Write(w, r, apierr.Unauthorized(apierr.CodeUnauthorized, "Unauthorized"))
+			return
+		}
+
+		// Get requested workspace
+		var workspaceID int64
+		if param := chi.URLParam(r, "workspaceID"); param != "" {
+			id, err := strconv.ParseInt(param, 10, 64)
+			if err != nil {
+				apierr.Write(w, r, apierr.BadRequest("Invalid workspace ID"))
+				return
+			}
+			workspaceID = id
+		} else if header := r.Header.Get(WorkspaceHeader); header != "" {
+			id, err := strconv.ParseInt(header, 10, 64)
+			if err != nil {
+				apierr.Write(w, r, apierr.BadRequest("Invalid "+WorkspaceHeader+" header"))
+				return
+			}
+			workspaceID = id
+		}
+
+		// API keys may only act within their own workspace
+		if boundID, ok := GetWorkspaceID(r.Context()); ok {
+			if workspaceID != 0 && workspaceID != boundID {
+				apierr.Write(w, r, apierr.Forbidden("API key does not belong to this workspace"))
+				return
+			}
+			workspaceID = boundID
+		}
+
+		// Default to personal workspace
+		if workspaceID == 0 {
+			org, err := models.GetPersonalOrganization(r.Context(), userID)
+			if err != nil {
+				apierr.Write(w, r, apierr.Internal("Failed to get workspace", err))
+				return
+			}
+			workspaceID = org.ID
+		}
+
+		// Check membership
+		membership, err := models.GetMembership(r.Context(), workspaceID, userID)
+		if err != nil {
+			apierr.Write(w, r, apierr.Internal("Failed to get workspace", err))
+			return
+		}
+		if membership == nil {
+			apierr.Write(w, r, apierr.Forbidden("Not a member of this workspace"))
+			return
+		}
+
+		// Add workspace and role to context
+		ctx := context.WithValue(r.Context(), WorkspaceIDKey, workspaceID)
+		ctx = context.WithValue(ctx, WorkspaceRoleKey, membership.Role)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetWorkspaceID gets the current workspace ID from the context
+func GetWorkspaceID(ctx context.Context) (int64, bool) {
+	workspaceID, ok := ctx.Value(WorkspaceIDKey).(int64)
+	return workspaceID, ok
+}
+
+// GetWorkspaceRole gets the user's role in the current workspace from the context
+func GetWorkspaceRole(ctx context.Context) string {
+	role, _ := ctx.Value(WorkspaceRoleKey).(string)
+	return role
+}
+
+// RequirePermission rejects requests whose role in the current workspace does not grant the
+// permission. It must run after ResolveWorkspace. API key and OAuth requests must also have
+// been granted the permission as a scope.
+func RequirePermission(permission string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !Can(r.Context(), permission) {
+				apierr.Write(w, r, apierr.Forbidden("Missing required permission "+permission))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Can checks if the request may perform an action within the current workspace
+func Can(ctx context.Context, permission string) bool {
+	if !auth.RoleHasPermission(GetWorkspaceRole(ctx), permission) {
+		return false
+	}
+	return HasScope(ctx, permission)
+}
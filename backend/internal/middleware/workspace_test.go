@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/models"
+)
+
+func TestRequirePermission(t *testing.T) {
+	policy, err := auth.NewPolicy(map[string][]string{
+		models.RoleAdmin:  {auth.PermissionLinksRead, auth.PermissionLinksWrite, auth.PermissionMembersManage},
+		models.RoleViewer: {auth.PermissionLinksRead},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	auth.SetPolicy(policy)
+	t.Cleanup(func() { auth.SetPolicy(nil) })
+
+	tests := []struct {
+		name       string
+		role       string
+		method     string
+		scopes     []string
+		permission string
+		want       int
+	}{
+		{"session with granted permission", models.RoleViewer, AuthMethodSession, nil, auth.PermissionLinksRead, http.StatusOK},
+		{"session without permission", models.RoleViewer, AuthMethodSession, nil, auth.PermissionLinksWrite, http.StatusForbidden},
+		{"no workspace role", "", AuthMethodSession, nil, auth.PermissionLinksRead, http.StatusForbidden},
+		{"api key with role and scope", models.RoleAdmin, AuthMethodAPIKey, []string{auth.ScopeLinksWrite}, auth.PermissionLinksWrite, http.StatusOK},
+		{"api key missing scope", models.RoleAdmin, AuthMethodAPIKey, []string{auth.ScopeLinksRead}, auth.PermissionLinksWrite, http.StatusForbidden},
+		{"api key scope without role", models.RoleViewer, AuthMethodAPIKey, []string{auth.ScopeLinksWrite}, auth.PermissionLinksWrite, http.StatusForbidden},
+		{"oauth token never manages members", models.RoleAdmin, AuthMethodOAuth, []string{auth.ScopeLinksRead}, auth.PermissionMembersManage, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequirePermission(tt.permission)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			ctx := context.WithValue(context.Background(), AuthMethodKey, tt.method)
+			ctx = context.WithValue(ctx, ScopesKey, tt.scopes)
+			if tt.role != "" {
+				ctx = context.WithValue(ctx, WorkspaceRoleKey, tt.role)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/api/links", nil).WithContext(ctx)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if rec.Code == http.StatusForbidden {
+				var body struct {
+					Error struct {
+						Code string `json:"code"`
+					} `json:"error"`
+				}
+				if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error.Code != "forbidden" {
+					t.Errorf("body = %q, want a forbidden error envelope", rec.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/database"
+	"github.com/lib/pq"
+)
+
+// APIKey represents a personal API key that acts within one workspace.
+// Only the prefix and a hash of the secret are stored.
+type APIKey struct {
+	ID             int64      `json:"id"`
+	UserID         int64      `json:"user_id"`
+	OrganizationID int64      `json:"organization_id"`
+	Name           string     `json:"name"`
+	Prefix         string     `json:"prefix"`
+	SecretHash     string     `json:"-"`
+	Scopes         []string   `json:"scopes"`
+	LastUsedAt     *time.Time `json:"last_used_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// apiKeyColumns are the columns selected for an APIKey
+const apiKeyColumns = "id, user_id, organization_id, name, prefix, secret_hash, scopes, last_used_at, expires_at, revoked_at, created_at, updated_at"
+
+// Active checks if the API key is neither revoked nor expired
+func (k *APIKey) Active() bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || k.ExpiresAt.After(time.Now()))
+}
+
+// HasScope checks if the API key was granted a scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKey creates a new API key for a user within an organization
+func CreateAPIKey(ctx context.Context, userID, organizationID int64, name, prefix, secretHash string, scopes []string, expiresAt *time.Time) (*APIKey, error) {
+	return NewPostgresAPIKeyRepository(database.DB).Create(ctx, userID, organizationID, name, prefix, secretHash, scopes, expiresAt)
+}
+
+// GetAPIKeyByPrefix retrieves an API key by its public prefix
+func GetAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	return NewPostgresAPIKeyRepository(database.DB).GetByPrefix(ctx, prefix)
+}
+
+// GetAPIKeys retrieves a user's API keys within an organization
+func GetAPIKeys(ctx context.Context, userID, organizationID int64) ([]*APIKey, error) {
+	rows, err := conn(ctx).QueryContext(ctx,
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = $1 AND organization_id = $2 ORDER BY created_at DESC",
+		userID, organizationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// GetAPIKeysByUserID retrieves all of a user's API keys across organizations
+func GetAPIKeysByUserID(ctx context.Context, userID int64) ([]*APIKey, error) {
+	rows, err := conn(ctx).QueryContext(ctx,
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes a user's API key and returns false if no active key matched
+func RevokeAPIKey(ctx context.Context, id, userID int64) (bool, error) {
+	return NewPostgresAPIKeyRepository(database.DB).Revoke(ctx, id, userID)
+}
+
+// TouchAPIKey records that an API key was used, at most once a minute
+func TouchAPIKey(ctx context.Context, id int64) error {
+	return NewPostgresAPIKeyRepository(database.DB).Touch(ctx, id)
+}
+
+// PostgresAPIKeyRepository is an APIKeyRepository backed by Postgres
+type PostgresAPIKeyRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresAPIKeyRepository creates a new PostgresAPIKeyRepository
+func NewPostgresAPIKeyRepository(db *sql.DB) *PostgresAPIKeyRepository {
+	return &PostgresAPIKeyRepository{DB: db}
+}
+
+// Create creates a new API key for a user within an organization
+func (r *PostgresAPIKeyRepository) Create(ctx context.Context, userID, organizationID int64, name, prefix, secretHash string, scopes []string, expiresAt *time.Time) (*APIKey, error) {
+	return scanAPIKey(database.Conn(ctx, r.DB).QueryRowContext(ctx,
+		"INSERT INTO api_keys (user_id, organization_id, name, prefix, secret_hash, scopes, expires_at, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW()) RETURNING "+apiKeyColumns,
+		userID, organizationID, name, prefix, secretHash, pq.Array(scopes), expiresAt,
+	))
+}
+
+// GetByPrefix retrieves an API key by its public prefix
+func (r *PostgresAPIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	key, err := scanAPIKey(database.Conn(ctx, r.DB).QueryRowContext(ctx,
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE prefix = $1",
+		prefix,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil // No key found, but not an error
+	}
+	return key, err
+}
+
+// Revoke revokes a user's API key and returns false if no active key matched
+func (r *PostgresAPIKeyRepository) Revoke(ctx context.Context, id, userID int64) (bool, error) {
+	result, err := database.Conn(ctx, r.DB).ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = NOW(), updated_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		id, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// Touch records that an API key was used, at most once a minute
+func (r *PostgresAPIKeyRepository) Touch(ctx context.Context, id int64) error {
+	_, err := database.Conn(ctx, r.DB).ExecContext(ctx,
+		"UPDATE api_keys SET last_used_at = NOW() WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')",
+		id,
+	)
+	return err
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAPIKey scans an APIKey selected with apiKeyColumns
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	var lastUsedAt, expiresAt, revokedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.UserID, &key.OrganizationID, &key.Name, &key.Prefix, &key.SecretHash, pq.Array(&key.Scopes), &lastUsedAt, &expiresAt, &revokedAt, &key.CreatedAt, &key.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	key.LastUsedAt = nullTimePtr(lastUsedAt)
+	key.ExpiresAt = nullTimePtr(expiresAt)
+	key.RevokedAt = nullTimePtr(revokedAt)
+
+	return &key, nil
+}
+
+// nullTimePtr converts a sql.NullTime into a *time.Time
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
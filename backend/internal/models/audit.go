@@ -0,0 +1,155 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/database"
+)
+
+// AuditEvent represents a recorded security-relevant or resource-changing action
+type AuditEvent struct {
+	ID             int64           `json:"id"`
+	OrganizationID *int64          `json:"organization_id"`
+	ActorID        *int64          `json:"actor_id"`
+	Action         string          `json:"action"`
+	TargetType     string          `json:"target_type"`
+	TargetID       string          `json:"target_id"`
+	IPAddress      string          `json:"ip_address"`
+	UserAgent      string          `json:"user_agent"`
+	RequestID      string          `json:"request_id"`
+	Changes        json.RawMessage `json:"changes,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// AuditFilter selects audit events. Zero values do not filter.
+type AuditFilter struct {
+	OrganizationID int64
+	// PersonalActorID also includes events without a workspace, such as logins, by this actor
+	PersonalActorID int64
+	ActorID         int64
+	Action          string
+	TargetType      string
+	TargetID        string
+	Since           time.Time
+	Until           time.Time
+	// BeforeID returns events older than this event, for pagination
+	BeforeID int64
+	// Limit caps the number of events returned. Zero returns every matching event.
+	Limit int
+}
+
+// PostgresAuditEventRepository is an AuditEventRepository backed by Postgres
+type PostgresAuditEventRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresAuditEventRepository creates a new PostgresAuditEventRepository
+func NewPostgresAuditEventRepository(db *sql.DB) *PostgresAuditEventRepository {
+	return &PostgresAuditEventRepository{DB: db}
+}
+
+// Create appends an audit event
+func (r *PostgresAuditEventRepository) Create(ctx context.Context, event *AuditEvent) error {
+	var changes interface{}
+	if len(event.Changes) > 0 {
+		changes = []byte(event.Changes)
+	}
+
+	return database.Conn(ctx, r.DB).QueryRowContext(ctx,
+		`INSERT INTO audit_events (organization_id, actor_id, action, target_type, target_id, ip_address, user_agent, request_id, changes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW()) RETURNING id, created_at`,
+		event.OrganizationID, event.ActorID, event.Action, event.TargetType, event.TargetID, event.IPAddress, event.UserAgent, event.RequestID, changes,
+	).Scan(&event.ID, &event.CreatedAt)
+}
+
+// ListAuditEvents retrieves audit events matching a filter, newest first
+func ListAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.PersonalActorID != 0 {
+		args = append(args, filter.OrganizationID, filter.PersonalActorID)
+		conditions = append(conditions, fmt.Sprintf("(organization_id = $%d OR (organization_id IS NULL AND actor_id = $%d))", len(args)-1, len(args)))
+	} else if filter.OrganizationID != 0 {
+		addCondition("organization_id = $%d", filter.OrganizationID)
+	}
+	if filter.ActorID != 0 {
+		addCondition("actor_id = $%d", filter.ActorID)
+	}
+	if filter.Action != "" {
+		addCondition("action = $%d", filter.Action)
+	}
+	if filter.TargetType != "" {
+		addCondition("target_type = $%d", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		addCondition("target_id = $%d", filter.TargetID)
+	}
+	if !filter.Since.IsZero() {
+		addCondition("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		addCondition("created_at < $%d", filter.Until)
+	}
+	if filter.BeforeID != 0 {
+		addCondition("id < $%d", filter.BeforeID)
+	}
+
+	query := "SELECT id, organization_id, actor_id, action, target_type, target_id, ip_address, user_agent, request_id, changes, created_at FROM audit_events"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := conn(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var organizationID, actorID sql.NullInt64
+		var changes []byte
+		if err := rows.Scan(&event.ID, &organizationID, &actorID, &event.Action, &event.TargetType, &event.TargetID, &event.IPAddress, &event.UserAgent, &event.RequestID, &changes, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		event.OrganizationID = nullInt64Ptr(organizationID)
+		event.ActorID = nullInt64Ptr(actorID)
+		event.Changes = changes
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
+
+// PruneAuditEvents deletes audit events created before a time and returns how many were deleted
+func PruneAuditEvents(ctx context.Context, before time.Time) (int64, error) {
+	result, err := conn(ctx).ExecContext(ctx, "DELETE FROM audit_events WHERE created_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// nullInt64Ptr converts a sql.NullInt64 into an *int64
+func nullInt64Ptr(n sql.NullInt64) *int64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Int64
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"context"
+
+	"github.com/RanitManik/zyply/internal/database"
+)
+
+// conn returns the connection model queries in ctx run on
+func conn(ctx context.Context) database.Querier {
+	return database.Conn(ctx, database.DB)
+}
+
+// withTx runs fn in a transaction on the global database, joining one already in ctx
+func withTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return database.WithTx(ctx, database.DB, fn)
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// Error kinds that callers can match with errors.Is
+var (
+	// ErrNotFound is the kind of errors returned when a record does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is the kind of errors returned when a record conflicts with an existing one
+	ErrConflict = errors.New("conflict")
+)
+
+// Error is a model error of a given kind with a message safe to show to users
+type Error struct {
+	Kind    error
+	Message string
+}
+
+// Error returns the error message
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the error kind, so errors.Is matches both the error and its kind
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+var (
+	// ErrUserNotFound is returned when no user matches a lookup
+	ErrUserNotFound = &Error{Kind: ErrNotFound, Message: "user not found"}
+	// ErrEmailTaken is returned when an email address already belongs to another user
+	ErrEmailTaken = &Error{Kind: ErrConflict, Message: "user with this email already exists"}
+	// ErrSlugTaken is returned when a workspace slug is already in use
+	ErrSlugTaken = &Error{Kind: ErrConflict, Message: "workspace with this slug already exists"}
+	// ErrOAuthAccountLinked is returned when an OAuth account is already linked to a user
+	ErrOAuthAccountLinked = &Error{Kind: ErrConflict, Message: "OAuth account is already linked"}
+)
+
+// uniqueViolation is the Postgres error code for unique constraint violations
+const uniqueViolation = "23505"
+
+// asConflict returns conflict if err is a unique constraint violation, and err otherwise
+func asConflict(err error, conflict *Error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return conflict
+	}
+	return err
+}
@@ -0,0 +1,682 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// memoryStore holds the users and OAuth accounts shared by the in-memory repositories
+type memoryStore struct {
+	mu            sync.Mutex
+	nextUserID    int64
+	nextAccountID int64
+	users         map[int64]*User
+	accounts      []*OAuthAccount
+}
+
+// MemoryUserRepository is a UserRepository that keeps users in memory, for tests and local tools
+type MemoryUserRepository struct {
+	store *memoryStore
+}
+
+// MemoryOAuthAccountRepository is an OAuthAccountRepository that keeps OAuth accounts in memory
+type MemoryOAuthAccountRepository struct {
+	store *memoryStore
+}
+
+// NewMemoryRepositories creates in-memory user and OAuth account repositories sharing one store
+func NewMemoryRepositories() (*MemoryUserRepository, *MemoryOAuthAccountRepository) {
+	store := &memoryStore{users: map[int64]*User{}}
+	return &MemoryUserRepository{store: store}, &MemoryOAuthAccountRepository{store: store}
+}
+
+// Create creates a new user
+func (r *MemoryUserRepository) Create(ctx context.Context, name, email, password string) (*User, error) {
+	// Hash password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return nil, err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	return r.store.createUser(name, email, string(hashedPassword))
+}
+
+// CreateWithOAuthAccount creates a new user and their OAuth account
+func (r *MemoryUserRepository) CreateWithOAuthAccount(ctx context.Context, name, email, password string, provider OAuthProvider, providerID, providerData string) (*User, error) {
+	// Hash password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return nil, err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	// Check the account first so nothing is stored on conflict
+	if r.store.account(provider, providerID) != nil {
+		return nil, ErrOAuthAccountLinked
+	}
+	user, err := r.store.createUser(name, email, string(hashedPassword))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.store.createAccount(user.ID, provider, providerID, providerData); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetByEmail retrieves a user by email
+func (r *MemoryUserRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, user := range r.store.users {
+		if user.Email == email {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// GetByID retrieves a user by ID
+func (r *MemoryUserRepository) GetByID(ctx context.Context, id int64) (*User, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	return r.store.user(id)
+}
+
+// GetByOAuthAccount retrieves a user by OAuth account
+func (r *MemoryUserRepository) GetByOAuthAccount(ctx context.Context, provider OAuthProvider, providerID string) (*User, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, account := range r.store.accounts {
+		if account.Provider == provider && account.ProviderID == providerID {
+			return r.store.user(account.UserID)
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// UpdatePassword hashes and stores a new password for a user
+func (r *MemoryUserRepository) UpdatePassword(ctx context.Context, id int64, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user, ok := r.store.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.Password = string(hashedPassword)
+	user.UpdatedAt = time.Now()
+	return nil
+}
+
+// Create creates a new OAuth account for a user
+func (r *MemoryOAuthAccountRepository) Create(ctx context.Context, userID int64, provider OAuthProvider, providerID, providerData string) (*OAuthAccount, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	return r.store.createAccount(userID, provider, providerID, providerData)
+}
+
+// Get retrieves an OAuth account by provider and provider ID
+func (r *MemoryOAuthAccountRepository) Get(ctx context.Context, provider OAuthProvider, providerID string) (*OAuthAccount, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, account := range r.store.accounts {
+		if account.Provider == provider && account.ProviderID == providerID {
+			copied := *account
+			return &copied, nil
+		}
+	}
+	return nil, nil // No account found, but not an error
+}
+
+// user returns a copy of a stored user. The caller must hold the lock.
+func (s *memoryStore) user(id int64) (*User, error) {
+	user, ok := s.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+// createUser stores a new user. The caller must hold the lock.
+func (s *memoryStore) createUser(name, email, hashedPassword string) (*User, error) {
+	// Check if user already exists
+	for _, user := range s.users {
+		if user.Email == email {
+			return nil, ErrEmailTaken
+		}
+	}
+
+	// Insert user
+	s.nextUserID++
+	now := time.Now()
+	user := &User{
+		ID:        s.nextUserID,
+		Name:      name,
+		Email:     email,
+		Password:  hashedPassword,
+		Timezone:  "UTC",
+		Locale:    "en",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.users[user.ID] = user
+
+	copied := *user
+	return &copied, nil
+}
+
+// createAccount stores a new OAuth account, enforcing the same constraints as the
+// oauth_accounts table. The caller must hold the lock.
+func (s *memoryStore) createAccount(userID int64, provider OAuthProvider, providerID, providerData string) (*OAuthAccount, error) {
+	if _, ok := s.users[userID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	if s.account(provider, providerID) != nil {
+		return nil, ErrOAuthAccountLinked
+	}
+
+	// Insert account
+	s.nextAccountID++
+	now := time.Now()
+	account := &OAuthAccount{
+		ID:           s.nextAccountID,
+		UserID:       userID,
+		Provider:     provider,
+		ProviderID:   providerID,
+		ProviderData: providerData,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	s.accounts = append(s.accounts, account)
+
+	copied := *account
+	return &copied, nil
+}
+
+// account returns the stored OAuth account for a provider ID, or nil. The caller must hold the lock.
+func (s *memoryStore) account(provider OAuthProvider, providerID string) *OAuthAccount {
+	for _, account := range s.accounts {
+		if account.Provider == provider && account.ProviderID == providerID {
+			return account
+		}
+	}
+	return nil
+}
+
+// MemoryMFARepository is an MFARepository that keeps TOTP credentials and recovery codes in memory
+type MemoryMFARepository struct {
+	mu            sync.Mutex
+	credentials   map[int64]*TOTPCredential
+	recoveryCodes map[int64]map[string]bool // code hash to whether it was used
+}
+
+// NewMemoryMFARepository creates an empty MemoryMFARepository
+func NewMemoryMFARepository() *MemoryMFARepository {
+	return &MemoryMFARepository{
+		credentials:   map[int64]*TOTPCredential{},
+		recoveryCodes: map[int64]map[string]bool{},
+	}
+}
+
+// SavePendingTOTP stores a new unconfirmed TOTP secret for a user, replacing any unconfirmed one
+func (r *MemoryMFARepository) SavePendingTOTP(ctx context.Context, userID int64, secret string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	cred, ok := r.credentials[userID]
+	if !ok {
+		r.credentials[userID] = &TOTPCredential{UserID: userID, Secret: secret, CreatedAt: now, UpdatedAt: now}
+		return nil
+	}
+	if !cred.Enabled() {
+		*cred = TOTPCredential{UserID: userID, Secret: secret, CreatedAt: cred.CreatedAt, UpdatedAt: now}
+	}
+	return nil
+}
+
+// GetTOTPCredential retrieves a user's TOTP credential
+func (r *MemoryMFARepository) GetTOTPCredential(ctx context.Context, userID int64) (*TOTPCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cred, ok := r.credentials[userID]
+	if !ok {
+		return nil, nil // No credential found, but not an error
+	}
+	copied := *cred
+	return &copied, nil
+}
+
+// IsMFAEnabled checks if a user has confirmed two-factor authentication
+func (r *MemoryMFARepository) IsMFAEnabled(ctx context.Context, userID int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cred, ok := r.credentials[userID]
+	return ok && cred.Enabled(), nil
+}
+
+// UseTOTPStep records a successful TOTP verification and confirms the credential
+func (r *MemoryMFARepository) UseTOTPStep(ctx context.Context, userID, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cred, ok := r.credentials[userID]
+	if !ok || cred.LastUsedStep >= step {
+		return false, nil
+	}
+	now := time.Now()
+	cred.LastUsedStep = step
+	if !cred.Enabled() {
+		cred.ConfirmedAt = sql.NullTime{Time: now, Valid: true}
+	}
+	cred.FailedAttempts = 0
+	cred.LockedUntil = sql.NullTime{}
+	cred.UpdatedAt = now
+	return true, nil
+}
+
+// RecordTOTPFailure records a failed TOTP verification and locks verification after too many failures
+func (r *MemoryMFARepository) RecordTOTPFailure(ctx context.Context, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cred, ok := r.credentials[userID]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	cred.FailedAttempts++
+	if cred.FailedAttempts >= MaxTOTPFailures {
+		cred.FailedAttempts = 0
+		cred.LockedUntil = sql.NullTime{Time: now.Add(TOTPLockout), Valid: true}
+	}
+	cred.UpdatedAt = now
+	return nil
+}
+
+// DeleteTOTP removes a user's TOTP credential and recovery codes
+func (r *MemoryMFARepository) DeleteTOTP(ctx context.Context, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.credentials, userID)
+	delete(r.recoveryCodes, userID)
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces all of a user's recovery codes with the given hashes
+func (r *MemoryMFARepository) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	codes := make(map[string]bool, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes[hash] = false
+	}
+	r.recoveryCodes[userID] = codes
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used and returns false if none matched
+func (r *MemoryMFARepository) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	used, ok := r.recoveryCodes[userID][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	r.recoveryCodes[userID][codeHash] = true
+	return true, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes a user has left
+func (r *MemoryMFARepository) CountRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, used := range r.recoveryCodes[userID] {
+		if !used {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// MemoryAPIKeyRepository is an APIKeyRepository that keeps API keys in memory
+type MemoryAPIKeyRepository struct {
+	mu     sync.Mutex
+	nextID int64
+	keys   []*APIKey
+}
+
+// NewMemoryAPIKeyRepository creates an empty MemoryAPIKeyRepository
+func NewMemoryAPIKeyRepository() *MemoryAPIKeyRepository {
+	return &MemoryAPIKeyRepository{}
+}
+
+// Create creates a new API key for a user within an organization
+func (r *MemoryAPIKeyRepository) Create(ctx context.Context, userID, organizationID int64, name, prefix, secretHash string, scopes []string, expiresAt *time.Time) (*APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	now := time.Now()
+	key := &APIKey{
+		ID:             r.nextID,
+		UserID:         userID,
+		OrganizationID: organizationID,
+		Name:           name,
+		Prefix:         prefix,
+		SecretHash:     secretHash,
+		Scopes:         scopes,
+		ExpiresAt:      expiresAt,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	r.keys = append(r.keys, key)
+	copied := *key
+	return &copied, nil
+}
+
+// GetByPrefix retrieves an API key by its public prefix
+func (r *MemoryAPIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, key := range r.keys {
+		if key.Prefix == prefix {
+			copied := *key
+			return &copied, nil
+		}
+	}
+	return nil, nil // No key found, but not an error
+}
+
+// Revoke revokes a user's API key and returns false if no active key matched
+func (r *MemoryAPIKeyRepository) Revoke(ctx context.Context, id, userID int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, key := range r.keys {
+		if key.ID == id && key.UserID == userID && key.RevokedAt == nil {
+			now := time.Now()
+			key.RevokedAt = &now
+			key.UpdatedAt = now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Touch records that an API key was used, at most once a minute
+func (r *MemoryAPIKeyRepository) Touch(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range r.keys {
+		if key.ID == id && (key.LastUsedAt == nil || key.LastUsedAt.Before(now.Add(-time.Minute))) {
+			key.LastUsedAt = &now
+		}
+	}
+	return nil
+}
+
+// MemoryOAuthServerRepository is an OAuthServerRepository that keeps clients, codes and tokens in memory
+type MemoryOAuthServerRepository struct {
+	mu           sync.Mutex
+	nextClientID int64
+	nextTokenID  int64
+	clients      []*OAuthClient
+	codes        map[string]*OAuthAuthorizationCode
+	tokens       map[string]*OAuthToken
+}
+
+// NewMemoryOAuthServerRepository creates an empty MemoryOAuthServerRepository
+func NewMemoryOAuthServerRepository() *MemoryOAuthServerRepository {
+	return &MemoryOAuthServerRepository{
+		codes:  map[string]*OAuthAuthorizationCode{},
+		tokens: map[string]*OAuthToken{},
+	}
+}
+
+// CreateClient registers a new OAuth client for an organization
+func (r *MemoryOAuthServerRepository) CreateClient(ctx context.Context, organizationID, ownerID int64, clientID, clientSecretHash, name string, redirectURIs, scopes []string) (*OAuthClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextClientID++
+	now := time.Now()
+	client := &OAuthClient{
+		ID:               r.nextClientID,
+		ClientID:         clientID,
+		ClientSecretHash: clientSecretHash,
+		Name:             name,
+		RedirectURIs:     redirectURIs,
+		Scopes:           scopes,
+		OrganizationID:   organizationID,
+		OwnerID:          ownerID,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	r.clients = append(r.clients, client)
+	copied := *client
+	return &copied, nil
+}
+
+// GetClient retrieves an OAuth client by client ID
+func (r *MemoryOAuthServerRepository) GetClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, client := range r.clients {
+		if client.ClientID == clientID {
+			copied := *client
+			return &copied, nil
+		}
+	}
+	return nil, nil // No client found, but not an error
+}
+
+// ListClients retrieves the OAuth clients registered by an organization, newest first
+func (r *MemoryOAuthServerRepository) ListClients(ctx context.Context, organizationID int64) ([]*OAuthClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	clients := []*OAuthClient{}
+	for i := len(r.clients) - 1; i >= 0; i-- {
+		if r.clients[i].OrganizationID == organizationID {
+			copied := *r.clients[i]
+			clients = append(clients, &copied)
+		}
+	}
+	return clients, nil
+}
+
+// DeleteClient deletes an organization's OAuth client along with its codes and tokens
+func (r *MemoryOAuthServerRepository) DeleteClient(ctx context.Context, clientID string, organizationID int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, client := range r.clients {
+		if client.ClientID == clientID && client.OrganizationID == organizationID {
+			r.clients = append(r.clients[:i], r.clients[i+1:]...)
+			for hash, code := range r.codes {
+				if code.ClientID == clientID {
+					delete(r.codes, hash)
+				}
+			}
+			for hash, token := range r.tokens {
+				if token.ClientID == clientID {
+					delete(r.tokens, hash)
+				}
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CreateAuthorizationCode stores a new authorization code
+func (r *MemoryOAuthServerRepository) CreateAuthorizationCode(ctx context.Context, code *OAuthAuthorizationCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copied := *code
+	r.codes[code.CodeHash] = &copied
+	return nil
+}
+
+// ConsumeAuthorizationCode deletes and returns an unexpired authorization code so it can only be used once
+func (r *MemoryOAuthServerRepository) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*OAuthAuthorizationCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.codes[codeHash]
+	if !ok || !code.ExpiresAt.After(time.Now()) {
+		return nil, nil // No code found, but not an error
+	}
+	delete(r.codes, codeHash)
+	return code, nil
+}
+
+// CreateTokens stores new access and refresh tokens
+func (r *MemoryOAuthServerRepository) CreateTokens(ctx context.Context, tokens ...*OAuthToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.createTokens(tokens)
+	return nil
+}
+
+// GetToken retrieves a token by its hash
+func (r *MemoryOAuthServerRepository) GetToken(ctx context.Context, tokenHash string) (*OAuthToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[tokenHash]
+	if !ok {
+		return nil, nil // No token found, but not an error
+	}
+	copied := *token
+	return &copied, nil
+}
+
+// RevokeToken revokes a client's token
+func (r *MemoryOAuthServerRepository) RevokeToken(ctx context.Context, tokenHash, clientID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if token, ok := r.tokens[tokenHash]; ok && token.ClientID == clientID && token.RevokedAt == nil {
+		now := time.Now()
+		token.RevokedAt = &now
+	}
+	return nil
+}
+
+// RotateRefreshToken revokes an active refresh token of a client and stores the tokens replacing it.
+// It returns nil without storing anything if the token is unknown, expired or already revoked.
+func (r *MemoryOAuthServerRepository) RotateRefreshToken(ctx context.Context, tokenHash, clientID string, tokens ...*OAuthToken) (*OAuthToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[tokenHash]
+	if !ok || token.ClientID != clientID || token.TokenType != OAuthTokenRefresh || !token.Active() {
+		return nil, nil // No active token found, but not an error
+	}
+	now := time.Now()
+	token.RevokedAt = &now
+	r.createTokens(tokens)
+	copied := *token
+	return &copied, nil
+}
+
+// RevokeGrant revokes every token a client holds for a user
+func (r *MemoryOAuthServerRepository) RevokeGrant(ctx context.Context, clientID string, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, token := range r.tokens {
+		if token.ClientID == clientID && token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// createTokens stores copies of tokens. The caller must hold the lock.
+func (r *MemoryOAuthServerRepository) createTokens(tokens []*OAuthToken) {
+	for _, token := range tokens {
+		r.nextTokenID++
+		copied := *token
+		copied.ID = r.nextTokenID
+		copied.CreatedAt = time.Now()
+		r.tokens[token.TokenHash] = &copied
+	}
+}
+
+// MemoryAuditEventRepository is an AuditEventRepository that keeps audit events in memory
+type MemoryAuditEventRepository struct {
+	mu     sync.Mutex
+	nextID int64
+	events []*AuditEvent
+}
+
+// NewMemoryAuditEventRepository creates an empty MemoryAuditEventRepository
+func NewMemoryAuditEventRepository() *MemoryAuditEventRepository {
+	return &MemoryAuditEventRepository{}
+}
+
+// Create appends an audit event
+func (r *MemoryAuditEventRepository) Create(ctx context.Context, event *AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	event.ID = r.nextID
+	event.CreatedAt = time.Now()
+	copied := *event
+	r.events = append(r.events, &copied)
+	return nil
+}
+
+// Events returns the recorded audit events, oldest first
+func (r *MemoryAuditEventRepository) Events() []*AuditEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := make([]*AuditEvent, len(r.events))
+	for i, event := range r.events {
+		copied := *event
+		events[i] = &copied
+	}
+	return events
+}
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/database"
+)
+
+// MaxTOTPFailures is the number of failed TOTP attempts after which verification is locked
+const MaxTOTPFailures = 5
+
+// TOTPLockout is how long TOTP verification stays locked after too many failures
+const TOTPLockout = 15 * time.Minute
+
+// TOTPCredential represents a user's TOTP authenticator
+type TOTPCredential struct {
+	UserID         int64        `json:"user_id"`
+	Secret         string       `json:"-"`
+	ConfirmedAt    sql.NullTime `json:"-"`
+	LastUsedStep   int64        `json:"-"`
+	FailedAttempts int          `json:"-"`
+	LockedUntil    sql.NullTime `json:"-"`
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+// Enabled checks if the TOTP credential has been confirmed
+func (c *TOTPCredential) Enabled() bool {
+	return c.ConfirmedAt.Valid
+}
+
+// Locked checks if TOTP verification is locked after too many failures
+func (c *TOTPCredential) Locked() bool {
+	return c.LockedUntil.Valid && c.LockedUntil.Time.After(time.Now())
+}
+
+// PostgresMFARepository is an MFARepository backed by Postgres
+type PostgresMFARepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresMFARepository creates a new PostgresMFARepository
+func NewPostgresMFARepository(db *sql.DB) *PostgresMFARepository {
+	return &PostgresMFARepository{DB: db}
+}
+
+// SavePendingTOTP stores a new unconfirmed TOTP secret for a user, replacing any unconfirmed one
+func (r *PostgresMFARepository) SavePendingTOTP(ctx context.Context, userID int64, secret string) error {
+	_, err := database.Conn(ctx, r.DB).ExecContext(ctx,
+		`INSERT INTO totp_credentials (user_id, secret, created_at, updated_at) VALUES ($1, $2, NOW(), NOW())
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0, failed_attempts = 0, locked_until = NULL, updated_at = NOW()
+		WHERE totp_credentials.confirmed_at IS NULL`,
+		userID, secret,
+	)
+	return err
+}
+
+// GetTOTPCredential retrieves a user's TOTP credential
+func (r *PostgresMFARepository) GetTOTPCredential(ctx context.Context, userID int64) (*TOTPCredential, error) {
+	var cred TOTPCredential
+	err := database.Conn(ctx, r.DB).QueryRowContext(ctx,
+		"SELECT user_id, secret, confirmed_at, last_used_step, failed_attempts, locked_until, created_at, updated_at FROM totp_credentials WHERE user_id = $1",
+		userID,
+	).Scan(&cred.UserID, &cred.Secret, &cred.ConfirmedAt, &cred.LastUsedStep, &cred.FailedAttempts, &cred.LockedUntil, &cred.CreatedAt, &cred.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No credential found, but not an error
+		}
+		return nil, err
+	}
+
+	return &cred, nil
+}
+
+// IsMFAEnabled checks if a user has confirmed two-factor authentication
+func (r *PostgresMFARepository) IsMFAEnabled(ctx context.Context, userID int64) (bool, error) {
+	var enabled bool
+	err := database.Conn(ctx, r.DB).QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM totp_credentials WHERE user_id = $1 AND confirmed_at IS NOT NULL)",
+		userID,
+	).Scan(&enabled)
+	return enabled, err
+}
+
+// UseTOTPStep records a successful TOTP verification and confirms the credential.
+// It returns false if the step was already used, which prevents code replay.
+func (r *PostgresMFARepository) UseTOTPStep(ctx context.Context, userID, step int64) (bool, error) {
+	result, err := database.Conn(ctx, r.DB).ExecContext(ctx,
+		`UPDATE totp_credentials
+		SET last_used_step = $2, confirmed_at = COALESCE(confirmed_at, NOW()), failed_attempts = 0, locked_until = NULL, updated_at = NOW()
+		WHERE user_id = $1 AND last_used_step < $2`,
+		userID, step,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// RecordTOTPFailure records a failed TOTP verification and locks verification after too many failures
+func (r *PostgresMFARepository) RecordTOTPFailure(ctx context.Context, userID int64) error {
+	_, err := database.Conn(ctx, r.DB).ExecContext(ctx,
+		`UPDATE totp_credentials
+		SET failed_attempts = CASE WHEN failed_attempts + 1 >= $2 THEN 0 ELSE failed_attempts + 1 END,
+			locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN NOW() + $3 * INTERVAL '1 second' ELSE locked_until END,
+			updated_at = NOW()
+		WHERE user_id = $1`,
+		userID, MaxTOTPFailures, int(TOTPLockout.Seconds()),
+	)
+	return err
+}
+
+// DeleteTOTP removes a user's TOTP credential and recovery codes
+func (r *PostgresMFARepository) DeleteTOTP(ctx context.Context, userID int64) error {
+	return database.WithTx(ctx, r.DB, func(ctx context.Context) error {
+		if _, err := database.Conn(ctx, r.DB).ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		_, err := database.Conn(ctx, r.DB).ExecContext(ctx, "DELETE FROM totp_credentials WHERE user_id = $1", userID)
+		return err
+	})
+}
+
+// ReplaceRecoveryCodes replaces all of a user's recovery codes with the given hashes
+func (r *PostgresMFARepository) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error {
+	return database.WithTx(ctx, r.DB, func(ctx context.Context) error {
+		if _, err := database.Conn(ctx, r.DB).ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		for _, hash := range codeHashes {
+			if _, err := database.Conn(ctx, r.DB).ExecContext(ctx,
+				"INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, NOW())",
+				userID, hash,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UseRecoveryCode marks an unused recovery code as used and returns false if none matched
+func (r *PostgresMFARepository) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error) {
+	result, err := database.Conn(ctx, r.DB).ExecContext(ctx,
+		"UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL",
+		userID, codeHash,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes a user has left
+func (r *PostgresMFARepository) CountRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	var count int
+	err := database.Conn(ctx, r.DB).QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL",
+		userID,
+	).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,303 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/database"
+	"github.com/lib/pq"
+)
+
+// OAuth token types issued by the authorization server
+const (
+	// OAuthTokenAccess is an access token
+	OAuthTokenAccess = "access"
+	// OAuthTokenRefresh is a refresh token
+	OAuthTokenRefresh = "refresh"
+)
+
+// OAuthClient represents a third-party application registered with the authorization server by a workspace
+type OAuthClient struct {
+	ID               int64     `json:"id"`
+	ClientID         string    `json:"client_id"`
+	ClientSecretHash string    `json:"-"`
+	Name             string    `json:"name"`
+	RedirectURIs     []string  `json:"redirect_uris"`
+	Scopes           []string  `json:"scopes"`
+	OrganizationID   int64     `json:"organization_id"`
+	OwnerID          int64     `json:"owner_id"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// OAuthAuthorizationCode represents a pending authorization code grant
+type OAuthAuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	UserID        int64
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+// OAuthToken represents an access or refresh token issued to a client
+type OAuthToken struct {
+	ID        int64
+	TokenHash string
+	TokenType string
+	ClientID  string
+	UserID    int64
+	Scopes    []string
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// oauthClientColumns are the columns selected for an OAuthClient
+const oauthClientColumns = "id, client_id, COALESCE(client_secret_hash, ''), name, redirect_uris, scopes, organization_id, COALESCE(owner_id, 0), created_at, updated_at"
+
+// oauthTokenColumns are the columns selected for an OAuthToken
+const oauthTokenColumns = "id, token_hash, token_type, client_id, user_id, scopes, expires_at, revoked_at, created_at"
+
+// Confidential checks if the client authenticates with a client secret
+func (c *OAuthClient) Confidential() bool {
+	return c.ClientSecretHash != ""
+}
+
+// AllowsRedirectURI checks if a redirect URI exactly matches one of the client's registered URIs
+func (c *OAuthClient) AllowsRedirectURI(redirectURI string) bool {
+	for _, uri := range c.RedirectURIs {
+		if uri == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsScope checks if the client may request a scope
+func (c *OAuthClient) AllowsScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Active checks if the token is neither revoked nor expired
+func (t *OAuthToken) Active() bool {
+	return t.RevokedAt == nil && t.ExpiresAt.After(time.Now())
+}
+
+// GetOAuthClientsByOwner retrieves the OAuth clients a user registered in any organization
+func GetOAuthClientsByOwner(ctx context.Context, ownerID int64) ([]*OAuthClient, error) {
+	return queryOAuthClients(ctx, database.DB,
+		"SELECT "+oauthClientColumns+" FROM oauth_clients WHERE owner_id = $1 ORDER BY created_at DESC",
+		ownerID,
+	)
+}
+
+// PostgresOAuthServerRepository is an OAuthServerRepository backed by Postgres
+type PostgresOAuthServerRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresOAuthServerRepository creates a new PostgresOAuthServerRepository
+func NewPostgresOAuthServerRepository(db *sql.DB) *PostgresOAuthServerRepository {
+	return &PostgresOAuthServerRepository{DB: db}
+}
+
+// CreateClient registers a new OAuth client for an organization. An empty secret hash registers a public client.
+func (r *PostgresOAuthServerRepository) CreateClient(ctx context.Context, organizationID, ownerID int64, clientID, clientSecretHash, name string, redirectURIs, scopes []string) (*OAuthClient, error) {
+	return scanOAuthClient(database.Conn(ctx, r.DB).QueryRowContext(ctx,
+		`INSERT INTO oauth_clients (client_id, client_secret_hash, name, redirect_uris, scopes, organization_id, owner_id, created_at, updated_at)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, NOW(), NOW())
+		RETURNING `+oauthClientColumns,
+		clientID, clientSecretHash, name, pq.Array(redirectURIs), pq.Array(scopes), organizationID, ownerID,
+	))
+}
+
+// GetClient retrieves an OAuth client by client ID
+func (r *PostgresOAuthServerRepository) GetClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	client, err := scanOAuthClient(database.Conn(ctx, r.DB).QueryRowContext(ctx,
+		"SELECT "+oauthClientColumns+" FROM oauth_clients WHERE client_id = $1",
+		clientID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil // No client found, but not an error
+	}
+	return client, err
+}
+
+// ListClients retrieves the OAuth clients registered by an organization
+func (r *PostgresOAuthServerRepository) ListClients(ctx context.Context, organizationID int64) ([]*OAuthClient, error) {
+	return queryOAuthClients(ctx, r.DB,
+		"SELECT "+oauthClientColumns+" FROM oauth_clients WHERE organization_id = $1 ORDER BY created_at DESC",
+		organizationID,
+	)
+}
+
+// DeleteClient deletes an organization's OAuth client along with its codes and tokens
+func (r *PostgresOAuthServerRepository) DeleteClient(ctx context.Context, clientID string, organizationID int64) (bool, error) {
+	result, err := database.Conn(ctx, r.DB).ExecContext(ctx,
+		"DELETE FROM oauth_clients WHERE client_id = $1 AND organization_id = $2",
+		clientID, organizationID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// CreateAuthorizationCode stores a new authorization code
+func (r *PostgresOAuthServerRepository) CreateAuthorizationCode(ctx context.Context, code *OAuthAuthorizationCode) error {
+	_, err := database.Conn(ctx, r.DB).ExecContext(ctx,
+		`INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())`,
+		code.CodeHash, code.ClientID, code.UserID, code.RedirectURI, pq.Array(code.Scopes), code.CodeChallenge, code.ExpiresAt,
+	)
+	return err
+}
+
+// ConsumeAuthorizationCode deletes and returns an unexpired authorization code so it can only be used once
+func (r *PostgresOAuthServerRepository) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*OAuthAuthorizationCode, error) {
+	var code OAuthAuthorizationCode
+	err := database.Conn(ctx, r.DB).QueryRowContext(ctx,
+		`DELETE FROM oauth_authorization_codes WHERE code_hash = $1 AND expires_at > NOW()
+		RETURNING code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at`,
+		codeHash,
+	).Scan(&code.CodeHash, &code.ClientID, &code.UserID, &code.RedirectURI, pq.Array(&code.Scopes), &code.CodeChallenge, &code.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No code found, but not an error
+		}
+		return nil, err
+	}
+
+	return &code, nil
+}
+
+// CreateTokens stores new access and refresh tokens in one transaction
+func (r *PostgresOAuthServerRepository) CreateTokens(ctx context.Context, tokens ...*OAuthToken) error {
+	return database.WithTx(ctx, r.DB, func(ctx context.Context) error {
+		for _, token := range tokens {
+			if _, err := database.Conn(ctx, r.DB).ExecContext(ctx,
+				`INSERT INTO oauth_tokens (token_hash, token_type, client_id, user_id, scopes, expires_at, created_at)
+				VALUES ($1, $2, $3, $4, $5, $6, NOW())`,
+				token.TokenHash, token.TokenType, token.ClientID, token.UserID, pq.Array(token.Scopes), token.ExpiresAt,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetToken retrieves a token by its hash
+func (r *PostgresOAuthServerRepository) GetToken(ctx context.Context, tokenHash string) (*OAuthToken, error) {
+	token, err := scanOAuthToken(database.Conn(ctx, r.DB).QueryRowContext(ctx,
+		"SELECT "+oauthTokenColumns+" FROM oauth_tokens WHERE token_hash = $1",
+		tokenHash,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil // No token found, but not an error
+	}
+	return token, err
+}
+
+// RevokeToken revokes a client's token
+func (r *PostgresOAuthServerRepository) RevokeToken(ctx context.Context, tokenHash, clientID string) error {
+	_, err := database.Conn(ctx, r.DB).ExecContext(ctx,
+		"UPDATE oauth_tokens SET revoked_at = NOW() WHERE token_hash = $1 AND client_id = $2 AND revoked_at IS NULL",
+		tokenHash, clientID,
+	)
+	return err
+}
+
+// RotateRefreshToken revokes an active refresh token of a client and stores the tokens replacing it in
+// one transaction. It returns nil without storing anything if the token is unknown, expired or already
+// revoked, so concurrent rotations of one token cannot both succeed.
+func (r *PostgresOAuthServerRepository) RotateRefreshToken(ctx context.Context, tokenHash, clientID string, tokens ...*OAuthToken) (*OAuthToken, error) {
+	var revoked *OAuthToken
+	err := database.WithTx(ctx, r.DB, func(ctx context.Context) error {
+		var err error
+		revoked, err = scanOAuthToken(database.Conn(ctx, r.DB).QueryRowContext(ctx,
+			`UPDATE oauth_tokens SET revoked_at = NOW()
+			WHERE token_hash = $1 AND client_id = $2 AND token_type = $3 AND revoked_at IS NULL AND expires_at > NOW()
+			RETURNING `+oauthTokenColumns,
+			tokenHash, clientID, OAuthTokenRefresh,
+		))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				revoked = nil // No active token found, but not an error
+				return nil
+			}
+			return err
+		}
+		return r.CreateTokens(ctx, tokens...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return revoked, nil
+}
+
+// RevokeGrant revokes every token a client holds for a user
+func (r *PostgresOAuthServerRepository) RevokeGrant(ctx context.Context, clientID string, userID int64) error {
+	_, err := database.Conn(ctx, r.DB).ExecContext(ctx,
+		"UPDATE oauth_tokens SET revoked_at = NOW() WHERE client_id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		clientID, userID,
+	)
+	return err
+}
+
+// queryOAuthClients retrieves the OAuth clients selected by a query
+func queryOAuthClients(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]*OAuthClient, error) {
+	rows, err := database.Conn(ctx, db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+// scanOAuthToken scans an OAuthToken selected with oauthTokenColumns
+func scanOAuthToken(row rowScanner) (*OAuthToken, error) {
+	var token OAuthToken
+	var revokedAt sql.NullTime
+	err := row.Scan(&token.ID, &token.TokenHash, &token.TokenType, &token.ClientID, &token.UserID, pq.Array(&token.Scopes), &token.ExpiresAt, &revokedAt, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	token.RevokedAt = nullTimePtr(revokedAt)
+
+	return &token, nil
+}
+
+// scanOAuthClient scans an OAuthClient row
+func scanOAuthClient(row rowScanner) (*OAuthClient, error) {
+	var client OAuthClient
+	err := row.Scan(&client.ID, &client.ClientID, &client.ClientSecretHash, &client.Name, pq.Array(&client.RedirectURIs), pq.Array(&client.Scopes), &client.OrganizationID, &client.OwnerID, &client.CreatedAt, &client.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
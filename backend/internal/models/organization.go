@@ -0,0 +1,475 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Workspace membership roles, from most to least privileged
+const (
+	// RoleOwner has full control of the workspace, including its owners
+	RoleOwner = "owner"
+	// RoleAdmin manages the workspace, its members and its integrations
+	RoleAdmin = "admin"
+	// RoleEditor creates and edits the workspace's shared resources
+	RoleEditor = "editor"
+	// RoleViewer has read-only access to the workspace's shared resources
+	RoleViewer = "viewer"
+)
+
+// Roles lists every membership role
+var Roles = []string{RoleOwner, RoleAdmin, RoleEditor, RoleViewer}
+
+// Organization represents a workspace that owns shared resources. Every user has a personal workspace.
+type Organization struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	Personal  bool      `json:"personal"`
+	CreatedBy int64     `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Membership represents a user's role in an organization
+type Membership struct {
+	OrganizationID int64     `json:"organization_id"`
+	UserID         int64     `json:"user_id"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// OrganizationMembership represents an organization along with the current user's role in it
+type OrganizationMembership struct {
+	*Organization
+	Role string `json:"role"`
+}
+
+// Member represents a user who belongs to an organization
+type Member struct {
+	UserID   int64     `json:"user_id"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// Invite represents a pending invitation to join an organization. Only a hash of the token is stored.
+type Invite struct {
+	ID             int64      `json:"id"`
+	OrganizationID int64      `json:"organization_id"`
+	Email          string     `json:"email"`
+	Role           string     `json:"role"`
+	TokenHash      string     `json:"-"`
+	InvitedBy      int64      `json:"invited_by"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// organizationColumns are the columns selected for an Organization
+const organizationColumns = "id, name, slug, personal, COALESCE(created_by, 0), created_at, updated_at"
+
+// ValidRole checks if a role can be given to a member
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRolePermissions retrieves the role_permissions policy table as the permissions each role grants
+func GetRolePermissions(ctx context.Context) (map[string][]string, error) {
+	rows, err := conn(ctx).QueryContext(ctx, "SELECT role, permission FROM role_permissions ORDER BY role, permission")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := map[string][]string{}
+	for rows.Next() {
+		var role, permission string
+		if err := rows.Scan(&role, &permission); err != nil {
+			return nil, err
+		}
+		grants[role] = append(grants[role], permission)
+	}
+
+	return grants, rows.Err()
+}
+
+// PersonalOrganizationSlug returns the slug of a user's personal workspace
+func PersonalOrganizationSlug(userID int64) string {
+	return fmt.Sprintf("personal-%d", userID)
+}
+
+// CreateOrganization creates an organization with the creator as its owner
+func CreateOrganization(ctx context.Context, name, slug string, createdBy int64) (*Organization, error) {
+	return createOrganization(ctx, name, slug, false, createdBy)
+}
+
+// createPersonalOrganization creates a user's personal workspace
+func createPersonalOrganization(ctx context.Context, user *User) (*Organization, error) {
+	return createOrganization(ctx, user.Name, PersonalOrganizationSlug(user.ID), true, user.ID)
+}
+
+// createOrganization creates an organization and its owner membership, joining the transaction in ctx if any
+func createOrganization(ctx context.Context, name, slug string, personal bool, createdBy int64) (*Organization, error) {
+	var org *Organization
+	err := withTx(ctx, func(ctx context.Context) error {
+		var err error
+		org, err = scanOrganization(conn(ctx).QueryRowContext(ctx,
+			"INSERT INTO organizations (name, slug, personal, created_by, created_at, updated_at) VALUES ($1, $2, $3, $4, NOW(), NOW()) RETURNING "+organizationColumns,
+			name, slug, personal, createdBy,
+		))
+		if err != nil {
+			return asConflict(err, ErrSlugTaken)
+		}
+		_, err = conn(ctx).ExecContext(ctx,
+			"INSERT INTO memberships (organization_id, user_id, role, created_at, updated_at) VALUES ($1, $2, $3, NOW(), NOW())",
+			org.ID, createdBy, RoleOwner,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// OrganizationSlugTaken checks if an organization slug is already in use
+func OrganizationSlugTaken(ctx context.Context, slug string) (bool, error) {
+	var exists bool
+	err := conn(ctx).QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM organizations WHERE slug = $1)", slug).Scan(&exists)
+	return exists, err
+}
+
+// GetOrganizationByID retrieves an organization by ID
+func GetOrganizationByID(ctx context.Context, id int64) (*Organization, error) {
+	org, err := scanOrganization(conn(ctx).QueryRowContext(ctx,
+		"SELECT "+organizationColumns+" FROM organizations WHERE id = $1",
+		id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil // No organization found, but not an error
+	}
+	return org, err
+}
+
+// GetOrganizationBySlug retrieves an organization by slug
+func GetOrganizationBySlug(ctx context.Context, slug string) (*Organization, error) {
+	org, err := scanOrganization(conn(ctx).QueryRowContext(ctx,
+		"SELECT "+organizationColumns+" FROM organizations WHERE slug = $1",
+		slug,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil // No organization found, but not an error
+	}
+	return org, err
+}
+
+// GetPersonalOrganization retrieves a user's personal workspace, creating it if it is missing
+func GetPersonalOrganization(ctx context.Context, userID int64) (*Organization, error) {
+	org, err := GetOrganizationBySlug(ctx, PersonalOrganizationSlug(userID))
+	if err != nil || org != nil {
+		return org, err
+	}
+
+	user, err := GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return createPersonalOrganization(ctx, user)
+}
+
+// GetOrganizationsByUserID retrieves the organizations a user belongs to along with their role
+func GetOrganizationsByUserID(ctx context.Context, userID int64) ([]*OrganizationMembership, error) {
+	rows, err := conn(ctx).QueryContext(ctx,
+		`SELECT o.id, o.name, o.slug, o.personal, COALESCE(o.created_by, 0), o.created_at, o.updated_at, m.role
+		FROM organizations o JOIN memberships m ON m.organization_id = o.id
+		WHERE m.user_id = $1 ORDER BY o.personal DESC, o.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []*OrganizationMembership{}
+	for rows.Next() {
+		var org Organization
+		var role string
+		if err := rows.Scan(&org.ID, &org.Name, &org.Slug, &org.Personal, &org.CreatedBy, &org.CreatedAt, &org.UpdatedAt, &role); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, &OrganizationMembership{Organization: &org, Role: role})
+	}
+
+	return orgs, rows.Err()
+}
+
+// GetSoleOwnedSharedOrganizations retrieves the organizations with other members in which a user is the only owner
+func GetSoleOwnedSharedOrganizations(ctx context.Context, userID int64) ([]*Organization, error) {
+	rows, err := conn(ctx).QueryContext(ctx,
+		`SELECT o.id, o.name, o.slug, o.personal, COALESCE(o.created_by, 0), o.created_at, o.updated_at
+		FROM organizations o JOIN memberships m ON m.organization_id = o.id
+		WHERE m.user_id = $1 AND m.role = $2
+		AND NOT EXISTS (SELECT 1 FROM memberships om WHERE om.organization_id = o.id AND om.role = $2 AND om.user_id <> $1)
+		AND EXISTS (SELECT 1 FROM memberships om WHERE om.organization_id = o.id AND om.user_id <> $1)
+		ORDER BY o.name`,
+		userID, RoleOwner,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []*Organization{}
+	for rows.Next() {
+		org, err := scanOrganization(rows)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+
+	return orgs, rows.Err()
+}
+
+// GetMembership retrieves a user's membership in an organization
+func GetMembership(ctx context.Context, organizationID, userID int64) (*Membership, error) {
+	var m Membership
+	err := conn(ctx).QueryRowContext(ctx,
+		"SELECT organization_id, user_id, role, created_at, updated_at FROM memberships WHERE organization_id = $1 AND user_id = $2",
+		organizationID, userID,
+	).Scan(&m.OrganizationID, &m.UserID, &m.Role, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No membership found, but not an error
+		}
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// AddMembership adds a user to an organization, keeping their role if they already belong to it
+func AddMembership(ctx context.Context, organizationID, userID int64, role string) error {
+	_, err := conn(ctx).ExecContext(ctx,
+		`INSERT INTO memberships (organization_id, user_id, role, created_at, updated_at) VALUES ($1, $2, $3, NOW(), NOW())
+		ON CONFLICT (organization_id, user_id) DO NOTHING`,
+		organizationID, userID, role,
+	)
+	return err
+}
+
+// GetMembers retrieves the members of an organization
+func GetMembers(ctx context.Context, organizationID int64) ([]*Member, error) {
+	rows, err := conn(ctx).QueryContext(ctx,
+		`SELECT u.id, u.name, u.email, m.role, m.created_at
+		FROM memberships m JOIN users u ON u.id = m.user_id
+		WHERE m.organization_id = $1 ORDER BY m.created_at`,
+		organizationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*Member{}
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.UserID, &m.Name, &m.Email, &m.Role, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, &m)
+	}
+
+	return members, rows.Err()
+}
+
+// UpdateMembershipRole changes a member's role. The last owner cannot be demoted.
+func UpdateMembershipRole(ctx context.Context, organizationID, userID int64, role string) (bool, error) {
+	var updated bool
+	err := withTx(ctx, func(ctx context.Context) error {
+		allowed, err := lockOwnerChange(ctx, organizationID, userID, role != RoleOwner)
+		if err != nil || !allowed {
+			return err
+		}
+
+		result, err := conn(ctx).ExecContext(ctx,
+			"UPDATE memberships SET role = $3, updated_at = NOW() WHERE organization_id = $1 AND user_id = $2",
+			organizationID, userID, role,
+		)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		updated = rows == 1
+		return err
+	})
+	return updated, err
+}
+
+// RemoveMembership removes a user from an organization. The last owner cannot be removed.
+func RemoveMembership(ctx context.Context, organizationID, userID int64) (bool, error) {
+	var removed bool
+	err := withTx(ctx, func(ctx context.Context) error {
+		allowed, err := lockOwnerChange(ctx, organizationID, userID, true)
+		if err != nil || !allowed {
+			return err
+		}
+
+		result, err := conn(ctx).ExecContext(ctx,
+			"DELETE FROM memberships WHERE organization_id = $1 AND user_id = $2",
+			organizationID, userID,
+		)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		removed = rows == 1
+		return err
+	})
+	return removed, err
+}
+
+// lockOwnerChange locks the owner memberships of an organization so concurrent demotions and removals
+// are serialized, and checks that removing the user as an owner would leave at least one owner.
+// It must be called in a transaction.
+func lockOwnerChange(ctx context.Context, organizationID, userID int64, removesOwner bool) (bool, error) {
+	rows, err := conn(ctx).QueryContext(ctx,
+		"SELECT user_id FROM memberships WHERE organization_id = $1 AND role = $2 ORDER BY user_id FOR UPDATE",
+		organizationID, RoleOwner,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	isOwner, owners := false, 0
+	for rows.Next() {
+		var ownerID int64
+		if err := rows.Scan(&ownerID); err != nil {
+			return false, err
+		}
+		owners++
+		isOwner = isOwner || ownerID == userID
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	return !(removesOwner && isOwner && owners <= 1), nil
+}
+
+// CreateInvite creates an invitation to join an organization
+func CreateInvite(ctx context.Context, organizationID int64, email, role, tokenHash string, invitedBy int64, expiresAt time.Time) (*Invite, error) {
+	return scanInvite(conn(ctx).QueryRowContext(ctx,
+		`INSERT INTO invites (organization_id, email, role, token_hash, invited_by, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		RETURNING id, organization_id, email, role, token_hash, COALESCE(invited_by, 0), expires_at, accepted_at, created_at`,
+		organizationID, strings.ToLower(email), role, tokenHash, invitedBy, expiresAt,
+	))
+}
+
+// GetPendingInvites retrieves the unaccepted, unexpired invitations of an organization
+func GetPendingInvites(ctx context.Context, organizationID int64) ([]*Invite, error) {
+	rows, err := conn(ctx).QueryContext(ctx,
+		`SELECT id, organization_id, email, role, token_hash, COALESCE(invited_by, 0), expires_at, accepted_at, created_at
+		FROM invites WHERE organization_id = $1 AND accepted_at IS NULL AND expires_at > NOW() ORDER BY created_at DESC`,
+		organizationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []*Invite{}
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+
+	return invites, rows.Err()
+}
+
+// DeleteInvite deletes a pending invitation of an organization
+func DeleteInvite(ctx context.Context, id, organizationID int64) (bool, error) {
+	result, err := conn(ctx).ExecContext(ctx,
+		"DELETE FROM invites WHERE id = $1 AND organization_id = $2 AND accepted_at IS NULL",
+		id, organizationID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// AcceptInvite marks an invitation addressed to the email as accepted and adds the user to its organization.
+// It returns nil if no pending, unexpired invitation matched.
+func AcceptInvite(ctx context.Context, tokenHash string, userID int64, email string) (*Invite, error) {
+	var invite *Invite
+	err := withTx(ctx, func(ctx context.Context) error {
+		var err error
+		invite, err = scanInvite(conn(ctx).QueryRowContext(ctx,
+			`UPDATE invites SET accepted_at = NOW()
+			WHERE token_hash = $1 AND email = $2 AND accepted_at IS NULL AND expires_at > NOW()
+			RETURNING id, organization_id, email, role, token_hash, COALESCE(invited_by, 0), expires_at, accepted_at, created_at`,
+			tokenHash, strings.ToLower(email),
+		))
+		if err != nil {
+			return err
+		}
+
+		_, err = conn(ctx).ExecContext(ctx,
+			`INSERT INTO memberships (organization_id, user_id, role, created_at, updated_at) VALUES ($1, $2, $3, NOW(), NOW())
+			ON CONFLICT (organization_id, user_id) DO NOTHING`,
+			invite.OrganizationID, userID, invite.Role,
+		)
+		return err
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No invite found, but not an error
+		}
+		return nil, err
+	}
+	return invite, nil
+}
+
+// scanOrganization scans an Organization selected with organizationColumns
+func scanOrganization(row rowScanner) (*Organization, error) {
+	var org Organization
+	if err := row.Scan(&org.ID, &org.Name, &org.Slug, &org.Personal, &org.CreatedBy, &org.CreatedAt, &org.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// scanInvite scans an Invite
+func scanInvite(row rowScanner) (*Invite, error) {
+	var invite Invite
+	var acceptedAt sql.NullTime
+	err := row.Scan(&invite.ID, &invite.OrganizationID, &invite.Email, &invite.Role, &invite.TokenHash, &invite.InvitedBy, &invite.ExpiresAt, &acceptedAt, &invite.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	invite.AcceptedAt = nullTimePtr(acceptedAt)
+
+	return &invite, nil
+}
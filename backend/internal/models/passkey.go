@@ -0,0 +1,143 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// WebAuthn ceremonies a challenge can be issued for
+const (
+	// CeremonyRegistration is a passkey registration ceremony
+	CeremonyRegistration = "registration"
+	// CeremonyLogin is a passkey login ceremony
+	CeremonyLogin = "login"
+)
+
+// PasskeyCredential represents a WebAuthn credential registered by a user
+type PasskeyCredential struct {
+	ID           int64      `json:"id"`
+	UserID       int64      `json:"user_id"`
+	CredentialID []byte     `json:"-"`
+	Name         string     `json:"name"`
+	Credential   []byte     `json:"-"` // JSON-encoded webauthn.Credential
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// WebAuthnChallenge represents a pending WebAuthn ceremony
+type WebAuthnChallenge struct {
+	Challenge   string
+	UserID      int64
+	Ceremony    string
+	SessionData []byte
+	ExpiresAt   time.Time
+}
+
+// CreatePasskeyCredential stores a new WebAuthn credential for a user
+func CreatePasskeyCredential(ctx context.Context, userID int64, credentialID []byte, name string, credential []byte) (*PasskeyCredential, error) {
+	var cred PasskeyCredential
+	var lastUsedAt sql.NullTime
+	err := conn(ctx).QueryRowContext(ctx,
+		"INSERT INTO webauthn_credentials (user_id, credential_id, name, credential, created_at, updated_at) VALUES ($1, $2, $3, $4, NOW(), NOW()) RETURNING id, user_id, credential_id, name, credential, last_used_at, created_at, updated_at",
+		userID, credentialID, name, credential,
+	).Scan(&cred.ID, &cred.UserID, &cred.CredentialID, &cred.Name, &cred.Credential, &lastUsedAt, &cred.CreatedAt, &cred.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		cred.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return &cred, nil
+}
+
+// GetPasskeyCredentialsByUserID retrieves all WebAuthn credentials of a user
+func GetPasskeyCredentialsByUserID(ctx context.Context, userID int64) ([]*PasskeyCredential, error) {
+	rows, err := conn(ctx).QueryContext(ctx,
+		"SELECT id, user_id, credential_id, name, credential, last_used_at, created_at, updated_at FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creds := []*PasskeyCredential{}
+	for rows.Next() {
+		var cred PasskeyCredential
+		var lastUsedAt sql.NullTime
+		if err := rows.Scan(&cred.ID, &cred.UserID, &cred.CredentialID, &cred.Name, &cred.Credential, &lastUsedAt, &cred.CreatedAt, &cred.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if lastUsedAt.Valid {
+			cred.LastUsedAt = &lastUsedAt.Time
+		}
+		creds = append(creds, &cred)
+	}
+
+	return creds, rows.Err()
+}
+
+// UpdatePasskeyCredentialUsage stores the updated credential after a successful login
+func UpdatePasskeyCredentialUsage(ctx context.Context, credentialID []byte, credential []byte) error {
+	_, err := conn(ctx).ExecContext(ctx,
+		"UPDATE webauthn_credentials SET credential = $2, last_used_at = NOW(), updated_at = NOW() WHERE credential_id = $1",
+		credentialID, credential,
+	)
+	return err
+}
+
+// DeletePasskeyCredential deletes a user's WebAuthn credential and returns false if none matched
+func DeletePasskeyCredential(ctx context.Context, id, userID int64) (bool, error) {
+	result, err := conn(ctx).ExecContext(ctx,
+		"DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2",
+		id, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// SaveWebAuthnChallenge stores a pending WebAuthn ceremony and prunes expired ones.
+// A userID of 0 is stored as NULL for ceremonies without a known user.
+func SaveWebAuthnChallenge(ctx context.Context, challenge *WebAuthnChallenge) error {
+	// Prune expired challenges
+	if _, err := conn(ctx).ExecContext(ctx, "DELETE FROM webauthn_challenges WHERE expires_at < NOW()"); err != nil {
+		return err
+	}
+
+	// Insert challenge
+	userID := sql.NullInt64{Int64: challenge.UserID, Valid: challenge.UserID != 0}
+	_, err := conn(ctx).ExecContext(ctx,
+		"INSERT INTO webauthn_challenges (challenge, user_id, ceremony, session_data, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, NOW())",
+		challenge.Challenge, userID, challenge.Ceremony, challenge.SessionData, challenge.ExpiresAt,
+	)
+	return err
+}
+
+// ConsumeWebAuthnChallenge deletes and returns an unexpired WebAuthn challenge so it can only be used once
+func ConsumeWebAuthnChallenge(ctx context.Context, challenge, ceremony string) (*WebAuthnChallenge, error) {
+	var c WebAuthnChallenge
+	var userID sql.NullInt64
+	err := conn(ctx).QueryRowContext(ctx,
+		"DELETE FROM webauthn_challenges WHERE challenge = $1 AND ceremony = $2 AND expires_at > NOW() RETURNING challenge, user_id, ceremony, session_data, expires_at",
+		challenge, ceremony,
+	).Scan(&c.Challenge, &userID, &c.Ceremony, &c.SessionData, &c.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No challenge found, but not an error
+		}
+		return nil, err
+	}
+	c.UserID = userID.Int64
+
+	return &c, nil
+}
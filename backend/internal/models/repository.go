@@ -0,0 +1,238 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/RanitManik/zyply/internal/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserRepository stores and retrieves users. Lookups return ErrUserNotFound
+// for unknown users and users scheduled for deletion.
+type UserRepository interface {
+	// Create creates a user with a bcrypt hash of the password and returns ErrEmailTaken
+	// if the email already belongs to a user
+	Create(ctx context.Context, name, email, password string) (*User, error)
+	// CreateWithOAuthAccount creates a user and links an OAuth account to them as one unit of work
+	CreateWithOAuthAccount(ctx context.Context, name, email, password string, provider OAuthProvider, providerID, providerData string) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	GetByID(ctx context.Context, id int64) (*User, error)
+	GetByOAuthAccount(ctx context.Context, provider OAuthProvider, providerID string) (*User, error)
+	// UpdatePassword stores a bcrypt hash of a new password
+	UpdatePassword(ctx context.Context, id int64, password string) error
+}
+
+// OAuthAccountRepository stores and retrieves OAuth accounts linked to users
+type OAuthAccountRepository interface {
+	// Create returns ErrOAuthAccountLinked if the account is already linked to a user
+	Create(ctx context.Context, userID int64, provider OAuthProvider, providerID, providerData string) (*OAuthAccount, error)
+	// Get returns nil if no account matches
+	Get(ctx context.Context, provider OAuthProvider, providerID string) (*OAuthAccount, error)
+}
+
+// MFARepository stores users' TOTP credentials and recovery codes
+type MFARepository interface {
+	// SavePendingTOTP stores a new unconfirmed TOTP secret, replacing any unconfirmed one
+	SavePendingTOTP(ctx context.Context, userID int64, secret string) error
+	// GetTOTPCredential returns nil if the user has no credential
+	GetTOTPCredential(ctx context.Context, userID int64) (*TOTPCredential, error)
+	IsMFAEnabled(ctx context.Context, userID int64) (bool, error)
+	// UseTOTPStep confirms the credential and returns false if the step was already used
+	UseTOTPStep(ctx context.Context, userID, step int64) (bool, error)
+	// RecordTOTPFailure locks verification for TOTPLockout after MaxTOTPFailures failures
+	RecordTOTPFailure(ctx context.Context, userID int64) error
+	DeleteTOTP(ctx context.Context, userID int64) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error
+	// UseRecoveryCode returns false if no unused code matches
+	UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID int64) (int, error)
+}
+
+// APIKeyRepository stores personal API keys
+type APIKeyRepository interface {
+	Create(ctx context.Context, userID, organizationID int64, name, prefix, secretHash string, scopes []string, expiresAt *time.Time) (*APIKey, error)
+	// GetByPrefix returns nil if no key matches
+	GetByPrefix(ctx context.Context, prefix string) (*APIKey, error)
+	// Revoke returns false if the user has no active key with the ID
+	Revoke(ctx context.Context, id, userID int64) (bool, error)
+	// Touch records that a key was used, at most once a minute
+	Touch(ctx context.Context, id int64) error
+}
+
+// OAuthServerRepository stores the clients, authorization codes and tokens of the OAuth authorization server
+type OAuthServerRepository interface {
+	// CreateClient registers a client for an organization. An empty secret hash registers a public client.
+	CreateClient(ctx context.Context, organizationID, ownerID int64, clientID, clientSecretHash, name string, redirectURIs, scopes []string) (*OAuthClient, error)
+	// GetClient returns nil if no client matches
+	GetClient(ctx context.Context, clientID string) (*OAuthClient, error)
+	ListClients(ctx context.Context, organizationID int64) ([]*OAuthClient, error)
+	// DeleteClient deletes a client with its codes and tokens and returns false if the organization has no such client
+	DeleteClient(ctx context.Context, clientID string, organizationID int64) (bool, error)
+	CreateAuthorizationCode(ctx context.Context, code *OAuthAuthorizationCode) error
+	// ConsumeAuthorizationCode deletes and returns an unexpired code, or returns nil, so a code can only be used once
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*OAuthAuthorizationCode, error)
+	// CreateTokens stores tokens as one unit of work
+	CreateTokens(ctx context.Context, tokens ...*OAuthToken) error
+	// GetToken returns nil if no token matches
+	GetToken(ctx context.Context, tokenHash string) (*OAuthToken, error)
+	RevokeToken(ctx context.Context, tokenHash, clientID string) error
+	// RotateRefreshToken revokes an active refresh token of a client and stores the tokens replacing it as one
+	// unit of work. It returns nil without storing anything if the token is not active.
+	RotateRefreshToken(ctx context.Context, tokenHash, clientID string, tokens ...*OAuthToken) (*OAuthToken, error)
+	// RevokeGrant revokes every token a client holds for a user
+	RevokeGrant(ctx context.Context, clientID string, userID int64) error
+}
+
+// AuditEventRepository appends audit events
+type AuditEventRepository interface {
+	Create(ctx context.Context, event *AuditEvent) error
+}
+
+// PostgresUserRepository is a UserRepository backed by Postgres
+type PostgresUserRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresUserRepository creates a new PostgresUserRepository
+func NewPostgresUserRepository(db *sql.DB) *PostgresUserRepository {
+	return &PostgresUserRepository{DB: db}
+}
+
+// Create creates a new user along with their personal workspace
+func (r *PostgresUserRepository) Create(ctx context.Context, name, email, password string) (*User, error) {
+	// Hash password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *User
+	err = database.WithTx(ctx, r.DB, func(ctx context.Context) error {
+		// Insert user, which fails if the email is taken
+		user, err = scanUser(database.Conn(ctx, r.DB).QueryRowContext(ctx,
+			"INSERT INTO users (name, email, password, created_at, updated_at) VALUES ($1, $2, $3, NOW(), NOW()) RETURNING "+userColumns,
+			name, email, string(hashedPassword),
+		))
+		if err != nil {
+			return asConflict(err, ErrEmailTaken)
+		}
+
+		// Create personal workspace
+		_, err = createPersonalOrganization(ctx, user)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// CreateWithOAuthAccount creates a new user, their personal workspace and their OAuth account
+func (r *PostgresUserRepository) CreateWithOAuthAccount(ctx context.Context, name, email, password string, provider OAuthProvider, providerID, providerData string) (*User, error) {
+	var user *User
+	err := database.WithTx(ctx, r.DB, func(ctx context.Context) error {
+		var err error
+		if user, err = r.Create(ctx, name, email, password); err != nil {
+			return err
+		}
+		_, err = NewPostgresOAuthAccountRepository(r.DB).Create(ctx, user.ID, provider, providerID, providerData)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetByEmail retrieves a user by email
+func (r *PostgresUserRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
+	return r.getUser(ctx, "SELECT "+userColumns+" FROM users WHERE email = $1 AND deleted_at IS NULL", email)
+}
+
+// GetByID retrieves a user by ID
+func (r *PostgresUserRepository) GetByID(ctx context.Context, id int64) (*User, error) {
+	return r.getUser(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1 AND deleted_at IS NULL", id)
+}
+
+// GetByOAuthAccount retrieves a user by OAuth account
+func (r *PostgresUserRepository) GetByOAuthAccount(ctx context.Context, provider OAuthProvider, providerID string) (*User, error) {
+	return r.getUser(ctx,
+		`SELECT u.id, u.name, u.email, u.password, u.avatar_url, u.timezone, u.locale, u.created_at, u.updated_at
+		FROM users u
+		JOIN oauth_accounts oa ON u.id = oa.user_id
+		WHERE oa.provider = $1 AND oa.provider_id = $2 AND u.deleted_at IS NULL`,
+		provider, providerID,
+	)
+}
+
+// UpdatePassword hashes and stores a new password for a user
+func (r *PostgresUserRepository) UpdatePassword(ctx context.Context, id int64, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Conn(ctx, r.DB).ExecContext(ctx,
+		"UPDATE users SET password = $2, updated_at = NOW() WHERE id = $1",
+		id, string(hashedPassword),
+	)
+	return err
+}
+
+// getUser retrieves the user selected by a query
+func (r *PostgresUserRepository) getUser(ctx context.Context, query string, args ...interface{}) (*User, error) {
+	user, err := scanUser(database.Conn(ctx, r.DB).QueryRowContext(ctx, query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// PostgresOAuthAccountRepository is an OAuthAccountRepository backed by Postgres
+type PostgresOAuthAccountRepository struct {
+	DB *sql.DB
+}
+
+// NewPostgresOAuthAccountRepository creates a new PostgresOAuthAccountRepository
+func NewPostgresOAuthAccountRepository(db *sql.DB) *PostgresOAuthAccountRepository {
+	return &PostgresOAuthAccountRepository{DB: db}
+}
+
+// Create creates a new OAuth account for a user
+func (r *PostgresOAuthAccountRepository) Create(ctx context.Context, userID int64, provider OAuthProvider, providerID, providerData string) (*OAuthAccount, error) {
+	var account OAuthAccount
+	err := database.Conn(ctx, r.DB).QueryRowContext(ctx,
+		"INSERT INTO oauth_accounts (user_id, provider, provider_id, provider_data, created_at, updated_at) VALUES ($1, $2, $3, $4, NOW(), NOW()) RETURNING id, user_id, provider, provider_id, provider_data, created_at, updated_at",
+		userID, provider, providerID, providerData,
+	).Scan(&account.ID, &account.UserID, &account.Provider, &account.ProviderID, &account.ProviderData, &account.CreatedAt, &account.UpdatedAt)
+	if err != nil {
+		return nil, asConflict(err, ErrOAuthAccountLinked)
+	}
+
+	return &account, nil
+}
+
+// Get retrieves an OAuth account by provider and provider ID
+func (r *PostgresOAuthAccountRepository) Get(ctx context.Context, provider OAuthProvider, providerID string) (*OAuthAccount, error) {
+	var account OAuthAccount
+	err := database.Conn(ctx, r.DB).QueryRowContext(ctx,
+		"SELECT id, user_id, provider, provider_id, provider_data, created_at, updated_at FROM oauth_accounts WHERE provider = $1 AND provider_id = $2",
+		provider, providerID,
+	).Scan(&account.ID, &account.UserID, &account.Provider, &account.ProviderID, &account.ProviderData, &account.CreatedAt, &account.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No account found, but not an error
+		}
+		return nil, err
+	}
+
+	return &account, nil
+}
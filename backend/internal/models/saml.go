@@ -0,0 +1,190 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrSAMLDomainTaken is returned when another organization has already verified an email domain
+var ErrSAMLDomainTaken = &Error{Kind: ErrConflict, Message: "domain is already verified by another organization"}
+
+// SAMLConnection represents an organization's SAML identity provider
+type SAMLConnection struct {
+	ID             int64         `json:"id"`
+	OrganizationID int64         `json:"organization_id"`
+	Organization   string        `json:"organization"`
+	IDPEntityID    string        `json:"idp_entity_id"`
+	IDPMetadata    string        `json:"-"`
+	DefaultRole    string        `json:"default_role"`
+	Domains        []*SAMLDomain `json:"domains"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// SAMLDomain is an email domain of a SAML connection. Only verified domains admit users.
+type SAMLDomain struct {
+	Domain            string     `json:"domain"`
+	VerificationToken string     `json:"-"`
+	VerifiedAt        *time.Time `json:"verified_at"`
+}
+
+// Verified checks if the organization has proven it controls the domain
+func (d *SAMLDomain) Verified() bool {
+	return d.VerifiedAt != nil
+}
+
+// Provider returns the OAuth provider value used to link SAML identities to users
+func (c *SAMLConnection) Provider() OAuthProvider {
+	return OAuthProvider("saml:" + c.Organization)
+}
+
+// Active checks if the connection has at least one verified domain
+func (c *SAMLConnection) Active() bool {
+	for _, d := range c.Domains {
+		if d.Verified() {
+			return true
+		}
+	}
+	return false
+}
+
+// Domain returns the connection's domain with the given name, or nil
+func (c *SAMLConnection) Domain(name string) *SAMLDomain {
+	name = strings.ToLower(name)
+	for _, d := range c.Domains {
+		if d.Domain == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// AllowsEmail checks if the email belongs to one of the connection's verified domains
+func (c *SAMLConnection) AllowsEmail(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	d := c.Domain(email[at+1:])
+	return d != nil && d.Verified()
+}
+
+// UpsertSAMLConnection creates or replaces the SAML connection for an organization.
+// Domains already on the connection keep their verification token and state;
+// new domains are stored unverified with the token they carry.
+func UpsertSAMLConnection(ctx context.Context, org *Organization, idpEntityID, idpMetadata, defaultRole string, domains []*SAMLDomain) (*SAMLConnection, error) {
+	connection := SAMLConnection{Organization: org.Slug}
+	err := withTx(ctx, func(ctx context.Context) error {
+		// Save connection
+		err := conn(ctx).QueryRowContext(ctx,
+			`INSERT INTO saml_connections (organization_id, idp_entity_id, idp_metadata, default_role, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, NOW(), NOW())
+			ON CONFLICT (organization_id) DO UPDATE
+			SET idp_entity_id = EXCLUDED.idp_entity_id, idp_metadata = EXCLUDED.idp_metadata, default_role = EXCLUDED.default_role, updated_at = NOW()
+			RETURNING id, organization_id, idp_entity_id, idp_metadata, default_role, created_at, updated_at`,
+			org.ID, idpEntityID, idpMetadata, defaultRole,
+		).Scan(&connection.ID, &connection.OrganizationID, &connection.IDPEntityID, &connection.IDPMetadata, &connection.DefaultRole, &connection.CreatedAt, &connection.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		// Replace domains
+		names := make([]string, 0, len(domains))
+		for _, d := range domains {
+			names = append(names, d.Domain)
+		}
+		if _, err := conn(ctx).ExecContext(ctx,
+			"DELETE FROM saml_domains WHERE organization_id = $1 AND NOT (domain = ANY($2))",
+			org.ID, pq.Array(names),
+		); err != nil {
+			return err
+		}
+		for _, d := range domains {
+			if _, err := conn(ctx).ExecContext(ctx,
+				`INSERT INTO saml_domains (organization_id, domain, verification_token, created_at)
+				VALUES ($1, $2, $3, NOW()) ON CONFLICT (organization_id, domain) DO NOTHING`,
+				org.ID, d.Domain, d.VerificationToken,
+			); err != nil {
+				return err
+			}
+		}
+
+		connection.Domains, err = getSAMLDomains(ctx, org.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &connection, nil
+}
+
+// GetSAMLConnection retrieves the SAML connection for an organization by its slug
+func GetSAMLConnection(ctx context.Context, organization string) (*SAMLConnection, error) {
+	var connection SAMLConnection
+	err := conn(ctx).QueryRowContext(ctx,
+		`SELECT s.id, s.organization_id, o.slug, s.idp_entity_id, s.idp_metadata, s.default_role, s.created_at, s.updated_at
+		FROM saml_connections s JOIN organizations o ON o.id = s.organization_id WHERE o.slug = $1`,
+		organization,
+	).Scan(&connection.ID, &connection.OrganizationID, &connection.Organization, &connection.IDPEntityID, &connection.IDPMetadata, &connection.DefaultRole, &connection.CreatedAt, &connection.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No connection found, but not an error
+		}
+		return nil, err
+	}
+
+	connection.Domains, err = getSAMLDomains(ctx, connection.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &connection, nil
+}
+
+// VerifySAMLDomain marks an organization's domain as verified. It returns nil if the organization
+// has no such domain and ErrSAMLDomainTaken if another organization verified it first.
+func VerifySAMLDomain(ctx context.Context, organizationID int64, domain string) (*SAMLDomain, error) {
+	var d SAMLDomain
+	err := conn(ctx).QueryRowContext(ctx,
+		`UPDATE saml_domains SET verified_at = COALESCE(verified_at, NOW())
+		WHERE organization_id = $1 AND domain = $2
+		RETURNING domain, verification_token, verified_at`,
+		organizationID, domain,
+	).Scan(&d.Domain, &d.VerificationToken, &d.VerifiedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No domain found, but not an error
+		}
+		return nil, asConflict(err, ErrSAMLDomainTaken)
+	}
+
+	return &d, nil
+}
+
+// getSAMLDomains retrieves the email domains of an organization's SAML connection
+func getSAMLDomains(ctx context.Context, organizationID int64) ([]*SAMLDomain, error) {
+	rows, err := conn(ctx).QueryContext(ctx,
+		"SELECT domain, verification_token, verified_at FROM saml_domains WHERE organization_id = $1 ORDER BY domain",
+		organizationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	domains := []*SAMLDomain{}
+	for rows.Next() {
+		var d SAMLDomain
+		if err := rows.Scan(&d.Domain, &d.VerificationToken, &d.VerifiedAt); err != nil {
+			return nil, err
+		}
+		domains = append(domains, &d)
+	}
+
+	return domains, rows.Err()
+}
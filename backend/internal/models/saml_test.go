@@ -0,0 +1,38 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSAMLConnectionAllowsEmail(t *testing.T) {
+	verifiedAt := time.Now()
+	conn := &SAMLConnection{Domains: []*SAMLDomain{
+		{Domain: "acme.example", VerifiedAt: &verifiedAt},
+		{Domain: "pending.example"},
+	}}
+
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"ada@acme.example", true},
+		{"Ada@ACME.example", true},
+		{"ada@pending.example", false},
+		{"ada@sub.acme.example", false},
+		{"ada@acme.example.evil", false},
+		{"ada", false},
+	}
+	for _, tt := range tests {
+		if got := conn.AllowsEmail(tt.email); got != tt.want {
+			t.Errorf("AllowsEmail(%q) = %v, want %v", tt.email, got, tt.want)
+		}
+	}
+
+	if !conn.Active() {
+		t.Error("Active() = false with a verified domain")
+	}
+	if (&SAMLConnection{Domains: []*SAMLDomain{{Domain: "pending.example"}}}).Active() {
+		t.Error("Active() = true without a verified domain")
+	}
+}
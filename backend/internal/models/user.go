@@ -1,8 +1,8 @@
 package models
 
 import (
+	"context"
 	"database/sql"
-	"errors"
 	"time"
 
 	"github.com/RanitManik/zyply/internal/database"
@@ -15,10 +15,25 @@ type User struct {
 	Name      string    `json:"name"`
 	Email     string    `json:"email"`
 	Password  string    `json:"-"` // Never expose password in JSON
+	AvatarURL string    `json:"avatar_url"`
+	Timezone  string    `json:"timezone"`
+	Locale    string    `json:"locale"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// userColumns are the columns selected for a User
+const userColumns = "id, name, email, password, avatar_url, timezone, locale, created_at, updated_at"
+
+// EmailChange represents a pending change of a user's email address awaiting verification
+type EmailChange struct {
+	ID        int64
+	UserID    int64
+	NewEmail  string
+	TokenHash string
+	ExpiresAt time.Time
+}
+
 // OAuthProvider represents an OAuth provider type
 type OAuthProvider string
 
@@ -41,123 +56,228 @@ type OAuthAccount struct {
 }
 
 // CreateUser creates a new user in the database
-func CreateUser(name, email, password string) (*User, error) {
-	// Check if user already exists
-	var exists bool
-	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)", email).Scan(&exists)
-	if err != nil {
-		return nil, err
-	}
-	if exists {
-		return nil, errors.New("user with this email already exists")
-	}
+func CreateUser(ctx context.Context, name, email, password string) (*User, error) {
+	return NewPostgresUserRepository(database.DB).Create(ctx, name, email, password)
+}
+
+// GetUserByEmail retrieves a user by email. Users scheduled for deletion are not found.
+func GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return NewPostgresUserRepository(database.DB).GetByEmail(ctx, email)
+}
 
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// GetUserByID retrieves a user by ID. Users scheduled for deletion are not found.
+func GetUserByID(ctx context.Context, id int64) (*User, error) {
+	return NewPostgresUserRepository(database.DB).GetByID(ctx, id)
+}
+
+// VerifyPassword checks if the provided password matches the stored hash
+func (u *User) VerifyPassword(password string) bool {
+	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
+	return err == nil
+}
+
+// CreateOAuthAccount creates a new OAuth account for a user
+func CreateOAuthAccount(ctx context.Context, userID int64, provider OAuthProvider, providerID, providerData string) (*OAuthAccount, error) {
+	return NewPostgresOAuthAccountRepository(database.DB).Create(ctx, userID, provider, providerID, providerData)
+}
+
+// GetOAuthAccount retrieves an OAuth account by provider and provider ID
+func GetOAuthAccount(ctx context.Context, provider OAuthProvider, providerID string) (*OAuthAccount, error) {
+	return NewPostgresOAuthAccountRepository(database.DB).Get(ctx, provider, providerID)
+}
+
+// GetOAuthAccountsByUserID retrieves the OAuth accounts linked to a user
+func GetOAuthAccountsByUserID(ctx context.Context, userID int64) ([]*OAuthAccount, error) {
+	rows, err := conn(ctx).QueryContext(ctx,
+		"SELECT id, user_id, provider, provider_id, provider_data, created_at, updated_at FROM oauth_accounts WHERE user_id = $1 ORDER BY created_at",
+		userID,
+	)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	// Insert user
-	var user User
-	err = database.DB.QueryRow(
-		"INSERT INTO users (name, email, password, created_at, updated_at) VALUES ($1, $2, $3, NOW(), NOW()) RETURNING id, name, email, created_at, updated_at",
-		name, email, string(hashedPassword),
-	).Scan(&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt)
-	if err != nil {
-		return nil, err
+	accounts := []*OAuthAccount{}
+	for rows.Next() {
+		var account OAuthAccount
+		if err := rows.Scan(&account.ID, &account.UserID, &account.Provider, &account.ProviderID, &account.ProviderData, &account.CreatedAt, &account.UpdatedAt); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, &account)
 	}
 
-	return &user, nil
+	return accounts, rows.Err()
 }
 
-// GetUserByEmail retrieves a user by email
-func GetUserByEmail(email string) (*User, error) {
-	var user User
-	err := database.DB.QueryRow(
-		"SELECT id, name, email, password, created_at, updated_at FROM users WHERE email = $1",
-		email,
-	).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, errors.New("user not found")
-		}
-		return nil, err
-	}
+// GetUserByOAuthAccount retrieves a user by OAuth account. Users scheduled for deletion are not found.
+func GetUserByOAuthAccount(ctx context.Context, provider OAuthProvider, providerID string) (*User, error) {
+	return NewPostgresUserRepository(database.DB).GetByOAuthAccount(ctx, provider, providerID)
+}
 
-	return &user, nil
+// UpdateUserProfile updates a user's profile fields
+func UpdateUserProfile(ctx context.Context, id int64, name, avatarURL, timezone, locale string) (*User, error) {
+	return scanUser(conn(ctx).QueryRowContext(ctx,
+		"UPDATE users SET name = $2, avatar_url = $3, timezone = $4, locale = $5, updated_at = NOW() WHERE id = $1 RETURNING "+userColumns,
+		id, name, avatarURL, timezone, locale,
+	))
 }
 
-// GetUserByID retrieves a user by ID
-func GetUserByID(id int64) (*User, error) {
-	var user User
-	err := database.DB.QueryRow(
-		"SELECT id, name, email, password, created_at, updated_at FROM users WHERE id = $1",
-		id,
-	).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+// UpdateUserPassword hashes and stores a new password for a user
+func UpdateUserPassword(ctx context.Context, id int64, password string) error {
+	return NewPostgresUserRepository(database.DB).UpdatePassword(ctx, id, password)
+}
+
+// EmailTaken checks if an email address belongs to a user
+func EmailTaken(ctx context.Context, email string) (bool, error) {
+	var exists bool
+	err := conn(ctx).QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)", email).Scan(&exists)
+	return exists, err
+}
+
+// CreateEmailChange stores a pending email change, replacing any earlier pending change of the user
+func CreateEmailChange(ctx context.Context, userID int64, newEmail, tokenHash string, expiresAt time.Time) error {
+	return withTx(ctx, func(ctx context.Context) error {
+		if _, err := conn(ctx).ExecContext(ctx, "DELETE FROM email_changes WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		_, err := conn(ctx).ExecContext(ctx,
+			"INSERT INTO email_changes (user_id, new_email, token_hash, expires_at, created_at) VALUES ($1, $2, $3, $4, NOW())",
+			userID, newEmail, tokenHash, expiresAt,
+		)
+		return err
+	})
+}
+
+// ConfirmEmailChange applies the pending email change matching a token and returns the updated user.
+// It returns nil if no unexpired change matched.
+func ConfirmEmailChange(ctx context.Context, tokenHash string) (*EmailChange, *User, error) {
+	var change EmailChange
+	var user *User
+	err := withTx(ctx, func(ctx context.Context) error {
+		// Consume change
+		err := conn(ctx).QueryRowContext(ctx,
+			"DELETE FROM email_changes WHERE token_hash = $1 AND expires_at > NOW() RETURNING id, user_id, new_email, token_hash, expires_at",
+			tokenHash,
+		).Scan(&change.ID, &change.UserID, &change.NewEmail, &change.TokenHash, &change.ExpiresAt)
+		if err != nil {
+			return err
+		}
+
+		// Update email, which fails if it was taken in the meantime
+		user, err = scanUser(conn(ctx).QueryRowContext(ctx,
+			"UPDATE users SET email = $2, updated_at = NOW() WHERE id = $1 RETURNING "+userColumns,
+			change.UserID, change.NewEmail,
+		))
+		return asConflict(err, ErrEmailTaken)
+	})
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("user not found")
+			return nil, nil, nil // No change found, but not an error
 		}
-		return nil, err
+		return nil, nil, err
 	}
-
-	return &user, nil
+	return &change, user, nil
 }
 
-// VerifyPassword checks if the provided password matches the stored hash
-func (u *User) VerifyPassword(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
-	return err == nil
+// ScheduleUserDeletion soft-deletes a user, who can be restored with the restore token until
+// purgeAfter. Their API keys and OAuth tokens are revoked and pending email changes dropped.
+func ScheduleUserDeletion(ctx context.Context, id int64, purgeAfter time.Time, restoreTokenHash string) error {
+	return withTx(ctx, func(ctx context.Context) error {
+		// Mark user deleted
+		result, err := conn(ctx).ExecContext(ctx,
+			"UPDATE users SET deleted_at = NOW(), purge_after = $2, restore_token_hash = $3, updated_at = NOW() WHERE id = $1 AND deleted_at IS NULL",
+			id, purgeAfter, restoreTokenHash,
+		)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrUserNotFound
+		}
+
+		// Revoke credentials
+		if _, err := conn(ctx).ExecContext(ctx, "UPDATE api_keys SET revoked_at = NOW(), updated_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", id); err != nil {
+			return err
+		}
+		if _, err := conn(ctx).ExecContext(ctx, "UPDATE oauth_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", id); err != nil {
+			return err
+		}
+		_, err = conn(ctx).ExecContext(ctx, "DELETE FROM email_changes WHERE user_id = $1", id)
+		return err
+	})
 }
 
-// CreateOAuthAccount creates a new OAuth account for a user
-func CreateOAuthAccount(userID int64, provider OAuthProvider, providerID, providerData string) (*OAuthAccount, error) {
-	var account OAuthAccount
-	err := database.DB.QueryRow(
-		"INSERT INTO oauth_accounts (user_id, provider, provider_id, provider_data, created_at, updated_at) VALUES ($1, $2, $3, $4, NOW(), NOW()) RETURNING id, user_id, provider, provider_id, provider_data, created_at, updated_at",
-		userID, provider, providerID, providerData,
-	).Scan(&account.ID, &account.UserID, &account.Provider, &account.ProviderID, &account.ProviderData, &account.CreatedAt, &account.UpdatedAt)
-	if err != nil {
-		return nil, err
+// RestoreUser cancels the scheduled deletion matching a restore token and returns the user.
+// It returns nil if no user awaiting deletion matched. Revoked credentials stay revoked.
+func RestoreUser(ctx context.Context, restoreTokenHash string) (*User, error) {
+	user, err := scanUser(conn(ctx).QueryRowContext(ctx,
+		"UPDATE users SET deleted_at = NULL, purge_after = NULL, restore_token_hash = NULL, updated_at = NOW() WHERE restore_token_hash = $1 AND purge_after > NOW() RETURNING "+userColumns,
+		restoreTokenHash,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil // No user found, but not an error
 	}
-
-	return &account, nil
+	return user, err
 }
 
-// GetOAuthAccount retrieves an OAuth account by provider and provider ID
-func GetOAuthAccount(provider OAuthProvider, providerID string) (*OAuthAccount, error) {
-	var account OAuthAccount
-	err := database.DB.QueryRow(
-		"SELECT id, user_id, provider, provider_id, provider_data, created_at, updated_at FROM oauth_accounts WHERE provider = $1 AND provider_id = $2",
-		provider, providerID,
-	).Scan(&account.ID, &account.UserID, &account.Provider, &account.ProviderID, &account.ProviderData, &account.CreatedAt, &account.UpdatedAt)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // No account found, but not an error
+// PurgeDeletedUsers permanently deletes users whose deletion grace period ended before a time,
+// along with their personal workspaces and any workspaces left without members. Their audit
+// events are kept under the bare user ID, with the changes, IP address and user agent erased.
+// It returns how many users were deleted.
+func PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+	err := withTx(ctx, func(ctx context.Context) error {
+		// Scrub audit events by or about the users, including invites sent to their email
+		if _, err := conn(ctx).ExecContext(ctx,
+			`UPDATE audit_events a SET changes = NULL, ip_address = '', user_agent = ''
+			FROM users u
+			WHERE u.purge_after < $1 AND (
+				a.actor_id = u.id
+				OR (a.target_type IN ('user', 'member') AND a.target_id = u.id::text)
+				OR (a.target_type = 'invite' AND a.changes -> 'email' ->> 'after' = u.email)
+			)`,
+			before,
+		); err != nil {
+			return err
 		}
-		return nil, err
-	}
 
-	return &account, nil
+		// Delete personal workspaces
+		if _, err := conn(ctx).ExecContext(ctx,
+			"DELETE FROM organizations WHERE personal AND created_by IN (SELECT id FROM users WHERE purge_after < $1)",
+			before,
+		); err != nil {
+			return err
+		}
+
+		// Delete users
+		result, err := conn(ctx).ExecContext(ctx, "DELETE FROM users WHERE purge_after < $1", before)
+		if err != nil {
+			return err
+		}
+		if purged, err = result.RowsAffected(); err != nil || purged == 0 {
+			return err
+		}
+
+		// Delete workspaces left without members
+		_, err = conn(ctx).ExecContext(ctx, "DELETE FROM organizations o WHERE NOT EXISTS (SELECT 1 FROM memberships m WHERE m.organization_id = o.id)")
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
 }
 
-// GetUserByOAuthAccount retrieves a user by OAuth account
-func GetUserByOAuthAccount(provider OAuthProvider, providerID string) (*User, error) {
+// scanUser scans a User selected with userColumns
+func scanUser(row rowScanner) (*User, error) {
 	var user User
-	err := database.DB.QueryRow(
-		`SELECT u.id, u.name, u.email, u.password, u.created_at, u.updated_at 
-		FROM users u 
-		JOIN oauth_accounts oa ON u.id = oa.user_id 
-		WHERE oa.provider = $1 AND oa.provider_id = $2`,
-		provider, providerID,
-	).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.AvatarURL, &user.Timezone, &user.Locale, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, errors.New("user not found")
-		}
 		return nil, err
 	}
-
 	return &user, nil
 }
@@ -0,0 +1,184 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a global tracer provider that exports to memory and returns a function
+// that flushes and returns the ended spans
+func recordSpans(t *testing.T) func() tracetest.SpanStubs {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := NewTracerProvider(exporter, "zyply-test")
+
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	return func() tracetest.SpanStubs {
+		if err := provider.ForceFlush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return exporter.GetSpans()
+	}
+}
+
+// spanAttribute returns the value of a span attribute
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) (attribute.Value, bool) {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		exporter string
+		wantErr  bool
+	}{
+		{config.TracingExporterNone, false},
+		{config.TracingExporterStdout, false},
+		{"zipkin", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.exporter, func(t *testing.T) {
+			previous := otel.GetTracerProvider()
+			t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+			cfg := &config.Config{}
+			cfg.Tracing.Exporter = tt.exporter
+			cfg.Tracing.ServiceName = "zyply-test"
+			shutdown, err := Setup(context.Background(), cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Setup() = nil error, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Setup() = %v", err)
+			}
+			if err := shutdown(context.Background()); err != nil {
+				t.Errorf("shutdown() = %v", err)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	spans := recordSpans(t)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/api/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Continue a trace started by the caller
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/users/42?token=secret&page=2", nil)
+	propagation.TraceContext{}.Inject(trace.ContextWithRemoteSpanContext(context.Background(), parent), propagation.HeaderCarrier(req.Header))
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	got := spans()
+	if len(got) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(got))
+	}
+	span := got[0]
+	if span.Name != "GET /api/users/{id}" {
+		t.Errorf("span name = %q, want the route pattern", span.Name)
+	}
+	if span.Parent.TraceID() != parent.TraceID() || span.Parent.SpanID() != parent.SpanID() {
+		t.Errorf("span parent = %v, want the propagated span %v", span.Parent, parent)
+	}
+	if route, _ := spanAttribute(span, semconv.HTTPRouteKey); route.AsString() != "/api/users/{id}" {
+		t.Errorf("http.route = %q, want /api/users/{id}", route.AsString())
+	}
+	if status, _ := spanAttribute(span, semconv.HTTPStatusCodeKey); status.AsInt64() != http.StatusNoContent {
+		t.Errorf("http.status_code = %d, want 204", status.AsInt64())
+	}
+	target, _ := spanAttribute(span, semconv.HTTPTargetKey)
+	if strings.Contains(target.AsString(), "secret") || !strings.Contains(target.AsString(), "page=2") {
+		t.Errorf("http.target = %q, want the token redacted", target.AsString())
+	}
+	if name, _ := span.Resource.Set().Value(semconv.ServiceNameKey); name.AsString() != "zyply-test" {
+		t.Errorf("service.name = %q, want zyply-test", name.AsString())
+	}
+}
+
+func TestMiddlewareUnmatchedRoute(t *testing.T) {
+	spans := recordSpans(t)
+
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/api/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/unknown/42", nil))
+
+	// Unrouted paths are not used as span names
+	got := spans()
+	if len(got) != 1 || got[0].Name != http.MethodGet {
+		t.Fatalf("spans = %v, want one span named GET", got)
+	}
+}
+
+func TestHTTPClient(t *testing.T) {
+	spans := recordSpans(t)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var received trace.SpanContext
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		received = trace.SpanContextFromContext(ctx)
+	}))
+	defer server.Close()
+
+	// Outbound requests are traced as children of the current span
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	parent.End()
+
+	got := spans()
+	if len(got) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(got))
+	}
+	client := got[0]
+	if client.SpanKind != trace.SpanKindClient || client.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("client span = %+v, want a client span under the parent", client)
+	}
+	if received.TraceID() != parent.SpanContext().TraceID() || received.SpanID() != client.SpanContext.SpanID() {
+		t.Errorf("server received span %v, want the client span %v", received, client.SpanContext)
+	}
+}
@@ -0,0 +1,139 @@
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Field error codes
+const (
+	CodeRequired     = "required"
+	CodeInvalidEmail = "invalid_email"
+	CodeTooShort     = "too_short"
+	CodeTooLong      = "too_long"
+	CodeInvalidType  = "invalid_type"
+	CodeUnknownField = "unknown_field"
+	CodeInvalid      = "invalid"
+)
+
+// FieldError describes why a request field is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Struct checks the fields of a struct against their validate tags and returns every violation.
+// Fields are reported by their JSON name. Supported rules are required, email, min=N and max=N,
+// where min and max count characters and required rejects empty strings and slices. Nil pointer fields only fail the required rule.
+//
+//	Email string `json:"email" validate:"required,email,max=255"`
+func Struct(v interface{}) []FieldError {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		panic("validate: Struct called with a non-struct value")
+	}
+
+	var errs []FieldError
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || !field.IsExported() {
+			continue
+		}
+
+		name := jsonName(field)
+		fieldValue := value.Field(i)
+		if fieldValue.Kind() == reflect.Ptr {
+			if fieldValue.IsNil() {
+				if hasRule(tag, "required") {
+					errs = append(errs, FieldError{Field: name, Code: CodeRequired, Message: name + " is required"})
+				}
+				continue
+			}
+			fieldValue = fieldValue.Elem()
+		}
+
+		if err := checkField(name, fieldValue, tag); err != nil {
+			errs = append(errs, *err)
+		}
+	}
+
+	return errs
+}
+
+// checkField applies a field's rules in order and returns the first violation
+func checkField(name string, value reflect.Value, tag string) *FieldError {
+	str := ""
+	if value.Kind() == reflect.String {
+		str = value.String()
+	}
+
+	for _, rule := range strings.Split(tag, ",") {
+		rule, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch rule {
+		case "required":
+			if value.IsZero() || (value.Kind() == reflect.String && strings.TrimSpace(str) == "") || (value.Kind() == reflect.Slice && value.Len() == 0) {
+				return &FieldError{Field: name, Code: CodeRequired, Message: name + " is required"}
+			}
+		case "email":
+			if str != "" && !ValidEmail(str) {
+				return &FieldError{Field: name, Code: CodeInvalidEmail, Message: name + " must be a valid email address"}
+			}
+		case "min":
+			if n := ruleArg(rule, arg); str != "" && utf8.RuneCountInString(str) < n {
+				return &FieldError{Field: name, Code: CodeTooShort, Message: fmt.Sprintf("%s must be at least %d characters", name, n)}
+			}
+		case "max":
+			if n := ruleArg(rule, arg); utf8.RuneCountInString(str) > n {
+				return &FieldError{Field: name, Code: CodeTooLong, Message: fmt.Sprintf("%s must be at most %d characters", name, n)}
+			}
+		default:
+			panic("validate: unknown rule " + rule)
+		}
+	}
+
+	return nil
+}
+
+// ValidEmail checks if a value is a bare email address such as user@example.com
+func ValidEmail(value string) bool {
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value {
+		return false
+	}
+	_, domain, _ := strings.Cut(addr.Address, "@")
+	return strings.Contains(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+// jsonName returns the name a struct field is decoded from
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// hasRule checks if a validate tag contains a rule
+func hasRule(tag, rule string) bool {
+	for _, r := range strings.Split(tag, ",") {
+		if strings.TrimSpace(r) == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleArg parses the numeric argument of a min or max rule
+func ruleArg(rule, arg string) int {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		panic("validate: rule " + rule + " requires a number")
+	}
+	return n
+}
@@ -0,0 +1,165 @@
+package validate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRequired(t *testing.T) {
+	type request struct {
+		Name   string   `json:"name" validate:"required"`
+		Count  int      `json:"count" validate:"required"`
+		Scopes []string `json:"scopes" validate:"required"`
+		Note   *string  `json:"note" validate:"required"`
+	}
+	blank, note := "  ", "note"
+
+	tests := []struct {
+		name string
+		req  request
+		want []string
+	}{
+		{"all set", request{Name: "ada", Count: 1, Scopes: []string{"links:read"}, Note: &note}, nil},
+		{"all missing", request{}, []string{"name", "count", "scopes", "note"}},
+		{"whitespace only", request{Name: " \t", Count: 1, Scopes: []string{"links:read"}, Note: &note}, []string{"name"}},
+		{"empty slice", request{Name: "ada", Count: 1, Scopes: []string{}, Note: &note}, []string{"scopes"}},
+		{"blank pointer", request{Name: "ada", Count: 1, Scopes: []string{"links:read"}, Note: &blank}, []string{"note"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertFields(t, Struct(&tt.req), CodeRequired, tt.want)
+		})
+	}
+}
+
+func TestEmail(t *testing.T) {
+	type request struct {
+		Email string `json:"email" validate:"email"`
+	}
+
+	tests := []struct {
+		email string
+		valid bool
+	}{
+		{"", true},
+		{"ada@example.com", true},
+		{"ada.lovelace+links@mail.example.co.uk", true},
+		{"ada", false},
+		{"ada@", false},
+		{"@example.com", false},
+		{"ada@localhost", false},
+		{"ada@example.", false},
+		{"Ada <ada@example.com>", false},
+		{" ada@example.com", false},
+		{"ada@example.com, bob@example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			var want []string
+			if !tt.valid {
+				want = []string{"email"}
+			}
+			assertFields(t, Struct(request{Email: tt.email}), CodeInvalidEmail, want)
+		})
+	}
+}
+
+func TestMinAndMax(t *testing.T) {
+	type request struct {
+		Name string `json:"name" validate:"min=3,max=5"`
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		wantCode string
+	}{
+		{"empty skips min", "", ""},
+		{"too short", "ab", CodeTooShort},
+		{"shortest", "abc", ""},
+		{"longest", "abcde", ""},
+		{"too long", "abcdef", CodeTooLong},
+		{"characters rather than bytes", "日本語です", ""},
+		{"too many characters", "日本語ですね", CodeTooLong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want []string
+			if tt.wantCode != "" {
+				want = []string{"name"}
+			}
+			assertFields(t, Struct(request{Name: tt.value}), tt.wantCode, want)
+		})
+	}
+}
+
+func TestStruct(t *testing.T) {
+	type request struct {
+		Email    string  `json:"email,omitempty" validate:"required,email,max=255"`
+		Password string  `validate:"required"`
+		Name     *string `json:"name" validate:"max=3"`
+		Ignored  string  `json:"ignored"`
+		private  string  `validate:"required"`
+	}
+	long := "abcd"
+
+	// Each field reports only its first violation, under its JSON name
+	got := Struct(&request{Email: "", Name: &long, private: ""})
+	want := []FieldError{
+		{Field: "email", Code: CodeRequired, Message: "email is required"},
+		{Field: "Password", Code: CodeRequired, Message: "Password is required"},
+		{Field: "name", Code: CodeTooLong, Message: "name must be at most 3 characters"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Struct() = %+v, want %+v", got, want)
+	}
+
+	// Nil pointers only fail required
+	if got := Struct(request{Email: "ada@example.com", Password: "secret"}); got != nil {
+		t.Errorf("Struct() with a nil pointer = %+v, want nil", got)
+	}
+}
+
+func TestStructPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+	}{
+		{"non-struct", "ada"},
+		{"unknown rule", &struct {
+			Name string `validate:"alpha"`
+		}{}},
+		{"non-numeric argument", &struct {
+			Name string `validate:"max=ten"`
+		}{Name: "ada"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("Struct() did not panic")
+				}
+			}()
+			Struct(tt.v)
+		})
+	}
+}
+
+// assertFields checks that errs reports exactly the fields in want, each with code
+func assertFields(t *testing.T, errs []FieldError, code string, want []string) {
+	t.Helper()
+	var got []string
+	for _, err := range errs {
+		if err.Code != code {
+			t.Errorf("%s error code = %q, want %q", err.Field, err.Code, code)
+		}
+		got = append(got, err.Field)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fields = %v, want %v", got, want)
+	}
+}
@@ -3,54 +3,189 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/RanitManik/zyply/internal/account"
+	"github.com/RanitManik/zyply/internal/audit"
+	"github.com/RanitManik/zyply/internal/auth"
+	"github.com/RanitManik/zyply/internal/certs"
 	"github.com/RanitManik/zyply/internal/config"
 	"github.com/RanitManik/zyply/internal/database"
 	"github.com/RanitManik/zyply/internal/handlers"
+	"github.com/RanitManik/zyply/internal/logging"
+	"github.com/RanitManik/zyply/internal/mail"
+	"github.com/RanitManik/zyply/internal/metrics"
 	"github.com/RanitManik/zyply/internal/middleware"
+	"github.com/RanitManik/zyply/internal/models"
+	"github.com/RanitManik/zyply/internal/tracing"
 	"github.com/go-chi/chi/v5"
 	chimiddleware "github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/cors"
 )
 
+// usage describes the command line
+const usage = `Usage:
+  zyply [serve]                    Run the API server
+  zyply migrate up                 Apply all pending migrations
+  zyply migrate down               Roll back the last migration
+  zyply migrate status             Show applied and pending migrations
+  zyply migrate create [-dir DIR] NAME
+                                   Create a new SQL migration
+  zyply config print [--redacted]  Show the resolved configuration and where each value came from
+`
+
 func main() {
-	// Load configuration
-	cfg, err := config.LoadConfig()
+	// Parse command
+	command, args := "serve", []string(nil)
+	if len(os.Args) > 1 {
+		command, args = os.Args[1], os.Args[2:]
+	}
+
+	// Load configuration; config print shows it even when it is invalid
+	cfg, err := config.Resolve()
+	if err != nil && command != "config" {
+		fatal("Failed to load configuration", err)
+	}
+
+	// Set up logging, which also routes the standard logger through slog
+	logger := logging.New(cfg, os.Stderr)
+	slog.SetDefault(logger)
+
+	// Run command
+	switch command {
+	case "serve":
+		serve(cfg, logger)
+	case "migrate":
+		if err := runMigrate(cfg, args); err != nil {
+			fatal("Migration failed", err)
+		}
+	case "config":
+		if err := runConfig(os.Stdout, cfg, err, args); err != nil {
+			fatal("Config command failed", err)
+		}
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+// fatal logs an error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// serve runs the API server until it receives an interrupt or termination signal
+func serve(cfg *config.Config, logger *slog.Logger) {
+	// Set up tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("Failed to set up tracing", err)
 	}
 
 	// Initialize database
 	if err := database.Initialize(cfg); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		fatal("Failed to initialize database", err)
 	}
 	defer database.Close()
+	if cfg.Metrics.Enabled {
+		if err := metrics.RegisterDB(database.DB, cfg.Database.Name); err != nil {
+			fatal("Failed to register database metrics", err)
+		}
+	}
+
+	// Prune expired audit events in the background
+	retentionCtx, stopRetention := context.WithCancel(context.Background())
+	defer stopRetention()
+	audit.StartRetention(retentionCtx, cfg.Audit.Retention)
+
+	// Purge accounts whose deletion grace period has ended in the background
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	account.StartPurge(purgeCtx)
 
 	// Create router
 	r := chi.NewRouter()
 
 	// Middleware
-	r.Use(chimiddleware.Logger)
-	r.Use(chimiddleware.Recoverer)
-	r.Use(chimiddleware.RealIP)
+	r.Use(tracing.Middleware)
 	r.Use(chimiddleware.RequestID)
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{cfg.Server.FrontendURL, "*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: false, // Set to false since we're using JWT in Authorization header
-		MaxAge:           300,
-	}))
+	r.Use(middleware.TrustedProxies(cfg.Server.TrustedProxies))
+	r.Use(middleware.RequestLogger(logger))
+	if cfg.Metrics.Enabled {
+		r.Use(metrics.Middleware)
+	}
+	r.Use(middleware.SecurityHeaders(cfg))
+	r.Use(chimiddleware.Recoverer)
+	r.Use(middleware.CORS(
+		// Third-party apps exchange and revoke tokens from their own origins
+		middleware.CORSPolicy{
+			PathPrefixes: []string{"/api/oauth/token", "/api/oauth/revoke"},
+			Origins:      append(append([]string{}, cfg.CORS.AllowedOrigins...), cfg.CORS.OAuthAllowedOrigins...),
+			Methods:      []string{"POST", "OPTIONS"},
+			Headers:      []string{"Accept", "Authorization", "Content-Type"},
+			MaxAge:       cfg.CORS.MaxAge,
+		},
+		// The frontend uses the rest of the API
+		middleware.CORSPolicy{
+			PathPrefixes: []string{"/api/"},
+			Origins:      cfg.CORS.AllowedOrigins,
+			Methods:      []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			Headers:      []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", middleware.WorkspaceHeader},
+			MaxAge:       cfg.CORS.MaxAge,
+		},
+	))
+
+	// Load role policy
+	rolePolicy, err := auth.LoadPolicy(context.Background())
+	if err != nil {
+		fatal("Failed to load role policy", err)
+	}
+	auth.SetPolicy(rolePolicy)
+
+	// Load password policy
+	passwordPolicy, err := auth.NewPasswordPolicy(cfg)
+	if err != nil {
+		fatal("Failed to load password policy", err)
+	}
+
+	// Create repositories
+	users := models.NewPostgresUserRepository(database.DB)
+	oauthAccounts := models.NewPostgresOAuthAccountRepository(database.DB)
+	mfa := models.NewPostgresMFARepository(database.DB)
+	apiKeys := models.NewPostgresAPIKeyRepository(database.DB)
+	oauthServer := models.NewPostgresOAuthServerRepository(database.DB)
+	auditLog := audit.NewLog(models.NewPostgresAuditEventRepository(database.DB))
+	mailer := mail.NewSender(cfg)
+
+	// Create authentication middleware
+	authenticate := middleware.Authenticate(cfg, users, apiKeys, oauthServer)
 
 	// Create handlers
-	authHandler := handlers.NewAuthHandler(cfg)
+	authHandler := handlers.NewAuthHandler(cfg, passwordPolicy, users, oauthAccounts, mfa, auditLog, mailer)
+	apiKeyHandler := handlers.NewAPIKeyHandler(cfg)
+	oauthServerHandler := handlers.NewOAuthServerHandler(cfg, oauthServer, auditLog)
+	workspaceHandler := handlers.NewWorkspaceHandler(cfg, mailer)
+	auditHandler := handlers.NewAuditHandler(cfg)
+	userHandler := handlers.NewUserHandler(cfg, passwordPolicy, mfa, mailer)
+	samlHandler, err := handlers.NewSAMLHandler(cfg, users, oauthAccounts, mfa)
+	if err != nil {
+		fatal("Failed to initialize SAML", err)
+	}
+	passkeyHandler, err := handlers.NewPasskeyHandler(cfg)
+	if err != nil {
+		fatal("Failed to initialize WebAuthn", err)
+	}
+	healthHandler, err := handlers.NewHealthHandler(database.Pool{DB: database.DB})
+	if err != nil {
+		fatal("Failed to initialize health checks", err)
+	}
 
 	// Routes
 	r.Route("/api", func(r chi.Router) {
@@ -59,25 +194,161 @@ func main() {
 			r.Post("/signup", authHandler.Signup)
 			r.Post("/login", authHandler.Login)
 			r.Post("/forgot-password", authHandler.ForgotPassword)
+			r.Post("/reset-password", authHandler.ResetPassword)
+			r.Post("/mfa/verify", authHandler.VerifyMFA)
+			r.Post("/passkeys/login/begin", passkeyHandler.BeginLogin)
+			r.Post("/passkeys/login/finish", passkeyHandler.FinishLogin)
 			r.Get("/github", authHandler.GitHubLogin)
 			r.Get("/github/callback", authHandler.GitHubCallback)
 			r.Get("/google", authHandler.GoogleLogin)
 			r.Get("/google/callback", authHandler.GoogleCallback)
+			r.Get("/saml/{organization}", samlHandler.Login)
+			r.Get("/saml/{organization}/metadata", samlHandler.Metadata)
+			r.Post("/saml/{organization}/acs", samlHandler.ACS)
+			r.Get("/{provider}", authHandler.OIDCLogin)
+			r.Get("/{provider}/callback", authHandler.OIDCCallback)
 
 			// Protected routes
 			r.Group(func(r chi.Router) {
-				r.Use(middleware.Authenticate(cfg))
-				r.Get("/me", authHandler.Me)
+				r.Use(authenticate)
+				r.With(middleware.RequireScope(auth.ScopeProfileRead)).Get("/me", authHandler.Me)
+
+				// Account security settings cannot be changed with an API key
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RequireSession)
+					r.Get("/mfa", authHandler.MFAStatus)
+					r.Post("/mfa/totp", authHandler.EnrollTOTP)
+					r.Post("/mfa/totp/confirm", authHandler.ConfirmTOTP)
+					r.Delete("/mfa/totp", authHandler.DisableTOTP)
+					r.Post("/mfa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+					r.Get("/passkeys", passkeyHandler.List)
+					r.Delete("/passkeys/{id}", passkeyHandler.Delete)
+					r.Post("/passkeys/register/begin", passkeyHandler.BeginRegistration)
+					r.Post("/passkeys/register/finish", passkeyHandler.FinishRegistration)
+					r.Post("/saml/{organization}/link", samlHandler.Link)
+				})
 			})
 		})
-	})
 
-	// Health check
-	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+		// Current user's profile and account settings
+		r.Route("/user", func(r chi.Router) {
+			r.Post("/email/verify", userHandler.VerifyEmailChange)
+			r.Post("/restore", userHandler.RestoreAccount)
+
+			r.Group(func(r chi.Router) {
+				r.Use(authenticate)
+				r.With(middleware.RequireScope(auth.ScopeProfileRead)).Get("/profile", userHandler.GetProfile)
+
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RequireSession)
+					r.Put("/profile", userHandler.UpdateProfile)
+					r.Put("/password", userHandler.ChangePassword)
+					r.Post("/email", userHandler.ChangeEmail)
+					r.Get("/export", userHandler.Export)
+					r.Delete("/", userHandler.DeleteAccount)
+				})
+			})
+		})
+
+		// Workspaces, members and invitations
+		r.Route("/workspaces", func(r chi.Router) {
+			r.Use(authenticate)
+			r.Use(middleware.RequireSession)
+			r.Get("/", workspaceHandler.List)
+			r.Post("/", workspaceHandler.Create)
+
+			r.Route("/{workspaceID}", func(r chi.Router) {
+				r.Use(middleware.ResolveWorkspace)
+				r.With(middleware.RequirePermission(auth.PermissionMembersRead)).Get("/members", workspaceHandler.ListMembers)
+				r.With(middleware.RequirePermission(auth.PermissionMembersManage)).Put("/members/{userID}", workspaceHandler.UpdateMember)
+				r.Delete("/members/{userID}", workspaceHandler.RemoveMember)
+
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RequirePermission(auth.PermissionMembersManage))
+					r.Get("/invites", workspaceHandler.ListInvites)
+					r.Post("/invites", workspaceHandler.CreateInvite)
+					r.Delete("/invites/{inviteID}", workspaceHandler.DeleteInvite)
+				})
+			})
+		})
+		r.With(authenticate, middleware.RequireSession).Post("/invites/accept", workspaceHandler.AcceptInvite)
+
+		// API key management
+		r.Route("/api-keys", func(r chi.Router) {
+			r.Use(authenticate)
+			r.Use(middleware.RequireSession)
+			r.Use(middleware.ResolveWorkspace)
+			r.Get("/", apiKeyHandler.List)
+			r.Post("/", apiKeyHandler.Create)
+			r.Delete("/{id}", apiKeyHandler.Revoke)
+		})
+
+		// OAuth2 authorization server for third-party apps
+		r.Route("/oauth", func(r chi.Router) {
+			r.Post("/token", oauthServerHandler.Token)
+			r.Post("/revoke", oauthServerHandler.Revoke)
+
+			r.Group(func(r chi.Router) {
+				r.Use(authenticate)
+				r.Use(middleware.RequireSession)
+				r.Get("/authorize", oauthServerHandler.Consent)
+				r.Post("/authorize", oauthServerHandler.Authorize)
+
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.ResolveWorkspace)
+					r.Use(middleware.RequirePermission(auth.PermissionOAuthClientsManage))
+					r.Get("/clients", oauthServerHandler.ListClients)
+					r.Post("/clients", oauthServerHandler.RegisterClient)
+					r.Delete("/clients/{clientID}", oauthServerHandler.DeleteClient)
+				})
+			})
+		})
+
+		// Audit log of the current workspace
+		r.Route("/audit", func(r chi.Router) {
+			r.Use(authenticate)
+			r.Use(middleware.RequireSession)
+			r.Use(middleware.ResolveWorkspace)
+			r.Use(middleware.RequirePermission(auth.PermissionAuditRead))
+			r.Get("/", auditHandler.List)
+		})
+
+		// SAML connection management
+		r.Route("/saml/connections", func(r chi.Router) {
+			r.Use(authenticate)
+			r.Use(middleware.RequireSession)
+			r.Get("/{organization}", samlHandler.GetConnection)
+			r.Put("/{organization}", samlHandler.PutConnection)
+			r.Post("/{organization}/domains/{domain}/verify", samlHandler.VerifyDomain)
+		})
 	})
 
+	// Health checks. /health is kept as a liveness alias for existing probes.
+	r.Get("/livez", healthHandler.Live)
+	r.Get("/readyz", healthHandler.Ready)
+	r.Get("/health", healthHandler.Live)
+
+	// Metrics, on the admin port if one is configured. Production refuses to start without one.
+	var adminServer *http.Server
+	if cfg.Metrics.Enabled && cfg.Metrics.AdminPort != "" {
+		admin := http.NewServeMux()
+		admin.Handle("/metrics", metrics.Handler())
+		adminServer = &http.Server{
+			Addr:         fmt.Sprintf(":%s", cfg.Metrics.AdminPort),
+			Handler:      admin,
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+		}
+		go func() {
+			slog.Info("Admin server listening", "port", cfg.Metrics.AdminPort)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal("Failed to start admin server", err)
+			}
+		}()
+	} else if cfg.Metrics.Enabled {
+		r.Handle("/metrics", metrics.Handler())
+	}
+
 	// Start server
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%s", cfg.Server.Port),
@@ -87,11 +358,44 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Serve HTTPS when TLS is configured, redirecting HTTP to it if a redirect port is set
+	var redirectServer *http.Server
+	if cfg.TLSEnabled() {
+		tlsCtx, stopTLS := context.WithCancel(context.Background())
+		defer stopTLS()
+		tlsConfig, redirectHandler, err := certs.Setup(tlsCtx, cfg)
+		if err != nil {
+			fatal("Failed to set up TLS", err)
+		}
+		server.TLSConfig = tlsConfig
+
+		if cfg.TLS.RedirectPort != "" {
+			redirectServer = &http.Server{
+				Addr:         fmt.Sprintf(":%s", cfg.TLS.RedirectPort),
+				Handler:      redirectHandler,
+				ReadTimeout:  15 * time.Second,
+				WriteTimeout: 15 * time.Second,
+			}
+			go func() {
+				slog.Info("Redirect server listening", "port", cfg.TLS.RedirectPort)
+				if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					fatal("Failed to start redirect server", err)
+				}
+			}()
+		}
+	}
+
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Server listening on port %s", cfg.Server.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+		slog.Info("Server listening", "port", cfg.Server.Port, "tls", cfg.TLSEnabled())
+		var err error
+		if cfg.TLSEnabled() {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("Failed to start server", err)
 		}
 	}()
 
@@ -100,12 +404,27 @@ func main() {
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	<-sigChan
 
-	// Shutdown server gracefully
-	log.Println("Shutting down server...")
+	// Shutdown server gracefully, failing readiness first so load balancers drain traffic
+	slog.Info("Shutting down server")
+	healthHandler.StartShutdown()
+	time.Sleep(cfg.Server.ShutdownDrainDelay)
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server shutdown failed: %v", err)
+		fatal("Server shutdown failed", err)
+	}
+	if redirectServer != nil {
+		if err := redirectServer.Shutdown(ctx); err != nil {
+			fatal("Redirect server shutdown failed", err)
+		}
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			fatal("Admin server shutdown failed", err)
+		}
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
 	}
-	log.Println("Server stopped")
+	slog.Info("Server stopped")
 }
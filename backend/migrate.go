@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/RanitManik/zyply/internal/config"
+	"github.com/RanitManik/zyply/internal/database"
+)
+
+// runMigrate runs a migrate subcommand
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing migrate command, expected up, down, status or create")
+	}
+
+	// Creating a migration only writes a file and needs no database
+	if args[0] == "create" {
+		flags := flag.NewFlagSet("migrate create", flag.ExitOnError)
+		dir := flags.String("dir", "migrations", "directory to create the migration in")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			fmt.Fprint(os.Stderr, usage)
+			return errors.New("migrate create requires a name")
+		}
+		return database.CreateMigration(*dir, flags.Arg(0))
+	}
+
+	// Connect to database
+	if err := database.Connect(cfg); err != nil {
+		return err
+	}
+	defer database.Close()
+
+	switch args[0] {
+	case "up":
+		return database.MigrateUp()
+	case "down":
+		return database.MigrateDown()
+	case "status":
+		return database.MigrateStatus()
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down, status or create", args[0])
+	}
+}
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS saml_connections (
+    id SERIAL PRIMARY KEY,
+    organization VARCHAR(45) NOT NULL UNIQUE,
+    idp_entity_id VARCHAR(1024) NOT NULL,
+    idp_metadata TEXT NOT NULL,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Email domains of a SAML connection only admit users once the organization proves it
+-- controls them with a DNS TXT record
+CREATE TABLE IF NOT EXISTS saml_domains (
+    id SERIAL PRIMARY KEY,
+    organization VARCHAR(45) NOT NULL,
+    domain VARCHAR(255) NOT NULL,
+    verification_token VARCHAR(64) NOT NULL,
+    verified_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (organization, domain)
+);
+
+-- A domain can be verified by a single organization
+CREATE UNIQUE INDEX IF NOT EXISTS idx_saml_domains_verified ON saml_domains(domain) WHERE verified_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS saml_domains;
+DROP TABLE IF EXISTS saml_connections;
+-- +goose StatementEnd
@@ -0,0 +1,114 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS organizations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    slug VARCHAR(45) NOT NULL UNIQUE,
+    personal BOOLEAN NOT NULL DEFAULT FALSE,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS memberships (
+    organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_memberships_user_id ON memberships(user_id);
+
+CREATE TABLE IF NOT EXISTS invites (
+    id SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP NOT NULL,
+    accepted_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_invites_organization_id ON invites(organization_id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- Give every existing user a personal workspace
+INSERT INTO organizations (name, slug, personal, created_by, created_at, updated_at)
+SELECT name, 'personal-' || id, TRUE, id, NOW(), NOW() FROM users
+ON CONFLICT (slug) DO NOTHING;
+
+INSERT INTO memberships (organization_id, user_id, role, created_at, updated_at)
+SELECT id, created_by, 'owner', NOW(), NOW() FROM organizations WHERE personal
+ON CONFLICT DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- Turn SAML connection organizations into workspaces owned by the connection creator
+INSERT INTO organizations (name, slug, personal, created_by, created_at, updated_at)
+SELECT organization, organization, FALSE, created_by, NOW(), NOW() FROM saml_connections
+ON CONFLICT (slug) DO NOTHING;
+
+INSERT INTO memberships (organization_id, user_id, role, created_at, updated_at)
+SELECT o.id, s.created_by, 'owner', NOW(), NOW()
+FROM saml_connections s JOIN organizations o ON o.slug = s.organization
+WHERE s.created_by IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE saml_connections ADD COLUMN organization_id INTEGER REFERENCES organizations(id) ON DELETE CASCADE;
+UPDATE saml_connections s SET organization_id = o.id FROM organizations o WHERE o.slug = s.organization;
+ALTER TABLE saml_connections ALTER COLUMN organization_id SET NOT NULL;
+ALTER TABLE saml_connections ADD CONSTRAINT saml_connections_organization_id_key UNIQUE (organization_id);
+ALTER TABLE saml_connections DROP COLUMN organization;
+ALTER TABLE saml_connections DROP COLUMN created_by;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- Key SAML domains by workspace
+ALTER TABLE saml_domains ADD COLUMN organization_id INTEGER REFERENCES organizations(id) ON DELETE CASCADE;
+UPDATE saml_domains d SET organization_id = o.id FROM organizations o WHERE o.slug = d.organization;
+DELETE FROM saml_domains WHERE organization_id IS NULL;
+ALTER TABLE saml_domains ALTER COLUMN organization_id SET NOT NULL;
+ALTER TABLE saml_domains DROP COLUMN organization;
+ALTER TABLE saml_domains ADD CONSTRAINT saml_domains_organization_id_domain_key UNIQUE (organization_id, domain);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- Move API keys and OAuth clients to their owner's personal workspace
+ALTER TABLE api_keys ADD COLUMN organization_id INTEGER REFERENCES organizations(id) ON DELETE CASCADE;
+UPDATE api_keys k SET organization_id = o.id FROM organizations o WHERE o.personal AND o.created_by = k.user_id;
+ALTER TABLE api_keys ALTER COLUMN organization_id SET NOT NULL;
+
+ALTER TABLE oauth_clients ADD COLUMN organization_id INTEGER REFERENCES organizations(id) ON DELETE CASCADE;
+UPDATE oauth_clients c SET organization_id = o.id FROM organizations o WHERE o.personal AND o.created_by = c.owner_id;
+ALTER TABLE oauth_clients ALTER COLUMN organization_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_oauth_clients_organization_id ON oauth_clients(organization_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS organization_id;
+
+ALTER TABLE saml_domains ADD COLUMN organization VARCHAR(45);
+UPDATE saml_domains d SET organization = o.slug FROM organizations o WHERE o.id = d.organization_id;
+ALTER TABLE saml_domains ALTER COLUMN organization SET NOT NULL;
+ALTER TABLE saml_domains ADD CONSTRAINT saml_domains_organization_domain_key UNIQUE (organization, domain);
+ALTER TABLE saml_domains DROP COLUMN organization_id;
+
+ALTER TABLE saml_connections ADD COLUMN organization VARCHAR(45);
+ALTER TABLE saml_connections ADD COLUMN created_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+UPDATE saml_connections s SET organization = o.slug, created_by = o.created_by FROM organizations o WHERE o.id = s.organization_id;
+ALTER TABLE saml_connections ALTER COLUMN organization SET NOT NULL;
+ALTER TABLE saml_connections ADD CONSTRAINT saml_connections_organization_key UNIQUE (organization);
+ALTER TABLE saml_connections DROP COLUMN organization_id;
+
+DROP TABLE IF EXISTS invites;
+DROP TABLE IF EXISTS memberships;
+DROP TABLE IF EXISTS organizations;
+-- +goose StatementEnd
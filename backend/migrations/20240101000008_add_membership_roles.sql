@@ -0,0 +1,55 @@
+-- +goose Up
+-- +goose StatementBegin
+UPDATE memberships SET role = 'editor' WHERE role = 'member';
+UPDATE invites SET role = 'editor' WHERE role = 'member';
+ALTER TABLE memberships ADD CONSTRAINT memberships_role_check CHECK (role IN ('owner', 'admin', 'editor', 'viewer'));
+ALTER TABLE invites ADD CONSTRAINT invites_role_check CHECK (role IN ('owner', 'admin', 'editor', 'viewer'));
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- The policy table mapping each role to the permissions it grants
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+    permission VARCHAR(50) NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('owner', 'links:read'),
+    ('owner', 'links:write'),
+    ('owner', 'analytics:read'),
+    ('owner', 'members:read'),
+    ('owner', 'members:manage'),
+    ('owner', 'oauth_clients:manage'),
+    ('owner', 'workspace:manage'),
+    ('admin', 'links:read'),
+    ('admin', 'links:write'),
+    ('admin', 'analytics:read'),
+    ('admin', 'members:read'),
+    ('admin', 'members:manage'),
+    ('admin', 'oauth_clients:manage'),
+    ('admin', 'workspace:manage'),
+    ('editor', 'links:read'),
+    ('editor', 'links:write'),
+    ('editor', 'analytics:read'),
+    ('editor', 'members:read'),
+    ('viewer', 'links:read'),
+    ('viewer', 'analytics:read'),
+    ('viewer', 'members:read')
+ON CONFLICT DO NOTHING;
+
+-- Role given to users provisioned by single sign-on
+ALTER TABLE saml_connections ADD COLUMN default_role VARCHAR(20) NOT NULL DEFAULT 'viewer'
+    CHECK (default_role IN ('admin', 'editor', 'viewer'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE saml_connections DROP COLUMN IF EXISTS default_role;
+DROP TABLE IF EXISTS role_permissions;
+
+ALTER TABLE invites DROP CONSTRAINT IF EXISTS invites_role_check;
+ALTER TABLE memberships DROP CONSTRAINT IF EXISTS memberships_role_check;
+UPDATE invites SET role = 'member' WHERE role <> 'owner';
+UPDATE memberships SET role = 'member' WHERE role <> 'owner';
+-- +goose StatementEnd
@@ -0,0 +1,52 @@
+-- +goose Up
+-- +goose StatementBegin
+-- No foreign keys, so events outlive the users and workspaces they reference
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    organization_id INTEGER,
+    actor_id INTEGER,
+    action VARCHAR(100) NOT NULL,
+    target_type VARCHAR(50) NOT NULL DEFAULT '',
+    target_id VARCHAR(255) NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    request_id VARCHAR(100) NOT NULL DEFAULT '',
+    changes JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_organization_id ON audit_events(organization_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events(actor_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- Audit events are append-only; rows may only be deleted by retention pruning
+CREATE OR REPLACE FUNCTION reject_audit_event_update() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER audit_events_append_only
+BEFORE UPDATE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION reject_audit_event_update();
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- Owners and admins may read the workspace audit log
+INSERT INTO role_permissions (role, permission) VALUES
+    ('owner', 'audit:read'),
+    ('admin', 'audit:read')
+ON CONFLICT DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM role_permissions WHERE permission = 'audit:read';
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+DROP FUNCTION IF EXISTS reject_audit_event_update();
+DROP TABLE IF EXISTS audit_events;
+-- +goose StatementEnd
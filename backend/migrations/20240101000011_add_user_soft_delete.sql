@@ -0,0 +1,58 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN purge_after TIMESTAMP;
+ALTER TABLE users ADD COLUMN restore_token_hash CHAR(64) UNIQUE;
+
+CREATE INDEX IF NOT EXISTS idx_users_purge_after ON users(purge_after) WHERE purge_after IS NOT NULL;
+
+-- OAuth clients belong to their workspace and outlive the member who registered them
+ALTER TABLE oauth_clients ALTER COLUMN owner_id DROP NOT NULL;
+ALTER TABLE oauth_clients DROP CONSTRAINT IF EXISTS oauth_clients_owner_id_fkey;
+ALTER TABLE oauth_clients ADD CONSTRAINT oauth_clients_owner_id_fkey FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- Audit events stay append-only, except that purging an account may erase the personal
+-- data its events hold: the recorded changes, IP address and user agent
+CREATE OR REPLACE FUNCTION reject_audit_event_update() RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.id = OLD.id
+        AND NEW.organization_id IS NOT DISTINCT FROM OLD.organization_id
+        AND NEW.actor_id IS NOT DISTINCT FROM OLD.actor_id
+        AND NEW.action = OLD.action
+        AND NEW.target_type = OLD.target_type
+        AND NEW.target_id = OLD.target_id
+        AND NEW.request_id = OLD.request_id
+        AND NEW.created_at = OLD.created_at
+        AND NEW.changes IS NULL
+        AND NEW.ip_address = ''
+        AND NEW.user_agent = ''
+    THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION reject_audit_event_update() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DELETE FROM oauth_clients WHERE owner_id IS NULL;
+ALTER TABLE oauth_clients DROP CONSTRAINT IF EXISTS oauth_clients_owner_id_fkey;
+ALTER TABLE oauth_clients ADD CONSTRAINT oauth_clients_owner_id_fkey FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE oauth_clients ALTER COLUMN owner_id SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_users_purge_after;
+ALTER TABLE users DROP COLUMN IF EXISTS restore_token_hash;
+ALTER TABLE users DROP COLUMN IF EXISTS purge_after;
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
+-- +goose StatementEnd
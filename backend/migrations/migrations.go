@@ -0,0 +1,9 @@
+// Package migrations embeds the SQL migrations so the binary can run them from any working directory
+package migrations
+
+import "embed"
+
+// FS holds the goose SQL migrations
+//
+//go:embed *.sql
+var FS embed.FS
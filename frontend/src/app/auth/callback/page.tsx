@@ -13,6 +13,7 @@ export default function AuthCallback() {
 
   useEffect(() => {
     const token = searchParams.get("token");
+    const redirect = searchParams.get("redirect");
 
     if (!token) {
       setError("No authentication token received");
@@ -25,9 +26,13 @@ export default function AuthCallback() {
     // Refresh user data
     refreshUser()
       .then(() => {
-        // Redirect to dashboard
+        // Redirect to the requested page, or the dashboard by default
         setTimeout(() => {
-          router.push("/dashboard");
+          router.push(
+            redirect?.startsWith("/") && !redirect.startsWith("//")
+              ? redirect
+              : "/dashboard",
+          );
         }, 1500);
       })
       .catch((err) => {
@@ -10,6 +10,15 @@ const API_BASE_URL =
 console.log("API URL:", API_BASE_URL);
 
 // Types
+export type ApiErrorBody = {
+  error: {
+    code: string;
+    message: string;
+    details?: unknown;
+    request_id?: string;
+  };
+};
+
 export type ApiResponse<T> = {
   data: T | null;
   error: string | null;
@@ -74,7 +83,9 @@ async function request<T>(
       return {
         data: null,
         error:
-          responseData.error || `Request failed with status ${response.status}`,
+          responseData.error?.message ||
+          responseData.error ||
+          `Request failed with status ${response.status}`,
       };
     }
 